@@ -0,0 +1,81 @@
+// Package tdsbridgeotel 将桥接器的请求跟踪接入OpenTelemetry。
+// 独立为单独的模块，使主模块不依赖OpenTelemetry。
+package tdsbridgeotel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/axcom/tdsbridge-go/pkg"
+)
+
+// 跟踪器的默认名称
+const instrumentationName = "github.com/axcom/tdsbridge-go/otel"
+
+type config struct {
+	tracer trace.Tracer
+	redact func(string) string
+}
+
+// Option Instrument的选项
+type Option func(*config)
+
+// WithTracer 使用指定的跟踪器，默认使用全局TracerProvider的跟踪器
+func WithTracer(tracer trace.Tracer) Option {
+	return func(c *config) {
+		c.tracer = tracer
+	}
+}
+
+// WithStatementRedactor 设置记录语句前的处理函数，默认为pkg.NormalizeSQL，传入nil时记录原始语句
+func WithStatementRedactor(redact func(string) string) Option {
+	return func(c *config) {
+		c.redact = redact
+	}
+}
+
+// Instrument 为桥接器设置请求跟踪函数，每个SQLBatch或RPC请求与其响应记录为一个span。需在Start之前调用。
+func Instrument(ba *pkg.BridgeAcceptor, opts ...Option) {
+	c := &config{redact: pkg.NormalizeSQL}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.tracer == nil {
+		c.tracer = otel.Tracer(instrumentationName)
+	}
+	ba.SetRequestTracer(newRequestTracer(c.tracer), c.redact)
+}
+
+func newRequestTracer(tracer trace.Tracer) pkg.RequestTracer {
+	return func(bc *pkg.BridgedConnection, req *pkg.TracedRequest) func(*pkg.TracedResponse) {
+		attrs := []attribute.KeyValue{attribute.String("db.system", "mssql")}
+		if req.Statement != "" {
+			attrs = append(attrs, attribute.String("db.statement", req.Statement))
+		}
+		if req.ProcName != "" {
+			attrs = append(attrs, attribute.String("db.operation", req.ProcName))
+		}
+		_, span := tracer.Start(context.Background(), req.Type.String(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithTimestamp(req.Start),
+			trace.WithAttributes(attrs...))
+		return func(resp *pkg.TracedResponse) {
+			span.SetAttributes(attribute.Int64("db.row_count", int64(resp.RowCount)))
+			for _, e := range resp.Errors {
+				span.RecordError(e)
+			}
+			switch {
+			case resp.Err != nil:
+				span.RecordError(resp.Err)
+				span.SetStatus(codes.Error, resp.Err.Error())
+			case len(resp.Errors) > 0:
+				span.SetStatus(codes.Error, resp.Errors[0].Error())
+			}
+			span.End(trace.WithTimestamp(resp.End))
+		}
+	}
+}
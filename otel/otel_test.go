@@ -0,0 +1,142 @@
+package tdsbridgeotel
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/axcom/tdsbridge-go/pkg"
+)
+
+// sqlBatchPacket 构造带ALL_HEADERS的单包SQLBatch请求
+func sqlBatchPacket(text string) []byte {
+	payload := binary.LittleEndian.AppendUint32(nil, 22) // ALL_HEADERS总长度
+	payload = binary.LittleEndian.AppendUint32(payload, 18)
+	payload = binary.LittleEndian.AppendUint16(payload, 2) // 事务描述符头
+	payload = append(payload, make([]byte, 12)...)         // 事务描述符与未完成请求数
+	for _, u := range utf16.Encode([]rune(text)) {
+		payload = binary.LittleEndian.AppendUint16(payload, u)
+	}
+	header := pkg.BuildTDSHeader(pkg.SQLBatch, pkg.END_OF_MESSAGE, len(payload)+pkg.HEADER_SIZE, 1)
+	return append(header, payload...)
+}
+
+// doneResponse 构造只含一个带行数的最终DONE令牌的响应
+func doneResponse(rowCount uint64) []byte {
+	payload := []byte{byte(pkg.TokenDone)}
+	payload = binary.LittleEndian.AppendUint16(payload, pkg.DONE_FINAL|pkg.DONE_COUNT)
+	payload = binary.LittleEndian.AppendUint16(payload, 0xC1) // SELECT
+	payload = binary.LittleEndian.AppendUint64(payload, rowCount)
+	header := pkg.BuildTDSHeader(pkg.TabularResult, pkg.END_OF_MESSAGE, len(payload)+pkg.HEADER_SIZE, 1)
+	return append(header, payload...)
+}
+
+// freePort 返回一个当前空闲的本地端口
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+func TestInstrumentRecordsSpanPerRequest(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	first, second := sqlBatchPacket("SELECT * FROM t WHERE id = 42"), sqlBatchPacket("RAISERROR('x', 16, 1)")
+	responses := [][]byte{doneResponse(3), pkg.BuildErrorResponse(50000, 16, "x")}
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i, request := range [][]byte{first, second} {
+			if _, err := io.ReadFull(conn, make([]byte, len(request))); err != nil {
+				return
+			}
+			conn.Write(responses[i])
+		}
+		io.Copy(io.Discard, conn)
+	}()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	port := freePort(t)
+	ba := pkg.NewBridgeAcceptor(port, backend.Addr().String())
+	Instrument(ba, WithTracer(provider.Tracer("test")))
+	if err := ba.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ba.Stop()
+
+	client, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	for i, request := range [][]byte{first, second} {
+		if _, err := client.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(client, make([]byte, len(responses[i]))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exporter.GetSpans()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+
+	ok := spans[0]
+	if ok.SpanKind != trace.SpanKindClient {
+		t.Errorf("span kind = %v, want client", ok.SpanKind)
+	}
+	if got := attributeValue(ok.Attributes, "db.statement"); got != pkg.NormalizeSQL("SELECT * FROM t WHERE id = 42") {
+		t.Errorf("db.statement = %q, want the redacted statement", got)
+	}
+	if got := attributeValue(ok.Attributes, "db.row_count"); got != "3" {
+		t.Errorf("db.row_count = %q, want 3", got)
+	}
+	if ok.Status.Code == codes.Error {
+		t.Errorf("successful request has error status %v", ok.Status)
+	}
+
+	failed := spans[1]
+	if failed.Status.Code != codes.Error || len(failed.Events) != 1 {
+		t.Errorf("failed request status = %v with %d events, want an error with one recorded error", failed.Status, len(failed.Events))
+	}
+}
+
+func attributeValue(attrs []attribute.KeyValue, key attribute.Key) string {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+)
+
+// SetAcceptRateLimit 限制接受新连接的速率(每秒perSecond个)，用于抵御连接洪泛。
+// 以令牌桶实现：空闲时最多积累burst个令牌，突发的burst个连接可立即接受；
+// 超出速率的连接在接受后立即关闭，不连接后端，并以匹配ErrAcceptRateLimit的错误触发连接拒绝事件。
+// perSecond不大于0时不限制，burst小于1时按1处理。需在Start之前调用。
+func (ba *BridgeAcceptor) SetAcceptRateLimit(perSecond float64, burst int) {
+	if perSecond <= 0 {
+		ba.acceptLimiter = nil
+		return
+	}
+	ba.acceptLimiter = newTokenBucket(perSecond, burst)
+}
+
+// tokenBucket 令牌桶限速器
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newTokenBucket 创建装满令牌的令牌桶
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	tb := &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+	tb.last = tb.now()
+	return tb
+}
+
+// allow 按经过的时间补充令牌，有令牌时取走一个并返回true
+func (tb *tokenBucket) allow() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+	}
+	tb.last = now
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	tb := newTokenBucket(2, 3)
+	tb.now = func() time.Time { return now }
+	tb.last = now
+
+	// 突发的burst个立即允许
+	for i := 0; i < 3; i++ {
+		if !tb.allow() {
+			t.Fatalf("burst request %d rejected", i)
+		}
+	}
+	if tb.allow() {
+		t.Fatal("request beyond burst allowed")
+	}
+
+	// 每秒补充2个
+	now = now.Add(500 * time.Millisecond)
+	if !tb.allow() {
+		t.Fatal("request after refill rejected")
+	}
+	if tb.allow() {
+		t.Fatal("second request after half a second allowed")
+	}
+
+	// 空闲再久也只积累burst个
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !tb.allow() {
+			t.Fatalf("request %d after idle rejected", i)
+		}
+	}
+	if tb.allow() {
+		t.Fatal("idle bucket exceeded burst")
+	}
+}
+
+func TestAcceptRateLimitRejectsExcess(t *testing.T) {
+	rejected := make(chan error, 8)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		// 速率足够低，测试期间不会补充令牌
+		ba.SetAcceptRateLimit(0.01, 3)
+		ba.SetConnectionRejectedHandler(func(_ net.Conn, err error) {
+			rejected <- err
+		})
+	})
+
+	var clients []*scriptedConn
+	for i := 0; i < 6; i++ {
+		clients = append(clients, h.connect())
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-rejected:
+			if !errors.Is(err, ErrAcceptRateLimit) {
+				t.Errorf("rejected with %v, want ErrAcceptRateLimit", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("only %d connections rejected, want 3", i)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		h.backend(i)
+	}
+	for i, client := range clients {
+		if closed := client.isClosed(); closed != (i >= 3) {
+			t.Errorf("client %d closed = %v, want %v", i, closed, i >= 3)
+		}
+	}
+	select {
+	case err := <-rejected:
+		t.Errorf("extra rejection %v", err)
+	default:
+	}
+}
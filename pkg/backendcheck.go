@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxBackendPacketLength 协商包大小的上限，后端的数据包不会超过它
+const maxBackendPacketLength = 0x8000
+
+// SetVerifyBackendProtocol 设置是否检查后端确实在使用TDS协议，用于发现端点配置错误(如指向了HTTP服务)。
+// 启用后检查后端发来的第一个数据包：须为长度合理的表格结果数据包，回应PreLogin时其有效载荷
+// 须是带VERSION选项的PreLogin选项表；以TLS记录开始的回应(TDS 8.0)不做检查。
+// 检查失败时不转发后端的数据，向客户端回复错误并关闭连接，以匹配ErrBackendProtocol的错误触发桥接异常事件。
+// 需在Start之前调用。
+func (ba *BridgeAcceptor) SetVerifyBackendProtocol(enabled bool) {
+	ba.verifyBackendProtocol = enabled
+}
+
+// verifyBackendProtocol 在不消耗数据的情况下检查后端的第一个数据包。
+// 读取错误留给转发循环报告，此处只返回说明协议不符之处的错误。
+func (bc *BridgedConnection) verifyBackendProtocol(reader *TDSReader) error {
+	isTLSRecord, err := reader.NextIsTLSRecord()
+	if err != nil || isTLSRecord {
+		return nil
+	}
+	frame, err := reader.r.Peek(HEADER_SIZE)
+	if err != nil {
+		return nil
+	}
+	header := NewTDSHeader(frame)
+	if header.Type() != TabularResult {
+		return fmt.Errorf("expected a tabular result packet, got bytes % X", frame)
+	}
+	length := header.LengthIncludingHeader()
+	if length < HEADER_SIZE || length > maxBackendPacketLength {
+		return fmt.Errorf("invalid packet length %d", length)
+	}
+
+	endOfMessage := (header.StatusBitMask() & END_OF_MESSAGE) == END_OF_MESSAGE
+	if !endOfMessage || HeaderType(bc.lastRequestType.Load()) != PreLoginMessage {
+		return nil
+	}
+	if frame, err = reader.r.Peek(length); err != nil {
+		return nil
+	}
+	options, err := ParsePreLoginOptions(frame[HEADER_SIZE:])
+	if err != nil {
+		return fmt.Errorf("invalid prelogin response: %w", err)
+	}
+	for _, option := range options {
+		if option.Token == PreLoginVersion && len(option.Data) >= 6 {
+			return nil
+		}
+	}
+	return errors.New("prelogin response has no VERSION option")
+}
+
+// failBackendProtocol 记录后端协议不符为断开原因，并向客户端说明；
+// 连接随后由转发goroutine的退出关闭
+func (bc *BridgedConnection) failBackendProtocol(err error) error {
+	bc.mu.Lock()
+	if bc.disconnectReason == nil {
+		bc.disconnectReason = ErrBackendProtocol
+	}
+	bc.mu.Unlock()
+
+	bc.writeToClient(BuildErrorResponse(BRIDGE_ERROR_BACKEND_PROTOCOL, 20,
+		"The bridge backend did not respond as a SQL Server. Check the bridge configuration."))
+	return newBridgeError(ErrBackendProtocol, "verify backend", err)
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestVerifyBackendProtocolDetectsHTTPServer(t *testing.T) {
+	// 与nginx等HTTP服务一样，收到不是HTTP请求行的数据就回复400并关闭连接
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 4096))
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+	}()
+
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetVerifyBackendProtocol(true)
+		// 端点配置错误：指向了HTTP服务
+		ba.dialFunc = func(string) (net.Conn, error) {
+			return net.Dial("tcp", listener.Addr().String())
+		}
+	})
+	client := h.connect(preLoginPacket(ENCRYPT_OFF))
+
+	firstMatching(t, exceptions, ErrBackendProtocol)
+	waitFor(t, "client closed", client.isClosed)
+	written := client.Written()
+	if bytes.Contains(written, []byte("HTTP/")) {
+		t.Errorf("client received the HTTP response %q", written)
+	}
+	errs := responseErrors(t, written)
+	if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_BACKEND_PROTOCOL {
+		t.Errorf("client received errors %+v, want one %d", errs, BRIDGE_ERROR_BACKEND_PROTOCOL)
+	}
+}
+
+func TestVerifyBackendProtocolAcceptsPreLoginResponse(t *testing.T) {
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetVerifyBackendProtocol(true)
+	})
+	client := h.connect(preLoginPacket(ENCRYPT_OFF))
+	backend := h.backend(0)
+	waitWritten(t, backend, len(preLoginPacket(ENCRYPT_OFF)))
+
+	response := bridgePreLoginResponse()
+	backend.feed(response)
+	if written := waitWritten(t, client, len(response)); !bytes.Equal(written, response) {
+		t.Errorf("client received %x, want the PreLogin response %x", written, response)
+	}
+	select {
+	case err := <-exceptions:
+		t.Errorf("unexpected exception %v", err)
+	default:
+	}
+}
+
+func TestVerifyBackendProtocolRejectsMalformedPreLoginResponse(t *testing.T) {
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetVerifyBackendProtocol(true)
+	})
+	client := h.connect(preLoginPacket(ENCRYPT_OFF))
+	backend := h.backend(0)
+	waitWritten(t, backend, len(preLoginPacket(ENCRYPT_OFF)))
+
+	// 数据包头部正确，但有效载荷不是PreLogin选项表
+	backend.feed(buildPacket(TabularResult, END_OF_MESSAGE, 1, []byte{0x05, 0x00}))
+	firstMatching(t, exceptions, ErrBackendProtocol)
+	waitFor(t, "client closed", client.isClosed)
+}
@@ -0,0 +1,44 @@
+package pkg
+
+import "sync"
+
+// backendHealth 健康检查器报告的后端状态
+type backendHealth struct {
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+// set 记录后端的状态
+func (h *backendHealth) set(endpoint string, healthy bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.down == nil {
+		h.down = make(map[string]bool)
+	}
+	h.down[endpoint] = !healthy
+}
+
+// isDown 检查后端是否被标记为不健康，未报告过的后端视为健康
+func (h *backendHealth) isDown(endpoint string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.down[endpoint]
+}
+
+// SetBackendHealthy 由健康检查器报告后端端点的状态，未报告过的后端视为健康。
+// 启用SetRejectWhenAllBackendsDown时，连接选中的后端不健康则快速拒绝；后端恢复健康后自动恢复接受。
+// 可在运行中调用。
+func (ba *BridgeAcceptor) SetBackendHealthy(endpoint string, healthy bool) {
+	ba.backendHealth.set(endpoint, healthy)
+}
+
+// SetRejectWhenAllBackendsDown 设置在连接可选的后端都不健康时快速拒绝新连接，而不是等待连接后端超时。
+// 检查在连接策略(ConnectionConfig.Backend)和后端选择函数确定端点之后进行：选中的端点不健康时不拨号，
+// 按SetBackendRetries重新选择，每次选中的端点都不健康才拒绝。没有后端选择函数时端点是固定的，
+// 即该端点不健康时拒绝。被拒绝的客户端收到说明没有健康后端的TDS错误后被关闭
+// (客户端要求加密时直接关闭)，并触发连接拒绝事件，原因匹配ErrNoHealthyBackend。
+func (ba *BridgeAcceptor) SetRejectWhenAllBackendsDown(enabled bool) {
+	ba.rejectWhenAllBackendsDown = enabled
+}
@@ -0,0 +1,157 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// healthHarness 创建启用快速拒绝的装置，返回连接拒绝原因
+func healthHarness(t *testing.T) (*bridgeHarness, chan error) {
+	rejected := make(chan error, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetRejectWhenAllBackendsDown(true)
+		ba.SetConnectionRejectedHandler(func(conn net.Conn, err error) {
+			rejected <- err
+		})
+	})
+	return h, rejected
+}
+
+// dialCount 获取后端拨号次数
+func (h *bridgeHarness) dialCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.dialed)
+}
+
+func TestRejectWhenBackendDownAndRecover(t *testing.T) {
+	h, rejected := healthHarness(t)
+	h.ba.SetBackendHealthy(h.ba.sqlServerEndpoint, false)
+
+	client := h.connect(testLogin7{version: TDSVersion74, user: "app"}.packet())
+	errs := responseErrors(t, waitWritten(t, client, 1))
+	if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_NO_HEALTHY_BACKEND {
+		t.Fatalf("client errors = %v, want the no healthy backend error", errs)
+	}
+	waitFor(t, "client closed", client.isClosed)
+	if err := <-rejected; !errors.Is(err, ErrNoHealthyBackend) {
+		t.Errorf("rejection reason = %v, want ErrNoHealthyBackend", err)
+	}
+	if n := h.dialCount(); n != 0 {
+		t.Errorf("dialed the backend %d times while it was down", n)
+	}
+
+	// 后端恢复健康后自动恢复接受
+	h.ba.SetBackendHealthy(h.ba.sqlServerEndpoint, true)
+	batch := sqlBatchPacket("SELECT 1")
+	h.connect(batch)
+	waitWritten(t, h.backend(0), len(batch))
+}
+
+func TestRejectChecksRouteBackend(t *testing.T) {
+	h, rejected := healthHarness(t)
+	// 其他路由的后端不健康不影响本路由
+	h.ba.SetBackendHealthy("other:1433", false)
+	batch := sqlBatchPacket("SELECT 1")
+	h.connect(batch)
+	waitWritten(t, h.backend(0), len(batch))
+	select {
+	case err := <-rejected:
+		t.Fatalf("connection rejected with %v while its backend is healthy", err)
+	default:
+	}
+
+	// 本路由的后端不健康时即使其他后端健康也拒绝
+	h.ba.SetBackendHealthy("other:1433", true)
+	h.ba.SetBackendHealthy(h.ba.sqlServerEndpoint, false)
+	h.connect(testLogin7{version: TDSVersion74, user: "app"}.packet())
+	if err := <-rejected; !errors.Is(err, ErrNoHealthyBackend) {
+		t.Errorf("rejection reason = %v, want ErrNoHealthyBackend", err)
+	}
+	if n := h.dialCount(); n != 1 {
+		t.Errorf("dialed the backend %d times, want only the first connection", n)
+	}
+}
+
+func TestRejectChecksPolicyBackend(t *testing.T) {
+	h, rejected := healthHarness(t)
+	h.ba.SetConnectionPolicyHandler(func(info *AcceptInfo) (*ConnectionConfig, error) {
+		return &ConnectionConfig{Backend: "healthy:1433"}, nil
+	})
+	// 路由的默认后端不健康，但连接策略选择了健康的后端
+	h.ba.SetBackendHealthy(h.ba.sqlServerEndpoint, false)
+
+	batch := sqlBatchPacket("SELECT 1")
+	h.connect(batch)
+	waitWritten(t, h.backend(0), len(batch))
+	select {
+	case err := <-rejected:
+		t.Fatalf("connection rejected with %v while the chosen backend is healthy", err)
+	default:
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.dialed) != 1 || h.dialed[0] != "healthy:1433" {
+		t.Errorf("dialed %v, want the policy backend", h.dialed)
+	}
+}
+
+func TestRejectRetriesSelectorPastDownBackend(t *testing.T) {
+	h, rejected := healthHarness(t)
+	var picks []string
+	h.ba.SetBackendSelector(func(client net.Conn, login *Login7Message) (string, error) {
+		endpoint := []string{"down:1433", "up:1433"}[len(picks)%2]
+		picks = append(picks, endpoint)
+		return endpoint, nil
+	}, false)
+	h.ba.SetBackendRetries(1)
+	h.ba.SetBackendHealthy("down:1433", false)
+
+	// 选择函数先选中不健康的后端，重试时选中健康的后端
+	batch := sqlBatchPacket("SELECT 1")
+	h.connect(batch)
+	waitWritten(t, h.backend(0), len(batch))
+	h.mu.Lock()
+	dialed := append([]string(nil), h.dialed...)
+	h.mu.Unlock()
+	if len(dialed) != 1 || dialed[0] != "up:1433" {
+		t.Errorf("dialed %v, want only the healthy backend", dialed)
+	}
+
+	// 每次选中的后端都不健康时拒绝
+	h.ba.SetBackendHealthy("up:1433", false)
+	client := h.connect(testLogin7{version: TDSVersion74, user: "app"}.packet())
+	if err := <-rejected; !errors.Is(err, ErrNoHealthyBackend) {
+		t.Errorf("rejection reason = %v, want ErrNoHealthyBackend", err)
+	}
+	errs := responseErrors(t, waitWritten(t, client, 1))
+	if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_NO_HEALTHY_BACKEND {
+		t.Errorf("client errors = %v, want the no healthy backend error", errs)
+	}
+}
+
+func TestRejectAfterPeekedLogin(t *testing.T) {
+	h, rejected := healthHarness(t)
+	h.ba.SetBackendSelector(func(client net.Conn, login *Login7Message) (string, error) {
+		return "down:1433", nil
+	}, true)
+	h.ba.SetBackendHealthy("down:1433", false)
+
+	// 桥接器已回应PreLogin并读取了登录，直接回复错误
+	preLogin := preLoginPacket(ENCRYPT_OFF)
+	login := testLogin7{version: TDSVersion74, user: "app"}.packet()
+	client := h.connect(preLogin, login)
+	if err := <-rejected; !errors.Is(err, ErrNoHealthyBackend) {
+		t.Fatalf("rejection reason = %v, want ErrNoHealthyBackend", err)
+	}
+	waitFor(t, "client closed", client.isClosed)
+	written := client.Written()
+	response := written[NewTDSHeader(written).LengthIncludingHeader():]
+	if errs := responseErrors(t, response); len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_NO_HEALTHY_BACKEND {
+		t.Errorf("login response errors = %v, want the no healthy backend error", errs)
+	}
+	if n := h.dialCount(); n != 0 {
+		t.Errorf("dialed the backend %d times while it was down", n)
+	}
+}
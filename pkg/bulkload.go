@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BulkLoadMessage 批量加载(BCP)数据消息
+type BulkLoadMessage struct {
+	*BaseTDSMessage
+}
+
+// NewBulkLoadMessage 创建新的BulkLoadMessage
+func NewBulkLoadMessage() *BulkLoadMessage {
+	return &BulkLoadMessage{
+		BaseTDSMessage: NewBaseTDSMessage(),
+	}
+}
+
+// NewBulkLoadMessageWithPacket 从第一个数据包创建新的BulkLoadMessage
+func NewBulkLoadMessageWithPacket(firstPacket *TDSPacket) *BulkLoadMessage {
+	return &BulkLoadMessage{
+		BaseTDSMessage: NewBaseTDSMessageWithPacket(firstPacket),
+	}
+}
+
+func (m *BulkLoadMessage) String() string {
+	if m.IsComplete() {
+		sb := strings.Builder{}
+		sb.WriteString("BulkLoadMessage")
+		sb.WriteString(fmt.Sprintf("[#Packets=%d;IsComplete=%v;HasIgnoreBitSet=%v;TotalPayloadSize=%d",
+			len(m.Packets), m.IsComplete(), m.HasIgnoreBitSet(), len(m.AssemblePayload())))
+
+		for i, packet := range m.Packets {
+			sb.WriteString(fmt.Sprintf("\n\t[P%d[%s]]", i, packet))
+		}
+
+		sb.WriteString("]")
+		return sb.String()
+	}
+	return "BulkLoadMessage{Incomplete message}"
+}
+
+// isInsertBulk 检查批处理文本是否为INSERT BULK语句
+func isInsertBulk(text string) bool {
+	fields := strings.Fields(strings.ToUpper(text))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "INSERT" && fields[i+1] == "BULK" {
+			return true
+		}
+	}
+	return false
+}
+
+// correlateBulkLoad 将INSERT BULK批处理与随后的批量加载数据关联起来。
+// 同一连接上INSERT BULK之后的下一个客户端消息若为批量加载数据，则触发批量插入事件；
+// 其他任何消息都会清除待关联的批处理。
+func (bc *BridgedConnection) correlateBulkLoad(msg TDSMessage) {
+	switch m := msg.(type) {
+	case *SQLBatchMessage:
+		if isInsertBulk(m.GetBatchText()) {
+			bc.pendingInsertBulk = m
+			return
+		}
+	case *BulkLoadMessage:
+		if bc.pendingInsertBulk != nil {
+			bc.onBulkInsert(bc.pendingInsertBulk, m)
+		}
+	}
+	bc.pendingInsertBulk = nil
+}
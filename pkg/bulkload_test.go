@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// bulkInsertEvent 批量插入事件的参数
+type bulkInsertEvent struct {
+	batch *SQLBatchMessage
+	data  *BulkLoadMessage
+}
+
+func bulkInsertHarness(t *testing.T) (*bridgeHarness, chan bulkInsertEvent) {
+	events := make(chan bulkInsertEvent, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBulkInsertHandler(func(bc *BridgedConnection, batch *SQLBatchMessage, data *BulkLoadMessage) {
+			events <- bulkInsertEvent{batch, data}
+		})
+	})
+	return h, events
+}
+
+func TestBulkInsertCorrelation(t *testing.T) {
+	h, events := bulkInsertHarness(t)
+
+	rows := bytes.Repeat([]byte{0xD1, 0x01, 0x00, 0x00, 0x00}, 10)
+	h.connect(
+		sqlBatchPacket("insert bulk dbo.t ([id] int)"),
+		buildPacket(BulkLoadData, 0, 1, rows[:20]),
+		buildPacket(BulkLoadData, END_OF_MESSAGE, 2, rows[20:]),
+	)
+
+	select {
+	case ev := <-events:
+		if text := ev.batch.GetBatchText(); text != "insert bulk dbo.t ([id] int)" {
+			t.Fatalf("batch text = %q", text)
+		}
+		if len(ev.data.Packets) != 2 {
+			t.Fatalf("bulk data has %d packets, want 2", len(ev.data.Packets))
+		}
+		if payload := ev.data.AssemblePayload(); !bytes.Equal(payload, rows) {
+			t.Fatalf("bulk data payload = % x, want % x", payload, rows)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("bulk insert event not fired")
+	}
+}
+
+func TestBulkInsertCorrelationClearedByOtherMessage(t *testing.T) {
+	h, events := bulkInsertHarness(t)
+
+	h.connect(
+		sqlBatchPacket("INSERT BULK dbo.t ([id] int)"),
+		sqlBatchPacket("SELECT 1"),
+		buildPacket(BulkLoadData, END_OF_MESSAGE, 1, []byte{0xFD}),
+	)
+	waitWritten(t, h.backend(0), len(sqlBatchPacket("INSERT BULK dbo.t ([id] int)"))+len(sqlBatchPacket("SELECT 1"))+HEADER_SIZE+1)
+
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected bulk insert event for %q", ev.batch.GetBatchText())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestIsInsertBulk(t *testing.T) {
+	for text, want := range map[string]bool{
+		"INSERT BULK dbo.t (a int)":     true,
+		"insert\n\tbulk dbo.t (a int)":  true,
+		"INSERT INTO dbo.t VALUES (1)":  false,
+		"BULK INSERT dbo.t FROM 'file'": false,
+	} {
+		if got := isInsertBulk(text); got != want {
+			t.Errorf("isInsertBulk(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
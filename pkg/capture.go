@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// CAPTURE_FLUSH_INTERVAL 捕获写入器定期刷新缓冲(如gzip)的间隔，使捕获文件在运行中也可读取
+const CAPTURE_FLUSH_INTERVAL = time.Second
+
+// 捕获文件中每帧的记录头：时间戳(UnixNano)、连接ID、来源、数据长度，均为大端
+const captureFrameHeaderSize = 8 + 8 + 1 + 4
+
+// CaptureFrame 捕获的一帧：桥接器从一侧收到的一个TDS数据包或原始TLS记录
+type CaptureFrame struct {
+	Time         time.Time
+	ConnectionID uint64
+	// Source 数据来源：ClientBridge为客户端发往服务器，BridgeSQL为服务器发往客户端
+	Source ConnectionType
+	Data   []byte
+}
+
+// CaptureWriter 将帧按顺序写入io.Writer，多个连接并发写入时按帧串行化。
+// 底层写入器实现Flush() error时(如*gzip.Writer、*bufio.Writer)，每CAPTURE_FLUSH_INTERVAL刷新一次。
+// 压缩捕获可将gzip.NewWriter(f)传给NewCaptureWriter，或使用BridgeAcceptor.SetGzipCaptureFile。
+type CaptureWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	flusher interface{ Flush() error }
+	closers []io.Closer
+	done    chan struct{}
+	closed  bool
+	err     error
+}
+
+// NewCaptureWriter 创建新的CaptureWriter
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	cw := &CaptureWriter{
+		w:    w,
+		done: make(chan struct{}),
+	}
+	if flusher, ok := w.(interface{ Flush() error }); ok {
+		cw.flusher = flusher
+		go cw.flushLoop()
+	}
+	return cw
+}
+
+// flushLoop 定期刷新底层写入器
+func (cw *CaptureWriter) flushLoop() {
+	ticker := time.NewTicker(CAPTURE_FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cw.Flush()
+		case <-cw.done:
+			return
+		}
+	}
+}
+
+// WriteFrame 写入一帧。写入失败后不再写入，并一直返回第一个错误。
+func (cw *CaptureWriter) WriteFrame(frame *CaptureFrame) error {
+	header := make([]byte, captureFrameHeaderSize, captureFrameHeaderSize+len(frame.Data))
+	binary.BigEndian.PutUint64(header[0:], uint64(frame.Time.UnixNano()))
+	binary.BigEndian.PutUint64(header[8:], frame.ConnectionID)
+	header[16] = byte(frame.Source)
+	binary.BigEndian.PutUint32(header[17:], uint32(len(frame.Data)))
+	record := append(header, frame.Data...)
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.err != nil {
+		return cw.err
+	}
+	_, cw.err = cw.w.Write(record)
+	return cw.err
+}
+
+// Flush 刷新底层写入器的缓冲
+func (cw *CaptureWriter) Flush() error {
+	if cw.flusher == nil {
+		return nil
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.closed {
+		return nil
+	}
+	return cw.flusher.Flush()
+}
+
+// Close 停止定期刷新，刷新缓冲并关闭由CaptureWriter拥有的底层资源。之后的写入返回ErrCaptureClosed。
+func (cw *CaptureWriter) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	close(cw.done)
+
+	var err error
+	if cw.flusher != nil {
+		err = cw.flusher.Flush()
+	}
+	if cw.err == nil {
+		cw.err = ErrCaptureClosed
+	}
+	for _, closer := range cw.closers {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	cw.closers = nil
+	return err
+}
+
+// newGzipCaptureFile 创建写入gzip压缩文件的CaptureWriter，关闭时依次关闭gzip流和文件
+func newGzipCaptureFile(path string) (*CaptureWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	zw := gzip.NewWriter(f)
+	cw := NewCaptureWriter(zw)
+	cw.closers = []io.Closer{zw, f}
+	return cw, nil
+}
+
+// CaptureReader 读取CaptureWriter写入的帧
+type CaptureReader struct {
+	r io.Reader
+}
+
+// NewCaptureReader 创建新的CaptureReader，gzip压缩的捕获需先用gzip.NewReader包装
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{r: r}
+}
+
+// Next 读取下一帧，捕获结束时返回io.EOF
+func (cr *CaptureReader) Next() (*CaptureFrame, error) {
+	header := make([]byte, captureFrameHeaderSize)
+	if _, err := io.ReadFull(cr.r, header); err != nil {
+		return nil, err
+	}
+	frame := &CaptureFrame{
+		Time:         time.Unix(0, int64(binary.BigEndian.Uint64(header[0:]))),
+		ConnectionID: binary.BigEndian.Uint64(header[8:]),
+		Source:       ConnectionType(header[16]),
+		Data:         make([]byte, binary.BigEndian.Uint32(header[17:])),
+	}
+	if _, err := io.ReadFull(cr.r, frame.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("capture frame: %w", err)
+	}
+	return frame, nil
+}
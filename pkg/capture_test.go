@@ -0,0 +1,203 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readCaptureFrames 读取捕获数据中的所有帧
+func readCaptureFrames(t testing.TB, data []byte) []*CaptureFrame {
+	t.Helper()
+	reader := NewCaptureReader(bytes.NewReader(data))
+	var frames []*CaptureFrame
+	for {
+		frame, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("read capture: %v", err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestCaptureRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewCaptureWriter(&buf)
+	frames := []*CaptureFrame{
+		{Time: time.Unix(1700000000, 1), ConnectionID: 1, Source: ClientBridge, Data: sqlBatchPacket("SELECT 1")},
+		{Time: time.Unix(1700000000, 2), ConnectionID: 1, Source: BridgeSQL, Data: doneResponse(DONE_FINAL, 0)},
+		{Time: time.Unix(1700000000, 3), ConnectionID: 2, Source: ClientBridge, Data: nil},
+	}
+	for _, frame := range frames {
+		if err := cw.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame: %v", err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := cw.WriteFrame(frames[0]); !errors.Is(err, ErrCaptureClosed) {
+		t.Errorf("WriteFrame after Close = %v, want ErrCaptureClosed", err)
+	}
+
+	got := readCaptureFrames(t, buf.Bytes())
+	if len(got) != len(frames) {
+		t.Fatalf("read %d frames, want %d", len(got), len(frames))
+	}
+	for i, frame := range frames {
+		if !got[i].Time.Equal(frame.Time) || got[i].ConnectionID != frame.ConnectionID ||
+			got[i].Source != frame.Source || !bytes.Equal(got[i].Data, frame.Data) {
+			t.Errorf("frame %d = %+v, want %+v", i, got[i], frame)
+		}
+	}
+}
+
+func TestCaptureReaderTruncatedFrame(t *testing.T) {
+	var buf bytes.Buffer
+	NewCaptureWriter(&buf).WriteFrame(&CaptureFrame{Time: time.Now(), Data: sqlBatchPacket("SELECT 1")})
+	_, err := NewCaptureReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1])).Next()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Next() = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestGzipCaptureFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.gz")
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		if err := ba.SetGzipCaptureFile(path); err != nil {
+			t.Fatalf("SetGzipCaptureFile: %v", err)
+		}
+	})
+	batch := sqlBatchPacket("SELECT 1")
+	response := doneResponse(DONE_FINAL, 1)
+	client := h.connect(batch)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(batch))
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+	h.ba.Stop()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("read gzip capture: %v", err)
+	}
+	frames := readCaptureFrames(t, data)
+	if len(frames) != 2 {
+		t.Fatalf("captured %d frames, want 2", len(frames))
+	}
+	if frames[0].Source != ClientBridge || !bytes.Equal(frames[0].Data, batch) {
+		t.Errorf("first frame = %s %x, want the client batch", frames[0].Source, frames[0].Data)
+	}
+	if frames[1].Source != BridgeSQL || !bytes.Equal(frames[1].Data, response) {
+		t.Errorf("second frame = %s %x, want the server response", frames[1].Source, frames[1].Data)
+	}
+}
+
+func TestGzipCaptureReadableAfterFlush(t *testing.T) {
+	var buf syncBuffer
+	zw := gzip.NewWriter(&buf)
+	cw := NewCaptureWriter(zw)
+	defer cw.Close()
+	frame := &CaptureFrame{Time: time.Now(), ConnectionID: 7, Source: ClientBridge, Data: sqlBatchPacket("SELECT 1")}
+	cw.WriteFrame(frame)
+	if err := cw.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	// gzip流尚未结束，但已刷新的帧可以读出
+	zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := NewCaptureReader(zr).Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if got.ConnectionID != 7 || !bytes.Equal(got.Data, frame.Data) {
+		t.Errorf("read %+v, want %+v", got, frame)
+	}
+}
+
+func TestCaptureEnabledMidSession(t *testing.T) {
+	h := newBridgeHarness(t, nil)
+	before, during, after := sqlBatchPacket("SELECT 1"), sqlBatchPacket("SELECT 2"), sqlBatchPacket("SELECT 3")
+	client := h.connect(before)
+	backend := h.backend(0)
+	sent := waitWritten(t, backend, len(before))
+
+	var capture syncBuffer
+	h.ba.SetCaptureWriter(&capture)
+	client.feed(during)
+	sent = waitWritten(t, backend, len(sent)+len(during))
+	response := doneResponse(DONE_FINAL, 0)
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+
+	h.ba.SetCaptureWriter(nil)
+	client.feed(after)
+	waitWritten(t, backend, len(sent)+len(after))
+
+	frames := readCaptureFrames(t, capture.Bytes())
+	if len(frames) != 2 {
+		t.Fatalf("captured %d frames, want only the 2 sent while capture was on", len(frames))
+	}
+	if frames[0].Source != ClientBridge || !bytes.Equal(frames[0].Data, during) {
+		t.Errorf("first frame = %s %x, want the mid-session batch", frames[0].Source, frames[0].Data)
+	}
+	if frames[1].Source != BridgeSQL || !bytes.Equal(frames[1].Data, response) {
+		t.Errorf("second frame = %s %x, want the response", frames[1].Source, frames[1].Data)
+	}
+}
+
+func TestCaptureToggledDuringTraffic(t *testing.T) {
+	h := newBridgeHarness(t, nil)
+	client := h.connect()
+	backend := h.backend(0)
+
+	// 转发与切换捕获并发进行，由竞态检测器检查同步
+	batch := sqlBatchPacket("SELECT 1")
+	const batches = 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < batches; i++ {
+			client.feed(batch)
+		}
+	}()
+	var captures []*syncBuffer
+	for i := 0; i < 20; i++ {
+		capture := &syncBuffer{}
+		captures = append(captures, capture)
+		h.ba.SetCaptureWriter(capture)
+		time.Sleep(time.Millisecond)
+		h.ba.SetCaptureWriter(nil)
+	}
+	<-done
+	waitWritten(t, backend, batches*len(batch))
+
+	// 每个捕获都是完整可读的帧
+	for _, capture := range captures {
+		for _, frame := range readCaptureFrames(t, capture.Bytes()) {
+			if !bytes.Equal(frame.Data, batch) {
+				t.Fatalf("captured frame %x, want a complete batch", frame.Data)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ChaosOptions 单方向的混沌测试选项
+type ChaosOptions struct {
+	// Latency 每个数据包转发前附加的固定延迟
+	Latency time.Duration
+	// LatencyJitter 附加的随机延迟上限，实际延迟为 Latency + [0, LatencyJitter)
+	LatencyJitter time.Duration
+	// DropProbability 丢弃数据包的概率(0~1)
+	DropProbability float64
+	// CorruptProbability 随机损坏有效载荷中一个字节的概率(0~1)
+	CorruptProbability float64
+	// DropEndOfMessage 是否允许丢弃带END_OF_MESSAGE的数据包。
+	// 丢弃消息的最后一个包会使服务器一直等待，客户端因此挂起，仅在测试有意如此时开启。
+	DropEndOfMessage bool
+	// MessageLatency 按消息类型附加的延迟，在转发该类型消息的最后一个数据包前等待一次，
+	// 多包消息只延迟一次；如{RPC: 200 * time.Millisecond}只使RPC请求变慢。
+	// 与Latency叠加，未列出的类型不受影响。
+	MessageLatency map[HeaderType]time.Duration
+}
+
+// ChaosPolicy 混沌测试策略，按方向分别配置。
+// 缓存到END_OF_MESSAGE后一次转发的客户端消息(登录改写、消息拦截、转发改写)只应用MessageLatency，
+// 不应用逐包的Latency、丢弃和损坏。
+type ChaosPolicy struct {
+	ClientToServer *ChaosOptions
+	ServerToClient *ChaosOptions
+}
+
+// delayMessage 在转发headerType类型消息的最后一个数据包前按MessageLatency等待
+func (o *ChaosOptions) delayMessage(headerType HeaderType) {
+	if o == nil {
+		return
+	}
+	if delay := o.MessageLatency[headerType]; delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// apply 对即将转发的数据应用延迟、丢弃和损坏。
+// 返回实际要转发的数据(损坏时为副本，不修改原缓冲区)以及是否丢弃。
+func (o *ChaosOptions) apply(data []byte, endOfMessage bool) ([]byte, bool) {
+	if o == nil {
+		return data, false
+	}
+
+	// 延迟
+	delay := o.Latency
+	if o.LatencyJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(o.LatencyJitter)))
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	// 丢弃
+	if o.DropProbability > 0 && rand.Float64() < o.DropProbability {
+		if !endOfMessage || o.DropEndOfMessage {
+			return data, true
+		}
+	}
+
+	// 损坏
+	if o.CorruptProbability > 0 && len(data) > 0 && rand.Float64() < o.CorruptProbability {
+		corrupted := make([]byte, len(data))
+		copy(corrupted, data)
+		corrupted[rand.Intn(len(corrupted))] ^= byte(rand.Intn(255) + 1)
+		return corrupted, false
+	}
+
+	return data, false
+}
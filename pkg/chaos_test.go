@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestChaosLatencyApplied(t *testing.T) {
+	opts := &ChaosOptions{Latency: 30 * time.Millisecond, LatencyJitter: 10 * time.Millisecond}
+	start := time.Now()
+	if _, drop := opts.apply([]byte{1, 2, 3}, true); drop {
+		t.Fatal("packet dropped without a drop probability")
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("apply returned after %v, want at least 30ms", elapsed)
+	}
+}
+
+func TestChaosLatencyDelaysForwarding(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetChaosPolicy(&ChaosPolicy{ClientToServer: &ChaosOptions{Latency: 50 * time.Millisecond}})
+	})
+	packet := sqlBatchPacket("SELECT 1")
+	start := time.Now()
+	h.connect(packet)
+	waitWritten(t, h.backend(0), len(packet))
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("packet forwarded after %v, want at least 50ms", elapsed)
+	}
+}
+
+func TestChaosDropProbability(t *testing.T) {
+	opts := &ChaosOptions{DropProbability: 0.3}
+	const n = 20000
+	dropped := 0
+	for i := 0; i < n; i++ {
+		if _, drop := opts.apply([]byte{1}, false); drop {
+			dropped++
+		}
+	}
+	if rate := float64(dropped) / n; rate < 0.27 || rate > 0.33 {
+		t.Fatalf("drop rate = %.3f, want about 0.3", rate)
+	}
+}
+
+func TestChaosKeepsEndOfMessageByDefault(t *testing.T) {
+	opts := &ChaosOptions{DropProbability: 1}
+	if _, drop := opts.apply([]byte{1}, true); drop {
+		t.Fatal("END_OF_MESSAGE packet dropped without DropEndOfMessage")
+	}
+	opts.DropEndOfMessage = true
+	if _, drop := opts.apply([]byte{1}, true); !drop {
+		t.Fatal("END_OF_MESSAGE packet not dropped with DropEndOfMessage")
+	}
+}
+
+func TestChaosCorruptsCopy(t *testing.T) {
+	opts := &ChaosOptions{CorruptProbability: 1}
+	data := []byte{1, 2, 3, 4}
+	original := append([]byte(nil), data...)
+	corrupted, _ := opts.apply(data, false)
+	if bytes.Equal(corrupted, original) {
+		t.Fatal("data not corrupted")
+	}
+	if !bytes.Equal(data, original) {
+		t.Fatal("original buffer modified")
+	}
+}
+
+func TestChaosMessageLatencyDelaysOnlyRPC(t *testing.T) {
+	const delay = 150 * time.Millisecond
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetChaosPolicy(&ChaosPolicy{ClientToServer: &ChaosOptions{
+			MessageLatency: map[HeaderType]time.Duration{RPC: delay},
+		}})
+	})
+	batch := sqlBatchPacket("SELECT 1")
+	start := time.Now()
+	client := h.connect(batch)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(batch))
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("SQLBatch forwarded after %v, want it unaffected by the RPC latency", elapsed)
+	}
+
+	// 三个数据包的RPC只延迟一次
+	payload := rpcPayload("dbo.usp_slow", 0, intParam("@id", 1))
+	rpc := append(buildPacket(RPC, NORMAL, 1, payload[:10]), buildPacket(RPC, NORMAL, 2, payload[10:20])...)
+	rpc = append(rpc, buildPacket(RPC, END_OF_MESSAGE, 3, payload[20:])...)
+	start = time.Now()
+	client.feed(rpc)
+	waitWritten(t, backend, len(batch)+len(rpc))
+	elapsed := time.Since(start)
+	if elapsed < delay {
+		t.Errorf("RPC forwarded after %v, want at least %v", elapsed, delay)
+	}
+	if elapsed >= 2*delay {
+		t.Errorf("RPC forwarded after %v, want a single %v delay for the whole message", elapsed, delay)
+	}
+}
+
+func TestChaosHeldMessageOnlyDelayed(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetChaosPolicy(&ChaosPolicy{ClientToServer: &ChaosOptions{
+			CorruptProbability: 1,
+			MessageLatency:     map[HeaderType]time.Duration{SQLBatch: delay},
+		}})
+		// 拦截函数使消息缓存到END_OF_MESSAGE后一次转发
+		ba.SetMessageInterceptor(func(*BridgedConnection, TDSMessage) ([]byte, bool) { return nil, false })
+	})
+	batch := sqlBatchPacket("SELECT 1")
+	start := time.Now()
+	h.connect(batch)
+	got := waitWritten(t, h.backend(0), len(batch))
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("held SQLBatch forwarded after %v, want at least %v", elapsed, delay)
+	}
+	if !bytes.Equal(got, batch) {
+		t.Errorf("held message was corrupted: %x", got)
+	}
+}
@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// 排序规则标志位(位于前4字节中LCID之后)
+const (
+	CollationIgnoreCase   = 1 << 0
+	CollationIgnoreAccent = 1 << 1
+	CollationIgnoreKana   = 1 << 2
+	CollationIgnoreWidth  = 1 << 3
+	CollationBinary       = 1 << 4
+	CollationBinary2      = 1 << 5
+	CollationUTF8         = 1 << 6
+)
+
+// sqlSortNames 常见SQL排序规则的SortID
+var sqlSortNames = map[byte]string{
+	30: "SQL_Latin1_General_CP437_BIN",
+	31: "SQL_Latin1_General_CP437_CS_AS",
+	32: "SQL_Latin1_General_CP437_CI_AS",
+	33: "SQL_Latin1_General_Pref_CP437_CI_AS",
+	34: "SQL_Latin1_General_CP437_CI_AI",
+	40: "SQL_Latin1_General_CP850_BIN",
+	41: "SQL_Latin1_General_CP850_CS_AS",
+	42: "SQL_Latin1_General_CP850_CI_AS",
+	43: "SQL_Latin1_General_Pref_CP850_CI_AS",
+	44: "SQL_Latin1_General_CP850_CI_AI",
+	51: "SQL_Latin1_General_CP1_CS_AS",
+	52: "SQL_Latin1_General_CP1_CI_AS",
+	53: "SQL_Latin1_General_Pref_CP1_CI_AS",
+	54: "SQL_Latin1_General_CP1_CI_AI",
+}
+
+// windowsCollationNames 常见LCID对应的Windows排序规则名前缀
+var windowsCollationNames = map[uint32]string{
+	0x0401: "Arabic",
+	0x0404: "Chinese_Taiwan_Stroke",
+	0x0405: "Czech",
+	0x0408: "Greek",
+	0x0409: "Latin1_General",
+	0x040D: "Hebrew",
+	0x0411: "Japanese",
+	0x0412: "Korean_Wansung",
+	0x0415: "Polish",
+	0x0419: "Cyrillic_General",
+	0x041E: "Thai",
+	0x041F: "Turkish",
+	0x0804: "Chinese_PRC",
+	0x0C0A: "Modern_Spanish",
+}
+
+// collationVersionSuffixes 排序规则版本对应的名称后缀
+var collationVersionSuffixes = map[byte]string{
+	1: "_90",
+	2: "_100",
+	3: "_140",
+}
+
+// CollationInfo 解码后的5字节排序规则(COLLATION)
+type CollationInfo struct {
+	LCID    uint32
+	Flags   byte
+	Version byte
+	// SortID 非0时为SQL排序规则，此时LCID和标志仅供参考
+	SortID byte
+}
+
+// Decode 解码5字节的排序规则
+func (c *CollationInfo) Decode(b []byte) error {
+	if len(b) < collationSize {
+		return fmt.Errorf("%w: collation: %d bytes, need %d", ErrProtocol, len(b), collationSize)
+	}
+	v := binary.LittleEndian.Uint32(b)
+	c.LCID = v & 0xFFFFF
+	c.Flags = byte(v >> 20)
+	c.Version = byte(v >> 28)
+	c.SortID = b[4]
+	return nil
+}
+
+// String 返回排序规则名，如Latin1_General_CI_AS或SQL_Latin1_General_CP1_CI_AS；
+// 无法识别时返回LCID和SortID
+func (c CollationInfo) String() string {
+	if c.SortID != 0 {
+		if name, ok := sqlSortNames[c.SortID]; ok {
+			return name
+		}
+		return fmt.Sprintf("SortID=%d", c.SortID)
+	}
+
+	prefix, ok := windowsCollationNames[c.LCID]
+	if !ok {
+		return fmt.Sprintf("LCID=0x%05X", c.LCID)
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(prefix)
+	sb.WriteString(collationVersionSuffixes[c.Version])
+	switch {
+	case c.Flags&CollationBinary2 != 0:
+		sb.WriteString("_BIN2")
+	case c.Flags&CollationBinary != 0:
+		sb.WriteString("_BIN")
+	default:
+		if c.Flags&CollationIgnoreCase != 0 {
+			sb.WriteString("_CI")
+		} else {
+			sb.WriteString("_CS")
+		}
+		if c.Flags&CollationIgnoreAccent != 0 {
+			sb.WriteString("_AI")
+		} else {
+			sb.WriteString("_AS")
+		}
+		if c.Flags&CollationIgnoreKana == 0 {
+			sb.WriteString("_KS")
+		}
+		if c.Flags&CollationIgnoreWidth == 0 {
+			sb.WriteString("_WS")
+		}
+	}
+	if c.Flags&CollationUTF8 != 0 {
+		sb.WriteString("_UTF8")
+	}
+	return sb.String()
+}
+
+// CollationInfo 解码字符类型的排序规则，类型不带排序规则时返回false
+func (ti *TypeInfo) CollationInfo() (CollationInfo, bool) {
+	var c CollationInfo
+	if ti.Collation == nil || c.Decode(ti.Collation) != nil {
+		return c, false
+	}
+	return c, true
+}
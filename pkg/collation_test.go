@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCollationInfoDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		want  CollationInfo
+		label string
+	}{
+		{"windows Latin1_General", []byte{0x09, 0x04, 0xD0, 0x00, 0x00},
+			CollationInfo{LCID: 0x0409, Flags: CollationIgnoreCase | CollationIgnoreKana | CollationIgnoreWidth}, "Latin1_General_CI_AS"},
+		{"versioned and sensitive", []byte{0x09, 0x04, 0x00, 0x20, 0x00},
+			CollationInfo{LCID: 0x0409, Version: 2}, "Latin1_General_100_CS_AS_KS_WS"},
+		{"binary", []byte{0x09, 0x04, 0x00, 0x02, 0x00},
+			CollationInfo{LCID: 0x0409, Flags: CollationBinary2}, "Latin1_General_BIN2"},
+		{"utf8", []byte{0x09, 0x04, 0xD0, 0x24, 0x00},
+			CollationInfo{LCID: 0x0409, Flags: CollationIgnoreCase | CollationIgnoreKana | CollationIgnoreWidth | CollationUTF8, Version: 2}, "Latin1_General_100_CI_AS_UTF8"},
+		{"SQL collation", testCollation,
+			CollationInfo{LCID: 0x0409, Flags: CollationIgnoreCase | CollationIgnoreKana | CollationIgnoreWidth, SortID: 52}, "SQL_Latin1_General_CP1_CI_AS"},
+		{"unknown LCID", []byte{0x34, 0x12, 0x00, 0x00, 0x00},
+			CollationInfo{LCID: 0x1234}, "LCID=0x01234"},
+		{"unknown SortID", []byte{0x09, 0x04, 0x00, 0x00, 0xFE},
+			CollationInfo{LCID: 0x0409, SortID: 0xFE}, "SortID=254"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c CollationInfo
+			if err := c.Decode(tt.data); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if c != tt.want {
+				t.Errorf("Decode = %+v, want %+v", c, tt.want)
+			}
+			if got := c.String(); got != tt.label {
+				t.Errorf("String() = %q, want %q", got, tt.label)
+			}
+		})
+	}
+}
+
+func TestCollationInfoDecodeShort(t *testing.T) {
+	var c CollationInfo
+	if err := c.Decode([]byte{0x09, 0x04}); !errors.Is(err, ErrProtocol) {
+		t.Errorf("Decode of 2 bytes = %v, want ErrProtocol", err)
+	}
+}
+
+func TestTypeInfoCollationInfo(t *testing.T) {
+	if _, ok := (&TypeInfo{}).CollationInfo(); ok {
+		t.Error("CollationInfo() reported a collation for a type without one")
+	}
+	c, ok := (&TypeInfo{Collation: testCollation}).CollationInfo()
+	if !ok || c.SortID != 52 {
+		t.Errorf("CollationInfo() = %+v, %v, want SortID 52", c, ok)
+	}
+}
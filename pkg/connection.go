@@ -1,9 +1,15 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ConnectionType 连接类型枚举
@@ -29,40 +35,346 @@ func (ct ConnectionType) String() string {
 type SocketCouple struct {
 	ClientBridgeSocket net.Conn
 	BridgeSQLSocket    net.Conn
+
+	// 关闭时是否设置SO_LINGER为0(发送RST而非FIN)
+	abortiveClose bool
+
+	// 建立连接时记录的两端远端地址，套接字关闭后仍可用于日志
+	clientAddr  net.Addr
+	backendAddr net.Addr
 }
 
 func (sc *SocketCouple) String() string {
-	if sc.ClientBridgeSocket == nil || sc.BridgeSQLSocket == nil {
-		return fmt.Sprintf("SocketCouple[ClientBridgeSocket=%v, BridgeSQLSocket=%v]", sc.ClientBridgeSocket, sc.BridgeSQLSocket)
+	return fmt.Sprintf("SocketCouple[ClientBridgeSocket.RemoteEndPoint=%v, BridgeSQLSocket.RemoteEndPoint=%v]",
+		sc.ClientAddr(), sc.BackendAddr())
+}
+
+// remoteAddrOf 获取连接的远端地址，连接为nil或获取时panic(已关闭的自定义连接)时返回nil
+func remoteAddrOf(conn net.Conn) (addr net.Addr) {
+	if conn == nil {
+		return nil
+	}
+	defer func() {
+		if recover() != nil {
+			addr = nil
+		}
+	}()
+	return conn.RemoteAddr()
+}
+
+// Close 关闭两端的套接字
+func (sc *SocketCouple) Close() {
+	sc.closeSocket(sc.ClientBridgeSocket)
+	sc.closeSocket(sc.BridgeSQLSocket)
+}
+
+// ClientAddr 获取客户端的远端地址，优先使用接受连接时记录的地址；无法得知时返回nil
+func (sc *SocketCouple) ClientAddr() net.Addr {
+	if sc.clientAddr != nil {
+		return sc.clientAddr
+	}
+	return remoteAddrOf(sc.ClientBridgeSocket)
+}
+
+// LocalClientAddr 获取客户端连接在桥接器一侧(监听端)的本地地址，客户端套接字为nil时返回nil
+func (sc *SocketCouple) LocalClientAddr() net.Addr {
+	if sc.ClientBridgeSocket == nil {
+		return nil
+	}
+	return sc.ClientBridgeSocket.LocalAddr()
+}
+
+// BackendAddr 获取SQL Server的远端地址，优先使用连接后端时记录的地址；尚未连接后端时返回nil
+func (sc *SocketCouple) BackendAddr() net.Addr {
+	if sc.backendAddr != nil {
+		return sc.backendAddr
+	}
+	return remoteAddrOf(sc.BridgeSQLSocket)
+}
+
+// closeSocket 按关闭方式关闭单个套接字
+func (sc *SocketCouple) closeSocket(conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	closeConn(conn, sc.abortiveClose)
+}
+
+// underlyingConn 去掉预读和PROXY协议的包装，获取底层连接
+func underlyingConn(conn net.Conn) net.Conn {
+	for {
+		switch c := conn.(type) {
+		case *bufferedConn:
+			conn = c.Conn
+		case *proxiedConn:
+			conn = c.Conn
+		case *progressWriteConn:
+			conn = c.Conn
+		default:
+			return conn
+		}
+	}
+}
+
+// closeConn 关闭连接，非优雅关闭时先将SO_LINGER设为0，使内核直接发送RST
+func closeConn(conn net.Conn, abortive bool) {
+	conn = underlyingConn(conn)
+	if abortive {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
 	}
-	return fmt.Sprintf("SocketCouple[ClientBridgeSocket.RemoteEndPoint=%v, BridgeSQLSocket.RemoteEndPoint=%v]", 
-		sc.ClientBridgeSocket.RemoteAddr(), sc.BridgeSQLSocket.RemoteAddr())
+	conn.Close()
+}
+
+// closeWrite 关闭TCP连接的写方向(发送FIN)，连接不是TCPConn时返回false
+func closeWrite(conn net.Conn) bool {
+	tcpConn, ok := underlyingConn(conn).(*net.TCPConn)
+	return ok && tcpConn.CloseWrite() == nil
 }
 
 // 事件处理函数类型定义
 type TDSMessageReceivedHandler func(*BridgedConnection, TDSMessage)
 type TDSPacketReceivedHandler func(*BridgedConnection, *TDSPacket)
 type ConnectionAcceptedHandler func(net.Conn)
+type ConnectionPolicyHandler func(*AcceptInfo) (*ConnectionConfig, error)
 type BridgeExceptionHandler func(*BridgedConnection, ConnectionType, error)
 type ListeningThreadExceptionHandler func(net.Listener, error)
 type ConnectionDisconnectedHandler func(*BridgedConnection, ConnectionType)
+type MessageBlockedHandler func(*BridgedConnection, HeaderType)
+type BulkInsertHandler func(*BridgedConnection, *SQLBatchMessage, *BulkLoadMessage)
+type ResponseCompleteHandler func(*BridgedConnection, *TabularResultMessage)
+type ServerErrorHandler func(*BridgedConnection, *ServerError)
+type ConnectionRejectedHandler func(net.Conn, error)
+type ClientLimitExceededHandler func(conn net.Conn, clientIP string, active int)
+type BackendConnectedHandler func(bc *BridgedConnection, backend net.Conn) error
+type EncryptionPolicyViolationHandler func(*BridgedConnection, byte)
+type PacketSequenceAnomalyHandler func(*BridgedConnection, *PacketSequenceAnomaly)
+type RPCDumpErrorHandler func(bc *BridgedConnection, fileName string, err error)
+type MessageErrorHandler func(*BridgedConnection, TDSMessage, error)
+type TimeoutHandler func(*BridgedConnection, TimeoutKind)
+type MessageDroppedHandler func(*BridgedConnection, TDSMessage)
+type LargeMessageHandler func(bc *BridgedConnection, msg TDSMessage, size int)
+type PacketLengthMismatchHandler func(bc *BridgedConnection, source ConnectionType, header *TDSHeader, actual int)
+type LoginCompleteHandler func(*BridgedConnection, *Login7Message)
+type LoginFailedHandler func(*BridgedConnection, *Login7Message, *ServerError)
+type LoginAckHandler func(*BridgedConnection, *LoginAck)
+type PacketRewriter func(bc *BridgedConnection, header *TDSHeader)
+
+// COALESCE_BUFFER_LIMIT 合并写入多包消息时默认缓存的最大字节数
+const COALESCE_BUFFER_LIMIT = 1 << 20
 
 // BridgeAcceptor 桥接接收器结构体
 type BridgeAcceptor struct {
 	acceptPort        string
 	sqlServerEndpoint string
 
-	listener net.Listener
-	enabled  bool
+	// 构造时指定的端口之外增加的路由
+	routes []*bridgeRoute
+
+	// 创建监听套接字时使用的配置，nil表示使用net.Listen
+	listenConfig *net.ListenConfig
+
+	listeners []net.Listener
+	enabled   bool
 	mu       sync.Mutex
+	// 所有接受循环都开始运行时关闭，受mu保护；Stop后重新创建
+	ready chan struct{}
 
 	// 事件处理函数
 	tDSMessageReceivedHandler      TDSMessageReceivedHandler
 	tDSPacketReceivedHandler       TDSPacketReceivedHandler
 	connectionAcceptedHandler      ConnectionAcceptedHandler
+	connectionPolicyHandler        ConnectionPolicyHandler
 	bridgeExceptionHandler         BridgeExceptionHandler
 	listeningThreadExceptionHandler ListeningThreadExceptionHandler
 	connectionDisconnectedHandler  ConnectionDisconnectedHandler
+	messageBlockedHandler          MessageBlockedHandler
+	bulkInsertHandler              BulkInsertHandler
+	responseCompleteHandler        ResponseCompleteHandler
+	serverErrorHandler             ServerErrorHandler
+	connectionRejectedHandler      ConnectionRejectedHandler
+	clientLimitExceededHandler     ClientLimitExceededHandler
+	backendConnectedHandler        BackendConnectedHandler
+	encryptionPolicyViolationHandler EncryptionPolicyViolationHandler
+	packetSequenceAnomalyHandler   PacketSequenceAnomalyHandler
+	rpcDumpErrorHandler            RPCDumpErrorHandler
+	messageErrorHandler            MessageErrorHandler
+	timeoutHandler                 TimeoutHandler
+	messageDroppedHandler          MessageDroppedHandler
+	largeMessageHandler            LargeMessageHandler
+	packetLengthMismatchHandler    PacketLengthMismatchHandler
+	loginCompleteHandler           LoginCompleteHandler
+	loginFailedHandler             LoginFailedHandler
+	loginAckHandler                LoginAckHandler
+	packetRewriter                 PacketRewriter
+
+	// 混沌测试策略
+	chaosPolicy *ChaosPolicy
+
+	// 内联拦截函数，以及接收消息副本的观察函数和其队列
+	messageInterceptor MessageInterceptor
+	messageObserver    TDSMessageReceivedHandler
+	observerBufferSize int
+	observers          *eventQueue
+
+	// 服务器响应的旁路输出函数和其队列
+	responseSink           TDSMessageReceivedHandler
+	responseSinkBufferSize int
+	responseSinks          *eventQueue
+
+	// 转发前对SQLBatch文本的改写函数
+	forwardRedactor func(text string) string
+
+	// 查询代价过滤函数，以及是否传入规范化的文本
+	queryCostFilter         QueryCostFilter
+	normalizeCostFilterText bool
+
+	// 是否不转发设置了IGNORE_EVENT位的客户端消息
+	dropIgnoredMessages bool
+
+	// 禁止转发的消息类型
+	blockedHeaderTypes map[HeaderType]bool
+
+	// Login7初始数据库的改写映射，键为小写的原库名
+	databaseRewrites map[string]string
+
+	// 写SQL Server失败时是否先向客户端发送TDS错误
+	notifyOnWriteError bool
+
+	// 是否关闭消息解析
+	parsingDisabled bool
+
+	// 批处理文本的编码
+	batchTextEncoding BatchTextEncoding
+
+	// 是否以RST方式关闭连接
+	abortiveClose bool
+
+	// 一侧读到EOF时是否只关闭对端的写方向，让另一方向继续转发
+	halfCloseSupport bool
+
+	// 是否将多包消息的数据包合并后一次写入后端
+	coalesceMessages bool
+
+	// 是否检查后端的第一个数据包符合TDS协议
+	verifyBackendProtocol bool
+
+	// 是否断言数据包的实际有效载荷长度与头部一致
+	assertPacketLengths bool
+
+	// 是否改写登录响应中的路由目标，以及等待重新连接的路由映射
+	rewriteRouting   bool
+	routingRedirects routingRedirects
+
+	// 尚未转发客户端数据时连接后端失败的重试次数
+	backendRetries int
+
+	// 按消息类型的滑动窗口速率统计，nil表示未启用
+	messageRates *messageRates
+
+	// 按方向和数据包类型的有效载荷大小统计，nil表示未启用
+	payloadSizes *payloadSizes
+
+	// 消息NDJSON日志，nil表示未启用
+	jsonLog *messageJSONLog
+
+	// RPC请求转储，nil表示未启用
+	rpcDump *rpcDumper
+
+	// 是否要求客户端在PreLogin中请求加密，以及违反时是否拒绝连接
+	requireEncryption bool
+	refuseUnencrypted bool
+
+	// 协议跟踪，nil表示未启用
+	tracer *packetTracer
+
+	// 原始帧捕获，nil表示未启用；可在运行中替换
+	capture atomic.Pointer[CaptureWriter]
+
+	// 捕获中是否保留登录密码
+	captureSecrets bool
+
+	// 连接后端时经过的代理，nil表示直接连接
+	backendProxy *backendProxy
+
+	// 后端拨号函数，nil时按配置拨号。仅供测试装置以内存中的脚本化连接代替真实的后端，
+	// 使转发逻辑无需真实套接字即可确定地测试；生产代码不设置
+	dialFunc func(endpoint string) (net.Conn, error)
+
+	// 连接后端后发送的PROXY协议头版本，0表示不发送
+	proxyProtocolVersion int
+
+	// 客户端连接是否以PROXY协议头开始
+	acceptProxyProtocol bool
+
+	// 按连接选择后端，以及选择前是否预读客户端的Login7
+	backendSelector    BackendSelector
+	selectorPeeksLogin bool
+	loginPeekLimit     int
+
+	// 仅捕获该SPID的数据包和消息，0表示不过滤
+	captureSPID uint16
+
+	// 连接的最长存活时间和最长空闲时间，0表示不限制
+	maxLifetime time.Duration
+	idleTimeout time.Duration
+
+	// 按写入进展计算的写入超时，0表示不限制
+	writeTimeout time.Duration
+
+	// 健康检查器报告的后端状态，以及所有后端都不健康时是否快速拒绝新连接
+	backendHealth             backendHealth
+	rejectWhenAllBackendsDown bool
+
+	// 单个客户端消息的最大有效载荷字节数，0表示不限制
+	maxMessageBytes int
+
+	// 单个客户端消息的最大数据包数，0表示不限制
+	maxPacketsPerMessage int
+
+	// 触发大消息事件的有效载荷字节数，0表示不检查
+	largeMessageThreshold int
+
+	// 每个连接的会话记录保留的最大条数，0表示不记录
+	maxTranscriptEntries int
+
+	// 请求跟踪函数及其语句脱敏函数
+	requestTracer        RequestTracer
+	requestTraceRedactor func(string) string
+
+	// 解析RPC请求时最多解析的参数个数，0表示MAX_RPC_PARAMETERS
+	maxRPCParameters int
+
+	// 最大并发连接数，0表示不限制
+	maxConnections int
+
+	// 正在以TDS错误回应的被拒绝连接数，超过MAX_GRACEFUL_REJECTIONS时直接关闭
+	gracefulRejections atomic.Int32
+
+	// 接受新连接的速率限制，nil表示不限制
+	acceptLimiter *tokenBucket
+
+	// 语句频次统计，nil表示未启用
+	queryStats *queryStats
+
+	// 连接SQL Server时使用的本地地址
+	backendLocalAddr net.Addr
+
+	// 异步事件投递配置及运行中的队列
+	asyncEventBufferSize int
+	asyncEventPolicy     OverflowPolicy
+	asyncEventWorkers    int
+	events               *shardedEventQueue
+
+	// 活动连接注册表，以及按客户端IP统计的活动连接数
+	nextConnectionID  uint64
+	connections       map[uint64]*BridgedConnection
+	clientConnections map[string]int
+	connectionsMu     sync.Mutex
+
+	// 每个客户端IP的最大并发连接数，0表示不限制，受connectionsMu保护
+	maxConnectionsPerClient int
 }
 
 // NewBridgeAcceptor 创建新的BridgeAcceptor
@@ -71,6 +383,8 @@ func NewBridgeAcceptor(acceptPort, sqlServerEndpoint string) *BridgeAcceptor {
 		acceptPort:        acceptPort,
 		sqlServerEndpoint: sqlServerEndpoint,
 		enabled:           false,
+		connections:       make(map[uint64]*BridgedConnection),
+		clientConnections: make(map[string]int),
 	}
 }
 
@@ -84,12 +398,21 @@ func (ba *BridgeAcceptor) SetTDSPacketReceivedHandler(handler TDSPacketReceivedH
 	ba.tDSPacketReceivedHandler = handler
 }
 
+// SetConnectionPolicyHandler 设置连接策略处理函数。
+// 每个连接在分配ID之后、连接后端之前调用一次，可返回覆盖全局设置的配置(nil表示不覆盖)；
+// 返回错误时拒绝该连接，并以该错误触发连接拒绝事件。
+// 也可通过AcceptInfo.Connection.SetValue为连接附加元数据，之后的事件中用Value读取。
+func (ba *BridgeAcceptor) SetConnectionPolicyHandler(handler ConnectionPolicyHandler) {
+	ba.connectionPolicyHandler = handler
+}
+
 // SetConnectionAcceptedHandler 设置连接接受处理函数
 func (ba *BridgeAcceptor) SetConnectionAcceptedHandler(handler ConnectionAcceptedHandler) {
 	ba.connectionAcceptedHandler = handler
 }
 
-// SetConnectionDisconnectedHandler 设置连接断开处理函数
+// SetConnectionDisconnectedHandler 设置连接断开处理函数。每个连接只触发一次，
+// 在两个方向的转发都结束、两侧套接字都关闭之后；ConnectionType为最先断开的一侧。
 func (ba *BridgeAcceptor) SetConnectionDisconnectedHandler(handler ConnectionDisconnectedHandler) {
 	ba.connectionDisconnectedHandler = handler
 }
@@ -104,262 +427,1737 @@ func (ba *BridgeAcceptor) SetListeningThreadExceptionHandler(handler ListeningTh
 	ba.listeningThreadExceptionHandler = handler
 }
 
-// Start 启动BridgeAcceptor
-func (ba *BridgeAcceptor) Start() error {
-	ba.mu.Lock()
-	defer ba.mu.Unlock()
-
-	if ba.enabled {
-		return nil // 已经在运行中
-	}
+// SetMessageBlockedHandler 设置消息被拒绝处理函数
+func (ba *BridgeAcceptor) SetMessageBlockedHandler(handler MessageBlockedHandler) {
+	ba.messageBlockedHandler = handler
+}
 
-	ba.enabled = true
+// SetBulkInsertHandler 设置批量插入处理函数，在INSERT BULK批处理及其后的批量加载数据都到达后触发
+func (ba *BridgeAcceptor) SetBulkInsertHandler(handler BulkInsertHandler) {
+	ba.bulkInsertHandler = handler
+}
 
-	// 创建监听套接字
-	listener, err := net.Listen("tcp", ":"+ba.acceptPort)
-	if err != nil {
-		ba.enabled = false
-		return err
-	}
-	ba.listener = listener
+// SetResponseCompleteHandler 设置响应完成处理函数，在服务器以最终DONE结束对一个请求的响应时触发
+func (ba *BridgeAcceptor) SetResponseCompleteHandler(handler ResponseCompleteHandler) {
+	ba.responseCompleteHandler = handler
+}
 
-	// 启动接受连接的goroutine
-	go ba.acceptLoop()
+// SetBackendConnectedHandler 设置后端连接建立处理函数。在与SQL Server的TCP连接(经代理时为隧道)
+// 建立之后、转发任何客户端数据之前调用，可向backend写入前置数据(如PROXY协议头)或记录日志。
+// 返回错误时关闭两侧连接，并以匹配ErrBackendDial的错误触发桥接异常事件。
+func (ba *BridgeAcceptor) SetBackendConnectedHandler(handler BackendConnectedHandler) {
+	ba.backendConnectedHandler = handler
+}
 
-	return nil
+// SetServerErrorHandler 设置服务器错误处理函数，对服务器响应中的每个ERROR令牌触发一次
+func (ba *BridgeAcceptor) SetServerErrorHandler(handler ServerErrorHandler) {
+	ba.serverErrorHandler = handler
 }
 
-// Stop 停止BridgeAcceptor
-func (ba *BridgeAcceptor) Stop() {
-	ba.mu.Lock()
-	defer ba.mu.Unlock()
+// SetConnectionRejectedHandler 设置连接拒绝处理函数，错误说明拒绝原因
+func (ba *BridgeAcceptor) SetConnectionRejectedHandler(handler ConnectionRejectedHandler) {
+	ba.connectionRejectedHandler = handler
+}
 
-	if !ba.enabled {
-		return
+// SetBlockedHeaderTypes 设置禁止转发的消息类型。
+// 消息类型由其第一个数据包决定，被禁止的消息的所有数据包都不会转发到SQL Server，
+// 消息结束时桥接器向客户端返回一个TDS错误，会话继续保持。
+func (ba *BridgeAcceptor) SetBlockedHeaderTypes(types []HeaderType) {
+	blocked := make(map[HeaderType]bool, len(types))
+	for _, t := range types {
+		blocked[t] = true
 	}
+	ba.blockedHeaderTypes = blocked
+}
 
-	ba.enabled = false
+// SetNotifyClientOnWriteError 设置写SQL Server失败时是否先向客户端发送一个合成的TDS错误再关闭连接，
+// 使驱动程序得到明确的错误信息而不是直接的TCP重置。此时已被桥接器读取的客户端数据仍会丢失。
+func (ba *BridgeAcceptor) SetNotifyClientOnWriteError(enabled bool) {
+	ba.notifyOnWriteError = enabled
+}
 
-	// 关闭监听器
-	if ba.listener != nil {
-		ba.listener.Close()
-		ba.listener = nil
-	}
+// SetParsingEnabled 设置是否解析并重组TDS消息，默认开启。
+// 关闭后转发循环只做转发所需的最小分帧：不再构建TDSMessage，也不会触发TDS消息接收事件，
+// 依赖消息内容的功能(如TDS版本识别)随之失效；换来的是更低的每包开销。
+// 数据包接收事件仍会在设置了处理函数时触发。
+// 若同时未设置任何数据包/消息处理函数和改写类选项，连接将走io.Copy快速路径。
+func (ba *BridgeAcceptor) SetParsingEnabled(enabled bool) {
+	ba.parsingDisabled = !enabled
 }
 
-// acceptLoop 接受连接的循环
-func (ba *BridgeAcceptor) acceptLoop() {
-	for ba.isEnabled() {
-		// 接受客户端连接
-		clientConn, err := ba.listener.Accept()
-		if err != nil {
-			if ba.isEnabled() { // 只有在启用状态下才报告错误
-				ba.onListeningThreadException(ba.listener, err)
-			}
-			continue
-		}
+// SetBatchTextEncoding 设置解析SQLBatch文本时使用的编码，默认BatchTextAutoDetect：
+// 符合UTF-16LE特征的按UTF-16LE解码，否则按单字节解码。对事件、日志和统计中的批处理文本生效。
+func (ba *BridgeAcceptor) SetBatchTextEncoding(encoding BatchTextEncoding) {
+	ba.batchTextEncoding = encoding
+}
 
-		// 处理新连接
-		go ba.handleNewConnection(clientConn)
-	}
+// SetCloseBehavior 设置连接的关闭方式。
+// graceful为true(默认)时正常关闭(FIN)；为false时关闭前将SO_LINGER设为0，
+// 使对端立即收到RST而不是挂起等待，适用于被过滤器拒绝的连接。
+func (ba *BridgeAcceptor) SetCloseBehavior(graceful bool) {
+	ba.abortiveClose = !graceful
 }
 
-// handleNewConnection 处理新的客户端连接
-func (ba *BridgeAcceptor) handleNewConnection(clientConn net.Conn) {
-	// 通知连接已接受
-	ba.onConnectionAccepted(clientConn)
+// SetHalfCloseSupport 设置是否支持半关闭，默认关闭。
+// 开启后一侧读到EOF(对端发送了FIN)时，桥接器只对另一侧的TCP连接执行CloseWrite，
+// 另一方向继续转发(如服务器仍在发送的响应)，直到它也结束后才完全关闭连接；
+// 另一侧不是TCP连接时仍立即关闭两侧。断开事件的ConnectionType为最先读到EOF的一侧。
+func (ba *BridgeAcceptor) SetHalfCloseSupport(enabled bool) {
+	ba.halfCloseSupport = enabled
+}
 
-	// 连接到SQL Server
-	sqlConn, err := net.Dial("tcp", ba.sqlServerEndpoint)
-	if err != nil {
-		clientConn.Close()
-		// 这里可以触发异常事件
-		return
-	}
+// SetCoalesceMessages 设置是否合并写入多包消息，默认关闭。开启后客户端消息的数据包被缓存，
+// 在END_OF_MESSAGE处一次写入后端，减少写调用和发往后端的TCP段数；单包消息照常立即写入，不增加延迟。
+// 缓存不超过SetMaxMessageBytes的限制(未设置时为COALESCE_BUFFER_LIMIT)，达到时先写出已缓存的部分。
+func (ba *BridgeAcceptor) SetCoalesceMessages(enabled bool) {
+	ba.coalesceMessages = enabled
+}
 
-	// 创建SocketCouple
-	socketCouple := &SocketCouple{
-		ClientBridgeSocket: clientConn,
-		BridgeSQLSocket:    sqlConn,
+// coalesceLimit 合并写入时缓存的最大字节数
+func (ba *BridgeAcceptor) coalesceLimit() int {
+	if ba.maxMessageBytes > 0 {
+		return ba.maxMessageBytes
 	}
+	return COALESCE_BUFFER_LIMIT
+}
 
-	// 创建BridgedConnection
-	bridgedConn := NewBridgedConnection(ba, socketCouple)
+// SetBackendRetries 设置连接后端失败时的重试次数，默认0。只重试尚未向后端转发客户端数据的阶段：
+// 拨号、PROXY协议头、后端连接处理函数，以及启用登录预读(SetBackendSelector)时的登录握手，
+// 因此后端在接受连接后立即关闭时，预读的登录可以在另一次连接上重放。
+// 每次重试都重新调用后端选择器，使其可以改选健康的后端，选中被SetBackendHealthy标记为不健康的后端时
+// 不拨号而直接进入下一次重试；所有尝试都失败时报告最后一次的错误。预读的登录只触发一次事件和拦截，
+// 每次重试重放同样的数据。
+func (ba *BridgeAcceptor) SetBackendRetries(n int) {
+	ba.backendRetries = n
+}
 
-	// 启动桥接连接
-	bridgedConn.Start()
+// SetAsyncEvents 设置通过缓冲队列异步投递TDS消息和数据包接收事件，使慢速处理函数不阻塞转发。
+// 默认由单个goroutine按到达顺序执行，可用SetAsyncEventWorkers增加并发；队列满时按policy处理，
+// 丢弃的事件数可通过DroppedEvents获取。Stop之后已入队的事件仍会执行完，新的事件在转发goroutine中同步调用。
+// bufferSize小于等于0时恢复为在转发goroutine中同步调用。需在Start之前调用。
+func (ba *BridgeAcceptor) SetAsyncEvents(bufferSize int, policy OverflowPolicy) {
+	ba.asyncEventBufferSize = bufferSize
+	ba.asyncEventPolicy = policy
 }
 
-// isEnabled 检查是否启用
-func (ba *BridgeAcceptor) isEnabled() bool {
-	ba.mu.Lock()
-	defer ba.mu.Unlock()
-	return ba.enabled
+// SetAsyncEventWorkers 设置执行异步事件的goroutine数(默认1)，每个goroutine有各自大小为bufferSize的队列。
+// 事件按连接ID分配到goroutine：同一连接的事件总是按发生顺序依次执行，不同连接的事件可能并发执行。
+// 需在Start之前调用。
+func (ba *BridgeAcceptor) SetAsyncEventWorkers(n int) {
+	ba.asyncEventWorkers = n
 }
 
-// onTDSMessageReceived 触发TDS消息接收事件
-func (ba *BridgeAcceptor) onTDSMessageReceived(bc *BridgedConnection, msg TDSMessage) {
-	if ba.tDSMessageReceivedHandler != nil {
-		ba.tDSMessageReceivedHandler(bc, msg)
+// DroppedEvents 获取异步事件队列因溢出而丢弃的事件数
+func (ba *BridgeAcceptor) DroppedEvents() uint64 {
+	ba.mu.Lock()
+	events := ba.events
+	ba.mu.Unlock()
+
+	if events == nil {
+		return 0
 	}
+	return events.dropped()
 }
 
-// onTDSPacketReceived 触发TDS数据包接收事件
-func (ba *BridgeAcceptor) onTDSPacketReceived(bc *BridgedConnection, packet *TDSPacket) {
-	if ba.tDSPacketReceivedHandler != nil {
-		ba.tDSPacketReceivedHandler(bc, packet)
+// SetBackendLocalAddr 设置连接SQL Server时绑定的本地地址("ip"或"ip:port")，
+// 用于多网卡环境下让后端连接从指定IP发出。传入空字符串恢复由系统选择。
+func (ba *BridgeAcceptor) SetBackendLocalAddr(addr string) error {
+	if addr == "" {
+		ba.backendLocalAddr = nil
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "0")
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if tcpAddr.IP == nil {
+		return fmt.Errorf("backend local address %q has no IP", addr)
 	}
+	ba.backendLocalAddr = tcpAddr
+	return nil
 }
 
-// onConnectionAccepted 触发连接接受事件
-func (ba *BridgeAcceptor) onConnectionAccepted(conn net.Conn) {
-	if ba.connectionAcceptedHandler != nil {
-		ba.connectionAcceptedHandler(conn)
+// EnableQueryStats 启用语句频次统计，最多保留capacity条规范化语句，超出时淘汰低频语句。
+// 统计SQLBatch文本和RPC调用(sp_executesql取其@stmt，其余为EXEC 存储过程名)。需在Start之前调用。
+func (ba *BridgeAcceptor) EnableQueryStats(capacity int) {
+	if capacity <= 0 {
+		ba.queryStats = nil
+		return
 	}
+	ba.queryStats = newQueryStats(capacity)
 }
 
-// onListeningThreadException 触发监听线程异常事件
-func (ba *BridgeAcceptor) onListeningThreadException(listener net.Listener, err error) {
-	if ba.listeningThreadExceptionHandler != nil {
-		ba.listeningThreadExceptionHandler(listener, err)
+// EnableMessageJSONLog 将双向的每个完整消息以NDJSON格式写入w，每行包含时间戳、连接ID、方向和解码后的字段。
+// 写入在所有连接间串行化；写入失败不影响转发。传入nil关闭日志。需在Start之前调用。
+func (ba *BridgeAcceptor) EnableMessageJSONLog(w io.Writer) {
+	if w == nil {
+		ba.jsonLog = nil
+		return
 	}
+	ba.jsonLog = &messageJSONLog{w: w}
 }
 
-// onBridgeException 触发桥接异常事件
-func (ba *BridgeAcceptor) onBridgeException(bc *BridgedConnection, ct ConnectionType, err error) {
-	if ba.bridgeExceptionHandler != nil {
-		ba.bridgeExceptionHandler(bc, ct, err)
-	}
+// EnableMessageRates 启用按消息类型的速率统计，可通过MessageRates查询最近窗口内的每秒消息数。需在Start之前调用。
+func (ba *BridgeAcceptor) EnableMessageRates() {
+	ba.messageRates = newMessageRates()
 }
 
-// onConnectionDisconnected 触发连接断开事件
-func (ba *BridgeAcceptor) onConnectionDisconnected(bc *BridgedConnection, ct ConnectionType) {
-	if ba.connectionDisconnectedHandler != nil {
-		ba.connectionDisconnectedHandler(bc, ct)
+// MessageRates 获取最近window内各类客户端消息的每秒速率，window按整秒计，最长MAX_RATE_WINDOW。
+// 未启用统计时返回nil
+func (ba *BridgeAcceptor) MessageRates(window time.Duration) map[HeaderType]float64 {
+	if ba.messageRates == nil {
+		return nil
 	}
+	return ba.messageRates.rates(window)
 }
 
-// BridgedConnection 桥接连接结构体
-type BridgedConnection struct {
-	BridgeAcceptor *BridgeAcceptor
-	SocketCouple   *SocketCouple
-	mu             sync.Mutex
+// TopQueries 获取执行次数最多的n条规范化语句，未启用统计时返回nil
+func (ba *BridgeAcceptor) TopQueries(n int) []QueryStat {
+	if ba.queryStats == nil {
+		return nil
+	}
+	return ba.queryStats.top(n)
 }
 
-// NewBridgedConnection 创建新的BridgedConnection
-func NewBridgedConnection(bridgeAcceptor *BridgeAcceptor, socketCouple *SocketCouple) *BridgedConnection {
-	return &BridgedConnection{
-		BridgeAcceptor: bridgeAcceptor,
-		SocketCouple:   socketCouple,
+// ResetStats 清零桥接器的汇总统计：语句频次统计、消息速率统计、有效载荷大小统计，以及异步事件队列、观察队列和旁路输出队列的丢弃计数。
+// 不影响活动连接的转发，之后的流量照常计入；连接自身的计数(如StatementCount)不受影响。
+func (ba *BridgeAcceptor) ResetStats() {
+	if ba.queryStats != nil {
+		ba.queryStats.reset()
+	}
+	if ba.messageRates != nil {
+		ba.messageRates.reset()
+	}
+	if ba.payloadSizes != nil {
+		ba.payloadSizes.reset()
+	}
+
+	ba.mu.Lock()
+	events, observers, responseSinks := ba.events, ba.observers, ba.responseSinks
+	ba.mu.Unlock()
+	if events != nil {
+		events.resetDropped()
+	}
+	if observers != nil {
+		observers.dropped.Store(0)
+	}
+	if responseSinks != nil {
+		responseSinks.dropped.Store(0)
 	}
 }
 
-// Start 启动桥接连接
-func (bc *BridgedConnection) Start() {
-	// 启动客户端到SQL Server的goroutine
-	go bc.clientBridgeToSQLServer()
-	// 启动SQL Server到客户端的goroutine
-	go bc.sqlServerToClientBridge()
+// SetMaxConnections 设置最大并发桥接连接数，0表示不限制。
+// 超出上限的连接会收到一个说明桥接器已满的TDS错误后被关闭(客户端要求加密时直接关闭)，
+// 并触发连接拒绝事件。
+func (ba *BridgeAcceptor) SetMaxConnections(n int) {
+	ba.connectionsMu.Lock()
+	defer ba.connectionsMu.Unlock()
+	ba.maxConnections = n
 }
 
-// clientBridgeToSQLServer 处理从客户端到SQL Server的数据传输
-func (bc *BridgedConnection) clientBridgeToSQLServer() {
-	defer func() {
-		bc.onConnectionDisconnected(ClientBridge)
-	}()
+// SetMaxConnectionsPerClient 设置每个客户端IP(取自RemoteAddr)的最大并发桥接连接数，0表示不限制。
+// 超出上限的连接与超出SetMaxConnections时一样回复TDS错误后关闭，触发客户端超限事件和
+// 连接拒绝事件(原因匹配ErrClientLimit)。连接断开后计数随之减少。
+func (ba *BridgeAcceptor) SetMaxConnectionsPerClient(n int) {
+	ba.connectionsMu.Lock()
+	defer ba.connectionsMu.Unlock()
+	ba.maxConnectionsPerClient = n
+}
 
-	var bBuffer []byte
-	bHeader := make([]byte, HEADER_SIZE)
-	var tdsMessage TDSMessage
+// SetClientLimitExceededHandler 设置客户端超限处理函数，参数为被拒绝的连接、客户端IP及其现有的活动连接数
+func (ba *BridgeAcceptor) SetClientLimitExceededHandler(handler ClientLimitExceededHandler) {
+	ba.clientLimitExceededHandler = handler
+}
 
-	for {
-		// 接收头部
-		n, err := bc.SocketCouple.ClientBridgeSocket.Read(bHeader)
-		if err != nil || n == 0 {
-			bc.onBridgeException(ClientBridge, err)
-			return
-		}
+// SetMaxMessageBytes 限制组装中的单个客户端消息累计的有效载荷字节数，0表示不限制。
+// 超出时视为协议错误(同时匹配ErrProtocol和ErrBufferLimit)，触发桥接异常并断开连接，
+// 防止不发送END_OF_MESSAGE的客户端无限占用内存。关闭解析时消息不被组装，不做限制。
+func (ba *BridgeAcceptor) SetMaxMessageBytes(n int) {
+	ba.maxMessageBytes = n
+}
 
-		// 创建TDS头部
-		header := NewTDSHeader(bHeader)
+// SetMaxPacketsPerMessage 限制单个客户端消息的数据包数，0表示不限制。
+// 消息在END_OF_MESSAGE之前超出n个数据包时视为协议错误(同时匹配ErrProtocol和ErrBufferLimit)，
+// 触发桥接异常并断开连接，防范以大量小数据包缓慢发送消息的碎片化攻击。不需要解析，关闭解析时同样生效。
+func (ba *BridgeAcceptor) SetMaxPacketsPerMessage(n int) {
+	ba.maxPacketsPerMessage = n
+}
 
-		// 确保缓冲区大小足够
-		minBufferSize := max(0x1000, header.LengthIncludingHeader()+1)
-		if bBuffer == nil || len(bBuffer) < minBufferSize {
-			bBuffer = make([]byte, minBufferSize)
-		}
+// SetLargeMessageThreshold 设置大消息阈值：完整的客户端消息的有效载荷超过n字节时触发大消息事件，
+// 用于发现异常大的批处理或RPC(批量操作或滥用)。0表示不检查。关闭解析时消息不被组装，不做检查。
+func (ba *BridgeAcceptor) SetLargeMessageThreshold(n int) {
+	ba.largeMessageThreshold = n
+}
 
-		// 接收有效载荷
-		var received int
-		if header.Type() == HeaderType(23) {
-			received, err = bc.SocketCouple.ClientBridgeSocket.Read(bBuffer[:0x1000-HEADER_SIZE])
-		} else if header.PayloadSize() > 0 {
-			received, err = bc.SocketCouple.ClientBridgeSocket.Read(bBuffer[:header.PayloadSize()])
-		}
+// SetLargeMessageHandler 设置大消息处理函数，参数为消息及其有效载荷字节数。
+// 与消息接收事件一样经SetAsyncEvents的队列投递，不阻塞转发。
+func (ba *BridgeAcceptor) SetLargeMessageHandler(handler LargeMessageHandler) {
+	ba.largeMessageHandler = handler
+}
 
-		if err != nil {
-			bc.onBridgeException(ClientBridge, err)
-			return
-		}
+// SetMaxRPCParameters 设置解析RPC请求时最多解析的参数个数，0表示使用MAX_RPC_PARAMETERS。
+// 超出时停止解析，防止声明大量参数的请求消耗解析时间；消息仍原样转发，
+// 设置了消息错误处理函数时以匹配ErrBufferLimit的错误触发消息错误事件。
+func (ba *BridgeAcceptor) SetMaxRPCParameters(n int) {
+	ba.maxRPCParameters = n
+}
 
-		// 创建TDS数据包
-		tdsPacket := NewTDSPacket(bHeader, bBuffer, header.PayloadSize())
-		
-		// 触发数据包接收事件
-		bc.onTDSPacketReceived(tdsPacket)
-
-		// 构建消息
-		if tdsMessage == nil {
-			tdsMessage = CreateTDSMessageFromFirstPacket(tdsPacket)
-		} else {
-			tdsMessage.AddPacket(tdsPacket)
-		}
+// SetMessageErrorHandler 设置消息错误处理函数，在完整的客户端消息无法解析时触发，消息仍原样转发。
+// 设置后每个RPC请求的参数都会被解析以便检查。
+func (ba *BridgeAcceptor) SetMessageErrorHandler(handler MessageErrorHandler) {
+	ba.messageErrorHandler = handler
+}
 
-		// 检查消息是否完成
-		if (header.StatusBitMask() & END_OF_MESSAGE) == END_OF_MESSAGE {
-			bc.onTDSMessageReceived(tdsMessage)
-			tdsMessage = nil
-		}
+// SetMaxConnectionLifetime 设置连接的最长存活时间，0表示不限制。
+// 到期后连接在客户端请求的消息边界处被关闭(不截断正在转发的请求)，
+// 断开事件中可通过DisconnectReason得到ErrMaxLifetime。仅影响之后建立的连接。
+func (ba *BridgeAcceptor) SetMaxConnectionLifetime(d time.Duration) {
+	ba.maxLifetime = d
+}
 
-		// 发送头部到SQL Server
-		_, err = bc.SocketCouple.BridgeSQLSocket.Write(bHeader)
-		if err != nil {
-			bc.onBridgeException(ClientBridge, err)
-			return
-		}
+// SetCaptureSPIDFilter 只对指定SPID的流量触发数据包/消息事件和消息日志，所有流量仍照常转发。
+// 客户端数据包头部的SPID通常为0，此时按服务器在该连接上使用的SPID匹配；
+// 尚未从服务器得知SPID的连接(如登录完成前)不匹配。传入0取消过滤。
+func (ba *BridgeAcceptor) SetCaptureSPIDFilter(spid uint16) {
+	ba.captureSPID = spid
+}
 
-		// 发送有效载荷到SQL Server
-		if header.Type() == HeaderType(23) {
-			_, err = bc.SocketCouple.BridgeSQLSocket.Write(bBuffer[:received])
-		} else {
-			_, err = bc.SocketCouple.BridgeSQLSocket.Write(bBuffer[:header.PayloadSize()])
-		}
+// SetTraceWriter 为双向的每个TDS数据包和TLS记录向w写入一行可读的注释：方向、类型、
+// 解码后的状态位、SPID、数据包ID、长度及有效载荷预览。传入nil关闭跟踪。需在Start之前调用。
+func (ba *BridgeAcceptor) SetTraceWriter(w io.Writer) {
+	if w == nil {
+		ba.tracer = nil
+		return
+	}
+	ba.tracer = &packetTracer{w: w}
+}
 
-		if err != nil {
-			bc.onBridgeException(ClientBridge, err)
-			return
-		}
+// SetCaptureWriter 将双向收到的每个TDS数据包和TLS记录以捕获格式写入w，可用CaptureReader读回。
+// 压缩时传入gzip.NewWriter(f)即可，实现Flush的写入器会被定期刷新；
+// w本身不会被关闭，调用方应在Stop之后关闭它。传入nil关闭捕获。
+// 可在运行中调用以开始、停止或切换捕获，对活动连接的下一帧生效；
+// 但启动时走io.Copy快速路径的连接(见SetParsingEnabled)无法中途捕获。
+func (ba *BridgeAcceptor) SetCaptureWriter(w io.Writer) {
+	var cw *CaptureWriter
+	if w != nil {
+		cw = NewCaptureWriter(w)
 	}
+	ba.setCapture(cw)
 }
 
-// sqlServerToClientBridge 处理从SQL Server到客户端的数据传输
-func (bc *BridgedConnection) sqlServerToClientBridge() {
-	defer func() {
-		bc.onConnectionDisconnected(BridgeSQL)
-	}()
+// SetGzipCaptureFile 创建path文件并写入gzip压缩的捕获，定期刷新以便运行中读取，Stop时关闭文件。
+func (ba *BridgeAcceptor) SetGzipCaptureFile(path string) error {
+	cw, err := newGzipCaptureFile(path)
+	if err != nil {
+		return err
+	}
+	ba.setCapture(cw)
+	return nil
+}
 
-	bBuffer := make([]byte, 4096)
+// setCapture 替换捕获写入器，关闭原有的写入器。
+// 正在写入的数据包可能仍持有原写入器，关闭后其写入返回ErrCaptureClosed而被丢弃。
+func (ba *BridgeAcceptor) setCapture(cw *CaptureWriter) {
+	if old := ba.capture.Swap(cw); old != nil {
+		old.Close()
+	}
+}
+
+// SetIdleTimeout 设置连接的最长空闲时间，0表示不限制。
+// 两个方向都超过该时长没有数据时关闭连接，断开事件中DisconnectReason为ErrIdleTimeout。
+// 仅影响之后建立的连接，可由连接策略处理函数按连接覆盖。
+func (ba *BridgeAcceptor) SetIdleTimeout(d time.Duration) {
+	ba.idleTimeout = d
+}
+
+// SetRequireEncryption 要求客户端在PreLogin的ENCRYPTION选项中请求加密(ENCRYPT_ON或ENCRYPT_REQ)。
+// 客户端声明ENCRYPT_OFF(仅加密登录)或ENCRYPT_NOT_SUP时触发加密策略违反事件；
+// refuse为true时不转发该PreLogin，回复错误后关闭连接，DisconnectReason为ErrEncryptionRequired。
+// 关闭解析时不做检查。
+func (ba *BridgeAcceptor) SetRequireEncryption(require bool, refuse bool) {
+	ba.requireEncryption = require
+	ba.refuseUnencrypted = refuse
+}
+
+// SetEncryptionPolicyViolationHandler 设置加密策略违反处理函数，参数为客户端声明的ENCRYPTION值
+func (ba *BridgeAcceptor) SetEncryptionPolicyViolationHandler(handler EncryptionPolicyViolationHandler) {
+	ba.encryptionPolicyViolationHandler = handler
+}
+
+// SetChaosPolicy 设置混沌测试策略，用于在转发时注入延迟、丢包和字节损坏。
+// 两个方向均按帧(TDS数据包或原始TLS记录)生效。传入nil关闭。
+func (ba *BridgeAcceptor) SetChaosPolicy(policy *ChaosPolicy) {
+	ba.chaosPolicy = policy
+}
+
+// Start 启动BridgeAcceptor
+func (ba *BridgeAcceptor) Start() error {
+	ba.mu.Lock()
+	defer ba.mu.Unlock()
+
+	if ba.enabled {
+		return nil // 已经在运行中
+	}
+
+	ba.enabled = true
+
+	// 为每个路由创建监听套接字，任一失败时关闭已创建的
+	routes := ba.allRoutes()
+	if len(routes) == 0 {
+		ba.enabled = false
+		return newBridgeError(ErrListen, "listen", errors.New("no listen address"))
+	}
+	for _, route := range routes {
+		listener, err := ba.listen(route.listenAddr)
+		if err != nil {
+			for _, l := range ba.listeners {
+				l.Close()
+			}
+			ba.listeners = nil
+			ba.enabled = false
+			return newBridgeError(ErrListen, "listen "+route.listenAddr, err)
+		}
+		ba.listeners = append(ba.listeners, listener)
+	}
+
+	ba.startEventQueues()
+
+	// 启动接受连接的goroutine，传入监听器以免Stop将ba.listeners置为nil后被读取；
+	// 所有接受循环都开始运行后才算就绪
+	var started sync.WaitGroup
+	started.Add(len(routes))
+	for i, route := range routes {
+		go ba.acceptLoop(ba.listeners[i], route, &started)
+	}
+	ready := ba.readyChan()
+	go func() {
+		started.Wait()
+		close(ready)
+	}()
+
+	return nil
+}
+
+// SetListenConfig 设置Start创建监听套接字时使用的配置，nil表示使用net.Listen。
+// 其Control回调在bind之前对原始套接字调用，可设置Start未提供的套接字选项。
+// 例如在Linux上设置SO_REUSEPORT，使新旧两个进程同时监听同一端口，实现不停机重启：
+//
+//	ba.SetListenConfig(&net.ListenConfig{
+//		Control: func(network, address string, c syscall.RawConn) error {
+//			var sockErr error
+//			err := c.Control(func(fd uintptr) {
+//				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+//			})
+//			if err != nil {
+//				return err
+//			}
+//			return sockErr
+//		},
+//	})
+//
+// 配置对所有路由的监听器生效。需在Start之前调用。
+func (ba *BridgeAcceptor) SetListenConfig(config *net.ListenConfig) {
+	ba.listenConfig = config
+}
+
+// listen 按监听配置创建监听套接字
+func (ba *BridgeAcceptor) listen(address string) (net.Listener, error) {
+	if ba.listenConfig == nil {
+		return net.Listen("tcp", address)
+	}
+	return ba.listenConfig.Listen(context.Background(), "tcp", address)
+}
+
+// Stop 停止BridgeAcceptor
+func (ba *BridgeAcceptor) Stop() {
+	ba.mu.Lock()
+	defer ba.mu.Unlock()
+
+	if ba.closeListeners() {
+		ba.releaseRunState()
+	}
+}
+
+// closeListeners 关闭监听器，停止接受新连接，需持有mu。未在运行时返回false。
+func (ba *BridgeAcceptor) closeListeners() bool {
+	if !ba.enabled {
+		return false
+	}
+
+	ba.enabled = false
+
+	// 关闭监听器
+	for _, listener := range ba.listeners {
+		listener.Close()
+	}
+	ba.listeners = nil
+	ba.ready = nil
+	ba.routingRedirects.closeAll()
+	return true
+}
+
+// releaseRunState 停止事件队列并结束捕获，需持有mu
+func (ba *BridgeAcceptor) releaseRunState() {
+	ba.stopEventQueues()
+
+	// 结束捕获，之后仍在运行的连接不再写入
+	if capture := ba.capture.Load(); capture != nil {
+		capture.Close()
+	}
+}
+
+// startEventQueues 启动异步事件队列、观察队列和旁路输出队列，需持有mu
+func (ba *BridgeAcceptor) startEventQueues() {
+	if ba.asyncEventBufferSize > 0 {
+		ba.events = newShardedEventQueue(ba.asyncEventWorkers, ba.asyncEventBufferSize, ba.asyncEventPolicy)
+	}
+	if ba.messageObserver != nil {
+		bufferSize := ba.observerBufferSize
+		if bufferSize < 1 {
+			bufferSize = 1
+		}
+		ba.observers = newEventQueue(bufferSize, OverflowDropNewest)
+	}
+	if ba.responseSink != nil {
+		bufferSize := ba.responseSinkBufferSize
+		if bufferSize < 1 {
+			bufferSize = 1
+		}
+		ba.responseSinks = newEventQueue(bufferSize, OverflowDropNewest)
+	}
+}
+
+// stopEventQueues 停止异步事件队列、观察队列和旁路输出队列，需持有mu。
+// 已入队的事件仍会执行完，之后的事件在触发它的goroutine上直接执行。
+func (ba *BridgeAcceptor) stopEventQueues() {
+	if ba.events != nil {
+		ba.events.stop()
+	}
+	if ba.observers != nil {
+		ba.observers.stop()
+	}
+	if ba.responseSinks != nil {
+		ba.responseSinks.stop()
+	}
+}
+
+// acceptLoop 接受连接的循环
+func (ba *BridgeAcceptor) acceptLoop(listener net.Listener, route *bridgeRoute, started *sync.WaitGroup) {
+	started.Done()
+	for {
+		// 接受客户端连接
+		clientConn, err := listener.Accept()
+		if err != nil {
+			// 监听器已被Stop关闭(之后可能已用新的监听器重新Start)，本循环结束
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if ba.isEnabled() { // 只有在启用状态下才报告错误
+				ba.onListeningThreadException(listener, err)
+			}
+			continue
+		}
+
+		// 超出接受速率的连接直接关闭
+		if limiter := ba.acceptLimiter; limiter != nil && !limiter.allow() {
+			closeConn(clientConn, ba.abortiveClose)
+			ba.onConnectionRejected(clientConn, newBridgeError(ErrAcceptRateLimit, "accept", nil))
+			continue
+		}
+
+		// 处理新连接
+		go ba.handleNewConnection(clientConn, route)
+	}
+}
+
+// handleNewConnection 处理新的客户端连接
+func (ba *BridgeAcceptor) handleNewConnection(clientConn net.Conn, route *bridgeRoute) {
+	// 位于负载均衡器之后时先取得客户端的真实地址
+	if ba.acceptProxyProtocol {
+		conn, err := acceptProxyHeader(clientConn)
+		if err != nil {
+			closeConn(clientConn, ba.abortiveClose)
+			ba.onConnectionRejected(clientConn, classifyError("read PROXY header", err))
+			return
+		}
+		clientConn = conn
+	}
+
+	// 通知连接已接受
+	ba.onConnectionAccepted(clientConn)
+
+	// 创建SocketCouple，SQL Server端在连接成功后填入
+	socketCouple := &SocketCouple{
+		ClientBridgeSocket: clientConn,
+		abortiveClose:      ba.abortiveClose,
+		clientAddr:         remoteAddrOf(clientConn),
+	}
+
+	// 创建BridgedConnection并注册，超出连接数上限时拒绝
+	bridgedConn := NewBridgedConnection(ba, socketCouple)
+	bridgedConn.route = route
+	if err := ba.registerConnection(bridgedConn); err != nil {
+		if errors.Is(err, ErrClientLimit) {
+			ba.rejectConnection(clientConn, err, BRIDGE_ERROR_CLIENT_LIMIT,
+				"Too many connections from this client. Close unused connections and try again.")
+			return
+		}
+		ba.rejectConnection(clientConn, err,
+			BRIDGE_ERROR_SERVER_BUSY, "The bridge has reached its maximum number of connections. Try again later.")
+		return
+	}
+
+	// 此时尚无SQL Server端套接字，失败时以仅含客户端的连接对象报告异常
+	fail := func(ct ConnectionType, err error) {
+		ba.unregisterConnection(bridgedConn)
+		socketCouple.Close()
+		if isTimeout(err) {
+			ba.onTimeout(bridgedConn, TimeoutHandshake)
+		}
+		ba.onBridgeException(bridgedConn, ct, err)
+	}
+
+	// 按连接策略覆盖配置
+	endpoint := route.backend
+	if ba.connectionPolicyHandler != nil {
+		config, err := ba.connectionPolicyHandler(&AcceptInfo{
+			ID:         bridgedConn.ID(),
+			Conn:       clientConn,
+			AcceptedAt: bridgedConn.CreatedAt(),
+			Connection: bridgedConn,
+		})
+		if err != nil {
+			ba.unregisterConnection(bridgedConn)
+			closeConn(clientConn, ba.abortiveClose)
+			ba.onConnectionRejected(clientConn, err)
+			return
+		}
+		if config != nil {
+			config.apply(bridgedConn, &endpoint)
+		}
+	}
+
+	// 客户端按改写后的路由重新连接时，连接服务器路由的真实目标
+	if route.redirected {
+		endpoint = route.backend
+		bridgedConn.routingTarget = route.backend
+	}
+
+	// 需要时先预读客户端的登录
+	var peeked *peekedLogin
+	if ba.backendSelector != nil && ba.selectorPeeksLogin {
+		conn := clientConn
+		var err error
+		peeked, conn, err = bridgedConn.peekLogin(clientConn)
+		if err != nil {
+			fail(ClientBridge, classifyError("peek login", err))
+			return
+		}
+		socketCouple.ClientBridgeSocket = conn
+	}
+
+	// 选择并连接后端，尚未转发客户端数据时失败可重试
+	err := ba.connectBackend(bridgedConn, endpoint, peeked, false)
+	for retry := 0; err != nil && retry < ba.backendRetries; retry++ {
+		err = ba.connectBackend(bridgedConn, endpoint, peeked, true)
+	}
+	if errors.Is(err, ErrNoHealthyBackend) {
+		// 选中的后端都不健康，无需等待连接后端超时
+		ba.unregisterConnection(bridgedConn)
+		message := "No healthy backend is available. Try again later."
+		if peeked != nil {
+			// 桥接器已回应过PreLogin，客户端正在等待登录响应
+			ba.rejectLogin(socketCouple.ClientBridgeSocket, err, BRIDGE_ERROR_NO_HEALTHY_BACKEND, message)
+		} else {
+			ba.rejectConnection(clientConn, err, BRIDGE_ERROR_NO_HEALTHY_BACKEND, message)
+		}
+		return
+	}
+	if err != nil {
+		fail(BridgeSQL, err)
+		return
+	}
+
+	if ba.writeTimeout > 0 {
+		socketCouple.applyWriteTimeout(ba.writeTimeout)
+	}
+
+	// 启动桥接连接
+	bridgedConn.Start()
+}
+
+// connectBackend 选择后端并建立连接：发送PROXY协议头、执行后端连接处理函数，
+// 有预读的登录时与后端完成握手。成功时将连接填入SocketCouple，失败时关闭已建立的后端连接。
+// 重试或启用快速拒绝时不连接被标记为不健康的后端。
+func (ba *BridgeAcceptor) connectBackend(bc *BridgedConnection, endpoint string, peeked *peekedLogin, retry bool) error {
+	socketCouple := bc.SocketCouple
+	if ba.backendSelector != nil {
+		var login *Login7Message
+		if peeked != nil {
+			login = peeked.login
+		}
+		var err error
+		endpoint, err = ba.backendSelector(socketCouple.ClientBridgeSocket, login)
+		if err != nil {
+			return newBridgeError(ErrBackendDial, "select backend", err)
+		}
+	}
+	if (retry || ba.rejectWhenAllBackendsDown) && ba.backendHealth.isDown(endpoint) {
+		return newBridgeError(ErrNoHealthyBackend, "select backend", fmt.Errorf("%s is marked down", endpoint))
+	}
+
+	// 连接到SQL Server
+	sqlConn, err := ba.dialBackend(endpoint)
+	if err != nil {
+		return newBridgeError(ErrBackendDial, "dial "+endpoint, err)
+	}
+	socketCouple.BridgeSQLSocket = sqlConn
+	socketCouple.backendAddr = remoteAddrOf(sqlConn)
+	fail := func(err error) error {
+		socketCouple.closeSocket(sqlConn)
+		socketCouple.BridgeSQLSocket = nil
+		socketCouple.backendAddr = nil
+		return err
+	}
+
+	// 向后端说明客户端的真实地址
+	if version := ba.proxyProtocolVersion; version != 0 {
+		header, err := BuildProxyProtocolHeader(version, socketCouple.ClientAddr(), socketCouple.LocalClientAddr())
+		if err == nil {
+			_, err = sqlConn.Write(header)
+		}
+		if err != nil {
+			return fail(newBridgeError(ErrBackendDial, "send PROXY header to "+endpoint, err))
+		}
+	}
+
+	// 在转发客户端数据之前执行后端连接的初始化
+	if handler := ba.backendConnectedHandler; handler != nil {
+		if err := handler(bc, sqlConn); err != nil {
+			return fail(newBridgeError(ErrBackendDial, "backend connected "+endpoint, err))
+		}
+	}
+
+	// 以预读的登录与后端完成握手
+	if peeked != nil {
+		if err := bc.replayLogin(sqlConn, peeked); err != nil {
+			return fail(classifyError("replay login", err))
+		}
+		bc.backendResponded.Store(true)
+	}
+	return nil
+}
+
+// needsServerMessages 检查是否需要在服务器方向重组响应消息
+func (ba *BridgeAcceptor) needsServerMessages() bool {
+	return !ba.parsingDisabled && (ba.responseCompleteHandler != nil || ba.serverErrorHandler != nil || ba.jsonLog != nil ||
+		ba.maxTranscriptEntries > 0 || ba.requestTracer != nil || ba.responseSink != nil)
+}
+
+// canUseFastPath 检查是否既无处理函数也无解析、改写需求，从而可以用io.Copy转发
+func (ba *BridgeAcceptor) canUseFastPath() bool {
+	return ba.parsingDisabled &&
+		ba.tDSMessageReceivedHandler == nil &&
+		ba.tDSPacketReceivedHandler == nil &&
+		ba.messageInterceptor == nil &&
+		ba.messageObserver == nil &&
+		ba.bulkInsertHandler == nil &&
+		ba.responseCompleteHandler == nil &&
+		ba.serverErrorHandler == nil &&
+		ba.queryStats == nil &&
+		ba.jsonLog == nil &&
+		ba.rpcDump == nil &&
+		ba.messageRates == nil &&
+		ba.payloadSizes == nil &&
+		ba.packetRewriter == nil &&
+		ba.capture.Load() == nil &&
+		ba.tracer == nil &&
+		ba.packetSequenceAnomalyHandler == nil &&
+		!ba.assertPacketLengths &&
+		!ba.requireEncryption &&
+		ba.chaosPolicy == nil &&
+		!ba.coalesceMessages &&
+		!ba.verifyBackendProtocol &&
+		!ba.rewriteRouting &&
+		ba.maxPacketsPerMessage == 0 &&
+		len(ba.blockedHeaderTypes) == 0 &&
+		!ba.notifyOnWriteError
+}
+
+// registerConnection 为连接分配ID并加入活动连接注册表，超出总连接数或单个客户端的连接数上限时返回错误
+func (ba *BridgeAcceptor) registerConnection(bc *BridgedConnection) error {
+	ba.connectionsMu.Lock()
+
+	if ba.maxConnections > 0 && len(ba.connections) >= ba.maxConnections {
+		ba.connectionsMu.Unlock()
+		return newBridgeError(ErrConnectionLimit, "accept", nil)
+	}
+	clientIP := clientIPOf(bc.SocketCouple.ClientBridgeSocket)
+	if active := ba.clientConnections[clientIP]; ba.maxConnectionsPerClient > 0 && active >= ba.maxConnectionsPerClient {
+		ba.connectionsMu.Unlock()
+		ba.onClientLimitExceeded(bc.SocketCouple.ClientBridgeSocket, clientIP, active)
+		return newBridgeError(ErrClientLimit, "accept "+clientIP, nil)
+	}
+
+	ba.nextConnectionID++
+	bc.id = ba.nextConnectionID
+	bc.clientIP = clientIP
+	ba.connections[bc.id] = bc
+	ba.clientConnections[clientIP]++
+	ba.connectionsMu.Unlock()
+	return nil
+}
+
+// unregisterConnection 从活动连接注册表中移除连接，可重复调用
+func (ba *BridgeAcceptor) unregisterConnection(bc *BridgedConnection) {
+	ba.connectionsMu.Lock()
+	defer ba.connectionsMu.Unlock()
+
+	if _, ok := ba.connections[bc.id]; !ok {
+		return
+	}
+	delete(ba.connections, bc.id)
+	if ba.clientConnections[bc.clientIP]--; ba.clientConnections[bc.clientIP] <= 0 {
+		delete(ba.clientConnections, bc.clientIP)
+	}
+}
+
+// clientIPOf 获取连接远端的IP，无法解析时使用完整地址
+func clientIPOf(conn net.Conn) string {
+	if conn == nil || conn.RemoteAddr() == nil {
+		return ""
+	}
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// Connections 获取当前所有活动连接的快照
+func (ba *BridgeAcceptor) Connections() []*BridgedConnection {
+	ba.connectionsMu.Lock()
+	defer ba.connectionsMu.Unlock()
+
+	conns := make([]*BridgedConnection, 0, len(ba.connections))
+	for _, bc := range ba.connections {
+		conns = append(conns, bc)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	return conns
+}
+
+// CloseConnection 关闭指定ID的连接，连接不存在时返回false
+func (ba *BridgeAcceptor) CloseConnection(id uint64) bool {
+	ba.connectionsMu.Lock()
+	bc, ok := ba.connections[id]
+	ba.connectionsMu.Unlock()
+
+	if !ok {
+		return false
+	}
+	bc.Close()
+	return true
+}
+
+// readyChan 获取当前的就绪信号，需持有mu
+func (ba *BridgeAcceptor) readyChan() chan struct{} {
+	if ba.ready == nil {
+		ba.ready = make(chan struct{})
+	}
+	return ba.ready
+}
+
+// WaitForReady 等待接受循环开始运行。可在Start之前调用，此时等待之后的Start；
+// ctx结束时返回ctx.Err()。Stop之后再次调用会等待下一次Start。
+func (ba *BridgeAcceptor) WaitForReady(ctx context.Context) error {
+	ba.mu.Lock()
+	ready := ba.readyChan()
+	ba.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isEnabled 检查是否启用
+func (ba *BridgeAcceptor) isEnabled() bool {
+	ba.mu.Lock()
+	defer ba.mu.Unlock()
+	return ba.enabled
+}
+
+// onTDSMessageReceived 触发TDS消息接收事件
+func (ba *BridgeAcceptor) onTDSMessageReceived(bc *BridgedConnection, msg TDSMessage) {
+	if handler := ba.tDSMessageReceivedHandler; handler != nil {
+		ba.dispatch(bc, func() { handler(bc, msg) })
+	}
+}
+
+// onTDSPacketReceived 触发TDS数据包接收事件
+func (ba *BridgeAcceptor) onTDSPacketReceived(bc *BridgedConnection, packet *TDSPacket) {
+	if handler := ba.tDSPacketReceivedHandler; handler != nil {
+		ba.dispatch(bc, func() { handler(bc, packet) })
+	}
+}
+
+// onLargeMessage 触发大消息事件
+func (ba *BridgeAcceptor) onLargeMessage(bc *BridgedConnection, msg TDSMessage, size int) {
+	if handler := ba.largeMessageHandler; handler != nil {
+		ba.dispatch(bc, func() { handler(bc, msg, size) })
+	}
+}
+
+// dispatch 在启用异步事件时将事件加入连接对应的队列，否则立即执行。
+// Stop之后已入队的事件仍会执行完，新的事件在当前goroutine上立即执行。
+func (ba *BridgeAcceptor) dispatch(bc *BridgedConnection, ev func()) {
+	ba.mu.Lock()
+	events := ba.events
+	ba.mu.Unlock()
+
+	if events != nil {
+		events.enqueue(bc.id, ev)
+		return
+	}
+	ev()
+}
+
+// AcceptInfo 连接策略处理函数收到的连接信息
+type AcceptInfo struct {
+	ID         uint64
+	Conn       net.Conn
+	AcceptedAt time.Time
+	// Connection 尚未启动转发的桥接连接，可用SetValue附加元数据供之后的事件读取
+	Connection *BridgedConnection
+}
+
+// ConnectionConfig 单个连接的配置覆盖，零值字段沿用BridgeAcceptor的设置
+type ConnectionConfig struct {
+	// Backend 后端端点，设置了后端选择器时仍由选择器决定
+	Backend     string
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
+}
+
+// apply 将配置覆盖应用到尚未启动的连接
+func (c *ConnectionConfig) apply(bc *BridgedConnection, endpoint *string) {
+	if c.Backend != "" {
+		*endpoint = c.Backend
+	}
+	if c.IdleTimeout > 0 {
+		bc.idleTimeout = c.IdleTimeout
+	}
+	if c.MaxLifetime > 0 {
+		bc.maxLifetime = c.MaxLifetime
+	}
+}
+
+// onConnectionAccepted 触发连接接受事件
+func (ba *BridgeAcceptor) onConnectionAccepted(conn net.Conn) {
+	if ba.connectionAcceptedHandler != nil {
+		ba.connectionAcceptedHandler(conn)
+	}
+}
+
+// onListeningThreadException 触发监听线程异常事件
+func (ba *BridgeAcceptor) onListeningThreadException(listener net.Listener, err error) {
+	if ba.listeningThreadExceptionHandler != nil {
+		ba.listeningThreadExceptionHandler(listener, err)
+	}
+}
+
+// onBridgeException 触发桥接异常事件
+func (ba *BridgeAcceptor) onBridgeException(bc *BridgedConnection, ct ConnectionType, err error) {
+	if ba.bridgeExceptionHandler != nil {
+		ba.bridgeExceptionHandler(bc, ct, err)
+	}
+}
+
+// onMessageBlocked 触发消息被拒绝事件
+func (ba *BridgeAcceptor) onMessageBlocked(bc *BridgedConnection, headerType HeaderType) {
+	if ba.messageBlockedHandler != nil {
+		ba.messageBlockedHandler(bc, headerType)
+	}
+}
+
+// onBulkInsert 触发批量插入事件
+func (ba *BridgeAcceptor) onBulkInsert(bc *BridgedConnection, batch *SQLBatchMessage, data *BulkLoadMessage) {
+	if ba.bulkInsertHandler != nil {
+		ba.bulkInsertHandler(bc, batch, data)
+	}
+}
+
+// onResponseComplete 触发响应完成事件
+func (ba *BridgeAcceptor) onResponseComplete(bc *BridgedConnection, msg *TabularResultMessage) {
+	if ba.responseCompleteHandler != nil {
+		ba.responseCompleteHandler(bc, msg)
+	}
+}
+
+// onClientLimitExceeded 触发客户端超限事件
+func (ba *BridgeAcceptor) onClientLimitExceeded(conn net.Conn, clientIP string, active int) {
+	if ba.clientLimitExceededHandler != nil {
+		ba.clientLimitExceededHandler(conn, clientIP, active)
+	}
+}
+
+// onServerError 触发服务器错误事件
+func (ba *BridgeAcceptor) onServerError(bc *BridgedConnection, serverError *ServerError) {
+	if ba.serverErrorHandler != nil {
+		ba.serverErrorHandler(bc, serverError)
+	}
+}
+
+// onEncryptionPolicyViolation 触发加密策略违反事件
+func (ba *BridgeAcceptor) onEncryptionPolicyViolation(bc *BridgedConnection, encryption byte) {
+	if ba.encryptionPolicyViolationHandler != nil {
+		ba.encryptionPolicyViolationHandler(bc, encryption)
+	}
+}
+
+// onPacketSequenceAnomaly 触发数据包序号异常事件
+func (ba *BridgeAcceptor) onPacketSequenceAnomaly(bc *BridgedConnection, anomaly *PacketSequenceAnomaly) {
+	if ba.packetSequenceAnomalyHandler != nil {
+		ba.packetSequenceAnomalyHandler(bc, anomaly)
+	}
+}
+
+// onTimeout 触发超时事件
+func (ba *BridgeAcceptor) onTimeout(bc *BridgedConnection, kind TimeoutKind) {
+	if ba.timeoutHandler != nil {
+		ba.timeoutHandler(bc, kind)
+	}
+}
+
+// onMessageError 触发消息错误事件
+func (ba *BridgeAcceptor) onMessageError(bc *BridgedConnection, msg TDSMessage, err error) {
+	if ba.messageErrorHandler != nil {
+		ba.messageErrorHandler(bc, msg, err)
+	}
+}
+
+// onMessageDropped 触发消息被丢弃事件
+func (ba *BridgeAcceptor) onMessageDropped(bc *BridgedConnection, msg TDSMessage) {
+	if ba.messageDroppedHandler != nil {
+		ba.messageDroppedHandler(bc, msg)
+	}
+}
+
+// onRPCDumpError 触发RPC转储错误事件
+func (ba *BridgeAcceptor) onRPCDumpError(bc *BridgedConnection, fileName string, err error) {
+	if ba.rpcDumpErrorHandler != nil {
+		ba.rpcDumpErrorHandler(bc, fileName, err)
+	}
+}
+
+// onConnectionRejected 触发连接拒绝事件
+func (ba *BridgeAcceptor) onConnectionRejected(conn net.Conn, err error) {
+	if ba.connectionRejectedHandler != nil {
+		ba.connectionRejectedHandler(conn, err)
+	}
+}
+
+// onConnectionDisconnected 触发连接断开事件
+func (ba *BridgeAcceptor) onConnectionDisconnected(bc *BridgedConnection, ct ConnectionType) {
+	if ba.connectionDisconnectedHandler != nil {
+		ba.connectionDisconnectedHandler(bc, ct)
+	}
+}
+
+// BridgedConnection 桥接连接结构体
+type BridgedConnection struct {
+	BridgeAcceptor *BridgeAcceptor
+	SocketCouple   *SocketCouple
+	mu             sync.Mutex
+
+	// 保护对客户端套接字的写入，避免注入的响应与服务器数据交错
+	clientWriteMu sync.Mutex
+
+	// 连接ID，由BridgeAcceptor分配
+	id uint64
+
+	// 协商的TDS版本
+	tdsVersion atomic.Uint32
+
+	// 登录请求了使消息解析不可靠的功能，之后的消息只转发不解析
+	parsingUnsupported atomic.Bool
+
+	// 客户端是否在PreLogin中请求了MARS，以及正在组装的客户端消息数
+	mars                atomic.Bool
+	outstandingMessages atomic.Int32
+
+	// 客户端最近一个完整消息的类型，用于判断服务器响应的格式
+	lastRequestType atomic.Uint32
+
+	// 本连接上的语句数(SQLBatch和RPC)
+	statementCount atomic.Uint64
+
+	// 创建时间和最后一次读到数据的时间(UnixNano)
+	createdAt    time.Time
+	lastActivity atomic.Int64
+
+	// 客户端消息的组装器，nil表示使用DefaultReassembler
+	reassembler Reassembler
+
+	// 等待与批量加载数据关联的INSERT BULK批处理，仅在客户端转发goroutine中访问
+	pendingInsertBulk *SQLBatchMessage
+
+	// 是否已从后端收到过数据
+	backendResponded atomic.Bool
+
+	// 客户端请求是否正在转发中(已收到部分数据包但尚未转发完结束包)
+	midMessage atomic.Bool
+	// 最长存活时间的定时器及是否已到期
+	lifetimeTimer   *time.Timer
+	lifetimeExpired atomic.Bool
+	// 服务器为本连接分配的SPID，取自服务器数据包头部
+	spid atomic.Uint32
+
+	// 本连接生效的最长存活时间和最长空闲时间
+	maxLifetime time.Duration
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	// 是否已断开(定时器不再重新计时)，受mu保护
+	disconnected bool
+	// 最先检测到断开的一侧，受mu保护
+	initiator ConnectionType
+	// 一侧已读到EOF并对另一侧执行了CloseWrite，以及另一方向是否正在继续转发，受mu保护
+	halfClosed bool
+	draining   bool
+	// 已退出的转发goroutine数，两个都退出后连接对完全关闭
+	exitedForwarders atomic.Int32
+	closed           atomic.Bool
+
+	// 由桥接器主动关闭连接的原因，受mu保护
+	disconnectReason error
+
+	// 调用方附加的元数据，受mu保护
+	values map[any]any
+
+	// 客户端IP，用于按客户端统计连接数
+	clientIP string
+
+	// 接受该连接的路由
+	route *bridgeRoute
+
+	// 会话记录，nil表示未启用
+	transcript *SessionTranscript
+
+	// 经路由映射重新连接时的真实目标
+	routingTarget string
+
+	// 等待响应的请求跟踪
+	requestSpans requestSpans
+
+	// 已转发、尚未得知结果的Login7
+	pendingLogin atomic.Pointer[Login7Message]
+	// 服务器返回的LOGINACK
+	loginAck atomic.Pointer[LoginAck]
+}
+
+// NewBridgedConnection 创建新的BridgedConnection
+func NewBridgedConnection(bridgeAcceptor *BridgeAcceptor, socketCouple *SocketCouple) *BridgedConnection {
+	bc := &BridgedConnection{
+		BridgeAcceptor: bridgeAcceptor,
+		SocketCouple:   socketCouple,
+		createdAt:      time.Now(),
+		maxLifetime:    bridgeAcceptor.maxLifetime,
+		idleTimeout:    bridgeAcceptor.idleTimeout,
+	}
+	if n := bridgeAcceptor.maxTranscriptEntries; n > 0 {
+		bc.transcript = newSessionTranscript(n)
+	}
+	bc.lastActivity.Store(bc.createdAt.UnixNano())
+	return bc
+}
+
+// ID 获取连接ID
+func (bc *BridgedConnection) ID() uint64 {
+	return bc.id
+}
+
+// Close 关闭桥接连接的两端，转发goroutine随后退出并触发断开事件
+func (bc *BridgedConnection) Close() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.SocketCouple.Close()
+}
+
+// closeWithReason 记录原因后关闭连接
+func (bc *BridgedConnection) closeWithReason(reason error) {
+	bc.mu.Lock()
+	if bc.disconnectReason == nil {
+		bc.disconnectReason = reason
+	}
+	bc.mu.Unlock()
+	bc.Close()
+}
+
+// SPID 获取服务器为本连接分配的SPID，尚未得知时返回0
+func (bc *BridgedConnection) SPID() uint16 {
+	return uint16(bc.spid.Load())
+}
+
+// captures 检查SPID过滤器是否允许捕获给定SPID的流量，SPID为0时使用连接的SPID
+func (bc *BridgedConnection) captures(spid uint16) bool {
+	filter := bc.BridgeAcceptor.captureSPID
+	if filter == 0 {
+		return true
+	}
+	if spid == 0 {
+		spid = bc.SPID()
+	}
+	return spid == filter
+}
+
+// captureFrame 在启用捕获且SPID过滤器允许时写入一帧，parts依次拼接为帧数据
+func (bc *BridgedConnection) captureFrame(source ConnectionType, spid uint16, parts ...[]byte) {
+	capture := bc.BridgeAcceptor.capture.Load()
+	if capture == nil || !bc.captures(spid) {
+		return
+	}
+	var data []byte
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	capture.WriteFrame(&CaptureFrame{
+		Time:         time.Now(),
+		ConnectionID: bc.id,
+		Source:       source,
+		Data:         data,
+	})
+}
+
+// traceFrame 在启用协议跟踪时写入一帧的注释行
+func (bc *BridgedConnection) traceFrame(source ConnectionType, frame []byte, isTLSRecord bool) {
+	if tracer := bc.BridgeAcceptor.tracer; tracer != nil {
+		tracer.trace(bc.id, source, frame, isTLSRecord)
+	}
+}
+
+// capturesMessage 检查SPID过滤器是否允许捕获消息，按第一个数据包的SPID判断
+func (bc *BridgedConnection) capturesMessage(msg TDSMessage) bool {
+	var spid uint16
+	if packets := msg.GetPackets(); len(packets) > 0 {
+		spid = packets[0].Header.SPID()
+	}
+	return bc.captures(spid)
+}
+
+// DisconnectReason 获取桥接器主动关闭连接的原因(如ErrMaxLifetime)，
+// 连接由任一端自行断开时返回nil
+func (bc *BridgedConnection) DisconnectReason() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.disconnectReason
+}
+
+// checkIdle 空闲定时器到期：空闲时间已达上限则关闭连接，否则按剩余时间重新计时
+func (bc *BridgedConnection) checkIdle() {
+	idle := bc.IdleTime()
+	if idle >= bc.idleTimeout {
+		bc.onTimeout(TimeoutIdle)
+		bc.closeWithReason(ErrIdleTimeout)
+		return
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if !bc.disconnected {
+		bc.idleTimer.Reset(bc.idleTimeout - idle)
+	}
+}
+
+// expireLifetime 最长存活时间到期：不在转发请求时立即关闭，否则由客户端转发goroutine在消息结束后关闭
+func (bc *BridgedConnection) expireLifetime() {
+	bc.lifetimeExpired.Store(true)
+	if !bc.midMessage.Load() {
+		bc.onTimeout(TimeoutLifetime)
+		bc.closeWithReason(ErrMaxLifetime)
+	}
+}
+
+// CreatedAt 获取连接创建时间
+func (bc *BridgedConnection) CreatedAt() time.Time {
+	return bc.createdAt
+}
+
+// LastActivity 获取任一方向最后一次读到数据的时间
+func (bc *BridgedConnection) LastActivity() time.Time {
+	return time.Unix(0, bc.lastActivity.Load())
+}
+
+// Age 获取连接已建立的时长
+func (bc *BridgedConnection) Age() time.Duration {
+	return time.Since(bc.createdAt)
+}
+
+// IdleTime 获取距最后一次读到数据的时长
+func (bc *BridgedConnection) IdleTime() time.Duration {
+	return time.Since(bc.LastActivity())
+}
+
+// touch 记录一次数据活动
+func (bc *BridgedConnection) touch() {
+	bc.lastActivity.Store(time.Now().UnixNano())
+}
+
+// StatementCount 获取本连接上已发送的语句数(SQLBatch和RPC)
+func (bc *BridgedConnection) StatementCount() uint64 {
+	return bc.statementCount.Load()
+}
+
+// TDSVersion 获取本连接协商的TDS版本，登录前返回TDSVersionUnknown
+func (bc *BridgedConnection) TDSVersion() TDSVersion {
+	return TDSVersion(bc.tdsVersion.Load())
+}
+
+// ParsingSupported 检查本会话的消息能否可靠解析。客户端的Login7请求了、或服务器在登录响应的
+// FEATUREEXTACK中确认了改变令牌流或数据类型格式的功能(列加密、数据分类、JSON或向量类型)时返回false，
+// 此后该连接的消息只原样转发：不再组装和解析消息，不触发消息事件，改写和拦截也不再生效；数据包事件不受影响。
+func (bc *BridgedConnection) ParsingSupported() bool {
+	return !bc.parsingUnsupported.Load()
+}
+
+// markUnsupportedFeatures 功能中有使解析不可靠的功能时，将会话标记为不支持解析
+func (bc *BridgedConnection) markUnsupportedFeatures(features []FeatureExt) {
+	for _, feature := range features {
+		if feature.ID.breaksParsing() {
+			bc.parsingUnsupported.Store(true)
+			return
+		}
+	}
+}
+
+// Start 启动桥接连接
+func (bc *BridgedConnection) Start() {
+	bc.mu.Lock()
+	if bc.maxLifetime > 0 {
+		bc.lifetimeTimer = time.AfterFunc(bc.maxLifetime-time.Since(bc.createdAt), bc.expireLifetime)
+	}
+	if bc.idleTimeout > 0 {
+		bc.idleTimer = time.AfterFunc(bc.idleTimeout, bc.checkIdle)
+	}
+	bc.mu.Unlock()
+
+	// 无需逐包处理时直接在两个方向上io.Copy；io.Copy不记录活动时间，空闲超时需要逐包转发
+	if bc.idleTimeout == 0 && bc.BridgeAcceptor.canUseFastPath() {
+		go bc.copyStream(ClientBridge, bc.SocketCouple.BridgeSQLSocket, bc.SocketCouple.ClientBridgeSocket)
+		go bc.copyStream(BridgeSQL, bc.SocketCouple.ClientBridgeSocket, bc.SocketCouple.BridgeSQLSocket)
+		return
+	}
+
+	// 启动客户端到SQL Server的goroutine
+	go bc.clientBridgeToSQLServer()
+	// 启动SQL Server到客户端的goroutine
+	go bc.sqlServerToClientBridge()
+}
+
+// copyStream 快速路径：不分帧，直接复制一个方向上的字节流。
+// 与逐包循环一样，结束时触发桥接异常事件和连接断开事件；但不会更新最后活动时间。
+func (bc *BridgedConnection) copyStream(ct ConnectionType, dst net.Conn, src net.Conn) {
+	defer func() {
+		bc.onConnectionDisconnected(ct)
+	}()
+
+	n, err := io.Copy(dst, src)
+	if err == nil {
+		err = io.EOF
+	}
+	if ct == BridgeSQL && n == 0 {
+		err = bc.failUnresponsiveBackend("read", err)
+	}
+	bc.halfCloseAfter(ct, err)
+	bc.onBridgeException(ct, err)
+}
+
+// clientBridgeToSQLServer 处理从客户端到SQL Server的数据传输
+func (bc *BridgedConnection) clientBridgeToSQLServer() {
+	defer func() {
+		bc.onConnectionDisconnected(ClientBridge)
+	}()
+
+	reader := NewTDSReader(bc.SocketCouple.ClientBridgeSocket)
+	reassembler := bc.reassembler
+	if reassembler == nil {
+		reassembler = NewDefaultReassembler()
+	}
+	// 组装中的消息已累计的有效载荷字节数和数据包数
+	messageBytes := 0
+	messagePackets := 0
+	// 下一个数据包是否为新消息的第一个数据包
+	firstPacket := true
+	// 当前消息是否被禁止转发
+	var blockedType HeaderType
+	blocked := false
+	// 当前消息是否解析，在每个消息的第一个数据包处确定
+	parsing := false
+	// 当前消息是否需缓存到END_OF_MESSAGE再转发(登录改写或拦截)，以及代替它转发的数据
+	holding := false
+	var replacement []byte
+	var sequence packetSequence
+	var framing messageFraming
+	// 合并写入时缓存的当前消息的数据包
+	var pending []byte
+	// 捕获时隐藏登录密码；捕获可能在消息中途开启，因此始终跟踪登录消息
+	var redactor loginRedactor
+	redactsLogin := !bc.BridgeAcceptor.captureSecrets
+
+	for {
+		// 接收一帧：TDS数据包(切片指向读取器缓冲区，下一次读取前有效)，或加密后直接传输的TLS记录
+		var frame []byte
+		isTLSRecord, err := reader.NextIsTLSRecord()
+		if err == nil {
+			if isTLSRecord {
+				frame, err = reader.ReadTLSRecord()
+			} else {
+				frame, err = reader.PeekPacket()
+			}
+		}
+		if err != nil {
+			bc.halfCloseAfter(ClientBridge, err)
+			bc.onBridgeException(ClientBridge, err)
+			return
+		}
+		bc.touch()
+
+		// TLS记录无法解析，原样转发
+		if isTLSRecord {
+			bc.captureFrame(ClientBridge, 0, frame)
+			bc.traceFrame(ClientBridge, frame, true)
+			if chaos := bc.BridgeAcceptor.chaosPolicy; chaos != nil {
+				var drop bool
+				if frame, drop = chaos.ClientToServer.apply(frame, false); drop {
+					continue
+				}
+			}
+			if _, err = bc.SocketCouple.BridgeSQLSocket.Write(frame); err != nil {
+				bc.onBridgeException(ClientBridge, err)
+				return
+			}
+			continue
+		}
+		bc.midMessage.Store(true)
+
+		bHeader := frame[:HEADER_SIZE]
+		header := NewTDSHeader(bHeader)
+		if bc.BridgeAcceptor.packetRewriter != nil {
+			bc.rewritePacketHeader(header, bHeader)
+		}
+		endOfMessage := (header.StatusBitMask() & END_OF_MESSAGE) == END_OF_MESSAGE
+		isFirstPacket := firstPacket
+		firstPacket = endOfMessage
+		if isFirstPacket {
+			parsing = !bc.BridgeAcceptor.parsingDisabled && bc.ParsingSupported()
+		}
+
+		// 待发送的有效载荷
+		payload := frame[HEADER_SIZE:]
+		if redactsLogin {
+			bc.captureFrame(ClientBridge, header.SPID(), redactor.redact(frame, header, isFirstPacket))
+		} else {
+			bc.captureFrame(ClientBridge, header.SPID(), frame)
+		}
+		bc.traceFrame(ClientBridge, frame, false)
+		bc.checkPacketSequence(&sequence, ClientBridge, header)
+
+		// 前一个消息未结束时开始新消息属于协议错误，继续转发会使服务器收到错乱的请求
+		if err = framing.add(header, bc.mars.Load()); err != nil {
+			bc.onBridgeException(ClientBridge, newBridgeError(ErrProtocol, "assemble "+header.Type().String(), err))
+			return
+		}
+		bc.outstandingMessages.Store(int32(len(framing.open)))
+
+		// 限制单个消息的数据包数
+		if isFirstPacket {
+			messagePackets = 0
+		}
+		messagePackets++
+		if limit := bc.BridgeAcceptor.maxPacketsPerMessage; limit > 0 && messagePackets > limit {
+			bc.onBridgeException(ClientBridge, newBridgeError(ErrProtocol, "assemble "+header.Type().String(),
+				fmt.Errorf("%w: message exceeds %d packets", ErrBufferLimit, limit)))
+			return
+		}
+
+		// 创建TDS数据包
+		var tdsPacket *TDSPacket
+		bc.recordPayloadSize(ClientBridge, header, len(payload))
+		if parsing || bc.BridgeAcceptor.tDSPacketReceivedHandler != nil {
+			// 按实际收到并将转发的字节构建，而非头部声明的长度
+			tdsPacket = NewTDSPacket(bHeader, payload, len(payload))
+
+			// 触发数据包接收事件
+			bc.onTDSPacketReceived(tdsPacket)
+		}
+
+		// 检查消息类型是否被禁止，类型只能从消息的第一个数据包得知
+		if isFirstPacket && bc.BridgeAcceptor.blockedHeaderTypes[header.Type()] {
+			blocked = true
+			blockedType = header.Type()
+		}
+		if blocked {
+			if endOfMessage {
+				blocked = false
+				bc.onMessageBlocked(blockedType)
+				response := BuildErrorResponse(BRIDGE_ERROR_BLOCKED, 16,
+					fmt.Sprintf("%s messages are not allowed through this bridge.", blockedType))
+				if err = bc.writeToClient(response); err != nil {
+					bc.onBridgeException(ClientBridge, err)
+					return
+				}
+			}
+			bc.midMessage.Store(!endOfMessage)
+			continue
+		}
+		if isFirstPacket {
+			holding = parsing && bc.BridgeAcceptor.holdsMessage(header.Type())
+		}
+
+		// 本数据包是否使组装器完成了一个消息
+		completed := false
+		if parsing {
+			// 构建消息
+			messageBytes += len(tdsPacket.Payload)
+			if limit := bc.BridgeAcceptor.maxMessageBytes; limit > 0 && messageBytes > limit {
+				bc.onBridgeException(ClientBridge, newBridgeError(ErrProtocol, "assemble "+header.Type().String(),
+					fmt.Errorf("%w: message payload exceeds %d bytes", ErrBufferLimit, limit)))
+				return
+			}
+			var tdsMessage TDSMessage
+			tdsMessage, completed = reassembler.AddPacket(tdsPacket)
+
+			// 检查消息是否完成
+			if completed {
+				size := messageBytes
+				messageBytes = 0
+				bc.inspectMessage(tdsMessage)
+				if bc.violatesEncryptionPolicy(tdsMessage) && bc.BridgeAcceptor.refuseUnencrypted {
+					bc.writeToClient(BuildErrorResponse(BRIDGE_ERROR_ENCRYPTION_REQUIRED, 20,
+						"This bridge requires an encrypted connection. Enable encryption in the client connection settings."))
+					bc.closeWithReason(ErrEncryptionRequired)
+					return
+				}
+				bc.onTDSMessageReceived(tdsMessage)
+				if threshold := bc.BridgeAcceptor.largeMessageThreshold; threshold > 0 && size > threshold {
+					bc.BridgeAcceptor.onLargeMessage(bc, tdsMessage, size)
+				}
+				if bc.BridgeAcceptor.bulkInsertHandler != nil {
+					bc.correlateBulkLoad(tdsMessage)
+				}
+				// 服务器会丢弃设置了忽略位的消息，也不作回应，因此无需转发
+				if holding && bc.BridgeAcceptor.dropIgnoredMessages && tdsMessage.HasIgnoreBitSet() {
+					holding = false
+					bc.abandonRequestSpan(tdsMessage)
+					bc.BridgeAcceptor.onMessageDropped(bc, tdsMessage)
+					bc.midMessage.Store(false)
+					continue
+				}
+				if holding {
+					var drop bool
+					rejection := fmt.Sprintf("The %s message was rejected by this bridge.", header.Type())
+					if message, blocked := bc.checkQueryCost(tdsMessage); blocked {
+						drop, rejection = true, message
+					} else if replacement, drop, err = bc.interceptMessage(tdsMessage); err != nil {
+						bc.onBridgeException(ClientBridge, newBridgeError(ErrProtocol, "rewrite "+header.Type().String(), err))
+						return
+					}
+					if drop {
+						holding = false
+						bc.abandonRequestSpan(tdsMessage)
+						bc.onMessageBlocked(header.Type())
+						response := BuildErrorResponse(BRIDGE_ERROR_BLOCKED, 16, rejection)
+						if err = bc.writeToClient(response); err != nil {
+							bc.onBridgeException(ClientBridge, err)
+							return
+						}
+						bc.midMessage.Store(false)
+						continue
+					}
+				}
+			}
+		}
+
+		// 缓存的消息在完整接收后一次转发(改写后的数据包或原样的数据包)
+		if holding {
+			if !completed {
+				continue
+			}
+			holding = false
+			data := replacement
+			replacement = nil
+			if chaos := bc.BridgeAcceptor.chaosPolicy; chaos != nil {
+				chaos.ClientToServer.delayMessage(header.Type())
+			}
+			bc.checkForwardedPackets(ClientBridge, data)
+			if _, err = bc.SocketCouple.BridgeSQLSocket.Write(data); err != nil {
+				bc.notifyClientOfBackendWriteError(err)
+				bc.onBridgeException(ClientBridge, err)
+				return
+			}
+			bc.midMessage.Store(false)
+			continue
+		}
+
+		// 混沌测试：延迟、丢弃或损坏数据包
+		if chaos := bc.BridgeAcceptor.chaosPolicy; chaos != nil {
+			if endOfMessage {
+				chaos.ClientToServer.delayMessage(header.Type())
+			}
+			var drop bool
+			payload, drop = chaos.ClientToServer.apply(payload, endOfMessage)
+			if drop {
+				bc.midMessage.Store(!endOfMessage)
+				continue
+			}
+		}
+
+		// 发送头部和有效载荷到SQL Server；合并写入时多包消息缓存到END_OF_MESSAGE一次写出
+		bc.checkPacketLength(ClientBridge, header, len(payload))
+		if bc.BridgeAcceptor.coalesceMessages && (!endOfMessage || len(pending) > 0) {
+			pending = append(pending, bHeader...)
+			pending = append(pending, payload...)
+			if !endOfMessage && len(pending) < bc.BridgeAcceptor.coalesceLimit() {
+				continue
+			}
+			_, err = bc.SocketCouple.BridgeSQLSocket.Write(pending)
+			pending = pending[:0]
+		} else {
+			buffers := net.Buffers{bHeader, payload}
+			_, err = buffers.WriteTo(bc.SocketCouple.BridgeSQLSocket)
+		}
+		if err != nil && !bc.backendResponded.Load() {
+			bc.onBridgeException(ClientBridge, bc.failUnresponsiveBackend("write", err))
+			return
+		}
+		if err != nil {
+			bc.notifyClientOfBackendWriteError(err)
+			bc.onBridgeException(ClientBridge, err)
+			return
+		}
+
+		// 请求已完整转发，存活时间已到期则在此关闭
+		bc.midMessage.Store(!endOfMessage)
+		if endOfMessage && bc.lifetimeExpired.Load() {
+			bc.onTimeout(TimeoutLifetime)
+			bc.closeWithReason(ErrMaxLifetime)
+			return
+		}
+	}
+}
+
+// sqlServerToClientBridge 处理从SQL Server到客户端的数据传输
+func (bc *BridgedConnection) sqlServerToClientBridge() {
+	defer func() {
+		bc.onConnectionDisconnected(BridgeSQL)
+	}()
+
+	reader := NewTDSReader(bc.SocketCouple.BridgeSQLSocket)
+	assemble := bc.BridgeAcceptor.needsServerMessages()
+	// 开启解析时总是组装登录响应，以跟踪服务器确认的功能
+	parsing := !bc.BridgeAcceptor.parsingDisabled
+	var response TDSMessage
+	var sequence packetSequence
+	// 预读登录时后端已在握手中回应过，无需再检查
+	verifying := bc.BridgeAcceptor.verifyBackendProtocol && !bc.backendResponded.Load()
+	// 改写路由时缓存登录响应，直到收到包含LOGINACK的完整响应
+	rerouting := bc.BridgeAcceptor.rewriteRouting && !bc.BridgeAcceptor.parsingDisabled
+	var heldLogin []byte
 
 	for {
-		// 接收数据
-		n, err := bc.SocketCouple.BridgeSQLSocket.Read(bBuffer)
-		if err != nil || n == 0 {
+		// 转发后端的第一个数据包之前确认它是TDS
+		if verifying {
+			verifying = false
+			if err := bc.verifyBackendProtocol(reader); err != nil {
+				bc.onBridgeException(BridgeSQL, bc.failBackendProtocol(err))
+				return
+			}
+		}
+
+		// 接收一帧：TDS数据包，或加密后直接传输的TLS记录
+		var data []byte
+		endOfMessage := false
+		isTLSRecord, err := reader.NextIsTLSRecord()
+		if err == nil {
+			if isTLSRecord {
+				if data, err = reader.ReadTLSRecord(); err == nil {
+					bc.captureFrame(BridgeSQL, 0, data)
+					bc.traceFrame(BridgeSQL, data, true)
+				}
+			} else {
+				// 数据包切片指向读取器缓冲区，在下一次读取前转发完毕
+				if data, err = reader.PeekPacket(); err == nil {
+					header := NewTDSHeader(data)
+					if spid := header.SPID(); spid != 0 {
+						bc.spid.Store(uint32(spid))
+					}
+					bc.captureFrame(BridgeSQL, header.SPID(), data)
+					bc.traceFrame(BridgeSQL, data, false)
+					bc.checkPacketSequence(&sequence, BridgeSQL, header)
+					bc.recordPayloadSize(BridgeSQL, header, len(data)-HEADER_SIZE)
+					endOfMessage = (header.StatusBitMask() & END_OF_MESSAGE) == END_OF_MESSAGE
+
+					// 构建响应消息，会话不再支持解析时只完成正在组装的响应
+					if response != nil || (bc.ParsingSupported() &&
+						(assemble || parsing && isLoginRequest(HeaderType(bc.lastRequestType.Load())))) {
+						packet := NewTDSPacketFromBuffer(data)
+						if response == nil {
+							response = CreateTDSMessageFromFirstPacket(packet)
+						} else {
+							response.AddPacket(packet)
+						}
+						if endOfMessage {
+							bc.inspectResponse(response)
+							response = nil
+						}
+					}
+				}
+			}
+		}
+		if err != nil {
+			if !bc.backendResponded.Load() {
+				err = bc.failUnresponsiveBackend("read", err)
+			}
+			bc.halfCloseAfter(BridgeSQL, err)
 			bc.onBridgeException(BridgeSQL, err)
 			return
 		}
+		bc.backendResponded.Store(true)
+		bc.touch()
+
+		// 登录响应缓存到END_OF_MESSAGE，改写其中的路由目标后一次转发
+		if rerouting && !isTLSRecord && (heldLogin != nil || isLoginRequest(HeaderType(bc.lastRequestType.Load()))) {
+			heldLogin = append(heldLogin, data...)
+			if !endOfMessage {
+				continue
+			}
+			var loggedIn bool
+			data, loggedIn = bc.rewriteLoginResponse(heldLogin)
+			heldLogin = nil
+			rerouting = !loggedIn
+		}
+
+		// 混沌测试：延迟、丢弃或损坏数据包
+		if chaos := bc.BridgeAcceptor.chaosPolicy; chaos != nil {
+			if endOfMessage {
+				chaos.ServerToClient.delayMessage(HeaderType(data[0]))
+			}
+			var drop bool
+			data, drop = chaos.ServerToClient.apply(data, endOfMessage)
+			if drop {
+				continue
+			}
+		}
 
 		// 发送数据到客户端
-		_, err = bc.SocketCouple.ClientBridgeSocket.Write(bBuffer[:n])
+		if !isTLSRecord {
+			bc.checkForwardedPackets(BridgeSQL, data)
+		}
+		err = bc.writeToClient(data)
 		if err != nil {
 			bc.onBridgeException(BridgeSQL, err)
 			return
@@ -367,44 +2165,281 @@ func (bc *BridgedConnection) sqlServerToClientBridge() {
 	}
 }
 
+// inspectResponse 处理完整的服务器响应消息。
+// PreLogin请求的响应不是令牌流，不做令牌解析。
+func (bc *BridgedConnection) inspectResponse(msg TDSMessage) {
+	result, ok := msg.(*TabularResultMessage)
+	isPreLoginResponse := HeaderType(bc.lastRequestType.Load()) == PreLoginMessage
+	if ok && !isPreLoginResponse {
+		result.SetTDSVersion(bc.TDSVersion())
+	}
+	bc.sinkResponse(msg)
+
+	if log := bc.BridgeAcceptor.jsonLog; log != nil && bc.capturesMessage(msg) {
+		rec := NewMessageRecord(bc.id, DirectionServerToClient, msg)
+		rec.Route = bc.Route()
+		log.write(rec)
+	}
+	bc.recordTranscript(BridgeSQL, msg, isPreLoginResponse)
+
+	if ok && isLoginRequest(HeaderType(bc.lastRequestType.Load())) {
+		// 解析错误时只按已确认的功能判断
+		features, _ := result.Features()
+		bc.markUnsupportedFeatures(features)
+	}
+
+	if ok && !isPreLoginResponse && bc.BridgeAcceptor.serverErrorHandler != nil {
+		// 解析错误不影响转发，只报告已解码的错误
+		serverErrors, _ := result.GetServerErrors()
+		for _, serverError := range serverErrors {
+			bc.onServerError(serverError)
+		}
+	}
+
+	if ok && !isPreLoginResponse && result.IsFinalResponse() {
+		bc.checkLoginResult(result)
+		bc.endRequestSpan(result)
+		bc.onResponseComplete(result)
+	}
+}
+
+// violatesEncryptionPolicy 检查要求加密时客户端的PreLogin是否未请求加密，违反时触发事件
+func (bc *BridgedConnection) violatesEncryptionPolicy(msg TDSMessage) bool {
+	if !bc.BridgeAcceptor.requireEncryption {
+		return false
+	}
+	preLogin, ok := msg.(*PreLoginRequestMessage)
+	if !ok {
+		return false
+	}
+	encryption, ok := preLogin.GetEncryption()
+	if !ok {
+		// TLS握手数据同样使用PreLogin类型，没有选项表
+		return false
+	}
+	if encryption == ENCRYPT_ON || encryption == ENCRYPT_REQ {
+		return false
+	}
+	bc.BridgeAcceptor.onEncryptionPolicyViolation(bc, encryption)
+	return true
+}
+
+// inspectMessage 从完整的客户端消息中提取会话状态
+func (bc *BridgedConnection) inspectMessage(msg TDSMessage) {
+	if packets := msg.GetPackets(); len(packets) > 0 {
+		bc.lastRequestType.Store(uint32(packets[0].Header.Type()))
+		if rates := bc.BridgeAcceptor.messageRates; rates != nil {
+			rates.record(packets[0].Header.Type())
+		}
+	}
+
+	switch m := msg.(type) {
+	case *PreLoginRequestMessage:
+		bc.mars.Store(isMARS(m))
+	case *SQLBatchMessage:
+		m.SetTDSVersion(bc.TDSVersion())
+		m.SetTextEncoding(bc.BridgeAcceptor.batchTextEncoding)
+	case *RPCRequestMessage:
+		m.SetTDSVersion(bc.TDSVersion())
+		m.SetMaxParameters(bc.BridgeAcceptor.maxRPCParameters)
+		if bc.BridgeAcceptor.messageErrorHandler != nil {
+			if _, err := m.GetParameters(); err != nil {
+				bc.BridgeAcceptor.onMessageError(bc, msg, err)
+			}
+		}
+	}
+
+	switch msg.(type) {
+	case *SQLBatchMessage, *RPCRequestMessage:
+		bc.statementCount.Add(1)
+		if stats := bc.BridgeAcceptor.queryStats; stats != nil {
+			if statement, ok := statementOf(msg); ok {
+				stats.record(statement, len(msg.AssemblePayload()))
+			}
+		}
+	}
+
+	switch m := msg.(type) {
+	case *Login7Message:
+		m.SetRevealPassword(bc.BridgeAcceptor.captureSecrets)
+		bc.pendingLogin.Store(m)
+		if version := m.GetTDSVersion(); version != TDSVersionUnknown {
+			bc.tdsVersion.Store(uint32(version))
+		}
+		// 按客户端的请求保守判断，服务器可能并未确认这些功能
+		features, _ := m.Features()
+		bc.markUnsupportedFeatures(features)
+	}
+
+	if log := bc.BridgeAcceptor.jsonLog; log != nil && bc.capturesMessage(msg) {
+		rec := NewMessageRecord(bc.id, DirectionClientToServer, msg)
+		rec.Route = bc.Route()
+		log.write(rec)
+	}
+	bc.recordTranscript(ClientBridge, msg, false)
+	bc.startRequestSpan(msg)
+	bc.dumpRPC(msg)
+}
+
+// notifyClientOfBackendWriteError 按配置向客户端发送写SQL Server失败的TDS错误，尽力而为
+func (bc *BridgedConnection) notifyClientOfBackendWriteError(err error) {
+	if !bc.BridgeAcceptor.notifyOnWriteError {
+		return
+	}
+	response := BuildErrorResponse(BRIDGE_ERROR_BACKEND_WRITE, 20,
+		fmt.Sprintf("The bridge failed to forward the request to SQL Server: %v", err))
+	bc.writeToClient(response)
+}
+
+// failUnresponsiveBackend 处理后端在发出任何数据之前断开的情况：记录ErrBackendClosed为断开原因，
+// 向客户端回复TDS错误使其不必等待，并返回同时匹配ErrBackendClosed和err的错误。
+// 连接已因其他原因断开时原样返回err。
+func (bc *BridgedConnection) failUnresponsiveBackend(op string, err error) error {
+	bc.mu.Lock()
+	if bc.disconnected || bc.disconnectReason != nil {
+		bc.mu.Unlock()
+		return err
+	}
+	bc.disconnectReason = ErrBackendClosed
+	bc.mu.Unlock()
+
+	err = newBridgeError(ErrBackendClosed, op+" backend", err)
+	bc.writeToClient(BuildErrorResponse(BRIDGE_ERROR_BACKEND_CLOSED, 20,
+		"SQL Server closed the connection before responding. Try again later."))
+	return err
+}
+
+// writeToClient 向客户端写入数据
+func (bc *BridgedConnection) writeToClient(data []byte) error {
+	bc.clientWriteMu.Lock()
+	defer bc.clientWriteMu.Unlock()
+	_, err := bc.SocketCouple.ClientBridgeSocket.Write(data)
+	return err
+}
+
+// onServerError 触发服务器错误事件
+func (bc *BridgedConnection) onServerError(serverError *ServerError) {
+	bc.BridgeAcceptor.onServerError(bc, serverError)
+}
+
+// onResponseComplete 触发响应完成事件
+func (bc *BridgedConnection) onResponseComplete(msg *TabularResultMessage) {
+	bc.BridgeAcceptor.onResponseComplete(bc, msg)
+}
+
+// onBulkInsert 触发批量插入事件
+func (bc *BridgedConnection) onBulkInsert(batch *SQLBatchMessage, data *BulkLoadMessage) {
+	bc.BridgeAcceptor.onBulkInsert(bc, batch, data)
+}
+
+// onMessageBlocked 触发消息被拒绝事件
+func (bc *BridgedConnection) onMessageBlocked(headerType HeaderType) {
+	bc.BridgeAcceptor.onMessageBlocked(bc, headerType)
+}
+
 // onTDSMessageReceived 触发TDS消息接收事件
 func (bc *BridgedConnection) onTDSMessageReceived(msg TDSMessage) {
-	bc.BridgeAcceptor.onTDSMessageReceived(bc, msg)
+	if bc.capturesMessage(msg) {
+		bc.BridgeAcceptor.onTDSMessageReceived(bc, msg)
+		bc.observe(msg)
+	}
 }
 
 // onTDSPacketReceived 触发TDS数据包接收事件
 func (bc *BridgedConnection) onTDSPacketReceived(packet *TDSPacket) {
-	bc.BridgeAcceptor.onTDSPacketReceived(bc, packet)
+	if bc.captures(packet.Header.SPID()) {
+		bc.BridgeAcceptor.onTDSPacketReceived(bc, packet)
+	}
 }
 
 // onBridgeException 触发桥接异常事件
 func (bc *BridgedConnection) onBridgeException(ct ConnectionType, err error) {
-	bc.BridgeAcceptor.onBridgeException(bc, ct, err)
+	if kind, ok := ioTimeoutKind(err); ok {
+		bc.onTimeout(kind)
+	}
+	bc.BridgeAcceptor.onBridgeException(bc, ct, classifyError(ct.String(), err))
 }
 
-// onConnectionDisconnected 触发连接断开事件并关闭相应的连接
-func (bc *BridgedConnection) onConnectionDisconnected(ct ConnectionType) {
-	bc.BridgeAcceptor.onConnectionDisconnected(bc, ct)
+// halfCloseAfter 在启用半关闭且ct一侧读到EOF时，关闭另一侧的写方向，使其转发goroutine继续运行
+func (bc *BridgedConnection) halfCloseAfter(ct ConnectionType, err error) {
+	if !bc.BridgeAcceptor.halfCloseSupport || !errors.Is(err, io.EOF) {
+		return
+	}
+	peer := bc.SocketCouple.BridgeSQLSocket
+	if ct == BridgeSQL {
+		peer = bc.SocketCouple.ClientBridgeSocket
+	}
 
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
+	if !bc.disconnected && !bc.halfClosed && closeWrite(peer) {
+		bc.halfClosed = true
+	}
+}
 
-	switch ct {
-	case ClientBridge:
-		if bc.SocketCouple.BridgeSQLSocket != nil {
-			bc.SocketCouple.BridgeSQLSocket.Close()
+// onConnectionDisconnected 在转发goroutine退出时调用。最先退出的一侧被记录为发起方，
+// 并关闭两侧套接字使另一个goroutine退出(即使它阻塞在写入上)；两个goroutine都退出后才触发唯一一次断开事件，
+// 事件的ConnectionType参数为发起方。发起方已半关闭时保留套接字，由另一个goroutine退出时关闭。
+func (bc *BridgedConnection) onConnectionDisconnected(ct ConnectionType) {
+	bc.mu.Lock()
+	if bc.halfClosed && !bc.draining {
+		bc.draining = true
+		bc.initiator = ct
+	} else if !bc.disconnected {
+		bc.disconnected = true
+		if !bc.draining {
+			bc.initiator = ct
 		}
-	case BridgeSQL:
-		if bc.SocketCouple.ClientBridgeSocket != nil {
-			bc.SocketCouple.ClientBridgeSocket.Close()
+		if bc.lifetimeTimer != nil {
+			bc.lifetimeTimer.Stop()
+		}
+		if bc.idleTimer != nil {
+			bc.idleTimer.Stop()
 		}
+		bc.SocketCouple.Close()
+	}
+	initiator := bc.initiator
+	bc.mu.Unlock()
+
+	if bc.exitedForwarders.Add(1) < 2 {
+		return
 	}
+	bc.closed.Store(true)
+	bc.BridgeAcceptor.unregisterConnection(bc)
+	bc.endPendingRequestSpans()
+	bc.BridgeAcceptor.onConnectionDisconnected(bc, initiator)
 }
 
-// max 返回两个整数中的较大值
-func max(a, b int) int {
-	if a > b {
-		return a
+// SetValue 为连接附加元数据(如租户、跟踪ID)，可在任意事件处理函数中用Value读取。
+// 可并发调用；value为nil时删除该键。
+func (bc *BridgedConnection) SetValue(key, value any) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if value == nil {
+		delete(bc.values, key)
+		return
 	}
-	return b
-}
\ No newline at end of file
+	if bc.values == nil {
+		bc.values = make(map[any]any)
+	}
+	bc.values[key] = value
+}
+
+// Value 获取SetValue附加的元数据，不存在时返回nil
+func (bc *BridgedConnection) Value(key any) any {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.values[key]
+}
+
+// DisconnectInitiator 获取最先检测到断开的一侧，尚未断开时第二个返回值为false
+func (bc *BridgedConnection) DisconnectInitiator() (ConnectionType, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.initiator, bc.disconnected
+}
+
+// IsClosed 检查两个方向的转发是否都已结束且两侧套接字均已关闭
+func (bc *BridgedConnection) IsClosed() bool {
+	return bc.closed.Load()
+}
@@ -0,0 +1,1694 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitConnections 等待活动连接数达到n，返回按ID排序的连接
+func waitConnections(t *testing.T, ba *BridgeAcceptor, n int) []*BridgedConnection {
+	t.Helper()
+	var conns []*BridgedConnection
+	waitFor(t, "active connections", func() bool {
+		conns = ba.Connections()
+		return len(conns) == n
+	})
+	return conns
+}
+
+func TestConnectionsRegistry(t *testing.T) {
+	h := newBridgeHarness(t, nil)
+	clients := []*scriptedConn{h.connect(), h.connect(), h.connect()}
+
+	conns := waitConnections(t, h.ba, 3)
+	for i := 1; i < len(conns); i++ {
+		if conns[i-1].ID() >= conns[i].ID() {
+			t.Fatalf("connections not sorted by ID: %d before %d", conns[i-1].ID(), conns[i].ID())
+		}
+	}
+
+	closed := conns[1]
+	if !h.ba.CloseConnection(closed.ID()) {
+		t.Fatalf("CloseConnection(%d) = false, want true", closed.ID())
+	}
+	remaining := waitConnections(t, h.ba, 2)
+	for _, bc := range remaining {
+		if bc.ID() == closed.ID() {
+			t.Fatalf("closed connection %d still listed", closed.ID())
+		}
+		if bc.IsClosed() {
+			t.Fatalf("connection %d closed, want open", bc.ID())
+		}
+	}
+	if !closed.IsClosed() {
+		t.Fatal("closed connection not marked closed")
+	}
+
+	closedClients := 0
+	for _, client := range clients {
+		if client.isClosed() {
+			closedClients++
+		}
+	}
+	if closedClients != 1 {
+		t.Fatalf("%d client sockets closed, want 1", closedClients)
+	}
+
+	if h.ba.CloseConnection(closed.ID()) {
+		t.Fatal("CloseConnection on a removed ID = true, want false")
+	}
+}
+
+func TestConnectionsRemovedOnDisconnect(t *testing.T) {
+	h := newBridgeHarness(t, nil)
+	client := h.connect()
+	waitConnections(t, h.ba, 1)
+
+	client.Close()
+	waitConnections(t, h.ba, 0)
+}
+
+func TestBlockedHeaderTypeRejectsBulkLoad(t *testing.T) {
+	blockedTypes := make(chan HeaderType, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBlockedHeaderTypes([]HeaderType{BulkLoadData})
+		ba.SetMessageBlockedHandler(func(bc *BridgedConnection, headerType HeaderType) {
+			blockedTypes <- headerType
+		})
+	})
+
+	// 两个数据包的批量插入，只有第一个数据包能确定消息类型
+	batch := sqlBatchPacket("SELECT 1")
+	client := h.connect(
+		buildPacket(BulkLoadData, 0, 1, bytes.Repeat([]byte{0xAA}, 32)),
+		buildPacket(BulkLoadData, END_OF_MESSAGE, 2, bytes.Repeat([]byte{0xBB}, 16)),
+		batch,
+	)
+	backend := h.backend(0)
+
+	select {
+	case headerType := <-blockedTypes:
+		if headerType != BulkLoadData {
+			t.Fatalf("blocked type = %s, want %s", headerType, BulkLoadData)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message blocked event not fired")
+	}
+
+	response := waitWritten(t, client, HEADER_SIZE)
+	errs := responseErrors(t, response)
+	if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_BLOCKED {
+		t.Fatalf("client errors = %v, want one %d error", errs, BRIDGE_ERROR_BLOCKED)
+	}
+	// 会话继续，之后的消息照常转发，批量插入的字节都不转发
+	if got := waitWritten(t, backend, len(batch)); !bytes.Equal(got, batch) {
+		t.Fatalf("backend received % x, want only the batch % x", got, batch)
+	}
+}
+
+func TestLastActivityUpdatesOnTraffic(t *testing.T) {
+	h := newBridgeHarness(t, nil)
+	client := h.connect()
+	bc := waitConnections(t, h.ba, 1)[0]
+
+	created := bc.CreatedAt()
+	if !bc.LastActivity().Equal(created) {
+		t.Fatalf("LastActivity() = %v before traffic, want CreatedAt() %v", bc.LastActivity(), created)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if idle := bc.IdleTime(); idle < 20*time.Millisecond {
+		t.Fatalf("IdleTime() = %v while idle, want at least 20ms", idle)
+	}
+
+	client.feed(sqlBatchPacket("SELECT 1"))
+	waitFor(t, "activity after client traffic", func() bool {
+		return bc.LastActivity().After(created)
+	})
+	if idle := bc.IdleTime(); idle >= 20*time.Millisecond {
+		t.Fatalf("IdleTime() = %v right after traffic, want it reset", idle)
+	}
+
+	afterClient := bc.LastActivity()
+	time.Sleep(5 * time.Millisecond)
+	h.backend(0).feed(doneResponse(DONE_FINAL, 1))
+	waitFor(t, "activity after server traffic", func() bool {
+		return bc.LastActivity().After(afterClient)
+	})
+
+	if age := bc.Age(); age < 25*time.Millisecond {
+		t.Fatalf("Age() = %v, want at least 25ms", age)
+	}
+}
+
+func TestNotifyClientOnBackendWriteError(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetNotifyClientOnWriteError(true)
+	})
+	client := h.connect(sqlBatchPacket("SELECT 1"))
+	backend := h.backend(0)
+	waitWritten(t, backend, 1)
+
+	// 后端已响应过，之后的写入失败不属于后端未响应即断开的情况
+	response := doneResponse(DONE_FINAL, 1)
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+
+	backend.failWrites(syscall.EPIPE)
+	client.feed(sqlBatchPacket("SELECT 2"))
+
+	written := waitWritten(t, client, len(response)+HEADER_SIZE)
+	errs := responseErrors(t, written[len(response):])
+	if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_BACKEND_WRITE {
+		t.Fatalf("client errors = %v, want one %d error", errs, BRIDGE_ERROR_BACKEND_WRITE)
+	}
+	waitFor(t, "client close", client.isClosed)
+}
+
+func TestBackendWriteErrorWithoutNotify(t *testing.T) {
+	h := newBridgeHarness(t, nil)
+	client := h.connect(sqlBatchPacket("SELECT 1"))
+	backend := h.backend(0)
+	waitWritten(t, backend, 1)
+	response := doneResponse(DONE_FINAL, 1)
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+
+	backend.failWrites(syscall.EPIPE)
+	client.feed(sqlBatchPacket("SELECT 2"))
+	waitFor(t, "client close", client.isClosed)
+	if got := client.Written(); len(got) != len(response) {
+		t.Fatalf("client received %d bytes, want only the first response", len(got))
+	}
+}
+
+// benchmarkPacket 一个4096字节的单包SQLBatch
+func benchmarkPacket() []byte {
+	text := make([]byte, (4096-HEADER_SIZE-22)/2)
+	for i := range text {
+		text[i] = 'x'
+	}
+	return sqlBatchPacket(string(text))
+}
+
+func BenchmarkForwardParsing(b *testing.B) {
+	packet := benchmarkPacket()
+	b.Run("on", func(b *testing.B) {
+		benchmarkForwarding(b, packet, nil)
+	})
+	b.Run("off", func(b *testing.B) {
+		benchmarkForwarding(b, packet, func(ba *BridgeAcceptor) {
+			ba.SetParsingEnabled(false)
+			// 保持逐包的分帧循环，只比较解析本身的开销
+			ba.SetBlockedHeaderTypes([]HeaderType{BulkLoadData})
+		})
+	})
+}
+
+func TestFastPathFiresConnectionEvents(t *testing.T) {
+	accepted := make(chan net.Conn, 1)
+	disconnected := make(chan ConnectionType, 2)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetParsingEnabled(false)
+		ba.SetConnectionAcceptedHandler(func(conn net.Conn) {
+			accepted <- conn
+		})
+		ba.SetConnectionDisconnectedHandler(func(bc *BridgedConnection, ct ConnectionType) {
+			disconnected <- ct
+		})
+	})
+	if !h.ba.canUseFastPath() {
+		t.Fatal("canUseFastPath() = false with parsing disabled and no handlers")
+	}
+
+	// 快速路径不分帧，不是TDS数据包的字节也原样转发
+	data := []byte("not a tds packet")
+	client := h.connect(data)
+	select {
+	case <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection accepted event not fired")
+	}
+	if got := waitWritten(t, h.backend(0), len(data)); !bytes.Equal(got, data) {
+		t.Fatalf("backend received %q, want %q", got, data)
+	}
+
+	client.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection disconnected event not fired")
+	}
+	waitConnections(t, h.ba, 0)
+}
+
+func TestFastPathDisabledByHandlers(t *testing.T) {
+	ba := NewBridgeAcceptor("", "backend:1433")
+	ba.SetParsingEnabled(false)
+	ba.SetTDSPacketReceivedHandler(func(*BridgedConnection, *TDSPacket) {})
+	if ba.canUseFastPath() {
+		t.Fatal("canUseFastPath() = true with a packet handler registered")
+	}
+}
+
+func BenchmarkForwardFastPath(b *testing.B) {
+	packet := benchmarkPacket()
+	b.Run("copy", func(b *testing.B) {
+		benchmarkForwarding(b, packet, func(ba *BridgeAcceptor) {
+			ba.SetParsingEnabled(false)
+		})
+	})
+	b.Run("framing", func(b *testing.B) {
+		benchmarkForwarding(b, packet, func(ba *BridgeAcceptor) {
+			ba.SetParsingEnabled(false)
+			ba.SetBlockedHeaderTypes([]HeaderType{BulkLoadData})
+		})
+	})
+}
+
+// tcpPair 创建一对回环TCP连接
+func tcpPair(t *testing.T) (local, peer net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	local, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	peer = <-accepted
+	if peer == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		local.Close()
+		peer.Close()
+	})
+	return local, peer
+}
+
+func TestSocketCoupleCloseBehavior(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		abortive  bool
+		wantReset bool
+	}{
+		{"graceful", false, false},
+		{"abortive", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			local, peer := tcpPair(t)
+			sc := &SocketCouple{ClientBridgeSocket: local, abortiveClose: tc.abortive}
+			sc.Close()
+
+			peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, err := peer.Read(make([]byte, 1))
+			// SO_LINGER为0时内核发送RST，对端读到ECONNRESET而不是EOF
+			if reset := errors.Is(err, syscall.ECONNRESET); reset != tc.wantReset {
+				t.Fatalf("peer read error = %v, want reset %v", err, tc.wantReset)
+			}
+			if !tc.wantReset && !errors.Is(err, io.EOF) {
+				t.Fatalf("peer read error = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestSetCloseBehavior(t *testing.T) {
+	ba := NewBridgeAcceptor("", "backend:1433")
+	if ba.abortiveClose {
+		t.Fatal("close is abortive by default, want graceful")
+	}
+	ba.SetCloseBehavior(false)
+	if !ba.abortiveClose {
+		t.Fatal("SetCloseBehavior(false) did not make close abortive")
+	}
+}
+
+func TestSetBackendLocalAddrValidation(t *testing.T) {
+	ba := NewBridgeAcceptor("", "backend:1433")
+	for _, addr := range []string{":0", "127.0.0.1:notaport"} {
+		if err := ba.SetBackendLocalAddr(addr); err == nil {
+			t.Errorf("SetBackendLocalAddr(%q) succeeded, want error", addr)
+		}
+	}
+	if err := ba.SetBackendLocalAddr("127.0.0.2"); err != nil {
+		t.Fatalf("SetBackendLocalAddr(127.0.0.2): %v", err)
+	}
+	if got := ba.backendLocalAddr.String(); got != "127.0.0.2:0" {
+		t.Fatalf("backendLocalAddr = %s, want 127.0.0.2:0", got)
+	}
+	if err := ba.SetBackendLocalAddr(""); err != nil || ba.backendLocalAddr != nil {
+		t.Fatalf("SetBackendLocalAddr(\"\") = %v, addr %v, want reset", err, ba.backendLocalAddr)
+	}
+}
+
+func TestBackendLocalAddrUsedForDial(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	defer backend.Close()
+	// 127.0.0.0/8都在回环接口上，可用127.0.0.2作为另一个本地地址
+	if probe, err := net.DialTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}, backend.Addr().(*net.TCPAddr)); err != nil {
+		t.Skipf("cannot bind 127.0.0.2: %v", err)
+	} else {
+		probe.Close()
+		if conn, err := backend.Accept(); err == nil {
+			conn.Close()
+		}
+	}
+
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.dialFunc = nil
+		ba.sqlServerEndpoint = backend.Addr().String()
+		if err := ba.SetBackendLocalAddr("127.0.0.2"); err != nil {
+			t.Fatalf("SetBackendLocalAddr: %v", err)
+		}
+	})
+	h.connect()
+
+	backend.(*net.TCPListener).SetDeadline(time.Now().Add(2 * time.Second))
+	conn, err := backend.Accept()
+	if err != nil {
+		t.Fatalf("backend accept: %v", err)
+	}
+	defer conn.Close()
+	if ip := conn.RemoteAddr().(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 2)) {
+		t.Fatalf("backend connection from %s, want 127.0.0.2", ip)
+	}
+}
+
+func TestMaxLifetimeClosesActiveConnection(t *testing.T) {
+	disconnected := make(chan error, 2)
+	timeouts := make(chan TimeoutKind, 2)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxConnectionLifetime(50 * time.Millisecond)
+		ba.SetConnectionDisconnectedHandler(func(bc *BridgedConnection, ct ConnectionType) {
+			disconnected <- bc.DisconnectReason()
+		})
+		ba.SetTimeoutHandler(func(bc *BridgedConnection, kind TimeoutKind) {
+			timeouts <- kind
+		})
+	})
+	client := h.connect()
+	start := time.Now()
+
+	// 连接一直有流量，存活时间到期后仍被关闭
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				client.feed(sqlBatchPacket("SELECT 1"))
+			}
+		}
+	}()
+
+	select {
+	case reason := <-disconnected:
+		if !errors.Is(reason, ErrMaxLifetime) {
+			t.Fatalf("DisconnectReason() = %v, want ErrMaxLifetime", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not closed after its max lifetime")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("closed after %v, before the 50ms lifetime", elapsed)
+	}
+	if kind := <-timeouts; kind != TimeoutLifetime {
+		t.Fatalf("timeout kind = %s, want Lifetime", kind)
+	}
+	waitFor(t, "client close", client.isClosed)
+}
+
+func TestMaxLifetimeWaitsForMessageBoundary(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxConnectionLifetime(20 * time.Millisecond)
+	})
+	first := buildPacket(SQLBatch, 0, 1, sqlBatchPayload("SELECT 1"))
+	client := h.connect(first)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(first))
+
+	// 请求转发到一半时到期，连接保持到请求结束
+	time.Sleep(50 * time.Millisecond)
+	if client.isClosed() {
+		t.Fatal("connection closed in the middle of a request")
+	}
+
+	last := buildPacket(SQLBatch, END_OF_MESSAGE, 2, encodeUTF16LE(" UNION SELECT 2"))
+	client.feed(last)
+	waitFor(t, "client close", client.isClosed)
+	if got := backend.Written(); len(got) != len(first)+len(last) {
+		t.Fatalf("backend received %d bytes, want the whole request (%d)", len(got), len(first)+len(last))
+	}
+}
+
+// responseWithSPID 构造头部SPID为spid的单包最终响应
+func responseWithSPID(spid uint16) []byte {
+	packet := doneResponse(DONE_FINAL, 0)
+	binary.BigEndian.PutUint16(packet[4:], spid)
+	return packet
+}
+
+func TestCaptureSPIDFilter(t *testing.T) {
+	var capture syncBuffer
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetCaptureWriter(&capture)
+		ba.SetCaptureSPIDFilter(51)
+	})
+	// 尚未得知SPID时客户端数据包(SPID为0)不匹配
+	batch := sqlBatchPacket("SELECT 1")
+	client := h.connect(batch)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(batch))
+
+	sent := 0
+	exchange := func(spid uint16) {
+		response := responseWithSPID(spid)
+		backend.feed(response)
+		sent += len(response)
+		waitWritten(t, client, sent)
+		client.feed(batch)
+		waitWritten(t, backend, (sent/len(response)+1)*len(batch))
+	}
+	exchange(52)
+	exchange(51)
+
+	frames := readCaptureFrames(t, capture.Bytes())
+	if len(frames) != 2 {
+		t.Fatalf("captured %d frames, want 2", len(frames))
+	}
+	if frames[0].Source != BridgeSQL || NewTDSHeader(frames[0].Data).SPID() != 51 {
+		t.Errorf("first frame from %s with SPID %d, want the server's SPID 51 response", frames[0].Source, NewTDSHeader(frames[0].Data).SPID())
+	}
+	if frames[1].Source != ClientBridge || !bytes.Equal(frames[1].Data, batch) {
+		t.Errorf("second frame from %s, want the client batch sent after SPID 51 was seen", frames[1].Source)
+	}
+	if bc := h.ba.Connections()[0]; bc.SPID() != 51 {
+		t.Errorf("SPID() = %d, want 51", bc.SPID())
+	}
+}
+
+func TestConnectionPolicyIdleTimeoutOverride(t *testing.T) {
+	infos := make(chan *AcceptInfo, 2)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetConnectionPolicyHandler(func(info *AcceptInfo) (*ConnectionConfig, error) {
+			infos <- info
+			if info.ID == 1 {
+				return &ConnectionConfig{IdleTimeout: 50 * time.Millisecond}, nil
+			}
+			return nil, nil
+		})
+	})
+	short := h.connect()
+	h.backend(0)
+	long := h.connect()
+	h.backend(1)
+
+	for i := uint64(1); i <= 2; i++ {
+		info := <-infos
+		if info.ID != i || info.Connection == nil || info.Connection.ID() != i || info.Conn == nil {
+			t.Errorf("policy call %d got %+v", i, info)
+		}
+		if info.AcceptedAt.IsZero() || time.Since(info.AcceptedAt) > time.Second {
+			t.Errorf("AcceptedAt = %v, want the accept time", info.AcceptedAt)
+		}
+	}
+
+	waitFor(t, "idle close of the overridden connection", short.isClosed)
+	time.Sleep(100 * time.Millisecond)
+	if long.isClosed() {
+		t.Fatal("connection without override closed by the other connection's idle timeout")
+	}
+	conns := h.ba.Connections()
+	if len(conns) != 1 || conns[0].ID() != 2 {
+		t.Errorf("remaining connections %v, want only connection 2", conns)
+	}
+}
+
+func TestConnectionPolicyBackendOverride(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetConnectionPolicyHandler(func(info *AcceptInfo) (*ConnectionConfig, error) {
+			return &ConnectionConfig{Backend: "replica:1433"}, nil
+		})
+	})
+	h.connect()
+	h.backend(0)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.dialed) != 1 || h.dialed[0] != "replica:1433" {
+		t.Errorf("dialed %v, want [replica:1433]", h.dialed)
+	}
+}
+
+func TestConnectionPolicyRejects(t *testing.T) {
+	errDenied := errors.New("denied")
+	rejected := make(chan error, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetConnectionPolicyHandler(func(*AcceptInfo) (*ConnectionConfig, error) {
+			return nil, errDenied
+		})
+		ba.SetConnectionRejectedHandler(func(_ net.Conn, err error) {
+			rejected <- err
+		})
+	})
+	client := h.connect()
+	select {
+	case err := <-rejected:
+		if !errors.Is(err, errDenied) {
+			t.Errorf("rejected with %v, want the policy error", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no rejection event")
+	}
+	waitFor(t, "client close", client.isClosed)
+	waitConnections(t, h.ba, 0)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.dialed) != 0 {
+		t.Errorf("dialed %v for a rejected connection", h.dialed)
+	}
+}
+
+// encryptionPolicyHarness 创建要求加密的装置，违反事件的ENCRYPTION值送入返回的通道
+func encryptionPolicyHarness(t *testing.T, refuse bool) (*bridgeHarness, chan byte) {
+	violations := make(chan byte, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetRequireEncryption(true, refuse)
+		ba.SetEncryptionPolicyViolationHandler(func(_ *BridgedConnection, encryption byte) {
+			violations <- encryption
+		})
+	})
+	return h, violations
+}
+
+func TestEncryptionPolicyViolation(t *testing.T) {
+	h, violations := encryptionPolicyHarness(t, false)
+	preLogin := preLoginPacket(ENCRYPT_OFF)
+	h.connect(preLogin)
+	backend := h.backend(0)
+
+	select {
+	case encryption := <-violations:
+		if encryption != ENCRYPT_OFF {
+			t.Errorf("violation reported ENCRYPTION %#x, want ENCRYPT_OFF", encryption)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no encryption policy violation for ENCRYPT_OFF")
+	}
+	// 不拒绝时PreLogin照常转发
+	waitWritten(t, backend, len(preLogin))
+}
+
+func TestEncryptionPolicyRefusesConnection(t *testing.T) {
+	h, violations := encryptionPolicyHarness(t, true)
+	client := h.connect(preLoginPacket(ENCRYPT_NOT_SUP))
+	backend := h.backend(0)
+	<-violations
+
+	waitFor(t, "client close", client.isClosed)
+	if n := len(backend.Written()); n != 0 {
+		t.Errorf("backend received %d bytes of a refused PreLogin", n)
+	}
+	errs := responseErrors(t, client.Written())
+	if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_ENCRYPTION_REQUIRED {
+		t.Errorf("client received errors %v, want BRIDGE_ERROR_ENCRYPTION_REQUIRED", errs)
+	}
+}
+
+func TestEncryptionPolicyAllowsEncryptedClient(t *testing.T) {
+	h, violations := encryptionPolicyHarness(t, true)
+	preLogin := preLoginPacket(ENCRYPT_ON)
+	client := h.connect(preLogin)
+	waitWritten(t, h.backend(0), len(preLogin))
+	select {
+	case encryption := <-violations:
+		t.Errorf("violation reported for ENCRYPTION %#x", encryption)
+	default:
+	}
+	if client.isClosed() {
+		t.Error("encrypted client was refused")
+	}
+}
+
+func TestSocketCoupleAddrs(t *testing.T) {
+	var empty SocketCouple
+	if empty.ClientAddr() != nil || empty.BackendAddr() != nil || empty.LocalClientAddr() != nil {
+		t.Error("empty SocketCouple returned non-nil addresses")
+	}
+
+	clientSide, clientPeer := net.Pipe()
+	backendSide, backendPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer backendPeer.Close()
+	sc := &SocketCouple{ClientBridgeSocket: clientSide, BridgeSQLSocket: backendSide}
+	if got, want := sc.ClientAddr(), clientSide.RemoteAddr(); got != want {
+		t.Errorf("ClientAddr() = %v, want %v", got, want)
+	}
+	if got, want := sc.LocalClientAddr(), clientSide.LocalAddr(); got != want {
+		t.Errorf("LocalClientAddr() = %v, want %v", got, want)
+	}
+	if got, want := sc.BackendAddr(), backendSide.RemoteAddr(); got != want {
+		t.Errorf("BackendAddr() = %v, want %v", got, want)
+	}
+	sc.Close()
+}
+
+func TestBridgedConnectionAddrsSurviveClose(t *testing.T) {
+	couples := make(chan *SocketCouple, 2)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetConnectionDisconnectedHandler(func(bc *BridgedConnection, ct ConnectionType) {
+			couples <- bc.SocketCouple
+		})
+	})
+	client := h.connect()
+	h.backend(0)
+	client.Close()
+	var couple *SocketCouple
+	select {
+	case couple = <-couples:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no disconnect event")
+	}
+
+	if got := couple.ClientAddr().String(); got != "127.0.0.1:50000" {
+		t.Errorf("ClientAddr() = %s, want 127.0.0.1:50000", got)
+	}
+	if got := couple.LocalClientAddr().String(); got != "127.0.0.1:1433" {
+		t.Errorf("LocalClientAddr() = %s, want 127.0.0.1:1433", got)
+	}
+	if got := couple.BackendAddr().String(); got != "10.0.0.1:1433" {
+		t.Errorf("BackendAddr() = %s, want 10.0.0.1:1433", got)
+	}
+}
+
+// closedAddrConn 关闭后获取地址时panic的连接
+type closedAddrConn struct {
+	net.Conn
+	closed atomic.Bool
+}
+
+func (c *closedAddrConn) Close() error {
+	c.closed.Store(true)
+	return c.Conn.Close()
+}
+
+func (c *closedAddrConn) RemoteAddr() net.Addr {
+	if c.closed.Load() {
+		panic("RemoteAddr on closed connection")
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func TestSocketCoupleStringAfterClose(t *testing.T) {
+	if got, want := (&SocketCouple{}).String(),
+		"SocketCouple[ClientBridgeSocket.RemoteEndPoint=<nil>, BridgeSQLSocket.RemoteEndPoint=<nil>]"; got != want {
+		t.Errorf("empty String() = %s, want %s", got, want)
+	}
+
+	couples := make(chan *SocketCouple, 2)
+	var h *bridgeHarness
+	h = newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.dialFunc = func(endpoint string) (net.Conn, error) {
+			conn, err := h.dial(endpoint)
+			if err != nil {
+				return nil, err
+			}
+			return &closedAddrConn{Conn: conn}, nil
+		}
+		ba.SetConnectionDisconnectedHandler(func(bc *BridgedConnection, ct ConnectionType) {
+			couples <- bc.SocketCouple
+		})
+	})
+	client := h.connect()
+	h.backend(0)
+	client.Close()
+	var couple *SocketCouple
+	select {
+	case couple = <-couples:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no disconnect event")
+	}
+	couple.Close()
+
+	// 两端都已关闭，仍输出连接时的地址
+	want := "SocketCouple[ClientBridgeSocket.RemoteEndPoint=127.0.0.1:50000, BridgeSQLSocket.RemoteEndPoint=10.0.0.1:1433]"
+	for i := 0; i < 2; i++ {
+		if got := couple.String(); got != want {
+			t.Errorf("String() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestRemoteAddrOfRecoversPanic(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	closed := &closedAddrConn{Conn: conn}
+	closed.Close()
+	if addr := remoteAddrOf(closed); addr != nil {
+		t.Errorf("remoteAddrOf(closed) = %v, want nil", addr)
+	}
+	if addr := remoteAddrOf(nil); addr != nil {
+		t.Errorf("remoteAddrOf(nil) = %v, want nil", addr)
+	}
+	sc := &SocketCouple{ClientBridgeSocket: closed, BridgeSQLSocket: closed}
+	if got := sc.String(); !strings.Contains(got, "RemoteEndPoint=<nil>") {
+		t.Errorf("String() = %s", got)
+	}
+}
+
+func TestLargeMessageThreshold(t *testing.T) {
+	type largeMessage struct {
+		text string
+		size int
+	}
+	events := make(chan largeMessage, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetLargeMessageThreshold(100)
+		ba.SetLargeMessageHandler(func(bc *BridgedConnection, msg TDSMessage, size int) {
+			events <- largeMessage{msg.(*SQLBatchMessage).GetBatchText(), size}
+		})
+	})
+	large := "SELECT '" + strings.Repeat("x", 100) + "'"
+	// 有效载荷恰好等于阈值时不触发
+	exact := strings.Repeat("y", (100-len(sqlBatchPayload("")))/2)
+	reads := [][]byte{sqlBatchPacket("SELECT 1"), sqlBatchPacket(large), sqlBatchPacket(exact)}
+	if n := len(sqlBatchPayload(exact)); n != 100 {
+		t.Fatalf("exact payload is %d bytes, want 100", n)
+	}
+	total := 0
+	for _, packet := range reads {
+		total += len(packet)
+	}
+	h.connect(reads...)
+	waitWritten(t, h.backend(0), total)
+
+	select {
+	case ev := <-events:
+		if ev.text != large || ev.size != len(sqlBatchPayload(large)) {
+			t.Errorf("large message event for %q (%d bytes), want %q (%d bytes)",
+				ev.text, ev.size, large, len(sqlBatchPayload(large)))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no large message event")
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected large message event for %q (%d bytes)", ev.text, ev.size)
+	default:
+	}
+}
+
+func TestLargeMessageHandlerDoesNotBlockForwarding(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetAsyncEvents(4, OverflowDropNewest)
+		ba.SetLargeMessageThreshold(10)
+		ba.SetLargeMessageHandler(func(bc *BridgedConnection, msg TDSMessage, size int) {
+			<-release
+		})
+	})
+	var reads [][]byte
+	total := 0
+	for i := 0; i < 3; i++ {
+		packet := sqlBatchPacket("SELECT * FROM big_table")
+		reads = append(reads, packet)
+		total += len(packet)
+	}
+	h.connect(reads...)
+	waitWritten(t, h.backend(0), total)
+}
+
+func TestMaxMessageBytesTearsDownConnection(t *testing.T) {
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxMessageBytes(1000)
+	})
+	chunk := make([]byte, 400)
+	var reads [][]byte
+	for i := 1; i <= 10; i++ {
+		reads = append(reads, buildPacket(SQLBatch, NORMAL, byte(i), chunk))
+	}
+	client := h.connect(reads...)
+	backend := h.backend(0)
+
+	err := firstMatching(t, exceptions, ErrBufferLimit)
+	if !errors.Is(err, ErrProtocol) {
+		t.Errorf("exception %v does not match ErrProtocol", err)
+	}
+	waitFor(t, "client close", client.isClosed)
+	waitFor(t, "backend close", backend.isClosed)
+	if n := len(backend.Written()); n > 1000+2*HEADER_SIZE {
+		t.Errorf("backend received %d bytes, want the stream cut at the limit", n)
+	}
+}
+
+func TestMaxMessageBytesAllowsMessageAtLimit(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxMessageBytes(800)
+	})
+	chunk := make([]byte, 400)
+	first := buildPacket(SQLBatch, NORMAL, 1, chunk)
+	last := buildPacket(SQLBatch, END_OF_MESSAGE, 2, chunk)
+	next := sqlBatchPacket("SELECT 1")
+	client := h.connect(first, last, next)
+	waitWritten(t, h.backend(0), len(first)+len(last)+len(next))
+	if client.isClosed() {
+		t.Error("message at the limit closed the connection")
+	}
+}
+
+func TestMaxPacketsPerMessageTearsDownConnection(t *testing.T) {
+	for _, parsing := range []bool{true, false} {
+		t.Run(fmt.Sprintf("parsing=%v", parsing), func(t *testing.T) {
+			h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+				ba.SetMaxPacketsPerMessage(5)
+				ba.SetParsingEnabled(parsing)
+			})
+			// 以大量小数据包缓慢发送一个没有END_OF_MESSAGE的消息
+			payload := sqlBatchPayload("SELECT 1")
+			var reads [][]byte
+			for i := 1; i <= 20; i++ {
+				reads = append(reads, buildPacket(SQLBatch, NORMAL, byte(i), payload[:2]))
+			}
+			client := h.connect(reads...)
+			backend := h.backend(0)
+
+			err := firstMatching(t, exceptions, ErrBufferLimit)
+			if !errors.Is(err, ErrProtocol) {
+				t.Errorf("exception %v does not match ErrProtocol", err)
+			}
+			waitFor(t, "client close", client.isClosed)
+			waitFor(t, "backend close", backend.isClosed)
+			if n, max := len(backend.Written()), 5*len(reads[0]); n > max {
+				t.Errorf("backend received %d bytes, want at most the first 5 packets (%d bytes)", n, max)
+			}
+		})
+	}
+}
+
+func TestMaxPacketsPerMessageAllowsMessageAtLimit(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxPacketsPerMessage(3)
+	})
+	payload := sqlBatchPayload("SELECT 1")
+	message := splitMessage(SQLBatch, payload, (len(payload)+2)/3)
+	if n := len(message) - len(payload); n != 3*HEADER_SIZE {
+		t.Fatalf("message has %d packets, want 3", n/HEADER_SIZE)
+	}
+	// 计数在每个消息开始时重置
+	client := h.connect(message, message)
+	waitWritten(t, h.backend(0), 2*len(message))
+	if client.isClosed() {
+		t.Error("messages at the limit closed the connection")
+	}
+}
+
+// disconnectEvent 断开事件的参数和事件触发时连接的状态
+type disconnectEvent struct {
+	bc        *BridgedConnection
+	initiator ConnectionType
+	closed    bool
+}
+
+func TestSingleDisconnectEventReportsInitiator(t *testing.T) {
+	for _, initiator := range []ConnectionType{ClientBridge, BridgeSQL} {
+		t.Run(initiator.String(), func(t *testing.T) {
+			events := make(chan disconnectEvent, 4)
+			h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+				ba.SetConnectionDisconnectedHandler(func(bc *BridgedConnection, ct ConnectionType) {
+					events <- disconnectEvent{bc: bc, initiator: ct, closed: bc.IsClosed()}
+				})
+			})
+			client := h.connect()
+			backend := h.backend(0)
+			waitConnections(t, h.ba, 1)
+			bc := h.ba.Connections()[0]
+			if _, disconnected := bc.DisconnectInitiator(); disconnected || bc.IsClosed() {
+				t.Fatal("connection reported closed before either side disconnected")
+			}
+
+			if initiator == ClientBridge {
+				client.Close()
+			} else {
+				backend.Close()
+			}
+
+			var ev disconnectEvent
+			select {
+			case ev = <-events:
+			case <-time.After(2 * time.Second):
+				t.Fatal("no disconnect event")
+			}
+			if ev.initiator != initiator || !ev.closed {
+				t.Errorf("disconnect event initiator %s closed %v, want %s and fully closed", ev.initiator, ev.closed, initiator)
+			}
+			if got, ok := ev.bc.DisconnectInitiator(); !ok || got != initiator {
+				t.Errorf("DisconnectInitiator() = %s, %v, want %s", got, ok, initiator)
+			}
+			if !client.isClosed() || !backend.isClosed() {
+				t.Error("both sockets should be closed when the terminal event fires")
+			}
+			select {
+			case extra := <-events:
+				t.Errorf("second disconnect event with initiator %s", extra.initiator)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+// tenantKey 测试用的元数据键类型
+type tenantKey struct{}
+
+func TestConnectionValueSeededAtAccept(t *testing.T) {
+	tenants := make(chan any, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetConnectionPolicyHandler(func(info *AcceptInfo) (*ConnectionConfig, error) {
+			info.Connection.SetValue(tenantKey{}, fmt.Sprintf("tenant-%d", info.ID))
+			return nil, nil
+		})
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			tenants <- bc.Value(tenantKey{})
+		})
+		ba.SetConnectionDisconnectedHandler(func(bc *BridgedConnection, ct ConnectionType) {
+			tenants <- bc.Value(tenantKey{})
+		})
+	})
+	batch := sqlBatchPacket("SELECT 1")
+	client := h.connect(batch)
+	waitWritten(t, h.backend(0), len(batch))
+	client.Close()
+
+	for _, event := range []string{"message", "disconnect"} {
+		select {
+		case tenant := <-tenants:
+			if tenant != "tenant-1" {
+				t.Errorf("%s handler read %v, want tenant-1", event, tenant)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %s event", event)
+		}
+	}
+}
+
+func TestConnectionValueConcurrentAccess(t *testing.T) {
+	bc := &BridgedConnection{}
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 100; j++ {
+				bc.SetValue(i, j)
+				bc.Value(i)
+			}
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	if got := bc.Value(2); got != 99 {
+		t.Errorf("Value(2) = %v, want 99", got)
+	}
+	bc.SetValue(2, nil)
+	if got := bc.Value(2); got != nil {
+		t.Errorf("Value after deleting = %v, want nil", got)
+	}
+	if got := bc.Value("missing"); got != nil {
+		t.Errorf("Value of a missing key = %v, want nil", got)
+	}
+}
+
+func TestStartStopCycles(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	probe.Close()
+
+	var exceptions atomic.Int32
+	ba := NewBridgeAcceptor("0", "127.0.0.1:1")
+	ba.SetListeningThreadExceptionHandler(func(net.Listener, error) {
+		exceptions.Add(1)
+	})
+	for i := 0; i < 50; i++ {
+		if err := ba.Start(); err != nil {
+			t.Fatalf("Start #%d: %v", i, err)
+		}
+		// 在Stop关闭监听器的同时发起连接，接受循环必须干净地退出
+		ba.mu.Lock()
+		addr := ba.listeners[0].Addr().String()
+		ba.mu.Unlock()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				conn.Close()
+			}
+		}()
+		ba.Stop()
+		<-done
+	}
+	if n := exceptions.Load(); n != 0 {
+		t.Errorf("%d listening thread exceptions reported across Start/Stop cycles", n)
+	}
+}
+
+func TestMaxConnectionsPerClient(t *testing.T) {
+	type limitEvent struct {
+		ip     string
+		active int
+	}
+	exceeded := make(chan limitEvent, 4)
+	rejected := make(chan error, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxConnectionsPerClient(2)
+		ba.SetClientLimitExceededHandler(func(conn net.Conn, clientIP string, active int) {
+			exceeded <- limitEvent{clientIP, active}
+		})
+		ba.SetConnectionRejectedHandler(func(conn net.Conn, err error) {
+			rejected <- err
+		})
+	})
+	first := h.connect()
+	h.connect()
+	waitConnections(t, h.ba, 2)
+
+	// 同一IP的第三个连接被拒绝，并收到说明原因的错误
+	over := h.connect(sqlBatchPacket("SELECT 1"))
+	waitFor(t, "rejected client close", over.isClosed)
+	select {
+	case ev := <-exceeded:
+		if ev.ip != "127.0.0.1" || ev.active != 2 {
+			t.Errorf("client limit event %+v, want 127.0.0.1 with 2 active", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no client limit event")
+	}
+	if err := <-rejected; !errors.Is(err, ErrClientLimit) {
+		t.Errorf("rejected with %v, want ErrClientLimit", err)
+	}
+	if errs := responseErrors(t, over.Written()); len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_CLIENT_LIMIT {
+		t.Errorf("client errors = %v, want BRIDGE_ERROR_CLIENT_LIMIT", errs)
+	}
+
+	// 其他IP不受影响
+	h.connectFrom(net.IPv4(10, 1, 1, 1))
+	waitConnections(t, h.ba, 3)
+
+	// 断开后计数减少，同一IP可以再次连接
+	first.Close()
+	waitConnections(t, h.ba, 2)
+	again := h.connect()
+	waitConnections(t, h.ba, 3)
+	if again.isClosed() {
+		t.Error("connection after a disconnect was rejected")
+	}
+}
+
+func TestBackendConnectedHandlerRunsBeforeForwarding(t *testing.T) {
+	header := []byte("PROXY TCP4 127.0.0.1 10.0.0.1 50000 1433\r\n")
+	var handlerConn *BridgedConnection
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBackendConnectedHandler(func(bc *BridgedConnection, backend net.Conn) error {
+			handlerConn = bc
+			_, err := backend.Write(header)
+			return err
+		})
+	})
+	packet := sqlBatchPacket("SELECT 1")
+	h.connect(packet)
+
+	written := waitWritten(t, h.backend(0), len(header)+len(packet))
+	if !bytes.Equal(written[:len(header)], header) {
+		t.Fatalf("backend received %q first, want the handler's header", written[:len(header)])
+	}
+	if !bytes.Equal(written[len(header):], packet) {
+		t.Errorf("forwarded bytes = %x, want %x", written[len(header):], packet)
+	}
+	if handlerConn == nil {
+		t.Error("handler received no BridgedConnection")
+	}
+}
+
+func TestBackendConnectedHandlerErrorAborts(t *testing.T) {
+	refused := errors.New("setup failed")
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBackendConnectedHandler(func(*BridgedConnection, net.Conn) error {
+			return refused
+		})
+	})
+	client := h.connect(sqlBatchPacket("SELECT 1"))
+
+	err := firstMatching(t, exceptions, ErrBackendDial)
+	if !errors.Is(err, refused) {
+		t.Errorf("exception %v does not wrap the handler error", err)
+	}
+	backend := h.backend(0)
+	waitFor(t, "both sides closed", func() bool { return client.isClosed() && backend.isClosed() })
+	if written := backend.Written(); len(written) != 0 {
+		t.Errorf("backend received %x after the handler failed", written)
+	}
+	waitConnections(t, h.ba, 0)
+}
+
+func TestWaitForReadyBeforeConnect(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	probe.Close()
+
+	accepted := make(chan struct{}, 1)
+	ba := NewBridgeAcceptor("0", "127.0.0.1:1")
+	ba.SetConnectionAcceptedHandler(func(net.Conn) {
+		accepted <- struct{}{}
+	})
+
+	// 可在Start之前调用，等待之后的Start
+	waited := make(chan error, 1)
+	go func() { waited <- ba.WaitForReady(context.Background()) }()
+
+	if err := ba.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ba.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := ba.WaitForReady(ctx); err != nil {
+		t.Fatalf("WaitForReady after Start: %v", err)
+	}
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatalf("WaitForReady before Start: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("WaitForReady called before Start never returned")
+	}
+
+	ba.mu.Lock()
+	addr := ba.listeners[0].Addr().String()
+	ba.mu.Unlock()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial after WaitForReady: %v", err)
+	}
+	defer conn.Close()
+	select {
+	case <-accepted:
+	case <-ctx.Done():
+		t.Fatal("connection not accepted after WaitForReady")
+	}
+}
+
+func TestListenConfigControlRunsOnStart(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	probe.Close()
+
+	var controlled []string
+	ba := NewBridgeAcceptor("", "127.0.0.1:1")
+	ba.AddRoute("127.0.0.1:0", "127.0.0.1:1")
+	ba.AddRoute("127.0.0.1:0", "127.0.0.1:2")
+	ba.SetListenConfig(&net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled = append(controlled, network+" "+address)
+			return nil
+		},
+	})
+	if err := ba.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ba.Stop()
+	if len(controlled) != 2 {
+		t.Errorf("Control called for %v, want once per route", controlled)
+	}
+
+	// Control返回错误时Start失败
+	refused := errors.New("refused")
+	ba.SetListenConfig(&net.ListenConfig{
+		Control: func(string, string, syscall.RawConn) error { return refused },
+	})
+	if err := ba.Start(); !errors.Is(err, ErrListen) || !errors.Is(err, refused) {
+		ba.Stop()
+		t.Errorf("Start with failing Control = %v, want ErrListen wrapping the Control error", err)
+	}
+}
+
+func TestWaitForReadyHonorsContext(t *testing.T) {
+	ba := NewBridgeAcceptor("0", "127.0.0.1:1")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ba.WaitForReady(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForReady without Start = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestWaitForReadyWaitsForRestartAfterStop(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	probe.Close()
+
+	ba := NewBridgeAcceptor("0", "127.0.0.1:1")
+	if err := ba.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ba.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ba.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ba.WaitForReady(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForReady after Stop = %v, want it to wait for the next Start", err)
+	}
+}
+
+// halfCloseHarness 以真实的回环TCP连接桥接一个客户端和一个后端，返回应用侧的两端
+func halfCloseHarness(t *testing.T, halfClose bool, disconnected chan ConnectionType) (clientApp, serverApp net.Conn) {
+	clientApp, clientBridge := tcpPair(t)
+	backendBridge, serverApp := tcpPair(t)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetHalfCloseSupport(halfClose)
+		ba.dialFunc = func(string) (net.Conn, error) { return backendBridge, nil }
+		ba.SetConnectionDisconnectedHandler(func(bc *BridgedConnection, ct ConnectionType) {
+			disconnected <- ct
+		})
+	})
+	h.listener.conns <- clientBridge
+	deadline := time.Now().Add(2 * time.Second)
+	clientApp.SetDeadline(deadline)
+	serverApp.SetDeadline(deadline)
+	return clientApp, serverApp
+}
+
+func TestHalfCloseDrainsResponse(t *testing.T) {
+	disconnected := make(chan ConnectionType, 2)
+	clientApp, serverApp := halfCloseHarness(t, true, disconnected)
+
+	request := sqlBatchPacket("SELECT 1")
+	if _, err := clientApp.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientApp.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 后端先收到请求，再收到桥接器转发的FIN
+	received := make([]byte, len(request))
+	if _, err := io.ReadFull(serverApp, received); err != nil {
+		t.Fatalf("backend read: %v", err)
+	}
+	if n, err := serverApp.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("backend read after client FIN = %d, %v; want EOF", n, err)
+	}
+
+	// 半关闭之后仍在发送的响应到达客户端
+	response := doneResponse(DONE_FINAL|DONE_COUNT, 1)
+	if _, err := serverApp.Write(response); err != nil {
+		t.Fatalf("backend write after FIN: %v", err)
+	}
+	serverApp.Close()
+	got, err := io.ReadAll(clientApp)
+	if err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if !bytes.Equal(got, response) {
+		t.Errorf("client received %x, want the drained response %x", got, response)
+	}
+
+	select {
+	case ct := <-disconnected:
+		if ct != ClientBridge {
+			t.Errorf("disconnect initiator = %v, want ClientBridge", ct)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no disconnect event")
+	}
+	select {
+	case ct := <-disconnected:
+		t.Errorf("second disconnect event for %v", ct)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWithoutHalfCloseClientFINTearsDown(t *testing.T) {
+	disconnected := make(chan ConnectionType, 2)
+	clientApp, serverApp := halfCloseHarness(t, false, disconnected)
+
+	if _, err := clientApp.Write(sqlBatchPacket("SELECT 1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientApp.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	// 后端连接被完全关闭，客户端不会再收到任何响应
+	if _, err := io.ReadAll(serverApp); err != nil {
+		t.Fatalf("backend read: %v", err)
+	}
+	serverApp.Write(doneResponse(DONE_FINAL, 0))
+	if got, _ := io.ReadAll(clientApp); len(got) != 0 {
+		t.Errorf("client received %x after the bridge tore the connection down", got)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no disconnect event")
+	}
+}
+
+func TestBackendClosedBeforeRespondingTearsDown(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func() *scriptedConn
+	}{
+		// 后端接受连接后立即关闭：读到EOF
+		{"read EOF", func() *scriptedConn {
+			backend := newScriptedConn()
+			backend.eofWhenDone = true
+			return backend
+		}},
+		// 后端已重置连接：第一次转发的写入失败
+		{"write error", func() *scriptedConn {
+			backend := newScriptedConn()
+			backend.writeErr = syscall.ECONNRESET
+			return backend
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			disconnected := make(chan ConnectionType, 2)
+			h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+				ba.SetConnectionDisconnectedHandler(func(bc *BridgedConnection, ct ConnectionType) {
+					disconnected <- ct
+				})
+			})
+			h.prepareBackend(tc.backend())
+			client := h.connect(sqlBatchPacket("SELECT 1"))
+
+			firstMatching(t, exceptions, ErrBackendClosed)
+			waitFor(t, "client close", client.isClosed)
+			errs := responseErrors(t, client.Written())
+			if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_BACKEND_CLOSED {
+				t.Errorf("client received %+v, want one BRIDGE_ERROR_BACKEND_CLOSED error", errs)
+			}
+			select {
+			case <-disconnected:
+			case <-time.After(2 * time.Second):
+				t.Fatal("no disconnect event")
+			}
+			waitConnections(t, h.ba, 0)
+		})
+	}
+}
+
+func TestBackendRetriesReplayPeekedLogin(t *testing.T) {
+	h := peekingHarness(t, map[string]string{"alice": "db-a:1433"})
+	h.ba.SetBackendRetries(1)
+
+	// 第一个后端接受连接后立即关闭，重试的连接完成登录握手
+	dead := newScriptedConn()
+	dead.eofWhenDone = true
+	h.prepareBackend(dead)
+	response := bridgePreLoginResponse()
+	h.prepareBackend(newScriptedConn(response))
+
+	login := testLogin7{version: TDSVersion74, user: "alice", database: "master"}.packet()
+	client := h.connect(preLoginPacket(ENCRYPT_NOT_SUP), login)
+	waitWritten(t, client, len(response))
+	healthy := h.backend(1)
+	waitFor(t, "login at the retried backend", func() bool {
+		return bytes.HasSuffix(healthy.Written(), login)
+	})
+	if !dead.isClosed() {
+		t.Error("failed backend connection left open")
+	}
+	if client.isClosed() {
+		t.Error("client closed although the retry succeeded")
+	}
+}
+
+func TestBackendRetriesObserveLoginOnce(t *testing.T) {
+	var logins, intercepted atomic.Int32
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBackendSelector(func(net.Conn, *Login7Message) (string, error) { return "db-a:1433", nil }, true)
+		ba.SetBackendRetries(2)
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			if _, ok := msg.(*Login7Message); ok {
+				logins.Add(1)
+			}
+		})
+		ba.SetMessageInterceptor(func(bc *BridgedConnection, msg TDSMessage) ([]byte, bool) {
+			if _, ok := msg.(*Login7Message); ok {
+				intercepted.Add(1)
+			}
+			return nil, false
+		})
+	})
+	// 前两个后端在握手中途关闭，第三次完成登录
+	for i := 0; i < 2; i++ {
+		dead := newScriptedConn()
+		dead.eofWhenDone = true
+		h.prepareBackend(dead)
+	}
+	h.prepareBackend(newScriptedConn(bridgePreLoginResponse()))
+
+	login := testLogin7{version: TDSVersion74, user: "alice"}.packet()
+	h.connect(preLoginPacket(ENCRYPT_NOT_SUP), login)
+	healthy := h.backend(2)
+	waitFor(t, "login at the last backend", func() bool {
+		return bytes.HasSuffix(healthy.Written(), login)
+	})
+	if n := logins.Load(); n != 1 {
+		t.Errorf("Login7 message event fired %d times across retries, want 1", n)
+	}
+	if n := intercepted.Load(); n != 1 {
+		t.Errorf("interceptor saw the Login7 %d times across retries, want 1", n)
+	}
+}
+
+func TestBackendRetriesSkipDownBackends(t *testing.T) {
+	var picks, connects atomic.Int32
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		// 第一次选择的后端初始化失败，重试时先选中不健康的后端，再选中健康的后端
+		ba.SetBackendSelector(func(net.Conn, *Login7Message) (string, error) {
+			return []string{"db-a:1433", "down:1433", "db-b:1433"}[picks.Add(1)-1], nil
+		}, false)
+		ba.SetBackendConnectedHandler(func(bc *BridgedConnection, backend net.Conn) error {
+			if connects.Add(1) == 1 {
+				return errors.New("init failed")
+			}
+			return nil
+		})
+		ba.SetBackendRetries(2)
+		ba.SetBackendHealthy("down:1433", false)
+	})
+
+	batch := sqlBatchPacket("SELECT 1")
+	h.connect(batch)
+	waitWritten(t, h.backend(1), len(batch))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.dialed) != 2 || h.dialed[0] != "db-a:1433" || h.dialed[1] != "db-b:1433" {
+		t.Errorf("dialed %v, want the unhealthy backend skipped", h.dialed)
+	}
+}
+
+func TestBackendRetriesExhausted(t *testing.T) {
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBackendSelector(func(net.Conn, *Login7Message) (string, error) { return "db-a:1433", nil }, true)
+		ba.SetBackendRetries(2)
+	})
+	for i := 0; i < 3; i++ {
+		dead := newScriptedConn()
+		dead.eofWhenDone = true
+		h.prepareBackend(dead)
+	}
+	login := testLogin7{version: TDSVersion74, user: "alice"}.packet()
+	client := h.connect(preLoginPacket(ENCRYPT_NOT_SUP), login)
+
+	// 报告最后一次尝试的错误：后端在握手中途关闭
+	select {
+	case err := <-exceptions:
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("exception = %v, want the last attempt's EOF", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no exception after the retries were exhausted")
+	}
+	waitFor(t, "client close", client.isClosed)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.dialed) != 3 {
+		t.Errorf("dialed %d times, want 1 attempt plus 2 retries", len(h.dialed))
+	}
+}
+
+func TestResetStats(t *testing.T) {
+	release := make(chan struct{})
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.EnableQueryStats(16)
+		ba.EnableMessageRates()
+		ba.SetAsyncEvents(1, OverflowDropNewest)
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			<-release
+		})
+	})
+	defer close(release)
+
+	var stream []byte
+	for i := 0; i < 4; i++ {
+		stream = append(stream, sqlBatchPacket("SELECT 1")...)
+	}
+	client := h.connect(stream)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(stream))
+	waitFor(t, "aggregate counters", func() bool {
+		top := h.ba.TopQueries(1)
+		return len(top) == 1 && top[0].Count == 4 && h.ba.DroppedEvents() > 0
+	})
+	if rate := h.ba.MessageRates(MAX_RATE_WINDOW)[SQLBatch]; rate == 0 {
+		t.Fatal("no SQLBatch rate recorded")
+	}
+	bc := h.ba.Connections()[0]
+
+	h.ba.ResetStats()
+	if top := h.ba.TopQueries(-1); len(top) != 0 {
+		t.Errorf("TopQueries after reset = %v", top)
+	}
+	if rate := h.ba.MessageRates(MAX_RATE_WINDOW)[SQLBatch]; rate != 0 {
+		t.Errorf("SQLBatch rate after reset = %v", rate)
+	}
+	if dropped := h.ba.DroppedEvents(); dropped != 0 {
+		t.Errorf("DroppedEvents after reset = %d", dropped)
+	}
+	// 连接自身的计数不受影响
+	if n := bc.StatementCount(); n != 4 {
+		t.Errorf("StatementCount after reset = %d, want 4", n)
+	}
+
+	// 活动连接的新流量照常计入
+	batch := sqlBatchPacket("SELECT 2")
+	client.feed(batch)
+	waitWritten(t, backend, len(stream)+len(batch))
+	waitFor(t, "counters after reset", func() bool {
+		top := h.ba.TopQueries(-1)
+		return len(top) == 1 && top[0].Count == 1 && bc.StatementCount() == 5
+	})
+	if rate := h.ba.MessageRates(MAX_RATE_WINDOW)[SQLBatch]; rate == 0 {
+		t.Error("live connection traffic not counted after reset")
+	}
+}
+
+// writeCountingConn 记录写入数据并统计写调用次数的后端连接
+type writeCountingConn struct {
+	*scriptedConn
+	writes atomic.Int64
+}
+
+func (c *writeCountingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.scriptedConn.Write(b)
+}
+
+// coalescingHarness 开启合并写入、以writeCountingConn为后端的桥接环境，返回客户端和后端
+func coalescingHarness(t *testing.T) (*scriptedConn, *writeCountingConn) {
+	backend := &writeCountingConn{scriptedConn: newScriptedConn()}
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetCoalesceMessages(true)
+		ba.dialFunc = func(string) (net.Conn, error) { return backend, nil }
+	})
+	client := h.connect()
+	waitConnections(t, h.ba, 1)
+	return client, backend
+}
+
+// splitMessage 将有效载荷按每包chunk字节拆分为多包消息
+func splitMessage(headerType HeaderType, payload []byte, chunk int) []byte {
+	var stream []byte
+	for id := byte(1); len(payload) > 0; id++ {
+		n := min(chunk, len(payload))
+		status := byte(NORMAL)
+		if n == len(payload) {
+			status = END_OF_MESSAGE
+		}
+		stream = append(stream, buildPacket(headerType, status, id, payload[:n])...)
+		payload = payload[n:]
+	}
+	return stream
+}
+
+func TestCoalesceMessagesWritesOnce(t *testing.T) {
+	client, backend := coalescingHarness(t)
+
+	// 四个数据包的消息在END_OF_MESSAGE处一次写出
+	message := splitMessage(SQLBatch, sqlBatchPayload(strings.Repeat("x", 1800)), 1000)
+	client.feed(message)
+	if got := waitWritten(t, backend.scriptedConn, len(message)); !bytes.Equal(got, message) {
+		t.Fatalf("backend received %x, want the message unchanged", got)
+	}
+	if n := backend.writes.Load(); n != 1 {
+		t.Errorf("multi-packet message written in %d calls, want 1", n)
+	}
+
+	// 单包消息不等待后续数据包
+	single := sqlBatchPacket("SELECT 1")
+	client.feed(single)
+	waitWritten(t, backend.scriptedConn, len(message)+len(single))
+}
+
+func TestCoalesceMessagesBufferLimit(t *testing.T) {
+	client, backend := coalescingHarness(t)
+
+	// 超过COALESCE_BUFFER_LIMIT的消息分段写出，不无限缓存
+	message := splitMessage(BulkLoadData, bytes.Repeat([]byte{0xAB}, COALESCE_BUFFER_LIMIT+COALESCE_BUFFER_LIMIT/2), 4088)
+	client.feed(message)
+	if got := waitWritten(t, backend.scriptedConn, len(message)); !bytes.Equal(got, message) {
+		t.Fatal("backend did not receive the large message unchanged")
+	}
+	if n := backend.writes.Load(); n != 2 {
+		t.Errorf("large message written in %d calls, want 2 (one at the buffer limit, one at END_OF_MESSAGE)", n)
+	}
+}
+
+func BenchmarkCoalesceMessages(b *testing.B) {
+	// 四个4KB数据包组成的消息。测试连接不支持writev，未合并时每个数据包需要头部和有效载荷两次写调用，
+	// 在TCP连接上则为每个数据包一次
+	message := splitMessage(SQLBatch, sqlBatchPayload(strings.Repeat("x", 7000)), 4096-HEADER_SIZE)
+	for _, coalesce := range []bool{false, true} {
+		b.Run(fmt.Sprintf("coalesce=%v", coalesce), func(b *testing.B) {
+			backend := benchmarkForwarding(b, message, func(ba *BridgeAcceptor) {
+				ba.SetCoalesceMessages(coalesce)
+			})
+			b.ReportMetric(float64(backend.writes.Load())/float64(b.N), "writes/msg")
+		})
+	}
+}
@@ -0,0 +1,605 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TDSDataType TYPE_INFO中的数据类型标识
+type TDSDataType byte
+
+// TDS数据类型标识
+const (
+	typeNull            TDSDataType = 0x1F
+	typeInt1            TDSDataType = 0x30
+	typeBit             TDSDataType = 0x32
+	typeInt2            TDSDataType = 0x34
+	typeInt4            TDSDataType = 0x38
+	typeDateTim4        TDSDataType = 0x3A
+	typeFlt4            TDSDataType = 0x3B
+	typeMoney           TDSDataType = 0x3C
+	typeDateTime        TDSDataType = 0x3D
+	typeFlt8            TDSDataType = 0x3E
+	typeMoney4          TDSDataType = 0x7A
+	typeInt8            TDSDataType = 0x7F
+	typeGUID            TDSDataType = 0x24
+	typeIntN            TDSDataType = 0x26
+	typeDecimal         TDSDataType = 0x37
+	typeNumeric         TDSDataType = 0x3F
+	typeBitN            TDSDataType = 0x68
+	typeDecimalN        TDSDataType = 0x6A
+	typeNumericN        TDSDataType = 0x6C
+	typeFltN            TDSDataType = 0x6D
+	typeMoneyN          TDSDataType = 0x6E
+	typeDateTimeN       TDSDataType = 0x6F
+	typeDateN           TDSDataType = 0x28
+	typeTimeN           TDSDataType = 0x29
+	typeDateTime2N      TDSDataType = 0x2A
+	typeDateTimeOffsetN TDSDataType = 0x2B
+	typeChar            TDSDataType = 0x2F
+	typeVarChar         TDSDataType = 0x27
+	typeBinary          TDSDataType = 0x2D
+	typeVarBinary       TDSDataType = 0x25
+	typeBigVarBinary    TDSDataType = 0xA5
+	typeBigVarChar      TDSDataType = 0xA7
+	typeBigBinary       TDSDataType = 0xAD
+	typeBigChar         TDSDataType = 0xAF
+	typeNVarChar        TDSDataType = 0xE7
+	typeNChar           TDSDataType = 0xEF
+	typeXML             TDSDataType = 0xF1
+	typeUDT             TDSDataType = 0xF0
+	typeText            TDSDataType = 0x23
+	typeImage           TDSDataType = 0x22
+	typeNText           TDSDataType = 0x63
+	typeSSVariant       TDSDataType = 0x62
+	typeTVP             TDSDataType = 0xF3
+)
+
+const (
+	plpMaxLength  = 0xFFFF
+	collationSize = 5
+)
+
+// tdsDataTypeNames 数据类型的名称，与协议文档中的类型标记一致
+var tdsDataTypeNames = map[TDSDataType]string{
+	typeNull:            "NULL",
+	typeInt1:            "INT1",
+	typeBit:             "BIT",
+	typeInt2:            "INT2",
+	typeInt4:            "INT4",
+	typeDateTim4:        "DATETIM4",
+	typeFlt4:            "FLT4",
+	typeMoney:           "MONEY",
+	typeDateTime:        "DATETIME",
+	typeFlt8:            "FLT8",
+	typeMoney4:          "MONEY4",
+	typeInt8:            "INT8",
+	typeGUID:            "GUID",
+	typeIntN:            "INTN",
+	typeDecimal:         "DECIMAL",
+	typeNumeric:         "NUMERIC",
+	typeBitN:            "BITN",
+	typeDecimalN:        "DECIMALN",
+	typeNumericN:        "NUMERICN",
+	typeFltN:            "FLTN",
+	typeMoneyN:          "MONEYN",
+	typeDateTimeN:       "DATETIMN",
+	typeDateN:           "DATEN",
+	typeTimeN:           "TIMEN",
+	typeDateTime2N:      "DATETIME2N",
+	typeDateTimeOffsetN: "DATETIMEOFFSETN",
+	typeChar:            "CHAR",
+	typeVarChar:         "VARCHAR",
+	typeBinary:          "BINARY",
+	typeVarBinary:       "VARBINARY",
+	typeBigVarBinary:    "BIGVARBINARY",
+	typeBigVarChar:      "BIGVARCHAR",
+	typeBigBinary:       "BIGBINARY",
+	typeBigChar:         "BIGCHAR",
+	typeNVarChar:        "NVARCHAR",
+	typeNChar:           "NCHAR",
+	typeXML:             "XML",
+	typeUDT:             "UDT",
+	typeText:            "TEXT",
+	typeImage:           "IMAGE",
+	typeNText:           "NTEXT",
+	typeSSVariant:       "SSVARIANT",
+	typeTVP:             "TVP",
+}
+
+func (t TDSDataType) String() string {
+	if name, ok := tdsDataTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", byte(t))
+}
+
+// IsFixedLength 是否为定长类型，值不带长度前缀
+func (t TDSDataType) IsFixedLength() bool {
+	return fixedTypeSize(t) >= 0
+}
+
+// IsVariableLength 是否为带长度前缀的变长类型(包括可能以PLP编码的类型)
+func (t TDSDataType) IsVariableLength() bool {
+	_, known := tdsDataTypeNames[t]
+	return known && !t.IsFixedLength()
+}
+
+// IsPLP 是否可能以PLP编码：XML和UDT始终为PLP，BIGVARCHAR、BIGVARBINARY和NVARCHAR
+// 在最大长度为MAX时为PLP。具体的列或参数应使用TypeInfo.IsPLP判断
+func (t TDSDataType) IsPLP() bool {
+	switch t {
+	case typeBigVarBinary, typeBigVarChar, typeNVarChar, typeXML, typeUDT:
+		return true
+	}
+	return false
+}
+
+// TypeInfo 列或参数的TYPE_INFO
+type TypeInfo struct {
+	Type      TDSDataType
+	MaxLength int
+	Precision byte
+	Scale     byte
+	Collation []byte
+}
+
+// String 以类型名称和长度、精度描述TYPE_INFO，如NVARCHAR(50)、DECIMALN(18,2)、BIGVARBINARY(MAX)
+func (ti *TypeInfo) String() string {
+	switch {
+	case ti.IsPLP() && ti.Type != typeXML && ti.Type != typeUDT:
+		return ti.Type.String() + "(MAX)"
+	case ti.Type == typeDecimal, ti.Type == typeNumeric, ti.Type == typeDecimalN, ti.Type == typeNumericN:
+		return fmt.Sprintf("%s(%d,%d)", ti.Type, ti.Precision, ti.Scale)
+	case ti.Type == typeTimeN, ti.Type == typeDateTime2N, ti.Type == typeDateTimeOffsetN:
+		return fmt.Sprintf("%s(%d)", ti.Type, ti.Scale)
+	case ti.Type.IsVariableLength() && ti.Type != typeDateN && ti.Type != typeXML && ti.Type != typeUDT:
+		return fmt.Sprintf("%s(%d)", ti.Type, ti.MaxLength)
+	}
+	return ti.Type.String()
+}
+
+// IsPLP 是否为PLP(MAX)类型
+func (ti *TypeInfo) IsPLP() bool {
+	switch ti.Type {
+	case typeBigVarBinary, typeBigVarChar, typeNVarChar:
+		return ti.MaxLength == plpMaxLength
+	case typeXML, typeUDT:
+		return true
+	}
+	return false
+}
+
+// fixedTypeSize 返回定长类型的值长度，非定长类型返回-1
+func fixedTypeSize(t TDSDataType) int {
+	switch t {
+	case typeNull:
+		return 0
+	case typeInt1, typeBit:
+		return 1
+	case typeInt2:
+		return 2
+	case typeInt4, typeDateTim4, typeFlt4, typeMoney4:
+		return 4
+	case typeMoney, typeDateTime, typeFlt8, typeInt8:
+		return 8
+	}
+	return -1
+}
+
+// readTypeInfo 读取TYPE_INFO
+func readTypeInfo(r *bytes.Reader) (*TypeInfo, error) {
+	b, err := readByte(r)
+	if err != nil {
+		return nil, err
+	}
+	t := TDSDataType(b)
+	ti := &TypeInfo{Type: t}
+
+	if size := fixedTypeSize(t); size >= 0 {
+		ti.MaxLength = size
+		return ti, nil
+	}
+
+	switch t {
+	case typeGUID, typeIntN, typeBitN, typeFltN, typeMoneyN, typeDateTimeN,
+		typeChar, typeVarChar, typeBinary, typeVarBinary:
+		n, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		ti.MaxLength = int(n)
+	case typeDecimal, typeNumeric, typeDecimalN, typeNumericN:
+		n, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		ti.MaxLength = int(n)
+		if ti.Precision, err = readByte(r); err != nil {
+			return nil, err
+		}
+		if ti.Scale, err = readByte(r); err != nil {
+			return nil, err
+		}
+	case typeDateN:
+		ti.MaxLength = 3
+	case typeTimeN, typeDateTime2N, typeDateTimeOffsetN:
+		if ti.Scale, err = readByte(r); err != nil {
+			return nil, err
+		}
+	case typeBigVarBinary, typeBigBinary:
+		n, err := readUint16(r)
+		if err != nil {
+			return nil, err
+		}
+		ti.MaxLength = int(n)
+	case typeBigVarChar, typeBigChar, typeNVarChar, typeNChar:
+		n, err := readUint16(r)
+		if err != nil {
+			return nil, err
+		}
+		ti.MaxLength = int(n)
+		if ti.Collation, err = readBytes(r, collationSize); err != nil {
+			return nil, err
+		}
+	case typeText, typeNText, typeImage:
+		n, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		ti.MaxLength = int(n)
+		if t != typeImage {
+			if ti.Collation, err = readBytes(r, collationSize); err != nil {
+				return nil, err
+			}
+		}
+	case typeSSVariant:
+		n, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		ti.MaxLength = int(n)
+	case typeUDT:
+		n, err := readUint16(r)
+		if err != nil {
+			return nil, err
+		}
+		ti.MaxLength = int(n)
+		// 数据库名、架构名、类型名和程序集限定名
+		for i := 0; i < 3; i++ {
+			if _, err := readBVarChar(r); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := readUSVarChar(r); err != nil {
+			return nil, err
+		}
+	case typeXML:
+		// SCHEMA_PRESENT为1时后跟架构信息，RPC参数中通常为0
+		present, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		if present != 0 {
+			for i := 0; i < 2; i++ {
+				if _, err := readBVarChar(r); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := readUSVarChar(r); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported data type %s", ErrProtocol, t)
+	}
+	return ti, nil
+}
+
+// readTypedValue 按TYPE_INFO读取一个值，NULL返回nil
+func readTypedValue(r *bytes.Reader, ti *TypeInfo) ([]byte, error) {
+	if size := fixedTypeSize(ti.Type); size >= 0 {
+		if ti.Type == typeNull {
+			return nil, nil
+		}
+		return readBytes(r, size)
+	}
+
+	if ti.IsPLP() {
+		return ReadPLP(r)
+	}
+
+	switch ti.Type {
+	case typeBigVarBinary, typeBigBinary, typeBigVarChar, typeBigChar, typeNVarChar, typeNChar:
+		n, err := readUint16(r)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0xFFFF {
+			return nil, nil
+		}
+		return readBytes(r, int(n))
+	case typeText, typeNText, typeImage:
+		n, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0xFFFFFFFF {
+			return nil, nil
+		}
+		return readBytes(r, int(n))
+	case typeSSVariant:
+		n, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		return readBytes(r, int(n))
+	default:
+		n, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		return readBytes(r, int(n))
+	}
+}
+
+// PLP长度标记
+const (
+	plpNull          = 0xFFFFFFFFFFFFFFFF
+	plpUnknownLength = 0xFFFFFFFFFFFFFFFE
+)
+
+// ReadPLP 读取PLP(部分长度前缀)编码的值，用于NVARCHAR(MAX)、VARBINARY(MAX)等类型和批量数据：
+// 8字节总长度(或未知长度标记0xFFFFFFFFFFFFFFFE)，随后是以4字节长度为前缀的数据块，以长度为0的块结束。
+// NULL(0xFFFFFFFFFFFFFFFF)返回nil，空值返回非nil的空切片；已知总长度与数据块合计不符时返回错误。
+func ReadPLP(r *bytes.Reader) ([]byte, error) {
+	total, err := readUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	if total == plpNull {
+		return nil, nil
+	}
+
+	var value []byte
+	if total != plpUnknownLength && total <= uint64(r.Len()) {
+		value = make([]byte, 0, total)
+	}
+	for {
+		chunkLen, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		if chunkLen == 0 {
+			break
+		}
+		chunk, err := readBytes(r, int(chunkLen))
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, chunk...)
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	if total != plpUnknownLength && uint64(len(value)) != total {
+		return nil, fmt.Errorf("%w: PLP length mismatch: declared %d, got %d", ErrProtocol, total, len(value))
+	}
+	return value, nil
+}
+
+// isUnicodeType 是否为UTF-16编码的字符类型
+func isUnicodeType(t TDSDataType) bool {
+	return t == typeNVarChar || t == typeNChar || t == typeNText || t == typeXML
+}
+
+// isCharType 是否为单字节字符类型
+func isCharType(t TDSDataType) bool {
+	switch t {
+	case typeChar, typeVarChar, typeBigVarChar, typeBigChar, typeText:
+		return true
+	}
+	return false
+}
+
+// quoteSQLString 将字符串转为SQL字面量，单引号加倍转义
+func quoteSQLString(s string, unicode bool) string {
+	quoted := "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	if unicode {
+		return "N" + quoted
+	}
+	return quoted
+}
+
+// FormatSQLLiteral 将按TYPE_INFO编码的值格式化为T-SQL字面量，value为nil表示NULL
+func FormatSQLLiteral(ti *TypeInfo, value []byte) (string, error) {
+	if value == nil {
+		return "NULL", nil
+	}
+
+	switch {
+	case isUnicodeType(ti.Type):
+		return quoteSQLString(decodeUTF16LE(value), true), nil
+	case isCharType(ti.Type):
+		return quoteSQLString(string(value), false), nil
+	}
+
+	switch ti.Type {
+	case typeInt1, typeInt2, typeInt4, typeInt8, typeIntN, typeBit, typeBitN:
+		switch len(value) {
+		case 1:
+			return strconv.Itoa(int(value[0])), nil
+		case 2:
+			return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(value)))), nil
+		case 4:
+			return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(value)))), nil
+		case 8:
+			return strconv.FormatInt(int64(binary.LittleEndian.Uint64(value)), 10), nil
+		}
+	case typeFlt4, typeFlt8, typeFltN:
+		switch len(value) {
+		case 4:
+			return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(value))), 'g', -1, 32), nil
+		case 8:
+			return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(value)), 'g', -1, 64), nil
+		}
+	case typeMoney, typeMoney4, typeMoneyN:
+		var units int64
+		switch len(value) {
+		case 4:
+			units = int64(int32(binary.LittleEndian.Uint32(value)))
+		case 8:
+			units = int64(int32(binary.LittleEndian.Uint32(value)))<<32 | int64(binary.LittleEndian.Uint32(value[4:]))
+		default:
+			return "", fmt.Errorf("%w: invalid money length %d", ErrProtocol, len(value))
+		}
+		return formatScaled(big.NewInt(units), 4), nil
+	case typeDecimal, typeNumeric, typeDecimalN, typeNumericN:
+		if len(value) < 1 {
+			break
+		}
+		magnitude := make([]byte, len(value)-1)
+		for i := range magnitude {
+			magnitude[i] = value[len(value)-1-i]
+		}
+		n := new(big.Int).SetBytes(magnitude)
+		if value[0] == 0 {
+			n.Neg(n)
+		}
+		return formatScaled(n, int(ti.Scale)), nil
+	case typeGUID:
+		if len(value) != 16 {
+			break
+		}
+		return fmt.Sprintf("'%08X-%04X-%04X-%X-%X'",
+			binary.LittleEndian.Uint32(value[0:]),
+			binary.LittleEndian.Uint16(value[4:]),
+			binary.LittleEndian.Uint16(value[6:]),
+			value[8:10], value[10:16]), nil
+	case typeDateTime, typeDateTim4, typeDateTimeN:
+		t, err := decodeDateTime(value)
+		if err != nil {
+			return "", err
+		}
+		return "'" + t.Format("2006-01-02T15:04:05.000") + "'", nil
+	case typeDateN:
+		t, err := decodeDate(value)
+		if err != nil {
+			return "", err
+		}
+		return "'" + t.Format("2006-01-02") + "'", nil
+	case typeTimeN:
+		d, err := decodeTime(value, ti.Scale)
+		if err != nil {
+			return "", err
+		}
+		return "'" + time.Time{}.Add(d).Format("15:04:05.9999999") + "'", nil
+	case typeDateTime2N, typeDateTimeOffsetN:
+		t, err := decodeDateTime2(value, ti.Scale, ti.Type == typeDateTimeOffsetN)
+		if err != nil {
+			return "", err
+		}
+		if ti.Type == typeDateTimeOffsetN {
+			return "'" + t.Format("2006-01-02T15:04:05.9999999-07:00") + "'", nil
+		}
+		return "'" + t.Format("2006-01-02T15:04:05.9999999") + "'", nil
+	case typeBinary, typeVarBinary, typeBigBinary, typeBigVarBinary, typeImage:
+		return "0x" + strings.ToUpper(hex.EncodeToString(value)), nil
+	}
+	return "", fmt.Errorf("%w: cannot format data type %s with %d bytes", ErrProtocol, ti.Type, len(value))
+}
+
+// formatScaled 将整数按小数位数格式化
+func formatScaled(n *big.Int, scale int) string {
+	s := new(big.Int).Abs(n).String()
+	if scale > 0 {
+		for len(s) <= scale {
+			s = "0" + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if n.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// decodeDateTime 解码DATETIME(8字节)和SMALLDATETIME(4字节)
+func decodeDateTime(value []byte) (time.Time, error) {
+	base := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	switch len(value) {
+	case 4:
+		days := binary.LittleEndian.Uint16(value)
+		minutes := binary.LittleEndian.Uint16(value[2:])
+		return base.AddDate(0, 0, int(days)).Add(time.Duration(minutes) * time.Minute), nil
+	case 8:
+		days := int32(binary.LittleEndian.Uint32(value))
+		ticks := binary.LittleEndian.Uint32(value[4:])
+		ms := (int64(ticks)*10 + 1) / 3
+		return base.AddDate(0, 0, int(days)).Add(time.Duration(ms) * time.Millisecond), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: invalid datetime length %d", ErrProtocol, len(value))
+}
+
+// decodeDate 解码DATE：自0001-01-01起的天数(3字节)
+func decodeDate(value []byte) (time.Time, error) {
+	if len(value) != 3 {
+		return time.Time{}, fmt.Errorf("%w: invalid date length %d", ErrProtocol, len(value))
+	}
+	days := int(value[0]) | int(value[1])<<8 | int(value[2])<<16
+	return time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, days), nil
+}
+
+// decodeTime 解码TIME：按精度缩放的自午夜起的时间单位数
+func decodeTime(value []byte, scale byte) (time.Duration, error) {
+	if len(value) < 3 || len(value) > 5 {
+		return 0, fmt.Errorf("%w: invalid time length %d", ErrProtocol, len(value))
+	}
+	var units uint64
+	for i := len(value) - 1; i >= 0; i-- {
+		units = units<<8 | uint64(value[i])
+	}
+	for i := scale; i < 7; i++ {
+		units *= 10
+	}
+	return time.Duration(units) * 100, nil
+}
+
+// decodeDateTime2 解码DATETIME2和DATETIMEOFFSET(值为UTC，后跟2字节分钟偏移)
+func decodeDateTime2(value []byte, scale byte, withOffset bool) (time.Time, error) {
+	tail := 3
+	if withOffset {
+		tail = 5
+	}
+	if len(value) < tail+3 {
+		return time.Time{}, fmt.Errorf("%w: invalid datetime2 length %d", ErrProtocol, len(value))
+	}
+	timeLen := len(value) - tail
+	d, err := decodeTime(value[:timeLen], scale)
+	if err != nil {
+		return time.Time{}, err
+	}
+	date, err := decodeDate(value[timeLen : timeLen+3])
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := date.Add(d)
+	if withOffset {
+		offset := int(int16(binary.LittleEndian.Uint16(value[timeLen+3:])))
+		t = t.In(time.FixedZone("", offset*60))
+	}
+	return t, nil
+}
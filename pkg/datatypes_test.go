@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestTDSDataTypeString(t *testing.T) {
+	tests := []struct {
+		b    byte
+		want string
+	}{
+		{0x26, "INTN"},
+		{0x38, "INT4"},
+		{0x7F, "INT8"},
+		{0x68, "BITN"},
+		{0x6A, "DECIMALN"},
+		{0x6F, "DATETIMN"},
+		{0x2A, "DATETIME2N"},
+		{0x24, "GUID"},
+		{0xA5, "BIGVARBINARY"},
+		{0xA7, "BIGVARCHAR"},
+		{0xE7, "NVARCHAR"},
+		{0xEF, "NCHAR"},
+		{0xF1, "XML"},
+		{0x62, "SSVARIANT"},
+		{0xF3, "TVP"},
+		{0x99, "0x99"},
+	}
+	for _, tt := range tests {
+		if got := TDSDataType(tt.b).String(); got != tt.want {
+			t.Errorf("TDSDataType(0x%02X) = %s, want %s", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestTDSDataTypeLengthClass(t *testing.T) {
+	tests := []struct {
+		t                    TDSDataType
+		fixed, variable, plp bool
+	}{
+		{typeInt4, true, false, false},
+		{typeDateTime, true, false, false},
+		{typeNull, true, false, false},
+		{typeIntN, false, true, false},
+		{typeDecimalN, false, true, false},
+		{typeBigChar, false, true, false},
+		{typeNVarChar, false, true, true},
+		{typeBigVarBinary, false, true, true},
+		{typeXML, false, true, true},
+		{TDSDataType(0x99), false, false, false},
+	}
+	for _, tt := range tests {
+		if got := tt.t.IsFixedLength(); got != tt.fixed {
+			t.Errorf("%s.IsFixedLength() = %v, want %v", tt.t, got, tt.fixed)
+		}
+		if got := tt.t.IsVariableLength(); got != tt.variable {
+			t.Errorf("%s.IsVariableLength() = %v, want %v", tt.t, got, tt.variable)
+		}
+		if got := tt.t.IsPLP(); got != tt.plp {
+			t.Errorf("%s.IsPLP() = %v, want %v", tt.t, got, tt.plp)
+		}
+	}
+}
+
+func TestTypeInfoString(t *testing.T) {
+	tests := []struct {
+		ti   TypeInfo
+		want string
+	}{
+		{TypeInfo{Type: typeIntN, MaxLength: 4}, "INTN(4)"},
+		{TypeInfo{Type: typeNVarChar, MaxLength: 100}, "NVARCHAR(100)"},
+		{TypeInfo{Type: typeNVarChar, MaxLength: plpMaxLength}, "NVARCHAR(MAX)"},
+		{TypeInfo{Type: typeDecimalN, MaxLength: 9, Precision: 18, Scale: 2}, "DECIMALN(18,2)"},
+		{TypeInfo{Type: typeDateTime2N, Scale: 7}, "DATETIME2N(7)"},
+		{TypeInfo{Type: typeDateN}, "DATEN"},
+		{TypeInfo{Type: typeXML}, "XML"},
+		{TypeInfo{Type: typeInt8}, "INT8"},
+	}
+	for _, tt := range tests {
+		if got := tt.ti.String(); got != tt.want {
+			t.Errorf("TypeInfo %+v = %s, want %s", tt.ti, got, tt.want)
+		}
+	}
+}
+
+// plpValue 按PLP编码写出总长度和各数据块，以长度为0的块结束
+func plpValue(total uint64, chunks ...[]byte) []byte {
+	data := binary.LittleEndian.AppendUint64(nil, total)
+	for _, chunk := range chunks {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(chunk)))
+		data = append(data, chunk...)
+	}
+	return binary.LittleEndian.AppendUint32(data, 0)
+}
+
+func TestReadPLP(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"unknown length chunked", plpValue(plpUnknownLength, []byte("hello, "), []byte("PLP "), []byte("world")), []byte("hello, PLP world")},
+		{"known length", plpValue(5, []byte("ab"), []byte("cde")), []byte("abcde")},
+		{"empty", plpValue(0), []byte{}},
+		{"null", binary.LittleEndian.AppendUint64(nil, plpNull), nil},
+	}
+	for _, tt := range tests {
+		r := bytes.NewReader(append(tt.data, 0xEE))
+		got, err := ReadPLP(r)
+		if err != nil {
+			t.Errorf("%s: ReadPLP() error = %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("%s: ReadPLP() = %q, want %q", tt.name, got, tt.want)
+		}
+		if r.Len() != 1 {
+			t.Errorf("%s: %d bytes left after the value, want 1", tt.name, r.Len())
+		}
+	}
+
+	for _, data := range [][]byte{
+		plpValue(4, []byte("abc")),
+		plpValue(plpUnknownLength, []byte("abc"))[:14],
+		{0x01, 0x02},
+	} {
+		if _, err := ReadPLP(bytes.NewReader(data)); err == nil {
+			t.Errorf("ReadPLP(%x) succeeded, want an error", data)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"strings"
+)
+
+// DEFAULT_PACKET_SIZE 登录完成前双方使用的默认数据包大小(含头部)，
+// 也用于重新分包无法得知协商大小的消息
+const DEFAULT_PACKET_SIZE = 4096
+
+// SetDatabaseRewrite 将客户端Login7中请求的初始数据库from(不区分大小写)改写为to后再转发给后端，
+// 用于迁移时透明地把旧库名重定向到新库名。可多次调用以配置多个映射，to为空时取消from的映射。
+// 改写时桥接器缓存Login7的所有数据包，在END_OF_MESSAGE处转发重新分包后的登录记录；
+// 消息事件和日志中看到的仍是客户端发送的原始登录。关闭解析或登录在TLS内传输时不改写。
+// 需在Start之前调用。
+func (ba *BridgeAcceptor) SetDatabaseRewrite(from, to string) {
+	key := strings.ToLower(from)
+	if to == "" {
+		delete(ba.databaseRewrites, key)
+		return
+	}
+	if ba.databaseRewrites == nil {
+		ba.databaseRewrites = make(map[string]string)
+	}
+	ba.databaseRewrites[key] = to
+}
+
+// rewritesLogin 检查是否需要缓存Login7以便改写
+func (ba *BridgeAcceptor) rewritesLogin() bool {
+	return !ba.parsingDisabled && len(ba.databaseRewrites) > 0
+}
+
+// rewriteLogin 按配置的映射改写登录请求的初始数据库，返回重新分包后的线上数据；
+// 不需要改写时返回nil
+func (bc *BridgedConnection) rewriteLogin(msg TDSMessage) ([]byte, error) {
+	login, ok := msg.(*Login7Message)
+	if !ok {
+		return nil, nil
+	}
+	to, ok := bc.BridgeAcceptor.databaseRewrites[strings.ToLower(login.GetDatabase())]
+	if !ok {
+		return nil, nil
+	}
+	payload, err := login.RewriteDatabase(to)
+	if err != nil {
+		return nil, err
+	}
+	return packetizeMessage(login.GetPackets()[0].Header, payload, DEFAULT_PACKET_SIZE), nil
+}
+
+// packetizeMessage 将有效载荷按packetSize拆分为数据包，沿用first的类型、状态位和SPID，
+// 数据包ID从first的ID起递增，仅最后一个数据包设置END_OF_MESSAGE
+func packetizeMessage(first *TDSHeader, payload []byte, packetSize int) []byte {
+	chunkSize := packetSize - HEADER_SIZE
+	status := first.StatusBitMask() &^ END_OF_MESSAGE
+	packetID := first.GetByte(6)
+
+	data := make([]byte, 0, len(payload)+(len(payload)/chunkSize+1)*HEADER_SIZE)
+	for {
+		chunk := payload
+		packetStatus := status | END_OF_MESSAGE
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+			packetStatus = status
+		}
+		header := BuildTDSHeader(first.Type(), packetStatus, len(chunk)+HEADER_SIZE, packetID)
+		header[4], header[5] = first.GetByte(4), first.GetByte(5)
+		data = append(data, header...)
+		data = append(data, chunk...)
+
+		payload = payload[len(chunk):]
+		packetID++
+		if len(payload) == 0 {
+			return data
+		}
+	}
+}
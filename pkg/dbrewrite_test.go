@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDatabaseRewrite(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetDatabaseRewrite("OldDB", "NewDB")
+	})
+	login := testLogin7{version: TDSVersion74, user: "app", database: "olddb"}.packet()
+	batch := sqlBatchPacket("SELECT 1")
+	h.connect(login, batch)
+	backend := h.backend(0)
+	waitFor(t, "batch after the login", func() bool {
+		return bytes.HasSuffix(backend.Written(), batch)
+	})
+
+	forwarded := backend.Written()[:len(backend.Written())-len(batch)]
+	msg, err := NewTDSReader(bytes.NewReader(forwarded)).ReadMessage()
+	if err != nil {
+		t.Fatalf("read forwarded login: %v", err)
+	}
+	if got := msg.(*Login7Message).GetDatabase(); got != "NewDB" {
+		t.Errorf("backend login database = %q, want NewDB", got)
+	}
+	if got := msg.(*Login7Message).GetUserName(); got != "app" {
+		t.Errorf("backend login user = %q, want app", got)
+	}
+}
+
+func TestDatabaseRewriteLeavesOtherDatabases(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetDatabaseRewrite("OldDB", "NewDB")
+		ba.SetDatabaseRewrite("Temp", "Scratch")
+		ba.SetDatabaseRewrite("Temp", "")
+	})
+	login := testLogin7{version: TDSVersion74, user: "app", database: "Temp"}.packet()
+	h.connect(login)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(login))
+	if !bytes.Equal(backend.Written(), login) {
+		t.Error("login for an unmapped database was modified")
+	}
+}
+
+func TestPacketizeMessage(t *testing.T) {
+	first := buildPacket(TDS7Login, END_OF_MESSAGE|RESET_CONNECTION, 3, nil)
+	first[5] = 55
+	header := NewTDSHeader(first)
+	payload := bytes.Repeat([]byte{0x42}, 2*(512-HEADER_SIZE)+10)
+
+	data := packetizeMessage(header, payload, 512)
+	msg, err := NewTDSReader(bytes.NewReader(data)).ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Validate(); err != nil {
+		t.Errorf("packetized message is invalid: %v", err)
+	}
+	packets := msg.GetPackets()
+	if len(packets) != 3 || !bytes.Equal(msg.AssemblePayload(), payload) {
+		t.Fatalf("got %d packets, want 3 carrying the payload", len(packets))
+	}
+	for i, packet := range packets {
+		if id := packet.Header.GetByte(6); id != byte(3+i) {
+			t.Errorf("packet %d ID = %d, want %d", i, id, 3+i)
+		}
+		if packet.Header.SPID() != 55 || packet.Header.StatusBitMask()&RESET_CONNECTION == 0 {
+			t.Errorf("packet %d lost the SPID or status bits: %s", i, packet.Header)
+		}
+	}
+}
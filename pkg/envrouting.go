@@ -0,0 +1,285 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ENVCHANGE_ROUTING ENVCHANGE令牌中的路由类型，服务器据此让客户端重新连接到另一台服务器
+// (可用性组的只读路由、Azure SQL的重定向)
+const ENVCHANGE_ROUTING = 20
+
+// ROUTING_REDIRECT_TTL 改写路由后等待客户端重新连接的最长时间，超时的映射被丢弃
+const ROUTING_REDIRECT_TTL = 30 * time.Second
+
+// RoutingInfo ENVCHANGE路由令牌的内容
+type RoutingInfo struct {
+	// Protocol 0表示TCP
+	Protocol byte
+	Port     uint16
+	Server   string
+}
+
+// Address 获取路由目标的"主机:端口"
+func (ri RoutingInfo) Address() string {
+	return net.JoinHostPort(ri.Server, strconv.Itoa(int(ri.Port)))
+}
+
+func (ri RoutingInfo) String() string {
+	return fmt.Sprintf("RoutingInfo[Protocol=%d;Server=%s;Port=%d]", ri.Protocol, ri.Server, ri.Port)
+}
+
+// DecodeRoutingEnvChange 解码ENVCHANGE令牌中的路由信息，令牌不是路由类型时返回false
+func DecodeRoutingEnvChange(t *Token) (RoutingInfo, bool, error) {
+	var ri RoutingInfo
+	if t.Type != TokenEnvChange {
+		return ri, false, nil
+	}
+	r := bytes.NewReader(t.Data)
+	// 令牌体长度
+	if _, err := readUint16(r); err != nil {
+		return ri, false, err
+	}
+	envType, err := readByte(r)
+	if err != nil || envType != ENVCHANGE_ROUTING {
+		return ri, false, err
+	}
+	// RoutingDataValueLength
+	if _, err := readUint16(r); err != nil {
+		return ri, false, err
+	}
+	if ri.Protocol, err = readByte(r); err != nil {
+		return ri, false, err
+	}
+	if ri.Port, err = readUint16(r); err != nil {
+		return ri, false, err
+	}
+	if ri.Server, err = readUSVarChar(r); err != nil {
+		return ri, false, err
+	}
+	return ri, true, nil
+}
+
+// BuildRoutingEnvChange 构造完整的ENVCHANGE路由令牌(含令牌类型字节)，旧值为空
+func BuildRoutingEnvChange(ri RoutingInfo) []byte {
+	server := encodeUTF16LE(ri.Server)
+	routingLength := 1 + 2 + 2 + len(server)
+	tokenLength := 1 + 2 + routingLength + 2
+
+	token := make([]byte, 0, 3+tokenLength)
+	token = append(token, byte(TokenEnvChange))
+	token = binary.LittleEndian.AppendUint16(token, uint16(tokenLength))
+	token = append(token, ENVCHANGE_ROUTING)
+	token = binary.LittleEndian.AppendUint16(token, uint16(routingLength))
+	token = append(token, ri.Protocol)
+	token = binary.LittleEndian.AppendUint16(token, ri.Port)
+	token = binary.LittleEndian.AppendUint16(token, uint16(len(server)/2))
+	token = append(token, server...)
+	return binary.LittleEndian.AppendUint16(token, 0)
+}
+
+// RoutingRedirect 一个等待客户端重新连接的路由映射
+type RoutingRedirect struct {
+	// ClientIP 收到路由的客户端，BridgeAddr 桥接器为该映射单独监听的地址(改写后的路由目标)
+	ClientIP   string
+	BridgeAddr string
+	// Target 服务器路由的真实目标
+	Target  string
+	Expires time.Time
+}
+
+// pendingRedirect 等待重新连接的映射及其临时监听器
+type pendingRedirect struct {
+	RoutingRedirect
+	listener net.Listener
+}
+
+// routingRedirects 按临时监听地址保存的路由映射
+type routingRedirects struct {
+	mu        sync.Mutex
+	redirects map[string]*pendingRedirect
+}
+
+// add 保存映射
+func (rr *routingRedirects) add(redirect RoutingRedirect, listener net.Listener) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.redirects == nil {
+		rr.redirects = make(map[string]*pendingRedirect)
+	}
+	rr.redirects[redirect.BridgeAddr] = &pendingRedirect{RoutingRedirect: redirect, listener: listener}
+}
+
+// remove 删除映射并关闭其监听器，返回映射是否仍在等待(未被使用、过期或清除)
+func (rr *routingRedirects) remove(bridgeAddr string) bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	pending, ok := rr.redirects[bridgeAddr]
+	if !ok {
+		return false
+	}
+	delete(rr.redirects, bridgeAddr)
+	pending.listener.Close()
+	return true
+}
+
+// snapshot 获取等待中的映射
+func (rr *routingRedirects) snapshot() []RoutingRedirect {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	redirects := make([]RoutingRedirect, 0, len(rr.redirects))
+	for _, pending := range rr.redirects {
+		redirects = append(redirects, pending.RoutingRedirect)
+	}
+	return redirects
+}
+
+// closeAll 删除所有映射并关闭其监听器
+func (rr *routingRedirects) closeAll() {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	for bridgeAddr, pending := range rr.redirects {
+		pending.listener.Close()
+		delete(rr.redirects, bridgeAddr)
+	}
+}
+
+// SetRewriteRouting 设置是否透明处理服务器的路由(ENVCHANGE类型20)。启用后桥接器缓存登录响应，
+// 为其中的每个路由在客户端所连接的桥接器IP上打开一个临时端口，并将路由目标改为该端口；
+// 收到路由的客户端在ROUTING_REDIRECT_TTL内连接该端口时，桥接器连接服务器路由的真实目标而非配置的后端，
+// 使重新连接仍经过桥接器。每个映射有各自的端口，同一客户端的多个路由互不干扰；映射只使用一次，
+// 之后或过期时端口关闭，其他IP的连接被拒绝。后端选择回调的结果优先于映射。
+// 无法在该IP上监听时(如经PROXY协议接受的连接)原样转发路由。
+// 需要解析消息，且登录响应须未加密(仅加密登录时无法改写)。需在Start之前调用。
+func (ba *BridgeAcceptor) SetRewriteRouting(enabled bool) {
+	ba.rewriteRouting = enabled
+}
+
+// RoutingRedirects 获取尚未被重新连接使用且未过期的路由映射
+func (ba *BridgeAcceptor) RoutingRedirects() []RoutingRedirect {
+	return ba.routingRedirects.snapshot()
+}
+
+// RoutingTarget 获取本连接因路由映射而连接的真实目标，不是经路由重新连接时返回空字符串
+func (bc *BridgedConnection) RoutingTarget() string {
+	return bc.routingTarget
+}
+
+// serveRoutingRedirect 在映射的临时端口上等待收到路由的客户端重新连接，
+// 以服务器路由的真实目标为后端处理第一个来自该客户端IP的连接，该连接仍属于收到路由的连接的路由
+func (ba *BridgeAcceptor) serveRoutingRedirect(listener net.Listener, redirect RoutingRedirect, listenAddr string) {
+	expiry := time.AfterFunc(time.Until(redirect.Expires), func() {
+		ba.routingRedirects.remove(redirect.BridgeAddr)
+	})
+	defer expiry.Stop()
+
+	for {
+		clientConn, err := listener.Accept()
+		if err != nil {
+			// 映射已使用、过期或桥接器已停止
+			ba.routingRedirects.remove(redirect.BridgeAddr)
+			return
+		}
+		if clientIP := clientIPOf(clientConn); clientIP != redirect.ClientIP {
+			closeConn(clientConn, ba.abortiveClose)
+			ba.onConnectionRejected(clientConn, newBridgeError(ErrRoutingClient, "accept "+clientIP, nil))
+			continue
+		}
+		if !ba.routingRedirects.remove(redirect.BridgeAddr) {
+			closeConn(clientConn, ba.abortiveClose)
+			return
+		}
+		go ba.handleNewConnection(clientConn, &bridgeRoute{
+			listenAddr: listenAddr,
+			backend:    redirect.Target,
+			redirected: true,
+		})
+		return
+	}
+}
+
+// isLoginRequest 检查请求是否属于登录过程(Login7或之后的SSPI交换)，其响应可能包含路由
+func isLoginRequest(headerType HeaderType) bool {
+	return headerType == TDS7Login || headerType == SSPIMessage
+}
+
+// rewriteLoginResponse 改写完整的登录响应(一个或多个数据包)中的路由令牌，使其指向桥接器。
+// 返回要转发给客户端的数据，以及响应中是否已包含LOGINACK(登录完成)。无法解析时原样返回。
+func (bc *BridgedConnection) rewriteLoginResponse(frames []byte) ([]byte, bool) {
+	var first *TDSHeader
+	var payload []byte
+	packetSize := DEFAULT_PACKET_SIZE
+	for rest := frames; len(rest) >= HEADER_SIZE; {
+		header := NewTDSHeader(rest)
+		length := header.LengthIncludingHeader()
+		if length < HEADER_SIZE || length > len(rest) {
+			return frames, true
+		}
+		if first == nil {
+			first = header
+		} else {
+			packetSize = first.LengthIncludingHeader()
+		}
+		payload = append(payload, rest[HEADER_SIZE:length]...)
+		rest = rest[length:]
+	}
+	if first == nil || first.Type() != TabularResult {
+		return frames, true
+	}
+
+	tokens, err := ParseTokens(payload, bc.TDSVersion())
+	if err != nil {
+		return frames, true
+	}
+	loggedIn, rewritten := false, false
+	rebuilt := make([]byte, 0, len(payload)+64)
+	for _, token := range tokens {
+		if token.Type == TokenLoginAck {
+			loggedIn = true
+		}
+		if ri, ok, err := DecodeRoutingEnvChange(token); err == nil && ok {
+			if replacement, ok := bc.redirectRouting(ri); ok {
+				rebuilt = append(rebuilt, replacement...)
+				rewritten = true
+				continue
+			}
+		}
+		rebuilt = append(rebuilt, byte(token.Type))
+		rebuilt = append(rebuilt, token.Data...)
+	}
+	if !rewritten {
+		return frames, loggedIn
+	}
+	return packetizeMessage(first, rebuilt, packetSize), loggedIn
+}
+
+// redirectRouting 为路由目标打开临时端口并记录映射，返回指向该端口的路由令牌
+func (bc *BridgedConnection) redirectRouting(ri RoutingInfo) ([]byte, bool) {
+	bridgeAddr, ok := bc.SocketCouple.LocalClientAddr().(*net.TCPAddr)
+	if !ok || ri.Protocol != 0 {
+		return nil, false
+	}
+	ba := bc.BridgeAcceptor
+	listener, err := ba.listen(net.JoinHostPort(bridgeAddr.IP.String(), "0"))
+	if err != nil {
+		return nil, false
+	}
+	redirect := RoutingRedirect{
+		ClientIP:   bc.clientIP,
+		BridgeAddr: listener.Addr().String(),
+		Target:     ri.Address(),
+		Expires:    time.Now().Add(ROUTING_REDIRECT_TTL),
+	}
+	ba.routingRedirects.add(redirect, listener)
+	go ba.serveRoutingRedirect(listener, redirect, bc.Route())
+	return BuildRoutingEnvChange(RoutingInfo{
+		Protocol: ri.Protocol,
+		Port:     uint16(listener.Addr().(*net.TCPAddr).Port),
+		Server:   bridgeAddr.IP.String(),
+	}), true
+}
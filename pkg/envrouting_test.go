@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loginAckToken 构造LOGINACK令牌
+func loginAckToken(version TDSVersion, program string, serverVersion [4]byte) []byte {
+	body := []byte{1} // SQL_TSQL
+	body = binary.BigEndian.AppendUint32(body, uint32(version))
+	body = append(body, byte(len(program)))
+	body = append(body, encodeUTF16LE(program)...)
+	body = append(body, serverVersion[:]...)
+	token := binary.LittleEndian.AppendUint16([]byte{byte(TokenLoginAck)}, uint16(len(body)))
+	return append(token, body...)
+}
+
+// routingResponse 构造将客户端路由到target的登录响应
+func routingResponse(server string, port uint16) []byte {
+	payload := loginAckToken(TDSVersion74, "Microsoft SQL Server", [4]byte{16, 0, 0x10, 0x27})
+	payload = append(payload, BuildRoutingEnvChange(RoutingInfo{Port: port, Server: server})...)
+	payload = append(payload, doneToken(TokenDone, DONE_FINAL, 0, 0)...)
+	return buildPacket(TabularResult, END_OF_MESSAGE, 1, payload)
+}
+
+// routedAddress 从客户端收到的登录响应中取出路由目标
+func routedAddress(t *testing.T, response []byte) RoutingInfo {
+	t.Helper()
+	tokens, err := ParseTokens(response[HEADER_SIZE:], TDSVersion74)
+	if err != nil {
+		t.Fatalf("parse login response: %v", err)
+	}
+	for _, token := range tokens {
+		if ri, ok, err := DecodeRoutingEnvChange(token); err == nil && ok {
+			return ri
+		}
+	}
+	t.Fatal("login response has no routing token")
+	return RoutingInfo{}
+}
+
+// routingHarness 创建改写路由的装置，记录每个连接的路由目标；configure在启动前进一步配置桥接器
+func routingHarness(t *testing.T, configure func(ba *BridgeAcceptor)) (*bridgeHarness, func() []string) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	probe.Close()
+
+	var mu sync.Mutex
+	var targets []string
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetRewriteRouting(true)
+		ba.SetBackendConnectedHandler(func(bc *BridgedConnection, _ net.Conn) error {
+			mu.Lock()
+			defer mu.Unlock()
+			targets = append(targets, bc.RoutingTarget())
+			return nil
+		})
+		if configure != nil {
+			configure(ba)
+		}
+	})
+	return h, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), targets...)
+	}
+}
+
+// routeClient 让第i个客户端登录并收到路由到target的响应，返回改写后的路由
+func routeClient(t *testing.T, h *bridgeHarness, i int, server string, port uint16) RoutingInfo {
+	t.Helper()
+	login := testLogin7{version: TDSVersion74, user: "app", server: "ag-listener"}.packet()
+	client := h.connect(login)
+	backend := h.backend(i)
+	waitWritten(t, backend, len(login))
+	response := routingResponse(server, port)
+	backend.feed(response)
+	waitFor(t, "login response", func() bool { return len(client.Written()) > HEADER_SIZE })
+	return routedAddress(t, client.Written())
+}
+
+func TestRewriteRoutingPointsClientAtBridge(t *testing.T) {
+	h, targets := routingHarness(t, nil)
+	ri := routeClient(t, h, 0, "replica.example", 1444)
+
+	// 路由目标改为桥接器在客户端所连接的IP上为该映射打开的端口
+	if ri.Server != "127.0.0.1" || ri.Port == 0 || ri.Port == 1433 {
+		t.Fatalf("client routed to %s, want a dedicated bridge port on 127.0.0.1", ri.Address())
+	}
+	redirects := h.ba.RoutingRedirects()
+	if len(redirects) != 1 {
+		t.Fatalf("RoutingRedirects() = %v, want one mapping", redirects)
+	}
+	if r := redirects[0]; r.ClientIP != "127.0.0.1" || r.BridgeAddr != ri.Address() || r.Target != "replica.example:1444" {
+		t.Errorf("mapping = %+v, want 127.0.0.1 via %s to replica.example:1444", r, ri.Address())
+	}
+
+	// 重新连接经过桥接器到达真实目标，映射只使用一次
+	conn, err := net.Dial("tcp", ri.Address())
+	if err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	defer conn.Close()
+	h.backend(1)
+	h.mu.Lock()
+	dialed := append([]string(nil), h.dialed...)
+	h.mu.Unlock()
+	if dialed[1] != "replica.example:1444" {
+		t.Errorf("reconnection dialed %s, want replica.example:1444", dialed[1])
+	}
+	if got := targets(); len(got) != 2 || got[0] != "" || got[1] != "replica.example:1444" {
+		t.Errorf("RoutingTarget() of the connections = %q", got)
+	}
+	if redirects := h.ba.RoutingRedirects(); len(redirects) != 0 {
+		t.Errorf("mapping still pending after use: %v", redirects)
+	}
+	if again, err := net.DialTimeout("tcp", ri.Address(), time.Second); err == nil {
+		again.Close()
+		t.Error("redirect port still accepting after use")
+	}
+}
+
+func TestRewriteRoutingSeparatesRedirectsOfOneClient(t *testing.T) {
+	h, _ := routingHarness(t, nil)
+	// 同一客户端IP的两个连接被路由到不同的服务器
+	first := routeClient(t, h, 0, "replica-a.example", 1444)
+	second := routeClient(t, h, 1, "replica-b.example", 1445)
+	if first.Address() == second.Address() {
+		t.Fatalf("both redirects use %s", first.Address())
+	}
+
+	// 按与收到路由相反的顺序重新连接，仍各自到达自己的目标
+	for i, ri := range []RoutingInfo{second, first} {
+		conn, err := net.Dial("tcp", ri.Address())
+		if err != nil {
+			t.Fatalf("reconnect to %s: %v", ri.Address(), err)
+		}
+		defer conn.Close()
+		h.backend(2 + i)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.dialed[2] != "replica-b.example:1445" || h.dialed[3] != "replica-a.example:1444" {
+		t.Errorf("reconnections dialed %v, want replica-b then replica-a", h.dialed[2:])
+	}
+}
+
+func TestRewriteRoutingRejectsOtherClients(t *testing.T) {
+	rejected := make(chan error, 1)
+	h, _ := routingHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetConnectionRejectedHandler(func(_ net.Conn, err error) {
+			rejected <- err
+		})
+	})
+	ri := routeClient(t, h, 0, "replica.example", 1444)
+
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+	conn, err := dialer.Dial("tcp", ri.Address())
+	if err != nil {
+		t.Skipf("cannot dial from 127.0.0.2: %v", err)
+	}
+	defer conn.Close()
+	select {
+	case err := <-rejected:
+		if !errors.Is(err, ErrRoutingClient) {
+			t.Errorf("rejected with %v, want ErrRoutingClient", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection from another client was not rejected")
+	}
+	if redirects := h.ba.RoutingRedirects(); len(redirects) != 1 {
+		t.Errorf("RoutingRedirects() = %v, want the mapping kept for its client", redirects)
+	}
+}
+
+func TestStopClosesRoutingRedirects(t *testing.T) {
+	h, _ := routingHarness(t, nil)
+	ri := routeClient(t, h, 0, "replica.example", 1444)
+	h.ba.Stop()
+	if redirects := h.ba.RoutingRedirects(); len(redirects) != 0 {
+		t.Errorf("RoutingRedirects() after Stop = %v", redirects)
+	}
+	if conn, err := net.DialTimeout("tcp", ri.Address(), time.Second); err == nil {
+		conn.Close()
+		t.Error("redirect port still accepting after Stop")
+	}
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// BRIDGE_SERVER_NAME 桥接器合成错误时使用的服务器名
+const BRIDGE_SERVER_NAME = "TDSBridge"
+
+// 桥接器合成错误使用的错误号(取自SQL Server用户自定义错误号范围)
+const (
+	BRIDGE_ERROR_BLOCKED             = 50001
+	BRIDGE_ERROR_BACKEND_WRITE       = 50002
+	BRIDGE_ERROR_SERVER_BUSY         = 50003
+	BRIDGE_ERROR_ENCRYPTION_REQUIRED = 50004
+	BRIDGE_ERROR_CLIENT_LIMIT        = 50005
+	BRIDGE_ERROR_BACKEND_CLOSED      = 50006
+	BRIDGE_ERROR_BACKEND_PROTOCOL    = 50007
+	BRIDGE_ERROR_NO_HEALTHY_BACKEND  = 50008
+)
+
+// MAX_ERROR_MESSAGE_LENGTH 桥接器合成错误的消息文本最大长度(UTF-16码元)，使响应不超过默认的4096字节数据包
+const MAX_ERROR_MESSAGE_LENGTH = 2000
+
+// BuildErrorResponse 构造一个完整的TDS表格结果数据包(含头部)，
+// 其中包含一个ERROR令牌和一个带DONE_ERROR的最终DONE令牌，用于向客户端返回桥接器自身产生的错误。
+// 超过MAX_ERROR_MESSAGE_LENGTH的消息被截断。
+func BuildErrorResponse(number int32, class byte, message string) []byte {
+	msgText := encodeUTF16LE(message)
+	if len(msgText) > MAX_ERROR_MESSAGE_LENGTH*2 {
+		msgText = msgText[:MAX_ERROR_MESSAGE_LENGTH*2]
+		// 不留下不成对的高代理项
+		if last := binary.LittleEndian.Uint16(msgText[len(msgText)-2:]); utf16.IsSurrogate(rune(last)) && last < 0xDC00 {
+			msgText = msgText[:len(msgText)-2]
+		}
+	}
+	serverName := encodeUTF16LE(BRIDGE_SERVER_NAME)
+
+	// ERROR令牌
+	tokenLength := 4 + 1 + 1 + 2 + len(msgText) + 1 + len(serverName) + 1 + 4
+	payload := make([]byte, 0, 3+tokenLength+13)
+	payload = append(payload, byte(TokenError))
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(tokenLength))
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(number))
+	payload = append(payload, 1, class) // State, Class
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(len(msgText)/2))
+	payload = append(payload, msgText...)
+	payload = append(payload, byte(len(serverName)/2))
+	payload = append(payload, serverName...)
+	payload = append(payload, 0)                           // ProcName
+	payload = binary.LittleEndian.AppendUint32(payload, 1) // LineNumber
+
+	// DONE令牌
+	payload = append(payload, byte(TokenDone))
+	payload = binary.LittleEndian.AppendUint16(payload, DONE_ERROR|DONE_FINAL)
+	payload = binary.LittleEndian.AppendUint16(payload, 0) // CurCmd
+	payload = binary.LittleEndian.AppendUint64(payload, 0) // DoneRowCount
+
+	header := BuildTDSHeader(TabularResult, END_OF_MESSAGE, len(payload)+HEADER_SIZE, 1)
+	return append(header, payload...)
+}
+
+// encodeUTF16LE 将字符串编码为UTF-16LE字节
+func encodeUTF16LE(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, len(units)*2)
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[i*2:], u)
+	}
+	return b
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+)
+
+// 错误类别哨兵，可通过errors.Is判断错误原因
+var (
+	ErrListen      = errors.New("tdsbridge: listen failed")
+	ErrBackendDial = errors.New("tdsbridge: backend dial failed")
+	ErrProtocol    = errors.New("tdsbridge: protocol error")
+	ErrTimeout     = errors.New("tdsbridge: timeout")
+
+	ErrConnectionLimit    = errors.New("tdsbridge: connection limit reached")
+	ErrClientLimit        = errors.New("tdsbridge: per-client connection limit reached")
+	ErrMaxLifetime        = errors.New("tdsbridge: max connection lifetime exceeded")
+	ErrIdleTimeout        = errors.New("tdsbridge: idle timeout")
+	ErrBufferLimit        = errors.New("tdsbridge: buffer limit exceeded")
+	ErrCaptureClosed      = errors.New("tdsbridge: capture closed")
+	ErrEncryptionRequired = errors.New("tdsbridge: encryption required")
+	ErrBackendClosed      = errors.New("tdsbridge: backend closed before responding")
+	ErrInterleavedMessage = errors.New("tdsbridge: interleaved message")
+	ErrBackendProtocol    = errors.New("tdsbridge: backend is not speaking TDS")
+	ErrAcceptRateLimit    = errors.New("tdsbridge: accept rate limit exceeded")
+	ErrDrainTimeout       = errors.New("tdsbridge: connections still active after drain timeout")
+	ErrNoHealthyBackend   = errors.New("tdsbridge: no healthy backend")
+	ErrRoutingClient      = errors.New("tdsbridge: routing redirect port used by another client")
+)
+
+// BridgeError 桥接器错误，同时匹配其类别哨兵(Kind)和底层错误(Err)
+type BridgeError struct {
+	Kind error
+	Op   string
+	Err  error
+}
+
+func (e *BridgeError) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error() + ": " + e.Op
+	}
+	return e.Kind.Error() + ": " + e.Op + ": " + e.Err.Error()
+}
+
+// Unwrap 同时暴露类别哨兵和底层错误，供errors.Is/As使用
+func (e *BridgeError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// newBridgeError 创建指定类别的BridgeError
+func newBridgeError(kind error, op string, err error) error {
+	return &BridgeError{Kind: kind, Op: op, Err: err}
+}
+
+// classifyError 将网络超时错误归入ErrTimeout，其他错误原样返回
+func classifyError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var bridgeErr *BridgeError
+	if errors.As(err, &bridgeErr) {
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return newBridgeError(ErrTimeout, op, err)
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// exceptionHarness 创建记录桥接异常的装置
+func exceptionHarness(t *testing.T, configure func(ba *BridgeAcceptor)) (*bridgeHarness, chan error) {
+	exceptions := make(chan error, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBridgeExceptionHandler(func(bc *BridgedConnection, ct ConnectionType, err error) {
+			exceptions <- err
+		})
+		if configure != nil {
+			configure(ba)
+		}
+	})
+	return h, exceptions
+}
+
+// firstMatching 等待第一个匹配target的异常
+func firstMatching(t *testing.T, exceptions chan error, target error) error {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case err := <-exceptions:
+			if errors.Is(err, target) {
+				return err
+			}
+		case <-deadline:
+			t.Fatalf("no exception matching %v", target)
+		}
+	}
+}
+
+func TestStartListenErrorIsErrListen(t *testing.T) {
+	occupied, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	defer occupied.Close()
+
+	_, port, _ := net.SplitHostPort(occupied.Addr().String())
+	ba := NewBridgeAcceptor(port, "backend:1433")
+	err = ba.Start()
+	if err == nil {
+		ba.Stop()
+		t.Fatal("Start on an occupied address succeeded")
+	}
+	if !errors.Is(err, ErrListen) {
+		t.Fatalf("Start error %v does not match ErrListen", err)
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("Start error %v does not keep the underlying EADDRINUSE", err)
+	}
+}
+
+func TestBackendDialErrorIsErrBackendDial(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.dialFunc = func(string) (net.Conn, error) { return nil, refused }
+	})
+	client := h.connect()
+
+	err := firstMatching(t, exceptions, ErrBackendDial)
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("dial error %v does not keep the underlying error", err)
+	}
+	var bridgeErr *BridgeError
+	if !errors.As(err, &bridgeErr) || bridgeErr.Op != "dial backend:1433" {
+		t.Fatalf("dial error %v is not a BridgeError for dial backend:1433", err)
+	}
+	waitFor(t, "client close", client.isClosed)
+}
+
+func TestMalformedPacketIsErrProtocol(t *testing.T) {
+	h, exceptions := exceptionHarness(t, nil)
+	// 声明长度小于头部长度的数据包
+	h.connect(BuildTDSHeader(SQLBatch, END_OF_MESSAGE, 4, 1))
+	h.backend(0)
+
+	firstMatching(t, exceptions, ErrProtocol)
+}
+
+func TestOversizedMessageIsErrProtocolAndErrBufferLimit(t *testing.T) {
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxMessageBytes(64)
+	})
+	h.connect(buildPacket(SQLBatch, END_OF_MESSAGE, 1, make([]byte, 128)))
+	h.backend(0)
+
+	err := firstMatching(t, exceptions, ErrProtocol)
+	if !errors.Is(err, ErrBufferLimit) {
+		t.Fatalf("error %v does not match ErrBufferLimit", err)
+	}
+}
+
+func TestClassifyErrorTimeout(t *testing.T) {
+	err := classifyError("read", os.ErrDeadlineExceeded)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("classifyError(deadline exceeded) = %v, want ErrTimeout", err)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("classifyError lost the underlying error: %v", err)
+	}
+
+	plain := errors.New("plain")
+	if got := classifyError("read", plain); got != plain {
+		t.Fatalf("classifyError(non-timeout) = %v, want it unchanged", got)
+	}
+	wrapped := newBridgeError(ErrProtocol, "read", os.ErrDeadlineExceeded)
+	if got := classifyError("read", wrapped); got != wrapped || errors.Is(got, ErrTimeout) {
+		t.Fatalf("classifyError re-classified a BridgeError: %v", got)
+	}
+	if classifyError("read", nil) != nil {
+		t.Fatal("classifyError(nil) != nil")
+	}
+}
+
+func TestBridgeErrorMessage(t *testing.T) {
+	err := newBridgeError(ErrBackendDial, "dial db:1433", errors.New("refused"))
+	if got, want := err.Error(), "tdsbridge: backend dial failed: dial db:1433: refused"; got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+	err = newBridgeError(ErrConnectionLimit, "accept", nil)
+	if got, want := err.Error(), "tdsbridge: connection limit reached: accept"; got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, ErrConnectionLimit) {
+		t.Fatal("BridgeError without Err does not match its kind")
+	}
+}
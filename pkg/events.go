@@ -0,0 +1,165 @@
+package pkg
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy 异步事件队列满时的处理策略
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞转发直到队列有空位
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest 丢弃新事件并计数
+	OverflowDropNewest
+	// OverflowDropOldest 丢弃队列中最旧的事件并计数
+	OverflowDropOldest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "Block"
+	case OverflowDropNewest:
+		return "DropNewest"
+	case OverflowDropOldest:
+		return "DropOldest"
+	default:
+		return "Unknown"
+	}
+}
+
+// eventQueue 异步事件队列，由单独的goroutine按入队顺序执行事件。
+// 停止后已入队的事件仍会执行完，之后加入的事件在调用enqueue的goroutine上直接执行，不会被丢弃。
+type eventQueue struct {
+	ch      chan func()
+	done    chan struct{}
+	policy  OverflowPolicy
+	dropped atomic.Uint64
+
+	// 保护stopped和ch的关闭：持有读锁时ch不会被关闭
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// newEventQueue 创建事件队列并启动消费goroutine
+func newEventQueue(bufferSize int, policy OverflowPolicy) *eventQueue {
+	q := &eventQueue{
+		ch:     make(chan func(), bufferSize),
+		done:   make(chan struct{}),
+		policy: policy,
+	}
+	go q.run()
+	return q
+}
+
+// run 按顺序执行事件，直到队列停止且已入队的事件都执行完
+func (q *eventQueue) run() {
+	for ev := range q.ch {
+		ev()
+	}
+}
+
+// stop 停止接收新事件。已入队的事件由消费goroutine执行完后退出；
+// 因队列满而阻塞的enqueue改为直接执行其事件。
+func (q *eventQueue) stop() {
+	close(q.done)
+	q.mu.Lock()
+	q.stopped = true
+	close(q.ch)
+	q.mu.Unlock()
+}
+
+// enqueue 按溢出策略将事件加入队列，队列已停止时直接执行事件
+func (q *eventQueue) enqueue(ev func()) {
+	if !q.offer(ev) {
+		ev()
+	}
+}
+
+// offer 按溢出策略将事件加入队列或丢弃，队列已停止时返回false
+func (q *eventQueue) offer(ev func()) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.stopped {
+		return false
+	}
+
+	select {
+	case q.ch <- ev:
+		return true
+	default:
+	}
+
+	switch q.policy {
+	case OverflowBlock:
+		select {
+		case q.ch <- ev:
+		case <-q.done:
+			return false
+		}
+	case OverflowDropNewest:
+		q.dropped.Add(1)
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-q.ch:
+				q.dropped.Add(1)
+			default:
+			}
+			select {
+			case q.ch <- ev:
+				return true
+			default:
+			}
+		}
+	}
+	return true
+}
+
+// shardedEventQueue 按连接ID分片的事件队列。
+// 同一连接的事件总是进入同一分片，由同一goroutine按入队顺序执行；不同分片并发执行。
+type shardedEventQueue struct {
+	shards []*eventQueue
+}
+
+// newShardedEventQueue 创建workers个分片，每个分片的缓冲为bufferSize
+func newShardedEventQueue(workers, bufferSize int, policy OverflowPolicy) *shardedEventQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	q := &shardedEventQueue{shards: make([]*eventQueue, workers)}
+	for i := range q.shards {
+		q.shards[i] = newEventQueue(bufferSize, policy)
+	}
+	return q
+}
+
+// enqueue 将事件加入key对应的分片
+func (q *shardedEventQueue) enqueue(key uint64, ev func()) {
+	q.shards[key%uint64(len(q.shards))].enqueue(ev)
+}
+
+// stop 停止所有分片
+func (q *shardedEventQueue) stop() {
+	for _, shard := range q.shards {
+		shard.stop()
+	}
+}
+
+// resetDropped 清零所有分片的丢弃计数
+func (q *shardedEventQueue) resetDropped() {
+	for _, shard := range q.shards {
+		shard.dropped.Store(0)
+	}
+}
+
+// dropped 所有分片因溢出丢弃的事件总数
+func (q *shardedEventQueue) dropped() uint64 {
+	var n uint64
+	for _, shard := range q.shards {
+		n += shard.dropped.Load()
+	}
+	return n
+}
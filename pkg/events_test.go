@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsyncEventsDoNotBlockForwarding(t *testing.T) {
+	release := make(chan struct{})
+	var handled atomic.Int64
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetAsyncEvents(1, OverflowDropNewest)
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			<-release
+			handled.Add(1)
+		})
+	})
+
+	const batches = 5
+	var reads [][]byte
+	total := 0
+	for i := 0; i < batches; i++ {
+		packet := sqlBatchPacket("SELECT 1")
+		reads = append(reads, packet)
+		total += len(packet)
+	}
+	h.connect(reads...)
+
+	// 处理函数阻塞时所有消息仍被转发
+	waitWritten(t, h.backend(0), total)
+	waitFor(t, "dropped events", func() bool { return h.ba.DroppedEvents() > 0 })
+
+	close(release)
+	waitFor(t, "handled events", func() bool {
+		return uint64(handled.Load())+h.ba.DroppedEvents() == batches
+	})
+}
+
+func TestEventQueueDropOldest(t *testing.T) {
+	q := newEventQueue(2, OverflowDropOldest)
+	defer q.stop()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	q.enqueue(func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	ran := make(chan int, 4)
+	for i := 1; i <= 4; i++ {
+		i := i
+		q.enqueue(func() { ran <- i })
+	}
+	if dropped := q.dropped.Load(); dropped != 2 {
+		t.Fatalf("dropped = %d, want 2", dropped)
+	}
+
+	close(release)
+	for _, want := range []int{3, 4} {
+		select {
+		case got := <-ran:
+			if got != want {
+				t.Fatalf("ran event %d, want %d", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event %d not run", want)
+		}
+	}
+}
+
+func TestEventQueueAfterStop(t *testing.T) {
+	q := newEventQueue(4, OverflowDropNewest)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	q.enqueue(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	queued := make(chan struct{}, 1)
+	q.enqueue(func() { queued <- struct{}{} })
+
+	q.stop()
+
+	// 停止后的事件立即在调用方执行
+	inline := false
+	q.enqueue(func() { inline = true })
+	if !inline {
+		t.Fatal("event enqueued after stop did not run inline")
+	}
+
+	// 停止前已入队的事件仍会执行
+	close(release)
+	select {
+	case <-queued:
+	case <-time.After(2 * time.Second):
+		t.Fatal("event queued before stop was not run")
+	}
+	if dropped := q.dropped.Load(); dropped != 0 {
+		t.Fatalf("dropped = %d, want 0", dropped)
+	}
+}
+
+func TestEventQueueStopUnblocksBlockedEnqueue(t *testing.T) {
+	q := newEventQueue(1, OverflowBlock)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	q.enqueue(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	q.enqueue(func() {})
+
+	// 队列已满，阻塞的enqueue在stop时改为直接执行
+	ran := make(chan struct{})
+	go q.enqueue(func() { close(ran) })
+	time.Sleep(10 * time.Millisecond)
+	q.stop()
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked enqueue did not run after stop")
+	}
+	close(release)
+}
+
+func TestDispatchAfterStopRunsInline(t *testing.T) {
+	ba := NewBridgeAcceptor("", "backend:1433")
+	ba.SetAsyncEvents(4, OverflowBlock)
+	ba.mu.Lock()
+	ba.enabled = true
+	ba.startEventQueues()
+	ba.mu.Unlock()
+	ba.Stop()
+
+	bc := NewBridgedConnection(ba, &SocketCouple{})
+	ran := false
+	ba.dispatch(bc, func() { ran = true })
+	if !ran {
+		t.Fatal("dispatch after Stop did not run the event")
+	}
+}
+
+func TestAsyncEventsPreservePerConnectionOrder(t *testing.T) {
+	const batches = 50
+	var mu sync.Mutex
+	var calls atomic.Int64
+	seen := make(map[uint64][]string)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetAsyncEvents(batches, OverflowBlock)
+		ba.SetAsyncEventWorkers(4)
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			// 处理耗时不一，乱序执行会被发现
+			time.Sleep(time.Duration(calls.Add(1)%3) * 100 * time.Microsecond)
+			mu.Lock()
+			defer mu.Unlock()
+			seen[bc.ID()] = append(seen[bc.ID()], msg.(*SQLBatchMessage).GetBatchText())
+		})
+	})
+
+	clients := []*scriptedConn{h.connect(), h.connect()}
+	h.backend(1)
+	for i := 0; i < batches; i++ {
+		for c, client := range clients {
+			client.feed(sqlBatchPacket(fmt.Sprintf("SELECT %d, %d", c, i)))
+		}
+	}
+	waitFor(t, "all events", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen[1])+len(seen[2]) == 2*batches
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	for id, texts := range seen {
+		// 两个连接接受的先后不定，按第一条消息确定客户端
+		var c int
+		fmt.Sscanf(texts[0], "SELECT %d,", &c)
+		for i, text := range texts {
+			if want := fmt.Sprintf("SELECT %d, %d", c, i); text != want {
+				t.Fatalf("connection %d event %d = %q, want %q", id, i, text, want)
+			}
+		}
+	}
+}
+
+func TestAsyncEventWorkersRunConnectionsConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	handled := make(chan uint64, 2)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetAsyncEvents(4, OverflowBlock)
+		ba.SetAsyncEventWorkers(2)
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			if bc.ID() == 1 {
+				<-release
+			}
+			handled <- bc.ID()
+		})
+	})
+	defer close(release)
+
+	h.connect(sqlBatchPacket("SELECT 1"))
+	h.connect(sqlBatchPacket("SELECT 2"))
+	// 连接1的处理函数阻塞时，另一分片上的连接2仍能得到事件
+	select {
+	case id := <-handled:
+		if id != 2 {
+			t.Errorf("handled connection %d, want 2", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection 2 event blocked behind connection 1")
+	}
+}
@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// FeatureID Login7的FeatureExt块和服务器FEATUREEXTACK令牌中的功能标识
+type FeatureID byte
+
+const (
+	FEATURE_SESSIONRECOVERY    FeatureID = 0x01
+	FEATURE_FEDAUTH            FeatureID = 0x02
+	FEATURE_COLUMNENCRYPTION   FeatureID = 0x04
+	FEATURE_GLOBALTRANSACTIONS FeatureID = 0x05
+	FEATURE_AZURESQLSUPPORT    FeatureID = 0x08
+	FEATURE_DATACLASSIFICATION FeatureID = 0x09
+	FEATURE_UTF8_SUPPORT       FeatureID = 0x0A
+	FEATURE_AZURESQLDNSCACHING FeatureID = 0x0B
+	FEATURE_JSONSUPPORT        FeatureID = 0x0D
+	FEATURE_VECTORSUPPORT      FeatureID = 0x0E
+	FEATURE_TERMINATOR         FeatureID = 0xFF
+)
+
+func (id FeatureID) String() string {
+	switch id {
+	case FEATURE_SESSIONRECOVERY:
+		return "SESSIONRECOVERY"
+	case FEATURE_FEDAUTH:
+		return "FEDAUTH"
+	case FEATURE_COLUMNENCRYPTION:
+		return "COLUMNENCRYPTION"
+	case FEATURE_GLOBALTRANSACTIONS:
+		return "GLOBALTRANSACTIONS"
+	case FEATURE_AZURESQLSUPPORT:
+		return "AZURESQLSUPPORT"
+	case FEATURE_DATACLASSIFICATION:
+		return "DATACLASSIFICATION"
+	case FEATURE_UTF8_SUPPORT:
+		return "UTF8_SUPPORT"
+	case FEATURE_AZURESQLDNSCACHING:
+		return "AZURESQLDNSCACHING"
+	case FEATURE_JSONSUPPORT:
+		return "JSONSUPPORT"
+	case FEATURE_VECTORSUPPORT:
+		return "VECTORSUPPORT"
+	case FEATURE_TERMINATOR:
+		return "TERMINATOR"
+	default:
+		return fmt.Sprintf("0x%02X", byte(id))
+	}
+}
+
+// breaksParsing 检查功能是否会改变令牌流或数据类型的格式，使桥接器的消息解析不再可靠：
+// 列加密改变列元数据和值的编码，数据分类增加未知的令牌，JSON和向量类型引入新的数据类型
+func (id FeatureID) breaksParsing() bool {
+	switch id {
+	case FEATURE_COLUMNENCRYPTION, FEATURE_DATACLASSIFICATION, FEATURE_JSONSUPPORT, FEATURE_VECTORSUPPORT:
+		return true
+	}
+	return false
+}
+
+// FeatureExt 一个功能选项：客户端请求的FeatureData或服务器确认的AckData
+type FeatureExt struct {
+	ID   FeatureID
+	Data []byte
+}
+
+func (f FeatureExt) String() string {
+	return fmt.Sprintf("%s(%d bytes)", f.ID, len(f.Data))
+}
+
+// parseFeatureExt 解析(FeatureId, DWORD长度, 数据)序列，直到0xFF终止符。
+// 返回的选项和消耗的字节数；缺少终止符或长度越界时返回ErrProtocol。
+func parseFeatureExt(data []byte) ([]FeatureExt, int, error) {
+	var features []FeatureExt
+	pos := 0
+	for {
+		if pos >= len(data) {
+			return features, pos, fmt.Errorf("%w: feature ext: missing terminator", ErrProtocol)
+		}
+		id := FeatureID(data[pos])
+		pos++
+		if id == FEATURE_TERMINATOR {
+			return features, pos, nil
+		}
+		if pos+4 > len(data) {
+			return features, pos, fmt.Errorf("%w: feature ext: truncated option %s", ErrProtocol, id)
+		}
+		length := int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if length < 0 || length > len(data)-pos {
+			return features, pos, fmt.Errorf("%w: feature ext: option %s length %d out of range", ErrProtocol, id, length)
+		}
+		features = append(features, FeatureExt{ID: id, Data: data[pos : pos+length]})
+		pos += length
+	}
+}
+
+// Features 解析客户端在FeatureExt块中请求的功能，未设置fExtension时返回nil
+func (m *Login7Message) Features() ([]FeatureExt, error) {
+	payload, err := m.login7Payload()
+	if err != nil {
+		return nil, err
+	}
+	if payload[login7OptionFlags3]&login7FExtension == 0 {
+		return nil, nil
+	}
+	// ibExtension指向一个DWORD，其值为FeatureExt块的偏移
+	extIb := int(binary.LittleEndian.Uint16(payload[login7Extension:]))
+	extSize := int(binary.LittleEndian.Uint16(payload[login7Extension+2:]))
+	if extSize < 4 || extIb+4 > len(payload) {
+		return nil, fmt.Errorf("%w: login7: extension offset out of range", ErrProtocol)
+	}
+	offset := int(binary.LittleEndian.Uint32(payload[extIb:]))
+	if offset < LOGIN7_FIXED_SIZE || offset >= len(payload) {
+		return nil, fmt.Errorf("%w: login7: feature ext offset %d out of range", ErrProtocol, offset)
+	}
+	features, _, err := parseFeatureExt(payload[offset:])
+	return features, err
+}
+
+// Features 解析响应中FEATUREEXTACK令牌确认的功能，没有该令牌时返回nil
+func (m *TabularResultMessage) Features() ([]FeatureExt, error) {
+	tokens, err := m.GetTokens()
+	for _, token := range tokens {
+		if token.Type == TokenFeatureExtAck {
+			features, _, ackErr := parseFeatureExt(token.Data)
+			return features, ackErr
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// featureExtAckToken 构造FEATUREEXTACK令牌
+func featureExtAckToken(features ...FeatureExt) []byte {
+	token := []byte{byte(TokenFeatureExtAck)}
+	for _, feature := range features {
+		token = append(token, byte(feature.ID))
+		token = binary.LittleEndian.AppendUint32(token, uint32(len(feature.Data)))
+		token = append(token, feature.Data...)
+	}
+	return append(token, byte(FEATURE_TERMINATOR))
+}
+
+func TestLogin7Features(t *testing.T) {
+	login := testLogin7{
+		version: TDSVersion74, user: "sa", database: "master",
+		features: []FeatureExt{
+			{ID: FEATURE_SESSIONRECOVERY, Data: []byte{}},
+			{ID: FEATURE_UTF8_SUPPORT, Data: []byte{}},
+		},
+	}
+	features, err := login.message().Features()
+	if err != nil {
+		t.Fatalf("Features: %v", err)
+	}
+	if len(features) != 2 || features[0].ID != FEATURE_SESSIONRECOVERY || features[1].ID != FEATURE_UTF8_SUPPORT {
+		t.Fatalf("Features() = %v, want SESSIONRECOVERY and UTF8_SUPPORT", features)
+	}
+	if got := features[1].String(); got != "UTF8_SUPPORT(0 bytes)" {
+		t.Errorf("String() = %q", got)
+	}
+
+	plain := testLogin7{version: TDSVersion74, user: "sa"}
+	if features, err := plain.message().Features(); features != nil || err != nil {
+		t.Errorf("login without fExtension: Features() = %v, %v, want nil", features, err)
+	}
+}
+
+func TestFeatureExtAck(t *testing.T) {
+	msg := tokensMessage(
+		featureExtAckToken(FeatureExt{ID: FEATURE_UTF8_SUPPORT, Data: []byte{1}}),
+		doneToken(TokenDone, DONE_FINAL, 0, 0),
+	)
+	features, err := msg.Features()
+	if err != nil {
+		t.Fatalf("Features: %v", err)
+	}
+	if len(features) != 1 || features[0].ID != FEATURE_UTF8_SUPPORT || len(features[0].Data) != 1 || features[0].Data[0] != 1 {
+		t.Errorf("Features() = %v, want UTF8_SUPPORT acknowledged with data 01", features)
+	}
+	if features, err := tokensMessage(doneToken(TokenDone, DONE_FINAL, 0, 0)).Features(); features != nil || err != nil {
+		t.Errorf("response without FEATUREEXTACK: Features() = %v, %v", features, err)
+	}
+}
+
+func TestParseFeatureExtErrors(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"missing terminator": {byte(FEATURE_UTF8_SUPPORT), 0, 0, 0, 0},
+		"truncated length":   {byte(FEATURE_UTF8_SUPPORT), 1, 0},
+		"length overflow":    {byte(FEATURE_UTF8_SUPPORT), 9, 0, 0, 0, 1, 0xFF},
+	} {
+		if _, _, err := parseFeatureExt(data); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%s: err = %v, want ErrProtocol", name, err)
+		}
+	}
+	features, n, err := parseFeatureExt([]byte{byte(FEATURE_TERMINATOR), 0xAA})
+	if err != nil || len(features) != 0 || n != 1 {
+		t.Errorf("terminator only: %v, %d, %v", features, n, err)
+	}
+}
+
+func TestBreaksParsing(t *testing.T) {
+	for _, id := range []FeatureID{FEATURE_COLUMNENCRYPTION, FEATURE_DATACLASSIFICATION, FEATURE_JSONSUPPORT, FEATURE_VECTORSUPPORT} {
+		if !id.breaksParsing() {
+			t.Errorf("%v.breaksParsing() = false", id)
+		}
+	}
+	for _, id := range []FeatureID{FEATURE_SESSIONRECOVERY, FEATURE_UTF8_SUPPORT} {
+		if id.breaksParsing() {
+			t.Errorf("%v.breaksParsing() = true", id)
+		}
+	}
+}
+
+// parsingHarness 创建桥接环境，按到达顺序记录客户端消息事件的消息类型
+func parsingHarness(t *testing.T) (*bridgeHarness, chan HeaderType) {
+	types := make(chan HeaderType, 8)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			types <- msg.GetPackets()[0].Header.Type()
+		})
+	})
+	return h, types
+}
+
+// expectMessageTypes 等待依次收到want中的消息事件，之后不再有其他事件
+func expectMessageTypes(t *testing.T, types chan HeaderType, want ...HeaderType) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-types:
+			if got != w {
+				t.Fatalf("message event for %v, want %v", got, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no message event for %v", w)
+		}
+	}
+	select {
+	case got := <-types:
+		t.Errorf("unexpected message event for %v after parsing was disabled", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestUnsupportedLoginFeatureSkipsParsing(t *testing.T) {
+	h, types := parsingHarness(t)
+	login := testLogin7{
+		version: TDSVersion74, user: "sa",
+		features: []FeatureExt{{ID: FEATURE_COLUMNENCRYPTION, Data: []byte{1}}},
+	}.packet()
+	batch := sqlBatchPacket("SELECT 1")
+	h.connect(login, batch)
+
+	// 登录本身仍被解析，之后的消息只转发
+	backend := h.backend(0)
+	waitWritten(t, backend, len(login)+len(batch))
+	expectMessageTypes(t, types, TDS7Login)
+	if bc := h.ba.Connections()[0]; bc.ParsingSupported() {
+		t.Error("ParsingSupported() = true after the login requested column encryption")
+	}
+	if !bytes.Equal(backend.Written(), append(login, batch...)) {
+		t.Error("raw forwarding changed the bytes")
+	}
+}
+
+func TestFeatureExtAckDisablesParsing(t *testing.T) {
+	h, types := parsingHarness(t)
+	login := testLogin7{version: TDSVersion74, user: "sa"}.packet()
+	client := h.connect(login)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(login))
+	expectMessageTypes(t, types, TDS7Login)
+	bc := h.ba.Connections()[0]
+	if !bc.ParsingSupported() {
+		t.Fatal("ParsingSupported() = false before the server acknowledged any feature")
+	}
+
+	// 服务器在登录响应中确认了JSON类型
+	var response []byte
+	response = append(response, featureExtAckToken(FeatureExt{ID: FEATURE_JSONSUPPORT, Data: []byte{1}})...)
+	response = append(response, doneToken(TokenDone, DONE_FINAL, 0, 0)...)
+	responsePacket := buildPacket(TabularResult, END_OF_MESSAGE, 1, response)
+	backend.feed(responsePacket)
+	waitWritten(t, client, len(responsePacket))
+	if bc.ParsingSupported() {
+		t.Fatal("ParsingSupported() = true after FEATUREEXTACK acknowledged JSON support")
+	}
+
+	batch := sqlBatchPacket("SELECT 1")
+	client.feed(batch)
+	waitWritten(t, backend, len(login)+len(batch))
+	expectMessageTypes(t, types)
+}
+
+func TestSupportedFeaturesKeepParsing(t *testing.T) {
+	h, types := parsingHarness(t)
+	login := testLogin7{
+		version: TDSVersion74, user: "sa",
+		features: []FeatureExt{{ID: FEATURE_UTF8_SUPPORT, Data: []byte{}}},
+	}.packet()
+	client := h.connect(login)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(login))
+
+	response := append(featureExtAckToken(FeatureExt{ID: FEATURE_UTF8_SUPPORT, Data: []byte{1}}),
+		doneToken(TokenDone, DONE_FINAL, 0, 0)...)
+	responsePacket := buildPacket(TabularResult, END_OF_MESSAGE, 1, response)
+	backend.feed(responsePacket)
+	waitWritten(t, client, len(responsePacket))
+
+	client.feed(sqlBatchPacket("SELECT 1"))
+	expectMessageTypes(t, types, TDS7Login, SQLBatch)
+	if !h.ba.Connections()[0].ParsingSupported() {
+		t.Error("ParsingSupported() = false for UTF8_SUPPORT")
+	}
+}
@@ -0,0 +1,42 @@
+package pkg
+
+import "fmt"
+
+// messageFraming 跟踪客户端方向正在组装的消息，检测交错的消息。
+// 非MARS连接同一时间只能有一个消息在组装中；MARS连接按SPID分别跟踪。
+type messageFraming struct {
+	// 按SPID记录正在组装的消息类型，非MARS连接只使用键0
+	open map[uint16]HeaderType
+}
+
+// add 记录一个客户端数据包。消息尚未结束时收到另一类型的数据包，视为新消息在当前消息完成前开始，返回错误。
+func (f *messageFraming) add(header *TDSHeader, mars bool) error {
+	var key uint16
+	if mars {
+		key = header.SPID()
+	}
+	if current, ok := f.open[key]; ok && current != header.Type() {
+		return fmt.Errorf("%w: %s message started before %s message completed", ErrInterleavedMessage, header.Type(), current)
+	}
+	if (header.StatusBitMask() & END_OF_MESSAGE) == END_OF_MESSAGE {
+		delete(f.open, key)
+		return nil
+	}
+	if f.open == nil {
+		f.open = make(map[uint16]HeaderType)
+	}
+	f.open[key] = header.Type()
+	return nil
+}
+
+// OutstandingMessages 获取客户端已开始发送但尚未以END_OF_MESSAGE结束的消息数。
+// 非MARS连接最多为1；MARS连接按SPID分别计数。
+func (bc *BridgedConnection) OutstandingMessages() int {
+	return int(bc.outstandingMessages.Load())
+}
+
+// isMARS 检查客户端的PreLogin是否请求了MARS
+func isMARS(preLogin *PreLoginRequestMessage) bool {
+	data, ok := preLogin.GetOption(PreLoginMARS)
+	return ok && len(data) > 0 && data[0] == 1
+}
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMessageFraming(t *testing.T) {
+	type packet struct {
+		headerType HeaderType
+		status     byte
+		spid       uint16
+	}
+	header := func(p packet) *TDSHeader {
+		frame := buildPacket(p.headerType, p.status, 1, nil)
+		frame[4], frame[5] = byte(p.spid>>8), byte(p.spid)
+		return NewTDSHeader(frame)
+	}
+	for _, tc := range []struct {
+		name     string
+		mars     bool
+		packets  []packet
+		badIndex int // 报告交错的数据包下标，-1表示没有
+	}{
+		{"sequential messages", false, []packet{
+			{SQLBatch, NORMAL, 0}, {SQLBatch, END_OF_MESSAGE, 0}, {RPC, END_OF_MESSAGE, 0},
+		}, -1},
+		{"new message before end of message", false, []packet{
+			{SQLBatch, NORMAL, 0}, {RPC, END_OF_MESSAGE, 0},
+		}, 1},
+		{"non-MARS ignores SPID", false, []packet{
+			{SQLBatch, NORMAL, 51}, {RPC, END_OF_MESSAGE, 52},
+		}, 1},
+		{"MARS sessions interleave", true, []packet{
+			{SQLBatch, NORMAL, 51}, {RPC, NORMAL, 52}, {SQLBatch, END_OF_MESSAGE, 51}, {RPC, END_OF_MESSAGE, 52},
+		}, -1},
+		{"MARS interleaved within a session", true, []packet{
+			{SQLBatch, NORMAL, 51}, {RPC, END_OF_MESSAGE, 51},
+		}, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var f messageFraming
+			for i, p := range tc.packets {
+				err := f.add(header(p), tc.mars)
+				if i == tc.badIndex {
+					if !errors.Is(err, ErrInterleavedMessage) {
+						t.Errorf("packet %d: err = %v, want ErrInterleavedMessage", i, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("packet %d: %v", i, err)
+				}
+			}
+			if len(f.open) != 0 {
+				t.Errorf("%d messages still open after every message ended", len(f.open))
+			}
+		})
+	}
+}
+
+func TestInterleavedClientMessagesTerminate(t *testing.T) {
+	h, exceptions := exceptionHarness(t, nil)
+	payload := sqlBatchPayload("SELECT 1")
+	first := buildPacket(SQLBatch, NORMAL, 1, payload[:10])
+	interleaved := rpcPacket("sp_who", 0)
+	client := h.connect(first, interleaved)
+	backend := h.backend(0)
+
+	err := firstMatching(t, exceptions, ErrInterleavedMessage)
+	if !errors.Is(err, ErrProtocol) {
+		t.Errorf("exception %v does not match ErrProtocol", err)
+	}
+	waitFor(t, "client close", client.isClosed)
+	if got := backend.Written(); !bytes.Equal(got, first) {
+		t.Errorf("backend received %x, want only the packet before the interleaved message", got)
+	}
+}
+
+func TestOutstandingMessages(t *testing.T) {
+	h := newBridgeHarness(t, nil)
+	payload := sqlBatchPayload("SELECT 1")
+	first := buildPacket(SQLBatch, NORMAL, 1, payload[:10])
+	client := h.connect(first)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(first))
+	bc := h.ba.Connections()[0]
+	if n := bc.OutstandingMessages(); n != 1 {
+		t.Errorf("OutstandingMessages() mid-message = %d, want 1", n)
+	}
+
+	last := buildPacket(SQLBatch, END_OF_MESSAGE, 2, payload[10:])
+	client.feed(last)
+	waitWritten(t, backend, len(first)+len(last))
+	if n := bc.OutstandingMessages(); n != 0 {
+		t.Errorf("OutstandingMessages() after END_OF_MESSAGE = %d, want 0", n)
+	}
+}
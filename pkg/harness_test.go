@@ -0,0 +1,469 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试用的脚本化连接、假监听器和桥接器装置，使转发逻辑无需真实套接字即可确定地测试。
+
+// scriptedConn 按脚本依次返回预定义的读取数据并记录所有写入的net.Conn。
+// 脚本读完后阻塞直到关闭或追加数据；eofWhenDone为true时读完返回io.EOF。
+type scriptedConn struct {
+	mu          sync.Mutex
+	reads       [][]byte
+	written     bytes.Buffer
+	eofWhenDone bool
+	closed      bool
+	// 非空时Write返回该错误
+	writeErr error
+	// 有新的读取数据或关闭时广播
+	cond *sync.Cond
+
+	local, remote net.Addr
+}
+
+// newScriptedConn 创建按reads依次返回数据的连接，每个元素对应一次Read
+func newScriptedConn(reads ...[]byte) *scriptedConn {
+	c := &scriptedConn{
+		reads:  reads,
+		local:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1433},
+		remote: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000},
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// feed 追加一次读取的数据
+func (c *scriptedConn) feed(data []byte) {
+	c.mu.Lock()
+	c.reads = append(c.reads, data)
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+func (c *scriptedConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if c.closed {
+			return 0, net.ErrClosed
+		}
+		if len(c.reads) > 0 {
+			n := copy(b, c.reads[0])
+			if n < len(c.reads[0]) {
+				c.reads[0] = c.reads[0][n:]
+			} else {
+				c.reads = c.reads[1:]
+			}
+			return n, nil
+		}
+		if c.eofWhenDone {
+			return 0, io.EOF
+		}
+		c.cond.Wait()
+	}
+}
+
+func (c *scriptedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if c.writeErr != nil {
+		return 0, c.writeErr
+	}
+	return c.written.Write(b)
+}
+
+// failWrites 使之后的Write返回err
+func (c *scriptedConn) failWrites(err error) {
+	c.mu.Lock()
+	c.writeErr = err
+	c.mu.Unlock()
+}
+
+func (c *scriptedConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.cond.Broadcast()
+	return nil
+}
+
+// isClosed 检查连接是否已关闭
+func (c *scriptedConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Written 获取已写入数据的副本
+func (c *scriptedConn) Written() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.written.Bytes()...)
+}
+
+func (c *scriptedConn) LocalAddr() net.Addr                { return c.local }
+func (c *scriptedConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *scriptedConn) SetDeadline(t time.Time) error      { return nil }
+func (c *scriptedConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *scriptedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// fakeListener 由测试投入连接的net.Listener
+type fakeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{conns: make(chan net.Conn, 16), closed: make(chan struct{})}
+}
+
+func (l *fakeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *fakeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *fakeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1433}
+}
+
+// bridgeHarness 以假监听器接受脚本化的客户端连接，并以脚本化连接代替后端的桥接器
+type bridgeHarness struct {
+	t        testing.TB
+	ba       *BridgeAcceptor
+	listener *fakeListener
+
+	mu       sync.Mutex
+	backends []*scriptedConn
+	clients  []*scriptedConn
+	// 下一次拨号返回的后端连接，为空时创建不含脚本的连接
+	nextBackends []*scriptedConn
+	dialed       []string
+}
+
+// newBridgeHarness 创建装置，configure在接受循环启动前配置桥接器
+func newBridgeHarness(t testing.TB, configure func(ba *BridgeAcceptor)) *bridgeHarness {
+	t.Helper()
+	h := &bridgeHarness{t: t, listener: newFakeListener()}
+	h.ba = NewBridgeAcceptor("", "backend:1433")
+	h.ba.dialFunc = h.dial
+	if configure != nil {
+		configure(h.ba)
+	}
+	// 与Start相同地启用桥接器并启动事件队列，但不创建监听套接字
+	h.ba.mu.Lock()
+	h.ba.enabled = true
+	h.ba.startEventQueues()
+	h.ba.mu.Unlock()
+
+	var started sync.WaitGroup
+	started.Add(1)
+	route := &bridgeRoute{listenAddr: "fake:1433", backend: h.ba.sqlServerEndpoint}
+	go h.ba.acceptLoop(h.listener, route, &started)
+	started.Wait()
+
+	t.Cleanup(h.close)
+	return h
+}
+
+// dial 代替后端拨号，返回预先准备的或新的脚本化连接
+func (h *bridgeHarness) dial(endpoint string) (net.Conn, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dialed = append(h.dialed, endpoint)
+	var backend *scriptedConn
+	if len(h.nextBackends) > 0 {
+		backend, h.nextBackends = h.nextBackends[0], h.nextBackends[1:]
+	} else {
+		backend = newScriptedConn()
+	}
+	backend.remote = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1433}
+	h.backends = append(h.backends, backend)
+	return backend, nil
+}
+
+// prepareBackend 指定下一次拨号返回的后端连接
+func (h *bridgeHarness) prepareBackend(backend *scriptedConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextBackends = append(h.nextBackends, backend)
+}
+
+// connect 投入一个按reads发送数据的客户端连接
+func (h *bridgeHarness) connect(reads ...[]byte) *scriptedConn {
+	return h.connectFrom(nil, reads...)
+}
+
+// connectFrom 投入一个来自ip的客户端连接，ip为nil时使用默认的127.0.0.1；
+// ip为IPv6地址时，本地地址相应地使用[::1]:1433
+func (h *bridgeHarness) connectFrom(ip net.IP, reads ...[]byte) *scriptedConn {
+	client := newScriptedConn(reads...)
+	if ip != nil {
+		client.remote = &net.TCPAddr{IP: ip, Port: 50000}
+		if ip.To4() == nil {
+			client.local = &net.TCPAddr{IP: net.IPv6loopback, Port: 1433}
+		}
+	}
+	h.mu.Lock()
+	h.clients = append(h.clients, client)
+	h.mu.Unlock()
+	h.listener.conns <- client
+	return client
+}
+
+// backend 等待并返回第i个后端连接
+func (h *bridgeHarness) backend(i int) *scriptedConn {
+	h.t.Helper()
+	var backend *scriptedConn
+	waitFor(h.t, "backend dial", func() bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if len(h.backends) > i {
+			backend = h.backends[i]
+			return true
+		}
+		return false
+	})
+	return backend
+}
+
+// close 关闭监听器和所有连接
+func (h *bridgeHarness) close() {
+	h.listener.Close()
+	h.mu.Lock()
+	conns := append(append([]*scriptedConn(nil), h.clients...), h.backends...)
+	h.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	h.ba.Stop()
+}
+
+// waitFor 轮询直到cond为真，超时则测试失败
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitWritten 等待连接上写入至少n字节，返回已写入的数据
+func waitWritten(t testing.TB, c *scriptedConn, n int) []byte {
+	t.Helper()
+	var written []byte
+	waitFor(t, "written bytes", func() bool {
+		written = c.Written()
+		return len(written) >= n
+	})
+	return written
+}
+
+// buildPacket 构造一个完整的TDS数据包
+func buildPacket(headerType HeaderType, status byte, packetID byte, payload []byte) []byte {
+	return append(BuildTDSHeader(headerType, status, HEADER_SIZE+len(payload), packetID), payload...)
+}
+
+// sqlBatchPayload 构造带ALL_HEADERS(事务描述符)的SQLBatch有效载荷
+func sqlBatchPayload(text string) []byte {
+	payload := binary.LittleEndian.AppendUint32(nil, 22)
+	payload = binary.LittleEndian.AppendUint32(payload, 18)
+	payload = binary.LittleEndian.AppendUint16(payload, 2)
+	payload = binary.LittleEndian.AppendUint64(payload, 0)
+	payload = binary.LittleEndian.AppendUint32(payload, 1)
+	return append(payload, encodeUTF16LE(text)...)
+}
+
+// sqlBatchPacket 构造单包SQLBatch消息
+func sqlBatchPacket(text string) []byte {
+	return buildPacket(SQLBatch, END_OF_MESSAGE, 1, sqlBatchPayload(text))
+}
+
+// doneResponse 构造只含一个最终DONE令牌的表格结果数据包
+func doneResponse(status uint16, rowCount uint64) []byte {
+	payload := []byte{byte(TokenDone)}
+	payload = binary.LittleEndian.AppendUint16(payload, status)
+	payload = binary.LittleEndian.AppendUint16(payload, 0xC1) // CurCmd: SELECT
+	payload = binary.LittleEndian.AppendUint64(payload, rowCount)
+	return buildPacket(TabularResult, END_OF_MESSAGE, 1, payload)
+}
+
+func TestHarnessBridgesSQLBatch(t *testing.T) {
+	messages := make(chan TDSMessage, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			messages <- msg
+		})
+	})
+
+	packet := sqlBatchPacket("SELECT 1")
+	h.connect(packet)
+	backend := h.backend(0)
+
+	if got := waitWritten(t, backend, len(packet)); !bytes.Equal(got, packet) {
+		t.Fatalf("backend received % x, want % x", got, packet)
+	}
+	select {
+	case msg := <-messages:
+		batch, ok := msg.(*SQLBatchMessage)
+		if !ok {
+			t.Fatalf("message is %T, want *SQLBatchMessage", msg)
+		}
+		if text := batch.GetBatchText(); text != "SELECT 1" {
+			t.Fatalf("batch text = %q, want %q", text, "SELECT 1")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message event not fired")
+	}
+}
+
+func TestHarnessForwardsResponseToClient(t *testing.T) {
+	h := newBridgeHarness(t, nil)
+	response := doneResponse(DONE_FINAL, 1)
+	h.prepareBackend(newScriptedConn(response))
+
+	client := h.connect(sqlBatchPacket("SELECT 1"))
+	if got := waitWritten(t, client, len(response)); !bytes.Equal(got, response) {
+		t.Fatalf("client received % x, want % x", got, response)
+	}
+}
+
+func TestScriptedConnSplitsReads(t *testing.T) {
+	c := newScriptedConn([]byte{1, 2, 3})
+	c.eofWhenDone = true
+	b := make([]byte, 2)
+	if n, err := c.Read(b); n != 2 || err != nil {
+		t.Fatalf("first read = %d, %v", n, err)
+	}
+	if n, err := c.Read(b); n != 1 || err != nil || b[0] != 3 {
+		t.Fatalf("second read = %d, %v, % x", n, err, b[:n])
+	}
+	if _, err := c.Read(b); !errors.Is(err, io.EOF) {
+		t.Fatalf("third read err = %v, want EOF", err)
+	}
+}
+
+// responseErrors 解析单包表格结果中的ERROR令牌
+func responseErrors(t testing.TB, data []byte) []*ServerError {
+	t.Helper()
+	msg := NewTabularResultMessageWithPacket(NewTDSPacketFromBuffer(data))
+	errs, err := msg.GetServerErrors()
+	if err != nil {
+		t.Fatalf("decode response errors: %v", err)
+	}
+	return errs
+}
+
+// packetStream 重复发送同一个数据包count次的客户端连接，发送完后阻塞直到关闭
+type packetStream struct {
+	*scriptedConn
+	packet    []byte
+	remaining int
+	offset    int
+}
+
+func newPacketStream(packet []byte, count int) *packetStream {
+	return &packetStream{scriptedConn: newScriptedConn(), packet: packet, remaining: count}
+}
+
+func (s *packetStream) Read(b []byte) (int, error) {
+	if s.remaining == 0 {
+		return s.scriptedConn.Read(b)
+	}
+	n := 0
+	for n < len(b) && s.remaining > 0 {
+		copied := copy(b[n:], s.packet[s.offset:])
+		n += copied
+		s.offset += copied
+		if s.offset == len(s.packet) {
+			s.offset = 0
+			s.remaining--
+		}
+	}
+	return n, nil
+}
+
+// countingConn 丢弃写入数据的后端连接，累计写入target字节后关闭done
+type countingConn struct {
+	*scriptedConn
+	target  int64
+	written atomic.Int64
+	writes  atomic.Int64
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newCountingConn(target int64) *countingConn {
+	return &countingConn{scriptedConn: newScriptedConn(), target: target, done: make(chan struct{})}
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	if c.written.Add(int64(len(b))) >= c.target {
+		c.once.Do(func() { close(c.done) })
+	}
+	return len(b), nil
+}
+
+// benchmarkForwarding 测量客户端到SQL Server方向转发count个packet的吞吐量
+func benchmarkForwarding(b *testing.B, packet []byte, configure func(ba *BridgeAcceptor)) *countingConn {
+	backend := newCountingConn(int64(len(packet)) * int64(b.N))
+	h := newBridgeHarness(b, func(ba *BridgeAcceptor) {
+		ba.dialFunc = func(string) (net.Conn, error) { return backend, nil }
+		if configure != nil {
+			configure(ba)
+		}
+	})
+
+	b.SetBytes(int64(len(packet)))
+	b.ResetTimer()
+	client := newPacketStream(packet, b.N)
+	h.listener.conns <- client
+	<-backend.done
+	b.StopTimer()
+	client.Close()
+	return backend
+}
+
+// syncBuffer 可并发写入和读取的缓冲区
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes 获取已写入数据的副本
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
@@ -1,6 +1,9 @@
 package pkg
 
-import "fmt"
+import (
+	"encoding/binary"
+	"fmt"
+)
 
 // HeaderType TDS头部类型枚举
 type HeaderType int
@@ -56,7 +59,46 @@ const (
 	RESET_CONNECTION_SKIP_TRAN = 0x10
 )
 
-// TDSHeader TDS头部结构体
+// StatusBits 数据包头部的状态位
+type StatusBits byte
+
+// IsEndOfMessage 是否为消息的最后一个数据包
+func (s StatusBits) IsEndOfMessage() bool {
+	return s&END_OF_MESSAGE != 0
+}
+
+// IsIgnore 是否设置了忽略位(客户端取消正在发送的消息)
+func (s StatusBits) IsIgnore() bool {
+	return s&IGNORE_EVENT != 0
+}
+
+// IsResetConnection 是否要求在处理请求前重置连接
+func (s StatusBits) IsResetConnection() bool {
+	return s&RESET_CONNECTION != 0
+}
+
+// IsResetConnectionSkipTran 是否要求重置连接但保留事务状态
+func (s StatusBits) IsResetConnectionSkipTran() bool {
+	return s&RESET_CONNECTION_SKIP_TRAN != 0
+}
+
+// With 返回设置了指定状态位的副本
+func (s StatusBits) With(bits StatusBits) StatusBits {
+	return s | bits
+}
+
+// Without 返回清除了指定状态位的副本
+func (s StatusBits) Without(bits StatusBits) StatusBits {
+	return s &^ bits
+}
+
+// String 以|分隔列出已设置的状态位名称，无状态位时为"NORMAL"
+func (s StatusBits) String() string {
+	return StatusBitString(byte(s))
+}
+
+// TDSHeader TDS头部结构体。
+// 访问方法不假设Buffer至少有HEADER_SIZE字节，缺少的字节按0处理。
 type TDSHeader struct {
 	Buffer []byte
 }
@@ -74,26 +116,62 @@ func NewTDSHeader(buffer []byte) *TDSHeader {
 	return h
 }
 
+// BuildTDSHeader 按给定字段构造8字节的TDS头部
+func BuildTDSHeader(headerType HeaderType, status byte, lengthIncludingHeader int, packetID byte) []byte {
+	return []byte{
+		byte(headerType),
+		status,
+		byte(lengthIncludingHeader >> 8),
+		byte(lengthIncludingHeader),
+		0, 0, // SPID
+		packetID,
+		0, // Window
+	}
+}
+
 // Type 获取头部类型
 func (h *TDSHeader) Type() HeaderType {
-	return HeaderType(h.Buffer[0])
+	return HeaderType(h.GetByte(0))
 }
 
 // StatusBitMask 获取状态位掩码
 func (h *TDSHeader) StatusBitMask() byte {
-	return h.Buffer[1]
+	return h.GetByte(1)
+}
+
+// Status 获取类型化的状态位
+func (h *TDSHeader) Status() StatusBits {
+	return StatusBits(h.GetByte(1))
+}
+
+// SetStatus 设置状态位，如h.SetStatus(h.Status().With(RESET_CONNECTION))
+func (h *TDSHeader) SetStatus(status StatusBits) {
+	h.SetByte(1, byte(status))
 }
 
 // LengthIncludingHeader 获取包括头部的总长度
 func (h *TDSHeader) LengthIncludingHeader() int {
-	return int(h.Buffer[2])*0x100 + int(h.Buffer[3])
+	return int(h.GetByte(2))*0x100 + int(h.GetByte(3))
 }
 
-// PayloadSize 获取有效载荷大小
+// PayloadSize 获取有效载荷大小，声明的总长度小于头部长度时返回0
 func (h *TDSHeader) PayloadSize() int {
+	if h.LengthIncludingHeader() < HEADER_SIZE {
+		return 0
+	}
 	return h.LengthIncludingHeader() - HEADER_SIZE
 }
 
+// SPID 获取服务器进程ID，客户端发出的数据包通常为0
+func (h *TDSHeader) SPID() uint16 {
+	return uint16(h.GetByte(4))<<8 | uint16(h.GetByte(5))
+}
+
+// PacketID 获取数据包ID，消息内逐包加1，超过255后回绕为0
+func (h *TDSHeader) PacketID() byte {
+	return h.GetByte(6)
+}
+
 // GetByte 获取指定索引的字节
 func (h *TDSHeader) GetByte(idx int) byte {
 	if idx >= 0 && idx < len(h.Buffer) {
@@ -135,4 +213,122 @@ func (ah *AllHeader) Length() uint32 {
 			uint32(ah.Payload[0])*0x00000001
 	}
 	return 0
-}
\ No newline at end of file
+}
+
+// AllHeadersBlockLength 计算ALL_HEADERS块的长度(含4字节总长度字段)，即消息体在payload中的起始位置。
+// 声明长度小于4或超过payload大小时返回ErrProtocol；payload为空时返回0。
+func AllHeadersBlockLength(payload []byte) (int, error) {
+	if len(payload) == 0 {
+		return 0, nil
+	}
+	if len(payload) < 4 {
+		return 0, fmt.Errorf("%w: ALL_HEADERS truncated: %d bytes", ErrProtocol, len(payload))
+	}
+	length := NewAllHeader(payload).Length()
+	if length < 4 {
+		return 0, fmt.Errorf("%w: ALL_HEADERS length %d too small", ErrProtocol, length)
+	}
+	if uint64(length) > uint64(len(payload)) {
+		return 0, fmt.Errorf("%w: ALL_HEADERS length %d exceeds payload size %d", ErrProtocol, length, len(payload))
+	}
+	return int(length), nil
+}
+
+// HasAllHeaders 检查payload是否以结构合理的ALL_HEADERS块开头：
+// 总长度不超过payload，且其中各头部的长度恰好铺满整个块、类型为已知的头部类型。
+// 用于TDS版本未知时区分7.2之前(无ALL_HEADERS)和之后的请求。
+func HasAllHeaders(payload []byte) bool {
+	total, err := AllHeadersBlockLength(payload)
+	if err != nil || total < 4+6 {
+		return false
+	}
+	offset := 4
+	for offset < total {
+		if offset+6 > total {
+			return false
+		}
+		headerLength := int(NewAllHeader(payload[offset:]).Length())
+		headerType := int(payload[offset+4]) | int(payload[offset+5])<<8
+		if headerLength < 6 || offset+headerLength > total || headerType < 1 || headerType > 3 {
+			return false
+		}
+		offset += headerLength
+	}
+	return true
+}
+
+// StreamHeaderType ALL_HEADERS块中单个头部的类型
+type StreamHeaderType uint16
+
+const (
+	StreamHeaderQueryNotifications    StreamHeaderType = 1
+	StreamHeaderTransactionDescriptor StreamHeaderType = 2
+	StreamHeaderTraceActivity         StreamHeaderType = 3
+)
+
+func (t StreamHeaderType) String() string {
+	switch t {
+	case StreamHeaderQueryNotifications:
+		return "QueryNotifications"
+	case StreamHeaderTransactionDescriptor:
+		return "TransactionDescriptor"
+	case StreamHeaderTraceActivity:
+		return "TraceActivity"
+	default:
+		return fmt.Sprintf("StreamHeaderType(%d)", uint16(t))
+	}
+}
+
+// StreamHeader ALL_HEADERS块中的一个头部，Data为类型之后的头部数据
+type StreamHeader struct {
+	Type StreamHeaderType
+	Data []byte
+}
+
+// TransactionDescriptor 解析事务描述符头部，返回事务描述符和未完成的请求数；
+// 头部不是事务描述符或长度不足时第三个返回值为false
+func (h StreamHeader) TransactionDescriptor() (descriptor uint64, outstandingRequests uint32, ok bool) {
+	if h.Type != StreamHeaderTransactionDescriptor || len(h.Data) < 12 {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint64(h.Data), binary.LittleEndian.Uint32(h.Data[8:]), true
+}
+
+// ParseAllHeaders 解析payload开头的ALL_HEADERS块中的各个头部，结构不合理时返回ErrProtocol
+func ParseAllHeaders(payload []byte) ([]StreamHeader, error) {
+	total, err := AllHeadersBlockLength(payload)
+	if err != nil {
+		return nil, err
+	}
+	var headers []StreamHeader
+	offset := 4
+	for offset < total {
+		if offset+6 > total {
+			return headers, fmt.Errorf("%w: ALL_HEADERS: truncated header at offset %d", ErrProtocol, offset)
+		}
+		headerLength := int(NewAllHeader(payload[offset:]).Length())
+		if headerLength < 6 || offset+headerLength > total {
+			return headers, fmt.Errorf("%w: ALL_HEADERS: header length %d out of range", ErrProtocol, headerLength)
+		}
+		headers = append(headers, StreamHeader{
+			Type: StreamHeaderType(binary.LittleEndian.Uint16(payload[offset+4:])),
+			Data: payload[offset+6 : offset+headerLength],
+		})
+		offset += headerLength
+	}
+	return headers, nil
+}
+
+// requestBodyOffset 返回SQLBatch/RPC请求体在payload中的起始位置。
+// TDS 7.2之前没有ALL_HEADERS块；版本未知时按HasAllHeaders判断。
+func requestBodyOffset(payload []byte, version TDSVersion) (int, error) {
+	switch {
+	case version == TDSVersionUnknown:
+		if !HasAllHeaders(payload) {
+			return 0, nil
+		}
+	case !version.AtLeast(TDSVersion72):
+		return 0, nil
+	}
+	return AllHeadersBlockLength(payload)
+}
@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestAllHeadersBlockLength(t *testing.T) {
+	valid := sqlBatchPayload("SELECT 1")
+	zero := append(binary.LittleEndian.AppendUint32(nil, 0), valid[4:]...)
+	oversized := append(binary.LittleEndian.AppendUint32(nil, uint32(len(valid)+1)), valid[4:]...)
+
+	for _, tc := range []struct {
+		name    string
+		payload []byte
+		want    int
+		wantErr bool
+	}{
+		{"valid", valid, 22, false},
+		{"empty payload", nil, 0, false},
+		{"zero length", zero, 0, true},
+		{"shorter than length field", []byte{0x16, 0x00}, 0, true},
+		{"oversized", oversized, 0, true},
+		{"exactly payload size", binary.LittleEndian.AppendUint32(nil, 4), 4, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := AllHeadersBlockLength(tc.payload)
+			if tc.wantErr {
+				if !errors.Is(err, ErrProtocol) {
+					t.Fatalf("AllHeadersBlockLength() error = %v, want ErrProtocol", err)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("AllHeadersBlockLength() = %d, %v, want %d", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestBatchTextSkipsAllHeaders(t *testing.T) {
+	msg := NewSQLBatchMessageWithPacket(NewTDSPacketFromBuffer(sqlBatchPacket("SELECT 1")))
+	if text := msg.GetBatchText(); text != "SELECT 1" {
+		t.Fatalf("GetBatchText() = %q, want %q", text, "SELECT 1")
+	}
+}
+
+func TestRPCSkipsAllHeaders(t *testing.T) {
+	payload := rpcPayload("usp_test", 0)
+	body, err := requestBodyOffset(payload, TDSVersion74)
+	if err != nil || body != 22 {
+		t.Fatalf("requestBodyOffset() = %d, %v, want 22", body, err)
+	}
+
+	// 声明长度超过有效载荷时解析失败而不是越界
+	binary.LittleEndian.PutUint32(payload, uint32(len(payload)+10))
+	msg := NewRPCRequestMessageWithPacket(NewTDSPacketFromBuffer(buildPacket(RPC, END_OF_MESSAGE, 1, payload)))
+	msg.SetTDSVersion(TDSVersion74)
+	if _, err := msg.GetProcName(); !errors.Is(err, ErrProtocol) {
+		t.Fatalf("GetProcName() error = %v, want ErrProtocol", err)
+	}
+}
+
+func TestStatusBits(t *testing.T) {
+	header := NewTDSHeader(BuildTDSHeader(SQLBatch, END_OF_MESSAGE|IGNORE_EVENT|RESET_CONNECTION, HEADER_SIZE, 1))
+	status := header.Status()
+	if got, want := status.String(), "EOM|IGNORE|RESET_CONNECTION"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if !status.IsEndOfMessage() || !status.IsIgnore() || !status.IsResetConnection() || status.IsResetConnectionSkipTran() {
+		t.Errorf("predicates disagree with %s", status)
+	}
+	if byte(status) != header.StatusBitMask() {
+		t.Errorf("Status() = %#x, StatusBitMask() = %#x", byte(status), header.StatusBitMask())
+	}
+
+	skip := StatusBits(RESET_CONNECTION_SKIP_TRAN)
+	if !skip.IsResetConnectionSkipTran() || skip.IsResetConnection() || skip.IsEndOfMessage() {
+		t.Errorf("predicates disagree with %s", skip)
+	}
+	if got := StatusBits(NORMAL).String(); got != "NORMAL" {
+		t.Errorf("NORMAL String() = %q", got)
+	}
+	if got := status.Without(IGNORE_EVENT).With(RESET_CONNECTION_SKIP_TRAN).String(); got != "EOM|RESET_CONNECTION|RESET_CONNECTION_SKIP_TRAN" {
+		t.Errorf("Without/With = %q", got)
+	}
+}
+
+// streamHeader 构造ALL_HEADERS块中的一个头部
+func streamHeader(headerType StreamHeaderType, data []byte) []byte {
+	header := binary.LittleEndian.AppendUint32(nil, uint32(6+len(data)))
+	header = binary.LittleEndian.AppendUint16(header, uint16(headerType))
+	return append(header, data...)
+}
+
+// allHeaders 将头部拼接为ALL_HEADERS块
+func allHeaders(headers ...[]byte) []byte {
+	var body []byte
+	for _, header := range headers {
+		body = append(body, header...)
+	}
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(4+len(body))), body...)
+}
+
+func TestParseAllHeaders(t *testing.T) {
+	descriptor := binary.LittleEndian.AppendUint64(nil, 42)
+	descriptor = binary.LittleEndian.AppendUint32(descriptor, 1)
+	notifications := []byte{0x02, 0x00, 'i', 0x00, 0x00, 0x00}
+	block := allHeaders(
+		streamHeader(StreamHeaderQueryNotifications, notifications),
+		streamHeader(StreamHeaderTransactionDescriptor, descriptor),
+	)
+	payload := append(block, encodeUTF16LE("SELECT 1")...)
+
+	headers, err := ParseAllHeaders(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("ParseAllHeaders returned %d headers, want 2", len(headers))
+	}
+	if headers[0].Type != StreamHeaderQueryNotifications || string(headers[0].Data) != string(notifications) {
+		t.Errorf("first header = %s %x", headers[0].Type, headers[0].Data)
+	}
+	if _, _, ok := headers[0].TransactionDescriptor(); ok {
+		t.Error("query notifications header decoded as a transaction descriptor")
+	}
+	if d, n, ok := headers[1].TransactionDescriptor(); !ok || d != 42 || n != 1 {
+		t.Errorf("TransactionDescriptor() = %d, %d, %v", d, n, ok)
+	}
+	if got := StreamHeaderType(9).String(); got != "StreamHeaderType(9)" {
+		t.Errorf("unknown type String() = %q", got)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		block []byte
+	}{
+		{"header length below minimum", allHeaders([]byte{0x02, 0, 0, 0, 0x02, 0})},
+		{"header past block", allHeaders(append(binary.LittleEndian.AppendUint32(nil, 40), 0x02, 0))},
+		{"truncated header", allHeaders([]byte{0x12, 0, 0})},
+	} {
+		if _, err := ParseAllHeaders(tc.block); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%s: err = %v, want ErrProtocol", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package pkg
+
+// MessageInterceptor 在转发goroutine中内联处理完整的客户端消息。
+// 返回nil表示原样转发；返回非nil的有效载荷时以其替换消息，按原消息的头部字段重新分包；
+// drop为true时不转发该消息，改为向客户端回复错误(与被禁止的消息类型相同)。
+type MessageInterceptor func(bc *BridgedConnection, msg TDSMessage) (payload []byte, drop bool)
+
+// SetMessageInterceptor 设置消息拦截函数(拦截路径)。设置后每个客户端消息都被缓存到
+// END_OF_MESSAGE，拦截函数返回后才转发，其耗时直接计入转发延迟。关闭解析时不生效。需在Start之前调用。
+func (ba *BridgeAcceptor) SetMessageInterceptor(interceptor MessageInterceptor) {
+	ba.messageInterceptor = interceptor
+}
+
+// SetForwardRedactor 设置转发前对SQLBatch文本的改写函数，用于在批处理到达后端之前脱敏(如信用卡号)。
+// 设置后SQLBatch消息被缓存到END_OF_MESSAGE，改写函数返回的文本与原文本不同时，
+// 按原编码重新编码、保留ALL_HEADERS块并重新分包后转发；消息事件和日志中看到的仍是原始批处理。
+// 在消息拦截函数之前执行，拦截函数返回的有效载荷优先。关闭解析时不生效。传入nil取消。需在Start之前调用。
+func (ba *BridgeAcceptor) SetForwardRedactor(redactor func(text string) string) {
+	ba.forwardRedactor = redactor
+}
+
+// SetDropIgnoredMessages 设置是否不转发设置了IGNORE_EVENT位的客户端消息，以减轻后端负担。
+// 忽略位在消息的最后一个数据包上，因此启用后每个客户端消息都被缓存到END_OF_MESSAGE，
+// 完整接收后才决定丢弃或转发；被丢弃的消息仍触发消息接收事件，并触发消息丢弃事件。
+// 关闭解析时不生效。需在Start之前调用。
+func (ba *BridgeAcceptor) SetDropIgnoredMessages(enabled bool) {
+	ba.dropIgnoredMessages = enabled
+}
+
+// SetMessageDroppedHandler 设置消息丢弃处理函数，在设置了忽略位的客户端消息被丢弃时触发
+func (ba *BridgeAcceptor) SetMessageDroppedHandler(handler MessageDroppedHandler) {
+	ba.messageDroppedHandler = handler
+}
+
+// SetMessageObserver 设置只读的消息观察函数(观察路径)。观察函数收到完整客户端消息的副本，
+// 在独立的goroutine中按到达顺序执行，不影响转发：缓冲bufferSize个消息，
+// 队列满时丢弃新消息并计入DroppedObservations。传入nil取消。需在Start之前调用。
+func (ba *BridgeAcceptor) SetMessageObserver(observer TDSMessageReceivedHandler, bufferSize int) {
+	ba.messageObserver = observer
+	ba.observerBufferSize = bufferSize
+}
+
+// DroppedObservations 获取观察队列已满而未投递给观察函数的消息数
+func (ba *BridgeAcceptor) DroppedObservations() uint64 {
+	ba.mu.Lock()
+	observers := ba.observers
+	ba.mu.Unlock()
+
+	if observers == nil {
+		return 0
+	}
+	return observers.dropped.Load()
+}
+
+// holdsMessage 检查以该类型开始的客户端消息是否需要缓存到END_OF_MESSAGE再转发
+func (ba *BridgeAcceptor) holdsMessage(headerType HeaderType) bool {
+	if ba.parsingDisabled {
+		return false
+	}
+	return ba.messageInterceptor != nil || ba.dropIgnoredMessages ||
+		(headerType == TDS7Login && ba.rewritesLogin()) ||
+		(headerType == SQLBatch && (ba.forwardRedactor != nil || ba.queryCostFilter != nil))
+}
+
+// interceptMessage 对缓存的完整消息执行登录改写和拦截函数，返回要转发的线上数据；
+// drop为true时消息不转发
+func (bc *BridgedConnection) interceptMessage(msg TDSMessage) (data []byte, drop bool, err error) {
+	if bc.BridgeAcceptor.rewritesLogin() {
+		if data, err = bc.rewriteLogin(msg); err != nil {
+			return nil, false, err
+		}
+	}
+	if data == nil && bc.BridgeAcceptor.forwardRedactor != nil {
+		if data, err = bc.redactBatch(msg); err != nil {
+			return nil, false, err
+		}
+	}
+	if interceptor := bc.BridgeAcceptor.messageInterceptor; interceptor != nil {
+		payload, drop := interceptor(bc, msg)
+		if drop {
+			return nil, true, nil
+		}
+		if payload != nil {
+			packets := msg.GetPackets()
+			data = packetizeMessage(packets[0].Header, payload, messagePacketSize(packets))
+		}
+	}
+	if data == nil {
+		for _, packet := range msg.GetPackets() {
+			data = append(data, packet.Bytes()...)
+		}
+	}
+	return data, false, nil
+}
+
+// redactBatch 对SQLBatch文本执行转发改写函数，返回重新分包后的线上数据；文本未改变时返回nil
+func (bc *BridgedConnection) redactBatch(msg TDSMessage) ([]byte, error) {
+	batch, ok := msg.(*SQLBatchMessage)
+	if !ok {
+		return nil, nil
+	}
+	text := batch.GetBatchText()
+	redacted := bc.BridgeAcceptor.forwardRedactor(text)
+	if redacted == text {
+		return nil, nil
+	}
+	payload, err := batch.RewriteBatchText(redacted)
+	if err != nil {
+		return nil, err
+	}
+	packets := batch.GetPackets()
+	return packetizeMessage(packets[0].Header, payload, messagePacketSize(packets)), nil
+}
+
+// messagePacketSize 推断重新分包时使用的数据包大小：多包消息的非最后数据包长度即协商的数据包大小，
+// 单包消息无法得知，使用默认大小
+func messagePacketSize(packets []*TDSPacket) int {
+	if len(packets) > 1 && packets[0].Header.LengthIncludingHeader() > HEADER_SIZE {
+		return packets[0].Header.LengthIncludingHeader()
+	}
+	return DEFAULT_PACKET_SIZE
+}
+
+// observe 将消息的副本投递给观察函数。观察队列在Start和Stop时替换，需在mu下读取
+func (bc *BridgedConnection) observe(msg TDSMessage) {
+	ba := bc.BridgeAcceptor
+	ba.mu.Lock()
+	observers := ba.observers
+	ba.mu.Unlock()
+
+	observer := ba.messageObserver
+	if observer == nil || observers == nil {
+		return
+	}
+	copied := cloneMessage(msg)
+	observers.enqueue(func() { observer(bc, copied) })
+}
+
+// cloneMessage 复制消息及其数据包，保留解析所需的TDS版本和文本编码
+func cloneMessage(msg TDSMessage) TDSMessage {
+	var copied TDSMessage
+	for _, packet := range msg.GetPackets() {
+		clone := NewTDSPacket(packet.Header.Buffer, packet.Payload, len(packet.Payload))
+		if copied == nil {
+			copied = CreateTDSMessageFromFirstPacket(clone)
+		} else {
+			copied.AddPacket(clone)
+		}
+	}
+	switch m := msg.(type) {
+	case *SQLBatchMessage:
+		c := copied.(*SQLBatchMessage)
+		c.SetTDSVersion(m.tdsVersion)
+		c.SetTextEncoding(m.textEncoding)
+	case *Login7Message:
+		copied.(*Login7Message).SetRevealPassword(m.revealPassword)
+	case *RPCRequestMessage:
+		c := copied.(*RPCRequestMessage)
+		c.SetTDSVersion(m.tdsVersion)
+		c.SetMaxParameters(m.maxParameters)
+	case *TabularResultMessage:
+		copied.(*TabularResultMessage).SetTDSVersion(m.tdsVersion)
+	}
+	return copied
+}
@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestObserverNeverDelaysForwarding(t *testing.T) {
+	release := make(chan struct{})
+	var observed atomic.Int32
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMessageObserver(func(bc *BridgedConnection, msg TDSMessage) {
+			<-release
+			observed.Add(1)
+		}, 1)
+	})
+	defer close(release)
+
+	const batches = 10
+	batch := sqlBatchPacket("SELECT 1")
+	reads := make([][]byte, batches)
+	for i := range reads {
+		reads[i] = batch
+	}
+	h.connect(reads...)
+
+	// 观察函数一直阻塞，转发不受影响，放不进队列的副本被丢弃
+	waitWritten(t, h.backend(0), batches*len(batch))
+	waitFor(t, "dropped observations", func() bool { return h.ba.DroppedObservations() > 0 })
+	if observed.Load() != 0 {
+		t.Error("observer returned before it was released")
+	}
+}
+
+func TestObserverReceivesCopies(t *testing.T) {
+	observed := make(chan TDSMessage, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMessageObserver(func(bc *BridgedConnection, msg TDSMessage) {
+			// 修改副本不影响转发的数据
+			for _, packet := range msg.GetPackets() {
+				for i := range packet.Payload {
+					packet.Payload[i] = 0
+				}
+			}
+			observed <- msg
+		}, 4)
+	})
+	batch := sqlBatchPacket("SELECT 1")
+	h.connect(batch)
+	backend := h.backend(0)
+	select {
+	case msg := <-observed:
+		if _, ok := msg.(*SQLBatchMessage); !ok {
+			t.Errorf("observer got %T, want *SQLBatchMessage", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("observer not called")
+	}
+	waitWritten(t, backend, len(batch))
+	if !bytes.Equal(backend.Written(), batch) {
+		t.Error("observer's changes to its copy reached the backend")
+	}
+}
+
+func TestInterceptorReplacesMessage(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMessageInterceptor(func(bc *BridgedConnection, msg TDSMessage) ([]byte, bool) {
+			if batch, ok := msg.(*SQLBatchMessage); ok && batch.GetBatchText() == "SELECT 1" {
+				return sqlBatchPayload("SELECT 2"), false
+			}
+			return nil, false
+		})
+	})
+	other := sqlBatchPacket("SELECT 3")
+	h.connect(sqlBatchPacket("SELECT 1"), other)
+	backend := h.backend(0)
+	want := append(sqlBatchPacket("SELECT 2"), other...)
+	waitWritten(t, backend, len(want))
+	if !bytes.Equal(backend.Written(), want) {
+		t.Errorf("backend received %x, want the replaced batch followed by the untouched one", backend.Written())
+	}
+}
+
+func TestInterceptorDropsMessage(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMessageInterceptor(func(bc *BridgedConnection, msg TDSMessage) ([]byte, bool) {
+			return nil, msg.(*SQLBatchMessage).GetBatchText() == "DROP TABLE t"
+		})
+	})
+	allowed := sqlBatchPacket("SELECT 1")
+	client := h.connect(sqlBatchPacket("DROP TABLE t"), allowed)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(allowed))
+	if !bytes.Equal(backend.Written(), allowed) {
+		t.Error("dropped message reached the backend")
+	}
+	waitFor(t, "error response", func() bool { return len(client.Written()) > 0 })
+	if errs := responseErrors(t, client.Written()); len(errs) != 1 {
+		t.Errorf("client received %d errors, want 1 for the dropped message", len(errs))
+	}
+}
+
+// cardNumbers 匹配16位信用卡号
+var cardNumbers = regexp.MustCompile(`\b\d{4}-\d{4}-\d{4}-\d{4}\b`)
+
+func TestForwardRedactorRedactsBatch(t *testing.T) {
+	events := make(chan string, 2)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetForwardRedactor(func(text string) string {
+			return cardNumbers.ReplaceAllString(text, "****")
+		})
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			if batch, ok := msg.(*SQLBatchMessage); ok {
+				events <- batch.GetBatchText()
+			}
+		})
+	})
+
+	// 带有非零事务描述符的ALL_HEADERS块必须原样保留
+	payload := sqlBatchPayload("INSERT INTO cards VALUES ('4111-1111-1111-1111')")
+	binary.LittleEndian.PutUint64(payload[10:], 0x1122334455667788)
+	untouched := sqlBatchPacket("SELECT 1")
+	h.connect(buildPacket(SQLBatch, END_OF_MESSAGE, 1, payload), untouched)
+
+	wantPayload := append(append([]byte{}, payload[:22]...), encodeUTF16LE("INSERT INTO cards VALUES ('****')")...)
+	want := append(buildPacket(SQLBatch, END_OF_MESSAGE, 1, wantPayload), untouched...)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(want))
+	if !bytes.Equal(backend.Written(), want) {
+		t.Errorf("backend received %x, want %x", backend.Written(), want)
+	}
+
+	// 消息事件看到的仍是原始批处理
+	select {
+	case text := <-events:
+		if !strings.Contains(text, "4111-1111-1111-1111") {
+			t.Errorf("message event saw %q, want the original batch", text)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message event")
+	}
+}
+
+func TestForwardRedactorRepacketizesLongBatch(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetForwardRedactor(func(text string) string {
+			return strings.ReplaceAll(text, "secret", "[redacted-value]")
+		})
+	})
+
+	// 两个512字节的数据包，改写后的文本变长，需要重新按512字节分包
+	text := "SELECT 'secret'" + strings.Repeat(" ", 400)
+	payload := sqlBatchPayload(text)
+	first := buildPacket(SQLBatch, NORMAL, 1, payload[:512-HEADER_SIZE])
+	second := buildPacket(SQLBatch, END_OF_MESSAGE, 2, payload[512-HEADER_SIZE:])
+	h.connect(first, second)
+
+	redacted := sqlBatchPayload(strings.ReplaceAll(text, "secret", "[redacted-value]"))
+	firstLength := 512 - HEADER_SIZE
+	want := append(buildPacket(SQLBatch, NORMAL, 1, redacted[:firstLength]),
+		buildPacket(SQLBatch, END_OF_MESSAGE, 2, redacted[firstLength:])...)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(want))
+	if !bytes.Equal(backend.Written(), want) {
+		t.Errorf("backend received %x, want %x", backend.Written(), want)
+	}
+}
+
+func TestDropIgnoredMessages(t *testing.T) {
+	payload := sqlBatchPayload("SELECT * FROM big_table")
+	// 忽略位只在最后一个数据包上，第一个数据包到达时还无法判断
+	ignored := append(buildPacket(SQLBatch, byte(NORMAL), 1, payload[:20]),
+		buildPacket(SQLBatch, byte(END_OF_MESSAGE|IGNORE_EVENT), 2, payload[20:])...)
+	allowed := sqlBatchPacket("SELECT 1")
+
+	tests := []struct {
+		name    string
+		drop    bool
+		forward []byte
+	}{
+		{"enabled", true, allowed},
+		{"disabled", false, append(append([]byte{}, ignored...), allowed...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dropped := make(chan TDSMessage, 1)
+			h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+				ba.SetDropIgnoredMessages(tt.drop)
+				ba.SetMessageDroppedHandler(func(_ *BridgedConnection, msg TDSMessage) {
+					dropped <- msg
+				})
+			})
+			client := h.connect(ignored, allowed)
+			backend := h.backend(0)
+			if written := waitWritten(t, backend, len(tt.forward)); !bytes.Equal(written, tt.forward) {
+				t.Errorf("backend received %x, want %x", written, tt.forward)
+			}
+
+			select {
+			case msg := <-dropped:
+				if !tt.drop {
+					t.Fatal("message dropped with SetDropIgnoredMessages(false)")
+				}
+				if !msg.HasIgnoreBitSet() || len(msg.GetPackets()) != 2 {
+					t.Errorf("dropped %v, want the complete ignore-bit message", msg)
+				}
+			default:
+				if tt.drop {
+					t.Fatal("no message dropped event")
+				}
+			}
+			// 服务器不会回应被忽略的消息，桥接器也不回应
+			if len(client.Written()) != 0 {
+				t.Errorf("client received %x, want nothing", client.Written())
+			}
+		})
+	}
+}
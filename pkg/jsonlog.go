@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// 消息日志中的传输方向
+const (
+	DirectionClientToServer = "client_to_server"
+	DirectionServerToClient = "server_to_client"
+)
+
+// MessageRecord 消息日志中的一条记录，按消息类型填充可用的字段
+type MessageRecord struct {
+	Time         time.Time `json:"time"`
+	ConnectionID uint64    `json:"connection_id"`
+	Route        string    `json:"route,omitempty"`
+	Direction    string    `json:"direction"`
+	Type         string    `json:"type"`
+	Packets      int       `json:"packets"`
+	Size         int       `json:"size"`
+
+	SQL        string `json:"sql,omitempty"`
+	ProcName   string `json:"proc_name,omitempty"`
+	HostName   string `json:"host_name,omitempty"`
+	UserName   string `json:"user_name,omitempty"`
+	AppName    string `json:"app_name,omitempty"`
+	Database   string `json:"database,omitempty"`
+	Version    string `json:"tds_version,omitempty"`
+	Final      bool   `json:"final,omitempty"`
+	ParseError string `json:"parse_error,omitempty"`
+}
+
+// NewMessageRecord 从完整消息生成日志记录
+func NewMessageRecord(connectionID uint64, direction string, msg TDSMessage) *MessageRecord {
+	rec := &MessageRecord{
+		Time:         time.Now().UTC(),
+		ConnectionID: connectionID,
+		Direction:    direction,
+		Type:         UnknownHeader.String(),
+		Packets:      len(msg.GetPackets()),
+		Size:         len(msg.AssemblePayload()),
+	}
+	if packets := msg.GetPackets(); len(packets) > 0 {
+		rec.Type = packets[0].Header.Type().String()
+	}
+
+	switch m := msg.(type) {
+	case *SQLBatchMessage:
+		rec.SQL = m.GetBatchText()
+	case *RPCRequestMessage:
+		name, err := m.GetProcName()
+		if err != nil {
+			rec.ParseError = err.Error()
+			break
+		}
+		rec.ProcName = name
+		if isExecuteSQL(name) {
+			if sql, err := m.EffectiveSQL(); err == nil {
+				rec.SQL = sql
+			}
+		}
+	case *Login7Message:
+		rec.HostName = m.GetHostName()
+		rec.UserName = m.GetUserName()
+		rec.AppName = m.GetAppName()
+		rec.Database = m.GetDatabase()
+		rec.Version = m.GetTDSVersion().String()
+	case *PreTD7LoginMessage:
+		rec.HostName = m.GetHostName()
+		rec.UserName = m.GetUserName()
+		rec.AppName = m.GetAppName()
+		rec.Version, _ = m.GetProtocolVersion()
+	case *TabularResultMessage:
+		rec.Final = m.IsFinalResponse()
+	}
+	return rec
+}
+
+// messageJSONLog 将消息记录以NDJSON(每行一个JSON对象)写入io.Writer
+type messageJSONLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// write 序列化并写入一条记录，多个连接并发调用时按行串行化
+func (l *messageJSONLog) write(rec *MessageRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(line)
+	return err
+}
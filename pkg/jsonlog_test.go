@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMessageJSONLogWritesOneLinePerMessage(t *testing.T) {
+	var log syncBuffer
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.EnableMessageJSONLog(&log)
+	})
+	client := h.connect(sqlBatchPacket("SELECT 1"))
+	response := doneResponse(DONE_FINAL|DONE_COUNT, 1)
+	h.backend(0).feed(response)
+	waitWritten(t, client, len(response))
+
+	var lines [][]byte
+	waitFor(t, "two log lines", func() bool {
+		lines = bytes.Split(bytes.TrimSuffix(log.Bytes(), []byte("\n")), []byte("\n"))
+		return len(lines) == 2
+	})
+
+	var records [2]map[string]any
+	for i, line := range lines {
+		if err := json.Unmarshal(line, &records[i]); err != nil {
+			t.Fatalf("line %d is not valid JSON: %v: %s", i, err, line)
+		}
+		for _, field := range []string{"time", "connection_id", "direction", "type", "packets", "size"} {
+			if _, ok := records[i][field]; !ok {
+				t.Errorf("line %d lacks %q: %s", i, field, line)
+			}
+		}
+		if _, err := time.Parse(time.RFC3339Nano, records[i]["time"].(string)); err != nil {
+			t.Errorf("line %d time: %v", i, err)
+		}
+	}
+
+	request, reply := records[0], records[1]
+	if request["direction"] != DirectionClientToServer || request["type"] != "SQLBatch" || request["sql"] != "SELECT 1" {
+		t.Errorf("request record = %v", request)
+	}
+	if reply["direction"] != DirectionServerToClient || reply["type"] != "TabularResult" || reply["final"] != true {
+		t.Errorf("response record = %v", reply)
+	}
+	if request["connection_id"] != reply["connection_id"] {
+		t.Errorf("connection IDs differ: %v and %v", request["connection_id"], reply["connection_id"])
+	}
+}
+
+func TestMessageJSONLogSerializesWrites(t *testing.T) {
+	var out syncBuffer
+	log := &messageJSONLog{w: &out}
+	msg := NewSQLBatchMessageWithPacket(NewTDSPacketFromBuffer(sqlBatchPacket("SELECT 1")))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				log.write(NewMessageRecord(id, DirectionClientToServer, msg))
+			}
+		}(uint64(i))
+	}
+	wg.Wait()
+
+	lines := bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n"))
+	if len(lines) != 400 {
+		t.Fatalf("%d lines, want 400", len(lines))
+	}
+	for i, line := range lines {
+		if !json.Valid(line) {
+			t.Fatalf("line %d is not valid JSON: %s", i, line)
+		}
+	}
+}
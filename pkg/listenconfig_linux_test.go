@@ -0,0 +1,70 @@
+//go:build linux && (amd64 || arm64)
+
+package pkg
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+// soReusePort Linux的SO_REUSEPORT(syscall包在amd64上未定义)
+const soReusePort = 0xF
+
+// reusePortConfig 设置SO_REUSEPORT的监听配置
+func reusePortConfig() *net.ListenConfig {
+	return &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+}
+
+func TestListenConfigReusePort(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	addr := probe.Addr().String()
+	probe.Close()
+
+	// 新旧两个桥接器同时监听同一端口
+	var acceptors []*BridgeAcceptor
+	for i := 0; i < 2; i++ {
+		ba := NewBridgeAcceptor("", "127.0.0.1:1")
+		ba.SetListenConfig(reusePortConfig())
+		if err := ba.AddRoute(addr, "127.0.0.1:1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ba.Start(); err != nil {
+			t.Fatalf("acceptor %d Start: %v", i, err)
+		}
+		defer ba.Stop()
+		acceptors = append(acceptors, ba)
+	}
+
+	// 未设置SO_REUSEPORT时同一端口仍被拒绝
+	plain := NewBridgeAcceptor("", "127.0.0.1:1")
+	if err := plain.AddRoute(addr, "127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Start(); err == nil {
+		plain.Stop()
+		t.Error("Start without SO_REUSEPORT succeeded on a shared port")
+	}
+
+	// 旧进程停止后新的继续接受连接
+	acceptors[0].Stop()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial after stopping the first acceptor: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,247 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Login7固定部分的长度及偏移表中各字段的位置
+const (
+	LOGIN7_FIXED_SIZE = 94
+
+	// 初始数据库名的最大字符数
+	LOGIN7_MAX_DATABASE_LENGTH = 128
+
+	login7HostName       = 36
+	login7UserName       = 40
+	login7Password       = 44
+	login7AppName        = 48
+	login7ServerName     = 52
+	login7Extension      = 56
+	login7CltIntName     = 60
+	login7Language       = 64
+	login7Database       = 68
+	login7ClientID       = 72
+	login7SSPI           = 78
+	login7AtchDBFile     = 82
+	login7ChangePassword = 86
+)
+
+// Login7Message TDS7登录消息
+type Login7Message struct {
+	*BaseTDSMessage
+
+	// GetPassword是否返回密码
+	revealPassword bool
+}
+
+// NewLogin7Message 创建新的Login7Message
+func NewLogin7Message() *Login7Message {
+	return &Login7Message{
+		BaseTDSMessage: NewBaseTDSMessage(),
+	}
+}
+
+// NewLogin7MessageWithPacket 从第一个数据包创建新的Login7Message
+func NewLogin7MessageWithPacket(firstPacket *TDSPacket) *Login7Message {
+	return &Login7Message{
+		BaseTDSMessage: NewBaseTDSMessageWithPacket(firstPacket),
+	}
+}
+
+// login7Payload 获取登录记录，长度不足固定部分时返回错误
+func (m *Login7Message) login7Payload() ([]byte, error) {
+	payload := m.AssemblePayload()
+	if len(payload) < LOGIN7_FIXED_SIZE {
+		return nil, fmt.Errorf("%w: login7: payload too short", ErrProtocol)
+	}
+	return payload, nil
+}
+
+// GetTDSVersion 获取客户端请求的TDS版本
+func (m *Login7Message) GetTDSVersion() TDSVersion {
+	payload, err := m.login7Payload()
+	if err != nil {
+		return TDSVersionUnknown
+	}
+	return TDSVersion(binary.LittleEndian.Uint32(payload[4:]))
+}
+
+// GetPacketSize 获取客户端请求的数据包大小
+func (m *Login7Message) GetPacketSize() uint32 {
+	payload, err := m.login7Payload()
+	if err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(payload[8:])
+}
+
+// getField 按偏移表中的(ib, cch)读取UTF-16字符串字段
+func (m *Login7Message) getField(idx int) string {
+	payload, err := m.login7Payload()
+	if err != nil {
+		return ""
+	}
+	data, ok := login7FieldBytes(payload, idx)
+	if !ok {
+		return ""
+	}
+	return decodeUTF16LE(data)
+}
+
+// login7FieldBytes 返回偏移表中指定字段对应的原始字节
+func login7FieldBytes(payload []byte, idx int) ([]byte, bool) {
+	ib := int(binary.LittleEndian.Uint16(payload[idx:]))
+	cch := int(binary.LittleEndian.Uint16(payload[idx+2:]))
+	if ib+cch*2 > len(payload) {
+		return nil, false
+	}
+	return payload[ib : ib+cch*2], true
+}
+
+// GetHostName 获取客户端主机名
+func (m *Login7Message) GetHostName() string {
+	return m.getField(login7HostName)
+}
+
+// GetUserName 获取登录用户名
+func (m *Login7Message) GetUserName() string {
+	return m.getField(login7UserName)
+}
+
+// SetRevealPassword 设置GetPassword是否返回解除混淆后的密码，默认不返回。
+// 桥接器按SetCaptureSecrets为交给事件的登录消息设置该选项。
+func (m *Login7Message) SetRevealPassword(reveal bool) {
+	m.revealPassword = reveal
+}
+
+// GetPassword 获取解除混淆后的登录密码。未通过SetRevealPassword(true)允许时返回空字符串。
+func (m *Login7Message) GetPassword() string {
+	if !m.revealPassword {
+		return ""
+	}
+	payload, err := m.login7Payload()
+	if err != nil {
+		return ""
+	}
+	data, ok := login7FieldBytes(payload, login7Password)
+	if !ok {
+		return ""
+	}
+	plain := make([]byte, len(data))
+	for i, b := range data {
+		b ^= 0xA5
+		plain[i] = b<<4 | b>>4
+	}
+	return decodeUTF16LE(plain)
+}
+
+// GetAppName 获取应用程序名
+func (m *Login7Message) GetAppName() string {
+	return m.getField(login7AppName)
+}
+
+// GetServerName 获取客户端连接的服务器名
+func (m *Login7Message) GetServerName() string {
+	return m.getField(login7ServerName)
+}
+
+// GetLibraryName 获取客户端接口库名
+func (m *Login7Message) GetLibraryName() string {
+	return m.getField(login7CltIntName)
+}
+
+// GetLanguage 获取初始语言
+func (m *Login7Message) GetLanguage() string {
+	return m.getField(login7Language)
+}
+
+// GetDatabase 获取初始数据库
+func (m *Login7Message) GetDatabase() string {
+	return m.getField(login7Database)
+}
+
+// login7OffsetFields 偏移表中(ib, cch/cb)形式的字段，按表中顺序
+var login7OffsetFields = []int{
+	login7HostName, login7UserName, login7Password, login7AppName, login7ServerName,
+	login7Extension, login7CltIntName, login7Language, login7Database,
+	login7SSPI, login7AtchDBFile, login7ChangePassword,
+}
+
+// login7FExtension OptionFlags3中表示存在FeatureExt的位
+const (
+	login7OptionFlags3 = 27
+	login7FExtension   = 0x10
+)
+
+// RewriteDatabase 返回将初始数据库替换为database后的登录记录，
+// 位于数据库字段之后的变长数据的偏移(包括FeatureExt的偏移)和记录总长度随之调整。
+func (m *Login7Message) RewriteDatabase(database string) ([]byte, error) {
+	payload, err := m.login7Payload()
+	if err != nil {
+		return nil, err
+	}
+	dbIb := int(binary.LittleEndian.Uint16(payload[login7Database:]))
+	dbSize := int(binary.LittleEndian.Uint16(payload[login7Database+2:])) * 2
+	if dbIb < LOGIN7_FIXED_SIZE || dbIb+dbSize > len(payload) {
+		return nil, fmt.Errorf("%w: login7: database field out of range", ErrProtocol)
+	}
+	value := encodeUTF16LE(database)
+	if len(value)/2 > LOGIN7_MAX_DATABASE_LENGTH {
+		return nil, fmt.Errorf("login7: database name longer than %d characters", LOGIN7_MAX_DATABASE_LENGTH)
+	}
+	delta := len(value) - dbSize
+
+	rewritten := make([]byte, 0, len(payload)+delta)
+	rewritten = append(rewritten, payload[:dbIb]...)
+	rewritten = append(rewritten, value...)
+	rewritten = append(rewritten, payload[dbIb+dbSize:]...)
+
+	// 调整位于数据库字段之后的字段偏移
+	shifts := func(ib, idx int) bool {
+		return ib > dbIb || (ib == dbIb && idx > login7Database)
+	}
+	for _, idx := range login7OffsetFields {
+		if idx == login7Database {
+			continue
+		}
+		ib := int(binary.LittleEndian.Uint16(rewritten[idx:]))
+		if shifts(ib, idx) {
+			binary.LittleEndian.PutUint16(rewritten[idx:], uint16(ib+delta))
+		}
+	}
+	binary.LittleEndian.PutUint16(rewritten[login7Database+2:], uint16(len(value)/2))
+
+	// ibExtension指向一个DWORD，其值为FeatureExt数据的偏移
+	if rewritten[login7OptionFlags3]&login7FExtension != 0 {
+		extIb := int(binary.LittleEndian.Uint16(rewritten[login7Extension:]))
+		extSize := int(binary.LittleEndian.Uint16(rewritten[login7Extension+2:]))
+		if extSize >= 4 && extIb+4 <= len(rewritten) {
+			featureExt := int(binary.LittleEndian.Uint32(rewritten[extIb:]))
+			if featureExt > dbIb {
+				binary.LittleEndian.PutUint32(rewritten[extIb:], uint32(featureExt+delta))
+			}
+		}
+	}
+
+	binary.LittleEndian.PutUint32(rewritten[0:], uint32(len(rewritten)))
+	return rewritten, nil
+}
+
+func (m *Login7Message) String() string {
+	if m.IsComplete() {
+		sb := strings.Builder{}
+		sb.WriteString("Login7Message")
+		sb.WriteString(fmt.Sprintf("[#Packets=%d;IsComplete=%v;HasIgnoreBitSet=%v;TotalPayloadSize=%d;TDSVersion=%s",
+			len(m.Packets), m.IsComplete(), m.HasIgnoreBitSet(), len(m.AssemblePayload()), m.GetTDSVersion()))
+
+		for i, packet := range m.Packets {
+			sb.WriteString(fmt.Sprintf("\n\t[P%d[%s]]", i, packet))
+		}
+
+		sb.WriteString("]")
+		return sb.String()
+	}
+	return "Login7Message{Incomplete message}"
+}
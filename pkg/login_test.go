@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testLogin7 测试用的Login7登录记录内容
+type testLogin7 struct {
+	version  TDSVersion
+	host     string
+	user     string
+	password string
+	app      string
+	server   string
+	library  string
+	language string
+	database string
+	features []FeatureExt
+}
+
+// payload 构造登录记录，变长字段按偏移表顺序排列，FeatureExt块位于末尾
+func (l testLogin7) payload() []byte {
+	payload := make([]byte, LOGIN7_FIXED_SIZE)
+	binary.LittleEndian.PutUint32(payload[4:], uint32(l.version))
+	binary.LittleEndian.PutUint32(payload[8:], 4096)
+
+	putField := func(idx int, data []byte, count int) {
+		binary.LittleEndian.PutUint16(payload[idx:], uint16(len(payload)))
+		binary.LittleEndian.PutUint16(payload[idx+2:], uint16(count))
+		payload = append(payload, data...)
+	}
+	putString := func(idx int, value string) {
+		data := encodeUTF16LE(value)
+		putField(idx, data, len(data)/2)
+	}
+
+	putString(login7HostName, l.host)
+	putString(login7UserName, l.user)
+	password := encodeUTF16LE(l.password)
+	for i, b := range password {
+		password[i] = (b<<4 | b>>4) ^ 0xA5
+	}
+	putField(login7Password, password, len(password)/2)
+	putString(login7AppName, l.app)
+	putString(login7ServerName, l.server)
+	extIb := -1
+	if l.features != nil {
+		payload[login7OptionFlags3] |= login7FExtension
+		extIb = len(payload)
+		putField(login7Extension, make([]byte, 4), 4)
+	}
+	putString(login7CltIntName, l.library)
+	putString(login7Language, l.language)
+	putString(login7Database, l.database)
+	for _, idx := range []int{login7SSPI, login7AtchDBFile, login7ChangePassword} {
+		binary.LittleEndian.PutUint16(payload[idx:], uint16(len(payload)))
+	}
+
+	if extIb >= 0 {
+		binary.LittleEndian.PutUint32(payload[extIb:], uint32(len(payload)))
+		for _, feature := range l.features {
+			payload = append(payload, byte(feature.ID))
+			payload = binary.LittleEndian.AppendUint32(payload, uint32(len(feature.Data)))
+			payload = append(payload, feature.Data...)
+		}
+		payload = append(payload, byte(FEATURE_TERMINATOR))
+	}
+	binary.LittleEndian.PutUint32(payload[0:], uint32(len(payload)))
+	return payload
+}
+
+// packet 构造单包Login7消息
+func (l testLogin7) packet() []byte {
+	return buildPacket(TDS7Login, END_OF_MESSAGE, 1, l.payload())
+}
+
+// message 构造已完整的Login7Message
+func (l testLogin7) message() *Login7Message {
+	return NewLogin7MessageWithPacket(NewTDSPacketFromBuffer(l.packet()))
+}
+
+func TestLogin7Fields(t *testing.T) {
+	login := testLogin7{
+		version: TDSVersion74, host: "ws01", user: "sa", password: "secret", app: "app",
+		server: "db01", library: "go-mssqldb", language: "us_english", database: "master",
+	}
+	msg := login.message()
+	msg.SetRevealPassword(true)
+
+	checks := map[string][2]string{
+		"host":     {msg.GetHostName(), "ws01"},
+		"user":     {msg.GetUserName(), "sa"},
+		"password": {msg.GetPassword(), "secret"},
+		"app":      {msg.GetAppName(), "app"},
+		"server":   {msg.GetServerName(), "db01"},
+		"library":  {msg.GetLibraryName(), "go-mssqldb"},
+		"language": {msg.GetLanguage(), "us_english"},
+		"database": {msg.GetDatabase(), "master"},
+	}
+	for name, check := range checks {
+		if check[0] != check[1] {
+			t.Errorf("%s = %q, want %q", name, check[0], check[1])
+		}
+	}
+	if v := msg.GetTDSVersion(); v != TDSVersion74 {
+		t.Errorf("GetTDSVersion() = %s, want 7.4", v)
+	}
+}
+
+func TestLogin7RewriteDatabase(t *testing.T) {
+	features := []FeatureExt{
+		{ID: FEATURE_UTF8_SUPPORT, Data: []byte{}},
+		{ID: FEATURE_COLUMNENCRYPTION, Data: []byte{1}},
+	}
+	login := testLogin7{
+		version: TDSVersion74, host: "ws01", user: "sa", app: "app", server: "db01",
+		library: "go-mssqldb", language: "us_english", database: "OldDB", features: features,
+	}
+	for _, database := range []string{"NewDB", "Reporting_Archive", "X"} {
+		payload, err := login.message().RewriteDatabase(database)
+		if err != nil {
+			t.Fatalf("RewriteDatabase(%q): %v", database, err)
+		}
+		msg := NewLogin7MessageWithPacket(NewTDSPacketFromBuffer(buildPacket(TDS7Login, END_OF_MESSAGE, 1, payload)))
+		if got := msg.GetDatabase(); got != database {
+			t.Errorf("database = %q, want %q", got, database)
+		}
+		// 数据库之前和之后的字段以及FeatureExt都不受影响
+		if msg.GetHostName() != "ws01" || msg.GetLibraryName() != "go-mssqldb" || msg.GetLanguage() != "us_english" {
+			t.Errorf("rewrite to %q corrupted other fields: %s", database, msg)
+		}
+		got, err := msg.Features()
+		if err != nil || len(got) != 2 || got[0].ID != FEATURE_UTF8_SUPPORT || got[1].ID != FEATURE_COLUMNENCRYPTION || !bytes.Equal(got[1].Data, []byte{1}) {
+			t.Errorf("rewrite to %q: features = %v, %v", database, got, err)
+		}
+		if n := binary.LittleEndian.Uint32(payload); int(n) != len(payload) {
+			t.Errorf("login length field %d, record is %d bytes", n, len(payload))
+		}
+	}
+
+	if _, err := login.message().RewriteDatabase(strings.Repeat("d", LOGIN7_MAX_DATABASE_LENGTH+1)); err == nil {
+		t.Error("RewriteDatabase accepted a name over LOGIN7_MAX_DATABASE_LENGTH")
+	}
+
+	// 数据库偏移指向固定部分的畸形登录不能被改写
+	payload := login.payload()
+	binary.LittleEndian.PutUint16(payload[login7Database:], 10)
+	malformed := NewLogin7MessageWithPacket(NewTDSPacketFromBuffer(buildPacket(TDS7Login, END_OF_MESSAGE, 1, payload)))
+	if _, err := malformed.RewriteDatabase("NewDB"); !errors.Is(err, ErrProtocol) {
+		t.Errorf("RewriteDatabase of a database offset inside the fixed part = %v, want ErrProtocol", err)
+	}
+}
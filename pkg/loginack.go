@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// LoginAck 服务器在登录成功时返回的LOGINACK令牌内容
+type LoginAck struct {
+	// Interface 0为SQL_DFLT，1为SQL_TSQL
+	Interface byte
+	// TDSVersion 服务器确认的TDS版本
+	TDSVersion TDSVersion
+	// ProgramName 服务器程序名，如"Microsoft SQL Server"
+	ProgramName string
+	// Version 服务器版本：主版本、次版本、内部版本号高字节、低字节
+	Version [4]byte
+}
+
+// ServerVersion 获取"主版本.次版本.内部版本号"形式的服务器版本，如"16.0.4135"
+func (la *LoginAck) ServerVersion() string {
+	return fmt.Sprintf("%d.%d.%d", la.Version[0], la.Version[1], int(la.Version[2])<<8|int(la.Version[3]))
+}
+
+func (la *LoginAck) String() string {
+	return fmt.Sprintf("LoginAck[Interface=%d;TDSVersion=%s;ProgramName=%s;Version=%s]",
+		la.Interface, la.TDSVersion, la.ProgramName, la.ServerVersion())
+}
+
+// DecodeLoginAck 解码LOGINACK令牌。令牌中的TDSVersion为大端序，与Login7中的小端序不同。
+func DecodeLoginAck(t *Token) (*LoginAck, error) {
+	if t.Type != TokenLoginAck {
+		return nil, fmt.Errorf("%w: %s is not a LOGINACK token", ErrProtocol, t.Type)
+	}
+	r := bytes.NewReader(t.Data)
+	// 令牌体长度
+	if _, err := readUint16(r); err != nil {
+		return nil, err
+	}
+
+	la := &LoginAck{}
+	var err error
+	if la.Interface, err = readByte(r); err != nil {
+		return nil, err
+	}
+	version, err := readBytes(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	la.TDSVersion = TDSVersion(binary.BigEndian.Uint32(version))
+	if la.ProgramName, err = readBVarChar(r); err != nil {
+		return nil, err
+	}
+	progVersion, err := readBytes(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	copy(la.Version[:], progVersion)
+	return la, nil
+}
+
+// SetLoginAckHandler 设置LOGINACK处理函数，登录成功、收到服务器的LOGINACK时触发，
+// 先于登录完成事件。需要解析消息，关闭解析或登录加密时不触发。
+func (ba *BridgeAcceptor) SetLoginAckHandler(handler LoginAckHandler) {
+	ba.loginAckHandler = handler
+}
+
+// LoginAck 获取服务器在登录时返回的LOGINACK，尚未登录时返回nil。
+// 需要解析消息，关闭解析或登录加密时总是返回nil。
+func (bc *BridgedConnection) LoginAck() *LoginAck {
+	return bc.loginAck.Load()
+}
+
+// ServerProgram 获取服务器程序名，未知时返回空字符串
+func (bc *BridgedConnection) ServerProgram() string {
+	if la := bc.LoginAck(); la != nil {
+		return la.ProgramName
+	}
+	return ""
+}
+
+// ServerVersion 获取服务器版本，未知时返回空字符串
+func (bc *BridgedConnection) ServerVersion() string {
+	if la := bc.LoginAck(); la != nil {
+		return la.ServerVersion()
+	}
+	return ""
+}
+
+// acceptLoginAck 记录LOGINACK并以服务器确认的TDS版本作为连接协商的版本
+func (bc *BridgedConnection) acceptLoginAck(token *Token) {
+	la, err := DecodeLoginAck(token)
+	if err != nil {
+		return
+	}
+	bc.loginAck.Store(la)
+	if la.TDSVersion != TDSVersionUnknown {
+		bc.tdsVersion.Store(uint32(la.TDSVersion))
+	}
+	if handler := bc.BridgeAcceptor.loginAckHandler; handler != nil {
+		handler(bc, la)
+	}
+}
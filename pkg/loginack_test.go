@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+// SQL Server 2022 (16.0.4135)登录响应中的LOGINACK令牌
+const loginAckHex = "ad3200" + "01" + "74000004" + "14" +
+	"4d006900630072006f0073006f00660074002000530051004c002000530065007200760065007200" +
+	"10001027"
+
+func TestDecodeLoginAck(t *testing.T) {
+	raw, err := hex.DecodeString(loginAckHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := ParseTokens(raw, TDSVersion74)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("ParseTokens() = %v, %v", tokens, err)
+	}
+	la, err := DecodeLoginAck(tokens[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if la.Interface != 1 || la.TDSVersion != TDSVersion74 || la.ProgramName != "Microsoft SQL Server" {
+		t.Errorf("decoded %s, want SQL_TSQL, TDS 7.4, Microsoft SQL Server", la)
+	}
+	if got := la.ServerVersion(); got != "16.0.4135" {
+		t.Errorf("ServerVersion() = %q, want 16.0.4135", got)
+	}
+}
+
+func TestLoginAckTrackedWithoutHandlers(t *testing.T) {
+	connected := make(chan *BridgedConnection, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBackendConnectedHandler(func(bc *BridgedConnection, _ net.Conn) error {
+			connected <- bc
+			return nil
+		})
+	})
+	login := testLogin7{version: TDSVersion74, user: "app"}.packet()
+	h.connect(login)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(login))
+
+	raw, _ := hex.DecodeString(loginAckHex)
+	backend.feed(buildPacket(TabularResult, END_OF_MESSAGE, 1, append(raw, doneToken(TokenDone, DONE_FINAL, 0, 0)...)))
+
+	bc := <-connected
+	waitFor(t, "LOGINACK recorded", func() bool { return bc.LoginAck() != nil })
+	if bc.ServerProgram() != "Microsoft SQL Server" || bc.ServerVersion() != "16.0.4135" || bc.TDSVersion() != TDSVersion74 {
+		t.Errorf("session reports %q %q %s", bc.ServerProgram(), bc.ServerVersion(), bc.TDSVersion())
+	}
+}
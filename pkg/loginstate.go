@@ -0,0 +1,45 @@
+package pkg
+
+// SetLoginCompleteHandler 设置登录完成处理函数：客户端的Login7得到带LOGINACK的最终响应、会话已建立时触发一次，
+// 参数为解析后的Login7。需要解析消息，关闭解析或登录加密时不触发。
+func (ba *BridgeAcceptor) SetLoginCompleteHandler(handler LoginCompleteHandler) {
+	ba.loginCompleteHandler = handler
+}
+
+// SetLoginFailedHandler 设置登录失败处理函数：Login7的最终响应不含LOGINACK时触发一次，
+// 参数为解析后的Login7和响应中的第一个错误(没有ERROR令牌时为nil)。
+func (ba *BridgeAcceptor) SetLoginFailedHandler(handler LoginFailedHandler) {
+	ba.loginFailedHandler = handler
+}
+
+// checkLoginResult 对登录过程中的最终响应判断登录成功与否，记录LOGINACK并触发相应事件。
+// 集成身份验证的SSPI交换中间的响应不是最终响应，不做判断。
+func (bc *BridgedConnection) checkLoginResult(result *TabularResultMessage) {
+	if !isLoginRequest(HeaderType(bc.lastRequestType.Load())) {
+		return
+	}
+	login := bc.pendingLogin.Swap(nil)
+	if login == nil {
+		return
+	}
+
+	tokens, _ := result.GetTokens()
+	var serverError *ServerError
+	for _, token := range tokens {
+		switch token.Type {
+		case TokenLoginAck:
+			bc.acceptLoginAck(token)
+			if handler := bc.BridgeAcceptor.loginCompleteHandler; handler != nil {
+				handler(bc, login)
+			}
+			return
+		case TokenError:
+			if serverError == nil {
+				serverError, _ = DecodeServerError(token)
+			}
+		}
+	}
+	if handler := bc.BridgeAcceptor.loginFailedHandler; handler != nil {
+		handler(bc, login, serverError)
+	}
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+// loginEvents 记录登录完成和失败事件
+type loginEvents struct {
+	complete chan *Login7Message
+	failed   chan *ServerError
+}
+
+// loginEventHarness 创建记录登录完成和失败事件的装置
+func loginEventHarness(t *testing.T) (*bridgeHarness, *loginEvents) {
+	events := &loginEvents{complete: make(chan *Login7Message, 1), failed: make(chan *ServerError, 1)}
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetLoginCompleteHandler(func(bc *BridgedConnection, login *Login7Message) {
+			events.complete <- login
+		})
+		ba.SetLoginFailedHandler(func(bc *BridgedConnection, login *Login7Message, serverError *ServerError) {
+			events.failed <- serverError
+		})
+	})
+	return h, events
+}
+
+func TestLoginCompleteFiresOnLoginAck(t *testing.T) {
+	h, events := loginEventHarness(t)
+	login := testLogin7{version: TDSVersion74, user: "app", database: "Sales"}.packet()
+	h.connect(login)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(login))
+
+	payload := loginAckToken(TDSVersion74, "Microsoft SQL Server", [4]byte{16, 0, 0x10, 0x27})
+	payload = append(payload, doneToken(TokenDone, DONE_FINAL, 0, 0)...)
+	backend.feed(buildPacket(TabularResult, END_OF_MESSAGE, 1, payload))
+
+	select {
+	case msg := <-events.complete:
+		if user := msg.GetUserName(); user != "app" {
+			t.Errorf("user = %q, want app", user)
+		}
+		if database := msg.GetDatabase(); database != "Sales" {
+			t.Errorf("database = %q, want Sales", database)
+		}
+	case serverError := <-events.failed:
+		t.Fatalf("login failed with %v", serverError)
+	case <-time.After(5 * time.Second):
+		t.Fatal("login complete event not fired")
+	}
+}
+
+func TestLoginFailedFiresOnError(t *testing.T) {
+	h, events := loginEventHarness(t)
+	login := testLogin7{version: TDSVersion74, user: "app"}.packet()
+	h.connect(login)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(login))
+	backend.feed(BuildErrorResponse(18456, 14, "Login failed for user 'app'."))
+
+	select {
+	case serverError := <-events.failed:
+		if serverError == nil || serverError.Number != 18456 {
+			t.Errorf("login failed with %v, want error 18456", serverError)
+		}
+	case <-events.complete:
+		t.Fatal("login complete fired for a failed login")
+	case <-time.After(5 * time.Second):
+		t.Fatal("login failed event not fired")
+	}
+}
@@ -2,8 +2,10 @@ package pkg
 
 import (
 	"fmt"
+	"io"
 	"strings"
 	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // TDSMessage TDS消息接口
@@ -13,6 +15,8 @@ type TDSMessage interface {
 	AssemblePayload() []byte
 	AddPacket(packet *TDSPacket)
 	GetPackets() []*TDSPacket
+	Validate() error
+	WriteTo(w io.Writer) (int64, error)
 	String() string
 }
 
@@ -53,8 +57,13 @@ func (m *BaseTDSMessage) HasIgnoreBitSet() bool {
 	return (lastPacket.Header.StatusBitMask() & IGNORE_EVENT) == IGNORE_EVENT
 }
 
-// AssemblePayload 组装有效载荷
+// AssemblePayload 组装有效载荷。单包消息直接返回该数据包的Payload而不复制，
+// 多包消息返回新分配的拼接结果；调用方不应修改返回的切片。
 func (m *BaseTDSMessage) AssemblePayload() []byte {
+	if len(m.Packets) == 1 {
+		return m.Packets[0].Payload
+	}
+
 	var totalSize int
 	for _, packet := range m.Packets {
 		totalSize += len(packet.Payload)
@@ -76,11 +85,64 @@ func (m *BaseTDSMessage) AddPacket(packet *TDSPacket) {
 	m.Packets = append(m.Packets, packet)
 }
 
+// WriteTo 按线上格式依次写出所有数据包(头部+有效载荷)，可用ReadMessage读回
+func (m *BaseTDSMessage) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for _, packet := range m.Packets {
+		n, err := w.Write(packet.Bytes())
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Frames 按顺序返回每个数据包的线上格式(头部+有效载荷)，可直接写入套接字以重放原始字节。
+// 与AssemblePayload不同，保留了每个数据包的头部；每帧都是新分配的切片。
+func (m *BaseTDSMessage) Frames() [][]byte {
+	frames := make([][]byte, len(m.Packets))
+	for i, packet := range m.Packets {
+		frames[i] = packet.Bytes()
+	}
+	return frames
+}
+
 // GetPackets 获取所有数据包
 func (m *BaseTDSMessage) GetPackets() []*TDSPacket {
 	return m.Packets
 }
 
+// Validate 检查消息的内部一致性：仅最后一个数据包设置END_OF_MESSAGE，
+// 所有数据包的头部类型相同，且头部长度与有效载荷大小一致。不一致时返回ErrProtocol。
+func (m *BaseTDSMessage) Validate() error {
+	if len(m.Packets) == 0 {
+		return fmt.Errorf("%w: message has no packets", ErrProtocol)
+	}
+	first := m.Packets[0].Header.Type()
+	last := len(m.Packets) - 1
+	for i, packet := range m.Packets {
+		if packet.Header.Type() != first {
+			return fmt.Errorf("%w: packet %d has type %s, message type is %s", ErrProtocol, i, packet.Header.Type(), first)
+		}
+		endOfMessage := (packet.Header.StatusBitMask() & END_OF_MESSAGE) == END_OF_MESSAGE
+		if i < last && endOfMessage {
+			return fmt.Errorf("%w: packet %d of %d has END_OF_MESSAGE set", ErrProtocol, i, len(m.Packets))
+		}
+		if i == last && !endOfMessage {
+			return fmt.Errorf("%w: last packet lacks END_OF_MESSAGE", ErrProtocol)
+		}
+		if packet.Header.LengthIncludingHeader() < HEADER_SIZE {
+			return fmt.Errorf("%w: packet %d length %d smaller than header", ErrProtocol, i, packet.Header.LengthIncludingHeader())
+		}
+		if packet.Header.PayloadSize() != len(packet.Payload) {
+			return fmt.Errorf("%w: packet %d header declares %d payload bytes, has %d",
+				ErrProtocol, i, packet.Header.PayloadSize(), len(packet.Payload))
+		}
+	}
+	return nil
+}
+
 // DefaultTDSMessage 默认TDS消息实现
 type DefaultTDSMessage struct {
 	*BaseTDSMessage
@@ -117,9 +179,27 @@ func (m *DefaultTDSMessage) String() string {
 	return "DefaultTDSMessage{Incomplete message}"
 }
 
+// BatchTextEncoding 批处理文本的编码
+type BatchTextEncoding int
+
+const (
+	// BatchTextAutoDetect 根据内容判断是UTF-16LE还是单字节编码
+	BatchTextAutoDetect BatchTextEncoding = iota
+	// BatchTextUTF16LE 按UTF-16LE解码(协议规定的编码)
+	BatchTextUTF16LE
+	// BatchTextSingleByte 按单字节编码解码：合法的UTF-8按UTF-8，否则按Latin-1
+	BatchTextSingleByte
+)
+
 // SQLBatchMessage SQL批处理消息
 type SQLBatchMessage struct {
 	*BaseTDSMessage
+
+	// 协商的TDS版本，决定是否存在ALL_HEADERS块
+	tdsVersion TDSVersion
+
+	// 批处理文本的编码
+	textEncoding BatchTextEncoding
 }
 
 // NewSQLBatchMessage 创建新的SQLBatchMessage
@@ -136,26 +216,124 @@ func NewSQLBatchMessageWithPacket(firstPacket *TDSPacket) *SQLBatchMessage {
 	}
 }
 
-// GetBatchText 获取批处理文本
+// SetTDSVersion 设置连接协商的TDS版本
+func (m *SQLBatchMessage) SetTDSVersion(version TDSVersion) {
+	m.tdsVersion = version
+}
+
+// SetTextEncoding 设置GetBatchText使用的编码，默认自动判断
+func (m *SQLBatchMessage) SetTextEncoding(encoding BatchTextEncoding) {
+	m.textEncoding = encoding
+}
+
+// GetBatchText 获取批处理文本。TDS 7.2之前的批处理没有ALL_HEADERS块，
+// 版本未知时根据块结构是否合理判断。
 func (m *SQLBatchMessage) GetBatchText() string {
 	payload := m.AssemblePayload()
-	allHeader := NewAllHeader(payload)
-	headerLength := int(allHeader.Length())
+	headerLength, err := requestBodyOffset(payload, m.tdsVersion)
+	if err != nil {
+		return ""
+	}
 
 	if len(payload) > headerLength {
-		// SQL Server使用UTF-16编码
-		utf16Bytes := payload[headerLength:]
-		// 转换UTF-16字节为rune数组
-		utf16Runes := make([]uint16, len(utf16Bytes)/2)
-		for i := 0; i < len(utf16Runes); i++ {
-			utf16Runes[i] = uint16(utf16Bytes[i*2]) + uint16(utf16Bytes[i*2+1])<<8
+		body := payload[headerLength:]
+		switch m.textEncoding {
+		case BatchTextUTF16LE:
+			return decodeUTF16LE(body)
+		case BatchTextSingleByte:
+			return decodeSingleByte(body)
+		}
+		// SQL Server使用UTF-16编码，不符合UTF-16特征的按单字节解码
+		if looksLikeUTF16LE(body) {
+			return decodeUTF16LE(body)
 		}
-		// 转换为UTF-8字符串
-		return string(utf16.Decode(utf16Runes))
+		return decodeSingleByte(body)
 	}
 	return ""
 }
 
+// Headers 解析批处理ALL_HEADERS块中的头部(如事务描述符、查询通知)，
+// 批处理没有ALL_HEADERS块(TDS 7.2之前)时返回nil
+func (m *SQLBatchMessage) Headers() ([]StreamHeader, error) {
+	payload := m.AssemblePayload()
+	headerLength, err := requestBodyOffset(payload, m.tdsVersion)
+	if err != nil || headerLength == 0 {
+		return nil, err
+	}
+	return ParseAllHeaders(payload)
+}
+
+// RewriteBatchText 返回将批处理文本替换为text后的有效载荷，ALL_HEADERS块原样保留。
+// 文本按原批处理的编码写回：UTF-16LE(或为空)时按UTF-16LE编码，单字节文本按UTF-8编码。
+func (m *SQLBatchMessage) RewriteBatchText(text string) ([]byte, error) {
+	payload := m.AssemblePayload()
+	headerLength, err := requestBodyOffset(payload, m.tdsVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	body := payload[headerLength:]
+	var value []byte
+	switch {
+	case m.textEncoding == BatchTextUTF16LE,
+		m.textEncoding == BatchTextAutoDetect && (len(body) == 0 || looksLikeUTF16LE(body)):
+		value = encodeUTF16LE(text)
+	default:
+		value = []byte(text)
+	}
+
+	rewritten := make([]byte, 0, headerLength+len(value))
+	rewritten = append(rewritten, payload[:headerLength]...)
+	return append(rewritten, value...), nil
+}
+
+// looksLikeUTF16LE 判断文本是否像UTF-16LE编码：长度为偶数，且ASCII字符的高字节为0。
+// 没有为0的高字节时(如全部为中文)，合法的UTF-8视为单字节文本，
+// 否则可打印ASCII字节不足九成才视为UTF-16LE。
+func looksLikeUTF16LE(b []byte) bool {
+	if len(b)%2 != 0 {
+		return false
+	}
+	for i := 1; i < len(b); i += 2 {
+		if b[i] == 0 {
+			return true
+		}
+	}
+	if utf8.Valid(b) {
+		return false
+	}
+	printable := 0
+	for _, c := range b {
+		if (c >= 0x20 && c <= 0x7E) || c == '\t' || c == '\r' || c == '\n' {
+			printable++
+		}
+	}
+	return printable*10 < len(b)*9
+}
+
+// decodeSingleByte 解码单字节文本，合法的UTF-8按UTF-8，否则按Latin-1
+func decodeSingleByte(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// decodeUTF16LE 将UTF-16LE字节转换为UTF-8字符串
+func decodeUTF16LE(utf16Bytes []byte) string {
+	// 转换UTF-16字节为rune数组
+	utf16Runes := make([]uint16, len(utf16Bytes)/2)
+	for i := 0; i < len(utf16Runes); i++ {
+		utf16Runes[i] = uint16(utf16Bytes[i*2]) + uint16(utf16Bytes[i*2+1])<<8
+	}
+	// 转换为UTF-8字符串
+	return string(utf16.Decode(utf16Runes))
+}
+
 func (m *SQLBatchMessage) String() string {
 	if m.IsComplete() {
 		sb := strings.Builder{}
@@ -176,6 +354,12 @@ func (m *SQLBatchMessage) String() string {
 // RPCRequestMessage RPC请求消息
 type RPCRequestMessage struct {
 	*BaseTDSMessage
+
+	// 协商的TDS版本，决定是否存在ALL_HEADERS块
+	tdsVersion TDSVersion
+
+	// GetParameters最多解析的参数个数，0表示MAX_RPC_PARAMETERS
+	maxParameters int
 }
 
 // NewRPCRequestMessage 创建新的RPCRequestMessage
@@ -254,6 +438,16 @@ func CreateTDSMessageFromFirstPacket(firstPacket *TDSPacket) TDSMessage {
 		return NewAttentionMessageWithPacket(firstPacket)
 	case RPC:
 		return NewRPCRequestMessageWithPacket(firstPacket)
+	case PreLoginMessage:
+		return NewPreLoginRequestMessageWithPacket(firstPacket)
+	case PreTD7Login:
+		return NewPreTD7LoginMessageWithPacket(firstPacket)
+	case TDS7Login:
+		return NewLogin7MessageWithPacket(firstPacket)
+	case BulkLoadData:
+		return NewBulkLoadMessageWithPacket(firstPacket)
+	case TabularResult:
+		return NewTabularResultMessageWithPacket(firstPacket)
 	default:
 		return NewDefaultTDSMessageWithPacket(firstPacket)
 	}
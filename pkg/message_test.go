@@ -0,0 +1,337 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// batchMessage 构造单包SQLBatch消息
+func batchMessage(payload []byte) *SQLBatchMessage {
+	return NewSQLBatchMessageWithPacket(NewTDSPacketFromBuffer(buildPacket(SQLBatch, END_OF_MESSAGE, 1, payload)))
+}
+
+func TestBatchTextByVersion(t *testing.T) {
+	withHeaders := sqlBatchPayload("SELECT name FROM sys.databases")
+	withoutHeaders := encodeUTF16LE("SELECT name FROM sys.databases")
+
+	for _, tc := range []struct {
+		name    string
+		version TDSVersion
+		payload []byte
+	}{
+		{"7.4 with ALL_HEADERS", TDSVersion74, withHeaders},
+		{"7.1 without ALL_HEADERS", TDSVersion71, withoutHeaders},
+		{"7.0 without ALL_HEADERS", TDSVersion70, withoutHeaders},
+		{"unknown version with ALL_HEADERS", TDSVersionUnknown, withHeaders},
+		{"unknown version without ALL_HEADERS", TDSVersionUnknown, withoutHeaders},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := batchMessage(tc.payload)
+			msg.SetTDSVersion(tc.version)
+			if text := msg.GetBatchText(); text != "SELECT name FROM sys.databases" {
+				t.Fatalf("GetBatchText() = %q", text)
+			}
+		})
+	}
+}
+
+func TestBatchTextPre72ConnectionThroughBridge(t *testing.T) {
+	batches := make(chan string, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			if batch, ok := msg.(*SQLBatchMessage); ok {
+				batches <- batch.GetBatchText()
+			}
+		})
+	})
+
+	// 7.1的连接上批处理没有ALL_HEADERS，文本开头恰好像一个合理的长度字段
+	text := "\x16\x00\x00\x00 SELECT 1"
+	h.connect(
+		testLogin7{version: TDSVersion71, user: "sa"}.packet(),
+		buildPacket(SQLBatch, END_OF_MESSAGE, 1, encodeUTF16LE(text)),
+	)
+	select {
+	case got := <-batches:
+		if got != text {
+			t.Fatalf("GetBatchText() = %q, want %q", got, text)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("batch not received")
+	}
+}
+
+func TestMessageValidate(t *testing.T) {
+	payload := sqlBatchPayload("SELECT 1")
+	packet := func(headerType HeaderType, status byte, id byte, payload []byte) *TDSPacket {
+		return NewTDSPacketFromBuffer(buildPacket(headerType, status, id, payload))
+	}
+	message := func(packets ...*TDSPacket) TDSMessage {
+		msg := NewSQLBatchMessageWithPacket(packets[0])
+		for _, p := range packets[1:] {
+			msg.AddPacket(p)
+		}
+		return msg
+	}
+	truncated := packet(SQLBatch, END_OF_MESSAGE, 2, payload[10:])
+	truncated.Payload = truncated.Payload[:4]
+
+	tests := []struct {
+		name string
+		msg  TDSMessage
+		want string
+	}{
+		{"valid multi-packet", message(
+			packet(SQLBatch, NORMAL, 1, payload[:10]),
+			packet(SQLBatch, END_OF_MESSAGE, 2, payload[10:]),
+		), ""},
+		{"early end of message", message(
+			packet(SQLBatch, END_OF_MESSAGE, 1, payload[:10]),
+			packet(SQLBatch, END_OF_MESSAGE, 2, payload[10:]),
+		), "packet 0 of 2 has END_OF_MESSAGE"},
+		{"missing end of message", message(
+			packet(SQLBatch, NORMAL, 1, payload[:10]),
+			packet(SQLBatch, NORMAL, 2, payload[10:]),
+		), "last packet lacks END_OF_MESSAGE"},
+		{"type change", message(
+			packet(SQLBatch, NORMAL, 1, payload[:10]),
+			packet(RPC, END_OF_MESSAGE, 2, payload[10:]),
+		), "packet 1 has type"},
+		{"payload size mismatch", message(
+			packet(SQLBatch, NORMAL, 1, payload[:10]),
+			truncated,
+		), "has 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.msg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrProtocol) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want ErrProtocol containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchTextEncodingDetection(t *testing.T) {
+	headers := sqlBatchPayload("")
+	singleByte := func(text string) []byte {
+		return append(append([]byte{}, headers...), text...)
+	}
+	latin1 := append(append([]byte{}, headers...), []byte("SELECT 'caf\xe9'")...)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    string
+	}{
+		{"UTF-16 ASCII", sqlBatchPayload("SELECT 1"), "SELECT 1"},
+		{"UTF-16 mixed", sqlBatchPayload("SELECT N'中文'"), "SELECT N'中文'"},
+		{"UTF-16 without ASCII", sqlBatchPayload("中文表"), "中文表"},
+		{"single-byte even length", singleByte("SELECT 1"), "SELECT 1"},
+		{"single-byte odd length", singleByte("SELECT 10"), "SELECT 10"},
+		{"single-byte UTF-8", singleByte("SELECT '中'"), "SELECT '中'"},
+		{"single-byte Latin-1", latin1, "SELECT 'café'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := batchMessage(tt.payload)
+			msg.SetTDSVersion(TDSVersion74)
+			if got := msg.GetBatchText(); got != tt.want {
+				t.Errorf("GetBatchText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchTextEncodingOverride(t *testing.T) {
+	msg := batchMessage(sqlBatchPayload("SELECT 1"))
+	msg.SetTDSVersion(TDSVersion74)
+	msg.SetTextEncoding(BatchTextSingleByte)
+	if got := msg.GetBatchText(); got != "S\x00E\x00L\x00E\x00C\x00T\x00 \x001\x00" {
+		t.Errorf("forced single-byte decode = %q", got)
+	}
+
+	msg = batchMessage(append(sqlBatchPayload(""), "AB"...))
+	msg.SetTDSVersion(TDSVersion74)
+	msg.SetTextEncoding(BatchTextUTF16LE)
+	if got := msg.GetBatchText(); got != "䉁" {
+		t.Errorf("forced UTF-16LE decode = %q, want %q", got, "䉁")
+	}
+}
+
+func TestBridgeBatchTextEncoding(t *testing.T) {
+	texts := make(chan string, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBatchTextEncoding(BatchTextUTF16LE)
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			texts <- msg.(*SQLBatchMessage).GetBatchText()
+		})
+	})
+	h.connect(batchMessage(append(sqlBatchPayload(""), "AB"...)).GetPackets()[0].Bytes())
+	select {
+	case text := <-texts:
+		if text != "䉁" {
+			t.Errorf("handler saw %q, want the configured UTF-16LE decoding", text)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message event")
+	}
+}
+
+func TestAssemblePayload(t *testing.T) {
+	payload := sqlBatchPayload("SELECT 1")
+	single := batchMessage(payload)
+	if got := single.AssemblePayload(); &got[0] != &single.Packets[0].Payload[0] {
+		t.Error("single-packet AssemblePayload copied the payload")
+	}
+
+	multi := NewSQLBatchMessageWithPacket(NewTDSPacketFromBuffer(buildPacket(SQLBatch, NORMAL, 1, payload[:10])))
+	multi.AddPacket(NewTDSPacketFromBuffer(buildPacket(SQLBatch, END_OF_MESSAGE, 2, payload[10:])))
+	got := multi.AssemblePayload()
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("multi-packet AssemblePayload = %x, want %x", got, payload)
+	}
+	// 多包消息返回新的切片，修改它不影响数据包
+	got[0] ^= 0xFF
+	if multi.Packets[0].Payload[0] != payload[0] {
+		t.Error("multi-packet AssemblePayload shares memory with the packets")
+	}
+	if again := multi.AssemblePayload(); !bytes.Equal(again, payload) {
+		t.Errorf("second AssemblePayload = %x, want %x", again, payload)
+	}
+
+	if got := NewBaseTDSMessage().AssemblePayload(); len(got) != 0 {
+		t.Errorf("empty message payload = %x", got)
+	}
+}
+
+func BenchmarkAssemblePayload(b *testing.B) {
+	payload := sqlBatchPayload("SELECT name FROM sys.databases")
+	b.Run("single", func(b *testing.B) {
+		msg := batchMessage(payload)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = msg.AssemblePayload()
+		}
+		if allocs := testing.AllocsPerRun(100, func() { _ = msg.AssemblePayload() }); allocs != 0 {
+			b.Fatalf("single-packet AssemblePayload allocates %v times", allocs)
+		}
+	})
+	b.Run("multi", func(b *testing.B) {
+		msg := NewSQLBatchMessageWithPacket(NewTDSPacketFromBuffer(buildPacket(SQLBatch, NORMAL, 1, payload[:20])))
+		msg.AddPacket(NewTDSPacketFromBuffer(buildPacket(SQLBatch, END_OF_MESSAGE, 2, payload[20:])))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = msg.AssemblePayload()
+		}
+	})
+}
+
+func TestRewriteBatchTextKeepsEncoding(t *testing.T) {
+	utf16 := batchMessage(sqlBatchPayload("SELECT 1"))
+	utf16.SetTDSVersion(TDSVersion74)
+	got, err := utf16.RewriteBatchText("SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sqlBatchPayload("SELECT 2"); !bytes.Equal(got, want) {
+		t.Errorf("UTF-16LE rewrite = %x, want %x", got, want)
+	}
+
+	singleByte := batchMessage(append(sqlBatchPayload(""), "SELECT 1"...))
+	singleByte.SetTDSVersion(TDSVersion74)
+	got, err = singleByte.RewriteBatchText("SELECT 'é'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(sqlBatchPayload(""), "SELECT 'é'"...); !bytes.Equal(got, want) {
+		t.Errorf("single-byte rewrite = %x, want %x", got, want)
+	}
+
+	broken := batchMessage([]byte{0xFF, 0xFF, 0xFF, 0x7F})
+	broken.SetTDSVersion(TDSVersion74)
+	if _, err := broken.RewriteBatchText("SELECT 1"); err == nil {
+		t.Error("rewrite of a batch with a malformed ALL_HEADERS block succeeded")
+	}
+}
+
+func TestFramesRoundTrip(t *testing.T) {
+	payload := rpcPayload("sp_executesql", 0, nvarcharParam("@stmt", "SELECT 1"))
+	msg := NewRPCRequestMessageWithPacket(NewTDSPacketFromBuffer(buildPacket(RPC, NORMAL, 1, payload[:20])))
+	msg.AddPacket(NewTDSPacketFromBuffer(buildPacket(RPC, NORMAL, 2, payload[20:40])))
+	msg.AddPacket(NewTDSPacketFromBuffer(buildPacket(RPC, END_OF_MESSAGE|RESET_CONNECTION, 3, payload[40:])))
+
+	frames := msg.Frames()
+	if len(frames) != 3 {
+		t.Fatalf("Frames() returned %d frames, want 3", len(frames))
+	}
+	var stream []byte
+	for _, frame := range frames {
+		stream = append(stream, frame...)
+	}
+
+	reader := NewTDSReader(bytes.NewReader(stream))
+	for i, want := range msg.GetPackets() {
+		got, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if !bytes.Equal(got.Header.Buffer, want.Header.Buffer) || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("packet %d = %s %x, want %s %x", i, got.Header, got.Payload, want.Header, want.Payload)
+		}
+	}
+	if _, err := reader.ReadPacket(); err != io.EOF {
+		t.Errorf("read past the frames: %v, want io.EOF", err)
+	}
+
+	// 每帧都是新分配的切片
+	frames[0][HEADER_SIZE] ^= 0xFF
+	if msg.GetPackets()[0].Payload[0] != payload[0] {
+		t.Error("modifying a frame changed the message")
+	}
+}
+
+func TestSQLBatchHeaders(t *testing.T) {
+	// 事务描述符头部：长度18，类型2，描述符和未完成的请求数
+	payload := binary.LittleEndian.AppendUint32(nil, 22)
+	payload = binary.LittleEndian.AppendUint32(payload, 18)
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(StreamHeaderTransactionDescriptor))
+	payload = binary.LittleEndian.AppendUint64(payload, 0x0000000100000A2B)
+	payload = binary.LittleEndian.AppendUint32(payload, 1)
+	payload = append(payload, encodeUTF16LE("UPDATE t SET x = 1")...)
+
+	msg := batchMessage(payload)
+	msg.SetTDSVersion(TDSVersion74)
+	headers, err := msg.Headers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 1 || headers[0].Type != StreamHeaderTransactionDescriptor {
+		t.Fatalf("Headers() = %+v, want one transaction descriptor", headers)
+	}
+	descriptor, outstanding, ok := headers[0].TransactionDescriptor()
+	if !ok || descriptor != 0x0000000100000A2B || outstanding != 1 {
+		t.Errorf("TransactionDescriptor() = %#x, %d, %v", descriptor, outstanding, ok)
+	}
+	if got := msg.GetBatchText(); got != "UPDATE t SET x = 1" {
+		t.Errorf("GetBatchText() = %q", got)
+	}
+
+	// TDS 7.2之前没有ALL_HEADERS块
+	old := batchMessage(encodeUTF16LE("SELECT 1"))
+	old.SetTDSVersion(TDSVersion71)
+	if headers, err := old.Headers(); headers != nil || err != nil {
+		t.Errorf("Headers() without ALL_HEADERS = %+v, %v", headers, err)
+	}
+}
@@ -8,33 +8,45 @@ type TDSPacket struct {
 	Payload []byte
 }
 
-// NewTDSPacketFromBuffer 从完整缓冲区创建新的TDSPacket
+// NewTDSPacketFromBuffer 从完整缓冲区创建新的TDSPacket，有效载荷取头部声明的长度；
+// 缓冲区不足时只包含实际的字节(见PayloadLen)
 func NewTDSPacketFromBuffer(buffer []byte) *TDSPacket {
 	header := NewTDSHeader(buffer)
-	payloadSize := header.PayloadSize()
-	payload := make([]byte, payloadSize)
-	if len(buffer) >= HEADER_SIZE+payloadSize {
-		copy(payload, buffer[HEADER_SIZE:HEADER_SIZE+payloadSize])
-	}
-	return &TDSPacket{
-		Header:  header,
-		Payload: payload,
+	var payload []byte
+	if len(buffer) > HEADER_SIZE {
+		payload = buffer[HEADER_SIZE:]
 	}
+	return NewTDSPacket(header.Buffer, payload, header.PayloadSize())
 }
 
-// NewTDSPacket 从头部和负载创建新的TDSPacket
+// NewTDSPacket 从头部和负载创建新的TDSPacket，复制负载的前payloadSize字节；
+// 负载不足payloadSize时只包含实际的字节，不以零填充
 func NewTDSPacket(header []byte, payload []byte, payloadSize int) *TDSPacket {
 	tHeader := NewTDSHeader(header)
-	tPayload := make([]byte, payloadSize)
-	if len(payload) >= payloadSize {
-		copy(tPayload, payload[:payloadSize])
+	if len(payload) < payloadSize {
+		payloadSize = len(payload)
 	}
+	tPayload := make([]byte, payloadSize)
+	copy(tPayload, payload[:payloadSize])
 	return &TDSPacket{
 		Header:  tHeader,
 		Payload: tPayload,
 	}
 }
 
+// PayloadLen 获取有效载荷的实际字节数。构造函数不以零填充不足的有效载荷，
+// 因此它可能小于头部声明的PayloadSize；转发和Bytes都以实际的有效载荷为准，头部原样保留。
+func (p *TDSPacket) PayloadLen() int {
+	return len(p.Payload)
+}
+
+// Bytes 将数据包序列化为线上格式(头部+有效载荷)
+func (p *TDSPacket) Bytes() []byte {
+	data := make([]byte, 0, len(p.Header.Buffer)+len(p.Payload))
+	data = append(data, p.Header.Buffer...)
+	return append(data, p.Payload...)
+}
+
 func (p *TDSPacket) String() string {
 	return fmt.Sprintf("TDSPacket[Header=%s]", p.Header)
 }
\ No newline at end of file
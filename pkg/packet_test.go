@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"testing"
+)
+
+// exerciseMessage 调用消息类型的所有解析方法，只检查不发生panic
+func exerciseMessage(msg TDSMessage) {
+	_ = msg.String()
+	_ = msg.AssemblePayload()
+	_ = msg.Validate()
+	_ = msg.HasIgnoreBitSet()
+	for _, packet := range msg.GetPackets() {
+		h := packet.Header
+		_, _, _, _ = h.Type(), h.StatusBitMask(), h.SPID(), h.PayloadSize()
+		_ = h.String()
+	}
+
+	switch m := msg.(type) {
+	case *SQLBatchMessage:
+		for _, version := range []TDSVersion{TDSVersion71, TDSVersion74} {
+			m.SetTDSVersion(version)
+			_ = m.GetBatchText()
+			_, _ = m.Headers()
+			_, _ = m.RewriteBatchText("SELECT 1")
+		}
+	case *RPCRequestMessage:
+		_, _ = m.GetProcName()
+		_, _ = m.GetParameters()
+		_, _ = m.EffectiveSQL()
+	case *PreLoginRequestMessage:
+		_, _ = m.GetOptions()
+		_, _ = m.GetVersion()
+		_, _ = m.GetEncryption()
+	case *PreTD7LoginMessage:
+		_, _, _ = m.GetHostName(), m.GetUserName(), m.GetAppName()
+		_, _ = m.GetProtocolVersion()
+	case *Login7Message:
+		m.SetRevealPassword(true)
+		_, _, _ = m.GetHostName(), m.GetUserName(), m.GetPassword()
+		_, _, _ = m.GetAppName(), m.GetServerName(), m.GetLibraryName()
+		_, _ = m.GetLanguage(), m.GetDatabase()
+		_, _ = m.GetTDSVersion(), m.GetPacketSize()
+		_, _ = m.Features()
+		_, _ = m.RewriteDatabase("NewDB")
+	case *TabularResultMessage:
+		_, _ = m.GetTokens()
+		_ = m.DoneTokens()
+		_ = m.IsFinalResponse()
+		_, _ = m.GetServerErrors()
+		_ = m.HasServerError()
+		_, _ = m.Features()
+	}
+}
+
+func FuzzParsePacket(f *testing.F) {
+	for _, seed := range [][]byte{
+		nil,
+		{0x01},
+		{0x01, 0x01, 0x00, 0x08, 0x00, 0x00, 0x01, 0x00},
+		{0x01, 0x01, 0x00, 0x04, 0x00, 0x00, 0x01, 0x00},
+		{0x01, 0x01, 0xFF, 0xFF, 0x00, 0x00, 0x01, 0x00, 0x16, 0x00},
+		sqlBatchPacket("SELECT 1"),
+		buildPacket(SQLBatch, END_OF_MESSAGE, 1, []byte{0xFF, 0xFF, 0xFF, 0x7F, 0x12, 0x00}),
+		rpcPacket("sp_executesql", 0, nvarcharParam("@stmt", "SELECT @p"), intParam("@p", 1), nullIntParam("@q")),
+		rpcPacket("", 10),
+		preLoginPacket(ENCRYPT_ON),
+		testLogin7{version: TDSVersion74, user: "sa", database: "master", features: []FeatureExt{{ID: FEATURE_UTF8_SUPPORT}}}.packet(),
+		buildPacket(PreTD7Login, END_OF_MESSAGE, 1, preTDS7LoginPayload()),
+		doneResponse(DONE_FINAL, 3),
+		BuildErrorResponse(50000, 16, "error"),
+		buildPacket(TabularResult, END_OF_MESSAGE, 1, []byte{byte(TokenError), 0xFF, 0xFF}),
+		buildPacket(BulkLoadData, END_OF_MESSAGE, 1, []byte{0x81, 0x01, 0x00}),
+		buildPacket(AttentionSignal, END_OF_MESSAGE, 1, nil),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet := NewTDSPacketFromBuffer(data)
+		msg := CreateTDSMessageFromFirstPacket(packet)
+		exerciseMessage(msg)
+		// 同一数据作为后续数据包追加
+		if !msg.IsComplete() {
+			msg.AddPacket(NewTDSPacketFromBuffer(data))
+			exerciseMessage(msg)
+		}
+	})
+}
+
+func TestShortHeaderAccessors(t *testing.T) {
+	h := &TDSHeader{Buffer: []byte{byte(SQLBatch)}}
+	if h.Type() != SQLBatch || h.StatusBitMask() != 0 || h.LengthIncludingHeader() != 0 || h.SPID() != 0 || h.PayloadSize() != 0 {
+		t.Errorf("short header decoded as %s", h)
+	}
+	if h := NewTDSHeader([]byte{1, 1, 0, 4, 0, 0, 1, 0}); h.PayloadSize() != 0 {
+		t.Errorf("PayloadSize() = %d for a length below the header, want 0", h.PayloadSize())
+	}
+}
+
+func TestPacketPayloadLenDisagreesWithHeader(t *testing.T) {
+	header := BuildTDSHeader(SQLBatch, END_OF_MESSAGE, HEADER_SIZE+10, 1)
+
+	short := NewTDSPacket(header, []byte{1, 2, 3}, 10)
+	if short.PayloadLen() != 3 || short.Header.PayloadSize() != 10 {
+		t.Errorf("short payload: PayloadLen() = %d, header PayloadSize() = %d, want 3 and 10", short.PayloadLen(), short.Header.PayloadSize())
+	}
+	if got := short.Bytes(); len(got) != HEADER_SIZE+3 || NewTDSHeader(got).LengthIncludingHeader() != HEADER_SIZE+10 {
+		t.Errorf("Bytes() = %x, want the header unchanged and no zero padding", got)
+	}
+
+	long := NewTDSPacket(header, make([]byte, 20), 10)
+	if long.PayloadLen() != 10 {
+		t.Errorf("long payload: PayloadLen() = %d, want it cut to the declared 10", long.PayloadLen())
+	}
+
+	truncated := NewTDSPacketFromBuffer(append(header, 1, 2))
+	if truncated.PayloadLen() != 2 || truncated.Header.PayloadSize() != 10 {
+		t.Errorf("truncated buffer: PayloadLen() = %d, header PayloadSize() = %d, want 2 and 10", truncated.PayloadLen(), truncated.Header.PayloadSize())
+	}
+}
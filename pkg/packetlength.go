@@ -0,0 +1,50 @@
+package pkg
+
+// SetPacketLengthAssertions 设置是否断言每个转发的TDS数据包(双向)头部声明的长度与实际写出的字节数一致，
+// 不一致时触发数据包长度不符事件，参数为声明的长度所在的头部和实际转发的有效载荷字节数。
+// 比较在头部改写、消息拦截或改写、混沌测试之后，写入之前(合并写入时为加入缓存之前)进行，
+// 用于发现这些环节中破坏分包的缺陷。只做观察，不影响转发。
+func (ba *BridgeAcceptor) SetPacketLengthAssertions(enabled bool) {
+	ba.assertPacketLengths = enabled
+}
+
+// SetPacketLengthMismatchHandler 设置数据包长度不符处理函数
+func (ba *BridgeAcceptor) SetPacketLengthMismatchHandler(handler PacketLengthMismatchHandler) {
+	ba.packetLengthMismatchHandler = handler
+}
+
+// checkPacketLength 在启用断言时比较头部声明的有效载荷长度与实际长度，不一致时触发事件
+func (bc *BridgedConnection) checkPacketLength(source ConnectionType, header *TDSHeader, actual int) {
+	if !bc.BridgeAcceptor.assertPacketLengths || header.PayloadSize() == actual {
+		return
+	}
+	bc.onPacketLengthMismatch(source, header, actual)
+}
+
+// checkForwardedPackets 在启用断言时按头部声明的长度逐个检查将要写出的一段连续数据包，
+// 最后一个数据包的实际长度为剩余的字节数，发现不符后不再继续(之后的分包已无法确定)。
+// 末尾不足一个头部的残余字节以全零的头部报告，实际长度为残余的字节数。
+func (bc *BridgedConnection) checkForwardedPackets(source ConnectionType, data []byte) {
+	if !bc.BridgeAcceptor.assertPacketLengths {
+		return
+	}
+	for len(data) >= HEADER_SIZE {
+		header := NewTDSHeader(data)
+		length := header.LengthIncludingHeader()
+		if length < HEADER_SIZE || length > len(data) {
+			bc.onPacketLengthMismatch(source, header, len(data)-HEADER_SIZE)
+			return
+		}
+		data = data[length:]
+	}
+	if len(data) > 0 {
+		bc.onPacketLengthMismatch(source, NewTDSHeader(nil), len(data))
+	}
+}
+
+// onPacketLengthMismatch 触发数据包长度不符事件
+func (bc *BridgedConnection) onPacketLengthMismatch(source ConnectionType, header *TDSHeader, actual int) {
+	if handler := bc.BridgeAcceptor.packetLengthMismatchHandler; handler != nil {
+		handler(bc, source, header, actual)
+	}
+}
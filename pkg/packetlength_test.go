@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lengthMismatch 一次数据包长度不符事件
+type lengthMismatch struct {
+	source ConnectionType
+	// 头部声明的数据包长度(含头部)
+	declared int
+	// 实际的有效载荷字节数
+	actual int
+}
+
+// packetLengthHarness 创建启用长度断言的装置，记录不符事件和数据包接收事件中的数据包
+func packetLengthHarness(t *testing.T, configure func(ba *BridgeAcceptor)) (h *bridgeHarness, mismatches func() []lengthMismatch, packets func() []*TDSPacket) {
+	var mu sync.Mutex
+	var gotMismatches []lengthMismatch
+	var gotPackets []*TDSPacket
+	h = newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetPacketLengthAssertions(true)
+		ba.SetPacketLengthMismatchHandler(func(bc *BridgedConnection, source ConnectionType, header *TDSHeader, actual int) {
+			mu.Lock()
+			defer mu.Unlock()
+			gotMismatches = append(gotMismatches, lengthMismatch{source, header.LengthIncludingHeader(), actual})
+		})
+		ba.SetTDSPacketReceivedHandler(func(bc *BridgedConnection, packet *TDSPacket) {
+			mu.Lock()
+			defer mu.Unlock()
+			gotPackets = append(gotPackets, packet)
+		})
+		if configure != nil {
+			configure(ba)
+		}
+	})
+	mismatches = func() []lengthMismatch {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]lengthMismatch(nil), gotMismatches...)
+	}
+	packets = func() []*TDSPacket {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*TDSPacket(nil), gotPackets...)
+	}
+	return h, mismatches, packets
+}
+
+func TestPacketEventsMatchForwardedBytesAroundTLSRecord(t *testing.T) {
+	h, mismatches, packets := packetLengthHarness(t, nil)
+	batch := sqlBatchPacket("SELECT 1")
+	// 类型字节23：加密后直接传输的TLS应用数据记录，不是TDS数据包
+	record := []byte{23, 3, 3, 0, 5, 1, 2, 3, 4, 5}
+	client := h.connect(batch, record, batch)
+	backend := h.backend(0)
+	written := waitWritten(t, backend, 2*len(batch)+len(record))
+	if want := bytes.Join([][]byte{batch, record, batch}, nil); !bytes.Equal(written, want) {
+		t.Fatalf("backend received %x, want %x", written, want)
+	}
+
+	response := doneResponse(DONE_FINAL, 0)
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+
+	// 每个数据包事件都与转发的数据包一致，TLS记录不作为数据包报告
+	got := packets()
+	if len(got) != 2 {
+		t.Fatalf("handler saw %d packets, want 2", len(got))
+	}
+	for i, packet := range got {
+		if n := HEADER_SIZE + len(packet.Payload); n != len(batch) || packet.Header.LengthIncludingHeader() != n {
+			t.Errorf("packet %d: header declares %d bytes, payload makes %d, forwarded %d",
+				i, packet.Header.LengthIncludingHeader(), n, len(batch))
+		}
+		if !bytes.Equal(packet.Bytes(), batch) {
+			t.Errorf("packet %d = %x, want %x", i, packet.Bytes(), batch)
+		}
+	}
+	if m := mismatches(); len(m) != 0 {
+		t.Errorf("unexpected length mismatches %+v", m)
+	}
+}
+
+func TestPacketLengthAssertionsAfterRewriteAndCoalescing(t *testing.T) {
+	h, mismatches, _ := packetLengthHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetCoalesceMessages(true)
+		ba.SetForwardRedactor(func(text string) string {
+			return strings.ReplaceAll(text, "secret", "******")
+		})
+	})
+	// 改写后重新分包的消息和合并写入的多包消息
+	long := "SELECT 'secret' " + strings.Repeat("-", 6000)
+	payload := sqlBatchPayload("SELECT 2")
+	multi := append(buildPacket(SQLBatch, NORMAL, 1, payload[:10]),
+		buildPacket(SQLBatch, END_OF_MESSAGE, 2, payload[10:])...)
+	client := h.connect(splitMessage(SQLBatch, sqlBatchPayload(long), 4096), multi)
+	backend := h.backend(0)
+	waitFor(t, "both messages forwarded", func() bool {
+		return bytes.HasSuffix(backend.Written(), multi)
+	})
+
+	response := doneResponse(DONE_FINAL, 0)
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+	if m := mismatches(); len(m) != 0 {
+		t.Errorf("unexpected length mismatches %+v", m)
+	}
+}
+
+func TestCheckForwardedPackets(t *testing.T) {
+	batch := sqlBatchPacket("SELECT 1")
+	tests := []struct {
+		name string
+		data []byte
+		want []lengthMismatch
+	}{
+		{"consistent", append(append([]byte{}, batch...), batch...), nil},
+		{"truncated last packet", append(append([]byte{}, batch...), batch[:len(batch)-2]...),
+			[]lengthMismatch{{BridgeSQL, len(batch), len(batch) - HEADER_SIZE - 2}}},
+		{"declared length below header", []byte{0x04, 0x01, 0x00, 0x04, 0, 0, 1, 0},
+			[]lengthMismatch{{BridgeSQL, 4, 0}}},
+		{"bytes after last packet", append(append([]byte{}, batch...), 1, 2, 3),
+			[]lengthMismatch{{BridgeSQL, 0, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ba := NewBridgeAcceptor("", "backend:1433")
+			ba.SetPacketLengthAssertions(true)
+			var got []lengthMismatch
+			ba.SetPacketLengthMismatchHandler(func(bc *BridgedConnection, source ConnectionType, header *TDSHeader, actual int) {
+				got = append(got, lengthMismatch{source, header.LengthIncludingHeader(), actual})
+			})
+			bc := NewBridgedConnection(ba, &SocketCouple{})
+			bc.checkForwardedPackets(BridgeSQL, tt.data)
+			if len(got) != len(tt.want) {
+				t.Fatalf("mismatches = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("mismatch %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
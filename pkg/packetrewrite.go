@@ -0,0 +1,23 @@
+package pkg
+
+// SetPacketRewriter 设置客户端数据包头部的改写函数，在收到每个客户端TDS数据包后、任何处理之前调用，
+// 可改写状态位(如以header.SetStatus(header.Status().With(RESET_CONNECTION))要求服务器重置连接)、
+// SPID、PacketID和Window；长度由桥接器维护，对长度字段的修改被忽略。TLS记录不经过改写函数。
+// 之后的处理(捕获、跟踪、消息组装、拦截和转发)都基于改写后的头部，消息是否结束按改写后的END_OF_MESSAGE判断。
+//
+// 改写END_OF_MESSAGE有风险：清除消息最后一个数据包的该位会使桥接器和服务器继续等待不会到来的数据包，
+// 请求因此挂起；在消息中途设置该位会把一个消息拆成两个，后一部分对服务器而言是格式错误的请求。
+// 桥接器与服务器看到的消息边界保持一致，但与客户端发送的不再一致，响应的对应关系可能错乱。
+// 需在Start之前调用。
+func (ba *BridgeAcceptor) SetPacketRewriter(rewriter PacketRewriter) {
+	ba.packetRewriter = rewriter
+}
+
+// rewritePacketHeader 执行数据包改写函数，并将改写后的头部写回将转发的原始字节，保留原长度
+func (bc *BridgedConnection) rewritePacketHeader(header *TDSHeader, raw []byte) {
+	length := [2]byte{header.GetByte(2), header.GetByte(3)}
+	bc.BridgeAcceptor.packetRewriter(bc, header)
+	header.SetByte(2, length[0])
+	header.SetByte(3, length[1])
+	copy(raw, header.Buffer)
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPacketRewriterSetsResetConnection(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetPacketRewriter(func(bc *BridgedConnection, header *TDSHeader) {
+			header.SetStatus(header.Status().With(RESET_CONNECTION))
+			// 长度由桥接器维护
+			header.SetByte(2, 0xFF)
+		})
+	})
+	batch := sqlBatchPacket("SELECT 1")
+	h.connect(batch)
+	got := waitWritten(t, h.backend(0), len(batch))
+
+	header := NewTDSHeader(got)
+	if !header.Status().IsResetConnection() || !header.Status().IsEndOfMessage() {
+		t.Errorf("backend received status %s, want EOM|RESET_CONNECTION", header.Status())
+	}
+	if header.LengthIncludingHeader() != len(batch) {
+		t.Errorf("backend received length %d, want the original %d", header.LengthIncludingHeader(), len(batch))
+	}
+	if string(got[HEADER_SIZE:]) != string(batch[HEADER_SIZE:]) {
+		t.Error("payload changed by the header rewrite")
+	}
+}
+
+func TestPacketRewriterEndOfMessageDrivesAssembly(t *testing.T) {
+	var mu sync.Mutex
+	var messages []TDSMessage
+	packets := 0
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		// 清除第一个数据包的END_OF_MESSAGE，两个批处理被组装为一个消息
+		ba.SetPacketRewriter(func(bc *BridgedConnection, header *TDSHeader) {
+			packets++
+			if packets == 1 {
+				header.SetStatus(header.Status().Without(END_OF_MESSAGE))
+			}
+		})
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			messages = append(messages, msg)
+		})
+	})
+	first, second := sqlBatchPacket("SELECT 1"), sqlBatchPacket("SELECT 2")
+	h.connect(first, second)
+	got := waitWritten(t, h.backend(0), len(first)+len(second))
+
+	if NewTDSHeader(got).Status().IsEndOfMessage() {
+		t.Error("backend received END_OF_MESSAGE on the rewritten first packet")
+	}
+	waitFor(t, "message received", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(messages) > 0
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(messages) != 1 || len(messages[0].GetPackets()) != 2 {
+		t.Errorf("received %d messages, want one message of both packets", len(messages))
+	}
+}
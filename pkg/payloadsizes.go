@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// PayloadSizeBucketBounds 数据包有效载荷大小分布各桶的上界(含)，超过最后一个上界的计入最后一个桶
+var PayloadSizeBucketBounds = [...]int{64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768}
+
+// PayloadSizeStat 一个方向上一种数据包类型的有效载荷大小统计
+type PayloadSizeStat struct {
+	Direction ConnectionType
+	Type      HeaderType
+	Count     uint64
+	Min       int
+	Max       int
+	Avg       float64
+	// Buckets 按PayloadSizeBucketBounds分桶的数据包数，比上界多一个溢出桶
+	Buckets [len(PayloadSizeBucketBounds) + 1]uint64
+}
+
+// payloadSizeSlot 一个方向上一种数据包类型的计数器，全部为原子操作
+type payloadSizeSlot struct {
+	count atomic.Uint64
+	total atomic.Uint64
+	// min存储大小+1，0表示尚无数据
+	min     atomic.Uint64
+	max     atomic.Uint64
+	buckets [len(PayloadSizeBucketBounds) + 1]atomic.Uint64
+}
+
+// payloadSizes 按方向和数据包类型的有效载荷大小统计，记录无需加锁
+type payloadSizes struct {
+	slots [2][256]payloadSizeSlot
+}
+
+// record 记录一个数据包的有效载荷大小
+func (ps *payloadSizes) record(direction ConnectionType, headerType HeaderType, size int) {
+	slot := &ps.slots[direction][byte(headerType)]
+	slot.count.Add(1)
+	slot.total.Add(uint64(size))
+	for {
+		current := slot.min.Load()
+		if current != 0 && current <= uint64(size)+1 {
+			break
+		}
+		if slot.min.CompareAndSwap(current, uint64(size)+1) {
+			break
+		}
+	}
+	for {
+		current := slot.max.Load()
+		if current >= uint64(size) || slot.max.CompareAndSwap(current, uint64(size)) {
+			break
+		}
+	}
+	bucket := sort.SearchInts(PayloadSizeBucketBounds[:], size)
+	slot.buckets[bucket].Add(1)
+}
+
+// reset 清零所有计数器
+func (ps *payloadSizes) reset() {
+	for d := range ps.slots {
+		for t := range ps.slots[d] {
+			slot := &ps.slots[d][t]
+			slot.count.Store(0)
+			slot.total.Store(0)
+			slot.min.Store(0)
+			slot.max.Store(0)
+			for i := range slot.buckets {
+				slot.buckets[i].Store(0)
+			}
+		}
+	}
+}
+
+// stats 返回有数据的统计，按方向和类型排序。并发记录时各字段之间可能略有出入。
+func (ps *payloadSizes) stats() []PayloadSizeStat {
+	var stats []PayloadSizeStat
+	for d := range ps.slots {
+		for t := range ps.slots[d] {
+			slot := &ps.slots[d][t]
+			count := slot.count.Load()
+			if count == 0 {
+				continue
+			}
+			stat := PayloadSizeStat{
+				Direction: ConnectionType(d),
+				Type:      HeaderType(t),
+				Count:     count,
+				Max:       int(slot.max.Load()),
+				Avg:       float64(slot.total.Load()) / float64(count),
+			}
+			if min := slot.min.Load(); min > 0 {
+				stat.Min = int(min - 1)
+			}
+			for i := range slot.buckets {
+				stat.Buckets[i] = slot.buckets[i].Load()
+			}
+			stats = append(stats, stat)
+		}
+	}
+	return stats
+}
+
+// EnablePayloadSizeStats 启用数据包有效载荷大小统计(最小、平均、最大和分桶分布)，按方向和数据包类型区分，
+// 可通过PayloadSizes查询，由ResetStats清零。统计在逐包转发时记录，TLS记录不计入。需在Start之前调用。
+func (ba *BridgeAcceptor) EnablePayloadSizeStats() {
+	ba.payloadSizes = &payloadSizes{}
+}
+
+// PayloadSizes 获取各方向、各类数据包的有效载荷大小统计，未启用统计时返回nil
+func (ba *BridgeAcceptor) PayloadSizes() []PayloadSizeStat {
+	if ba.payloadSizes == nil {
+		return nil
+	}
+	return ba.payloadSizes.stats()
+}
+
+// recordPayloadSize 记录一个转发的数据包的有效载荷大小
+func (bc *BridgedConnection) recordPayloadSize(direction ConnectionType, header *TDSHeader, size int) {
+	if ps := bc.BridgeAcceptor.payloadSizes; ps != nil {
+		ps.record(direction, header.Type(), size)
+	}
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPayloadSizesMinAvgMax(t *testing.T) {
+	var ps payloadSizes
+	for _, size := range []int{100, 10, 64, 1000, 40000} {
+		ps.record(ClientBridge, SQLBatch, size)
+	}
+	ps.record(BridgeSQL, TabularResult, 0)
+
+	stats := ps.stats()
+	if len(stats) != 2 {
+		t.Fatalf("stats = %+v, want one entry per direction and type", stats)
+	}
+	batch := stats[0]
+	if batch.Direction != ClientBridge || batch.Type != SQLBatch || batch.Count != 5 {
+		t.Fatalf("first stat = %+v, want 5 client SQL batches", batch)
+	}
+	if batch.Min != 10 || batch.Max != 40000 || batch.Avg != 41174.0/5 {
+		t.Errorf("min/avg/max = %d/%v/%d, want 10/%v/40000", batch.Min, batch.Avg, batch.Max, 41174.0/5)
+	}
+	// 10和64在第一个桶(上界含)，100在128桶，1000在1024桶，40000在溢出桶
+	want := [len(PayloadSizeBucketBounds) + 1]uint64{0: 2, 1: 1, 4: 1, len(PayloadSizeBucketBounds): 1}
+	if batch.Buckets != want {
+		t.Errorf("buckets = %v, want %v", batch.Buckets, want)
+	}
+	if result := stats[1]; result.Direction != BridgeSQL || result.Min != 0 || result.Max != 0 || result.Count != 1 {
+		t.Errorf("response stat = %+v, want a single empty payload", result)
+	}
+
+	ps.reset()
+	if stats := ps.stats(); len(stats) != 0 {
+		t.Errorf("stats after reset = %+v, want none", stats)
+	}
+}
+
+func TestPayloadSizesRecordedWhileForwarding(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.EnablePayloadSizeStats()
+	})
+	short, long := sqlBatchPacket("SELECT 1"), sqlBatchPacket("SELECT * FROM "+string(bytes.Repeat([]byte("t"), 200)))
+	client := h.connect(short, long)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(short)+len(long))
+	response := doneResponse(DONE_FINAL, 0)
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+
+	waitFor(t, "response recorded", func() bool { return len(h.ba.PayloadSizes()) == 2 })
+	stats := h.ba.PayloadSizes()
+	if batch := stats[0]; batch.Count != 2 || batch.Min != len(short)-HEADER_SIZE || batch.Max != len(long)-HEADER_SIZE {
+		t.Errorf("batch stat = %+v, want sizes %d and %d", batch, len(short)-HEADER_SIZE, len(long)-HEADER_SIZE)
+	}
+	if result := stats[1]; result.Type != TabularResult || result.Min != len(response)-HEADER_SIZE {
+		t.Errorf("response stat = %+v, want one %d byte payload", result, len(response)-HEADER_SIZE)
+	}
+}
+
+func TestPayloadSizesDisabled(t *testing.T) {
+	if stats := NewBridgeAcceptor("", "backend:1433").PayloadSizes(); stats != nil {
+		t.Errorf("PayloadSizes without EnablePayloadSizeStats = %v, want nil", stats)
+	}
+}
@@ -0,0 +1,193 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// PreLogin选项标识
+const (
+	PreLoginVersion         = 0x00
+	PreLoginEncryption      = 0x01
+	PreLoginInstOpt         = 0x02
+	PreLoginThreadID        = 0x03
+	PreLoginMARS            = 0x04
+	PreLoginTraceID         = 0x05
+	PreLoginFedAuthRequired = 0x06
+	PreLoginNonceOpt        = 0x07
+	PreLoginTerminator      = 0xFF
+)
+
+// PreLogin中ENCRYPTION选项的取值
+const (
+	ENCRYPT_OFF     = 0x00
+	ENCRYPT_ON      = 0x01
+	ENCRYPT_NOT_SUP = 0x02
+	ENCRYPT_REQ     = 0x03
+)
+
+// PreLoginOption PreLogin选项
+type PreLoginOption struct {
+	Token byte
+	Data  []byte
+}
+
+// PreLoginVersionInfo PreLogin中VERSION选项的内容
+type PreLoginVersionInfo struct {
+	Major    byte
+	Minor    byte
+	Build    uint16
+	SubBuild uint16
+}
+
+func (v PreLoginVersionInfo) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d", v.Major, v.Minor, v.Build, v.SubBuild)
+}
+
+// PreLoginRequestMessage 客户端发送的PreLogin消息
+type PreLoginRequestMessage struct {
+	*BaseTDSMessage
+}
+
+// NewPreLoginRequestMessage 创建新的PreLoginRequestMessage
+func NewPreLoginRequestMessage() *PreLoginRequestMessage {
+	return &PreLoginRequestMessage{
+		BaseTDSMessage: NewBaseTDSMessage(),
+	}
+}
+
+// NewPreLoginRequestMessageWithPacket 从第一个数据包创建新的PreLoginRequestMessage
+func NewPreLoginRequestMessageWithPacket(firstPacket *TDSPacket) *PreLoginRequestMessage {
+	return &PreLoginRequestMessage{
+		BaseTDSMessage: NewBaseTDSMessageWithPacket(firstPacket),
+	}
+}
+
+// GetOptions 解析并返回所有PreLogin选项。
+// 加密协商后的TLS握手数据也使用PreLogin类型传输，此时返回错误。
+func (m *PreLoginRequestMessage) GetOptions() ([]PreLoginOption, error) {
+	return ParsePreLoginOptions(m.AssemblePayload())
+}
+
+// ParsePreLoginOptions 解析PreLogin选项表，客户端请求和服务器响应(类型4)格式相同
+func ParsePreLoginOptions(payload []byte) ([]PreLoginOption, error) {
+	var options []PreLoginOption
+
+	for pos := 0; ; pos += 5 {
+		if pos >= len(payload) {
+			return nil, fmt.Errorf("%w: prelogin: missing option terminator", ErrProtocol)
+		}
+		token := payload[pos]
+		if token == PreLoginTerminator {
+			return options, nil
+		}
+		if pos+5 > len(payload) {
+			return nil, fmt.Errorf("%w: prelogin: truncated option table", ErrProtocol)
+		}
+		offset := int(binary.BigEndian.Uint16(payload[pos+1:]))
+		length := int(binary.BigEndian.Uint16(payload[pos+3:]))
+		if offset+length > len(payload) {
+			return nil, fmt.Errorf("%w: prelogin: option 0x%02X out of range", ErrProtocol, token)
+		}
+		options = append(options, PreLoginOption{
+			Token: token,
+			Data:  payload[offset : offset+length],
+		})
+	}
+}
+
+// BuildPreLoginPayload 按选项顺序构造PreLogin选项表及其数据
+func BuildPreLoginPayload(options []PreLoginOption) []byte {
+	tableSize := len(options)*5 + 1
+	payload := make([]byte, tableSize)
+	offset := tableSize
+	for i, option := range options {
+		entry := payload[i*5:]
+		entry[0] = option.Token
+		binary.BigEndian.PutUint16(entry[1:], uint16(offset))
+		binary.BigEndian.PutUint16(entry[3:], uint16(len(option.Data)))
+		offset += len(option.Data)
+	}
+	payload[tableSize-1] = PreLoginTerminator
+	for _, option := range options {
+		payload = append(payload, option.Data...)
+	}
+	return payload
+}
+
+// bridgePreLoginResponse 构造桥接器代替服务器回应PreLogin的数据包：不支持加密、不启用MARS，
+// 使客户端以明文继续发送Login7
+func bridgePreLoginResponse() []byte {
+	response := BuildPreLoginPayload([]PreLoginOption{
+		{Token: PreLoginVersion, Data: []byte{0, 0, 0, 0, 0, 0}},
+		{Token: PreLoginEncryption, Data: []byte{ENCRYPT_NOT_SUP}},
+		{Token: PreLoginInstOpt, Data: []byte{0}},
+		{Token: PreLoginMARS, Data: []byte{0}},
+	})
+	return append(BuildTDSHeader(TabularResult, END_OF_MESSAGE, len(response)+HEADER_SIZE, 1), response...)
+}
+
+// preLoginEncryption 获取选项中的ENCRYPTION值
+func preLoginEncryption(options []PreLoginOption) (byte, bool) {
+	for _, option := range options {
+		if option.Token == PreLoginEncryption && len(option.Data) >= 1 {
+			return option.Data[0], true
+		}
+	}
+	return 0, false
+}
+
+// GetOption 获取指定选项的数据
+func (m *PreLoginRequestMessage) GetOption(token byte) ([]byte, bool) {
+	options, err := m.GetOptions()
+	if err != nil {
+		return nil, false
+	}
+	for _, option := range options {
+		if option.Token == token {
+			return option.Data, true
+		}
+	}
+	return nil, false
+}
+
+// GetVersion 获取VERSION选项(发送方的程序版本)
+func (m *PreLoginRequestMessage) GetVersion() (PreLoginVersionInfo, bool) {
+	data, ok := m.GetOption(PreLoginVersion)
+	if !ok || len(data) < 6 {
+		return PreLoginVersionInfo{}, false
+	}
+	return PreLoginVersionInfo{
+		Major:    data[0],
+		Minor:    data[1],
+		Build:    binary.BigEndian.Uint16(data[2:]),
+		SubBuild: binary.BigEndian.Uint16(data[4:]),
+	}, true
+}
+
+// GetEncryption 获取ENCRYPTION选项
+func (m *PreLoginRequestMessage) GetEncryption() (byte, bool) {
+	options, err := m.GetOptions()
+	if err != nil {
+		return 0, false
+	}
+	return preLoginEncryption(options)
+}
+
+func (m *PreLoginRequestMessage) String() string {
+	if m.IsComplete() {
+		sb := strings.Builder{}
+		sb.WriteString("PreLoginRequestMessage")
+		sb.WriteString(fmt.Sprintf("[#Packets=%d;IsComplete=%v;HasIgnoreBitSet=%v;TotalPayloadSize=%d",
+			len(m.Packets), m.IsComplete(), m.HasIgnoreBitSet(), len(m.AssemblePayload())))
+
+		for i, packet := range m.Packets {
+			sb.WriteString(fmt.Sprintf("\n\t[P%d[%s]]", i, packet))
+		}
+
+		sb.WriteString("]")
+		return sb.String()
+	}
+	return "PreLoginRequestMessage{Incomplete message}"
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 旧式登录记录(LOGINREC)中各定长字段的位置。
+// 每个字符串字段为30字节的单字节字符区，紧随其后的1字节为实际长度。
+const (
+	PRETDS7_LOGIN_MIN_SIZE = 462
+
+	preTDS7NameSize = 30
+
+	preTDS7HostName   = 0
+	preTDS7UserName   = 31
+	preTDS7Password   = 62
+	preTDS7HostProc   = 93
+	preTDS7AppName    = 140
+	preTDS7ServerName = 171
+	preTDS7Version    = 458
+	preTDS7Language   = 480
+)
+
+// PreTD7LoginMessage TDS 7.0之前的登录消息(类型2)。
+// 适用于TDS 4.2(SQL Server 4.2~6.5及早期DB-Library客户端)和TDS 5.0(Sybase)使用的定长登录记录。
+// 该记录不携带初始数据库，旧式客户端在登录成功后通过USE语句切换数据库。
+type PreTD7LoginMessage struct {
+	*BaseTDSMessage
+}
+
+// NewPreTD7LoginMessage 创建新的PreTD7LoginMessage
+func NewPreTD7LoginMessage() *PreTD7LoginMessage {
+	return &PreTD7LoginMessage{
+		BaseTDSMessage: NewBaseTDSMessage(),
+	}
+}
+
+// NewPreTD7LoginMessageWithPacket 从第一个数据包创建新的PreTD7LoginMessage
+func NewPreTD7LoginMessageWithPacket(firstPacket *TDSPacket) *PreTD7LoginMessage {
+	return &PreTD7LoginMessage{
+		BaseTDSMessage: NewBaseTDSMessageWithPacket(firstPacket),
+	}
+}
+
+// getField 读取从offset开始的定长字符串字段
+func (m *PreTD7LoginMessage) getField(offset int) string {
+	payload := m.AssemblePayload()
+	if len(payload) < offset+preTDS7NameSize+1 {
+		return ""
+	}
+	n := int(payload[offset+preTDS7NameSize])
+	if n > preTDS7NameSize {
+		n = preTDS7NameSize
+	}
+	return string(payload[offset : offset+n])
+}
+
+// GetHostName 获取客户端主机名
+func (m *PreTD7LoginMessage) GetHostName() string {
+	return m.getField(preTDS7HostName)
+}
+
+// GetUserName 获取登录用户名
+func (m *PreTD7LoginMessage) GetUserName() string {
+	return m.getField(preTDS7UserName)
+}
+
+// GetHostProcess 获取客户端进程标识
+func (m *PreTD7LoginMessage) GetHostProcess() string {
+	return m.getField(preTDS7HostProc)
+}
+
+// GetAppName 获取应用程序名
+func (m *PreTD7LoginMessage) GetAppName() string {
+	return m.getField(preTDS7AppName)
+}
+
+// GetServerName 获取客户端连接的服务器名
+func (m *PreTD7LoginMessage) GetServerName() string {
+	return m.getField(preTDS7ServerName)
+}
+
+// GetLanguage 获取初始语言
+func (m *PreTD7LoginMessage) GetLanguage() string {
+	return m.getField(preTDS7Language)
+}
+
+// GetProtocolVersion 获取客户端声明的协议版本，如"4.2"或"5.0"
+func (m *PreTD7LoginMessage) GetProtocolVersion() (string, error) {
+	payload := m.AssemblePayload()
+	if len(payload) < PRETDS7_LOGIN_MIN_SIZE {
+		return "", fmt.Errorf("%w: pre-TDS7 login: payload too short", ErrProtocol)
+	}
+	v := payload[preTDS7Version:]
+	return fmt.Sprintf("%d.%d", v[0], v[1]), nil
+}
+
+func (m *PreTD7LoginMessage) String() string {
+	if m.IsComplete() {
+		sb := strings.Builder{}
+		sb.WriteString("PreTD7LoginMessage")
+		sb.WriteString(fmt.Sprintf("[#Packets=%d;IsComplete=%v;HasIgnoreBitSet=%v;TotalPayloadSize=%d;HostName=%s;UserName=%s",
+			len(m.Packets), m.IsComplete(), m.HasIgnoreBitSet(), len(m.AssemblePayload()), m.GetHostName(), m.GetUserName()))
+
+		for i, packet := range m.Packets {
+			sb.WriteString(fmt.Sprintf("\n\t[P%d[%s]]", i, packet))
+		}
+
+		sb.WriteString("]")
+		return sb.String()
+	}
+	return "PreTD7LoginMessage{Incomplete message}"
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+// preTDS7LoginPayload 构造TDS 4.2客户端发送的512字节登录记录
+func preTDS7LoginPayload() []byte {
+	payload := make([]byte, 512)
+	putField := func(offset int, value string) {
+		copy(payload[offset:offset+preTDS7NameSize], value)
+		payload[offset+preTDS7NameSize] = byte(len(value))
+	}
+	putField(preTDS7HostName, "LEGACYHOST")
+	putField(preTDS7UserName, "sa")
+	putField(preTDS7Password, "secret")
+	putField(preTDS7HostProc, "1234")
+	putField(preTDS7AppName, "isql")
+	putField(preTDS7ServerName, "OLDSQL")
+	payload[preTDS7Version] = 4
+	payload[preTDS7Version+1] = 2
+	putField(preTDS7Language, "us_english")
+	return payload
+}
+
+func TestPreTD7LoginFields(t *testing.T) {
+	payload := preTDS7LoginPayload()
+	// 旧式客户端按512字节的数据包大小分两个数据包发送
+	msg := NewPreTD7LoginMessageWithPacket(NewTDSPacketFromBuffer(buildPacket(PreTD7Login, 0, 1, payload[:300])))
+	msg.AddPacket(NewTDSPacketFromBuffer(buildPacket(PreTD7Login, END_OF_MESSAGE, 2, payload[300:])))
+
+	for name, check := range map[string][2]string{
+		"host":     {msg.GetHostName(), "LEGACYHOST"},
+		"user":     {msg.GetUserName(), "sa"},
+		"process":  {msg.GetHostProcess(), "1234"},
+		"app":      {msg.GetAppName(), "isql"},
+		"server":   {msg.GetServerName(), "OLDSQL"},
+		"language": {msg.GetLanguage(), "us_english"},
+	} {
+		if check[0] != check[1] {
+			t.Errorf("%s = %q, want %q", name, check[0], check[1])
+		}
+	}
+	if version, err := msg.GetProtocolVersion(); err != nil || version != "4.2" {
+		t.Errorf("GetProtocolVersion() = %q, %v, want 4.2", version, err)
+	}
+}
+
+func TestPreTD7LoginTruncated(t *testing.T) {
+	msg := NewPreTD7LoginMessageWithPacket(NewTDSPacketFromBuffer(buildPacket(PreTD7Login, END_OF_MESSAGE, 1, preTDS7LoginPayload()[:100])))
+	if _, err := msg.GetProtocolVersion(); err == nil {
+		t.Error("GetProtocolVersion on a truncated record succeeded")
+	}
+	if host := msg.GetHostName(); host != "LEGACYHOST" {
+		t.Errorf("GetHostName() = %q, want the field within the truncated record", host)
+	}
+	if app := msg.GetAppName(); app != "" {
+		t.Errorf("GetAppName() = %q beyond the truncated record, want empty", app)
+	}
+}
+
+func TestPreTD7LoginFromFactory(t *testing.T) {
+	messages := make(chan TDSMessage, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			messages <- msg
+		})
+	})
+	h.connect(buildPacket(PreTD7Login, END_OF_MESSAGE, 1, preTDS7LoginPayload()))
+
+	select {
+	case msg := <-messages:
+		login, ok := msg.(*PreTD7LoginMessage)
+		if !ok {
+			t.Fatalf("message is %T, want *PreTD7LoginMessage", msg)
+		}
+		if user := login.GetUserName(); user != "sa" {
+			t.Fatalf("GetUserName() = %q, want sa", user)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message event not fired")
+	}
+}
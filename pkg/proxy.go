@@ -0,0 +1,228 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// PROXY_HANDSHAKE_TIMEOUT 与代理完成握手(认证及建立隧道)的最长时间
+const PROXY_HANDSHAKE_TIMEOUT = 30 * time.Second
+
+// backendProxy 连接SQL Server时经过的SOCKS5或HTTP CONNECT代理
+type backendProxy struct {
+	scheme string
+	addr   string
+	user   *url.Userinfo
+}
+
+// SetBackendProxy 设置经代理连接SQL Server，支持socks5://、socks5h://(由代理解析主机名)
+// 和http://(CONNECT隧道)，可在URL中携带用户名和密码。传入空字符串恢复直接连接。
+// 与SetBackendLocalAddr同时使用时，本地地址用于连接代理。
+func (ba *BridgeAcceptor) SetBackendProxy(proxyURL string) error {
+	if proxyURL == "" {
+		ba.backendProxy = nil
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+	case "http":
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	default:
+		return fmt.Errorf("backend proxy: unsupported scheme %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "1080")
+	}
+	ba.backendProxy = &backendProxy{
+		scheme: u.Scheme,
+		addr:   u.Host,
+		user:   u.User,
+	}
+	return nil
+}
+
+// dialBackend 连接SQL Server，设置了代理时经代理建立隧道
+func (ba *BridgeAcceptor) dialBackend(endpoint string) (net.Conn, error) {
+	if ba.dialFunc != nil {
+		return ba.dialFunc(endpoint)
+	}
+	dialer := &net.Dialer{LocalAddr: ba.backendLocalAddr}
+	proxy := ba.backendProxy
+	if proxy == nil {
+		return dialer.Dial("tcp", endpoint)
+	}
+
+	conn, err := dialer.Dial("tcp", proxy.addr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxy.addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(PROXY_HANDSHAKE_TIMEOUT))
+	if proxy.scheme == "http" {
+		conn, err = proxy.httpConnect(conn, endpoint)
+	} else {
+		err = proxy.socks5Connect(conn, endpoint)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxy.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socks5Connect 按RFC 1928(及RFC 1929用户名密码认证)请求代理连接target
+func (p *backendProxy) socks5Connect(conn net.Conn, target string) error {
+	host, portText, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return fmt.Errorf("socks5: invalid port %q", portText)
+	}
+
+	// 协商认证方式
+	greeting := []byte{5, 1, 0}
+	if p.user != nil {
+		greeting = []byte{5, 2, 0, 2}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return fmt.Errorf("socks5: unexpected version %d", reply[0])
+	}
+	switch reply[1] {
+	case 0:
+	case 2:
+		if p.user == nil {
+			return fmt.Errorf("socks5: proxy requires authentication")
+		}
+		password, _ := p.user.Password()
+		username := p.user.Username()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("socks5: username or password too long")
+		}
+		auth := []byte{1, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return fmt.Errorf("socks5: authentication failed")
+		}
+	default:
+		return fmt.Errorf("socks5: no acceptable authentication method")
+	}
+
+	// 请求CONNECT；socks5在本地解析主机名，socks5h交给代理解析
+	request := []byte{5, 1, 0}
+	ip := net.ParseIP(host)
+	if ip == nil && p.scheme == "socks5" {
+		addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+		if err != nil {
+			return err
+		}
+		ip = addrs[0].IP
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("socks5: host name too long")
+		}
+		request = append(request, 3, byte(len(host)))
+		request = append(request, host...)
+	case ip.To4() != nil:
+		request = append(request, 1)
+		request = append(request, ip.To4()...)
+	default:
+		request = append(request, 4)
+		request = append(request, ip.To16()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		return fmt.Errorf("socks5: connect to %s failed with code %d", target, header[1])
+	}
+	// 跳过BND.ADDR和BND.PORT
+	var skip int
+	switch header[3] {
+	case 1:
+		skip = net.IPv4len + 2
+	case 4:
+		skip = net.IPv6len + 2
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0]) + 2
+	default:
+		return fmt.Errorf("socks5: unknown address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// httpConnect 通过HTTP CONNECT建立到target的隧道
+func (p *backendProxy) httpConnect(conn net.Conn, target string) (net.Conn, error) {
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if p.user != nil {
+		password, _ := p.user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.user.Username() + ":" + password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		return conn, err
+	}
+
+	br := bufio.NewReader(conn)
+	response, err := http.ReadResponse(br, request)
+	if err != nil {
+		return conn, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("http connect to %s: %s", target, response.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
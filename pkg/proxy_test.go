@@ -0,0 +1,261 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// loopbackListener 在回环地址上监听，环境不支持时跳过测试
+func loopbackListener(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// echoBackend 启动把收到的数据原样返回的后端，返回其地址
+func echoBackend(t *testing.T) string {
+	listener := loopbackListener(t)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// splice 在两个连接之间双向复制数据，任一方向结束时关闭两端
+func splice(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+		b.Close()
+	}()
+	io.Copy(b, a)
+	a.Close()
+	b.Close()
+}
+
+// testProxy 记录隧道请求的测试代理
+type testProxy struct {
+	addr    string
+	targets chan string
+	auth    chan string
+}
+
+// socks5Proxy 启动最小的SOCKS5代理，user非空时要求用户名密码认证
+func socks5Proxy(t *testing.T, user, password string) *testProxy {
+	listener := loopbackListener(t)
+	p := &testProxy{addr: listener.Addr().String(), targets: make(chan string, 4), auth: make(chan string, 4)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serveSOCKS5(conn, user, password)
+		}
+	}()
+	return p
+}
+
+func (p *testProxy) serveSOCKS5(conn net.Conn, user, password string) {
+	defer conn.Close()
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	methods := make([]byte, greeting[1])
+	io.ReadFull(conn, methods)
+	if user == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		if !bytes.Contains(methods, []byte{2}) {
+			conn.Write([]byte{5, 0xFF})
+			return
+		}
+		conn.Write([]byte{5, 2})
+		head := make([]byte, 2)
+		io.ReadFull(conn, head)
+		gotUser := make([]byte, head[1])
+		io.ReadFull(conn, gotUser)
+		io.ReadFull(conn, head[:1])
+		gotPassword := make([]byte, head[0])
+		io.ReadFull(conn, gotPassword)
+		p.auth <- string(gotUser) + ":" + string(gotPassword)
+		if string(gotUser) != user || string(gotPassword) != password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, net.IPv4len)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	target := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+	p.targets <- target
+
+	backend, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	splice(conn, backend)
+}
+
+// httpConnectProxy 启动最小的HTTP CONNECT代理
+func httpConnectProxy(t *testing.T) *testProxy {
+	listener := loopbackListener(t)
+	p := &testProxy{addr: listener.Addr().String(), targets: make(chan string, 4), auth: make(chan string, 4)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || request.Method != http.MethodConnect {
+					return
+				}
+				p.targets <- request.Host
+				p.auth <- request.Header.Get("Proxy-Authorization")
+				backend, err := net.Dial("tcp", request.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				splice(conn, backend)
+			}()
+		}
+	}()
+	return p
+}
+
+// proxiedHarness 创建经proxyURL真实拨号到回显后端的装置
+func proxiedHarness(t *testing.T, proxyURL string) (*bridgeHarness, string) {
+	backend := echoBackend(t)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.dialFunc = nil
+		ba.sqlServerEndpoint = backend
+		if err := ba.SetBackendProxy(proxyURL); err != nil {
+			t.Fatalf("SetBackendProxy: %v", err)
+		}
+	})
+	return h, backend
+}
+
+// expectTunnel 检查代理收到了到target的隧道请求
+func expectTunnel(t *testing.T, p *testProxy, target string) {
+	t.Helper()
+	select {
+	case got := <-p.targets:
+		if got != target {
+			t.Errorf("proxy tunneled to %s, want %s", got, target)
+		}
+	default:
+		t.Error("backend was not reached through the proxy")
+	}
+}
+
+func TestBackendProxySOCKS5(t *testing.T) {
+	proxy := socks5Proxy(t, "", "")
+	h, backend := proxiedHarness(t, "socks5://"+proxy.addr)
+
+	batch := sqlBatchPacket("SELECT 1")
+	client := h.connect(batch)
+	waitWritten(t, client, len(batch))
+	if !bytes.Equal(client.Written(), batch) {
+		t.Errorf("client received %x, want the echoed batch", client.Written())
+	}
+	expectTunnel(t, proxy, backend)
+}
+
+func TestBackendProxySOCKS5Auth(t *testing.T) {
+	proxy := socks5Proxy(t, "bridge", "s3cret")
+	h, backend := proxiedHarness(t, "socks5://bridge:s3cret@"+proxy.addr)
+
+	batch := sqlBatchPacket("SELECT 1")
+	waitWritten(t, h.connect(batch), len(batch))
+	if auth := <-proxy.auth; auth != "bridge:s3cret" {
+		t.Errorf("proxy received credentials %q", auth)
+	}
+	expectTunnel(t, proxy, backend)
+}
+
+func TestBackendProxySOCKS5AuthFailure(t *testing.T) {
+	proxy := socks5Proxy(t, "bridge", "s3cret")
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.dialFunc = nil
+		ba.sqlServerEndpoint = "127.0.0.1:1433"
+		ba.SetBackendProxy("socks5://bridge:wrong@" + proxy.addr)
+	})
+	client := h.connect()
+	firstMatching(t, exceptions, ErrBackendDial)
+	waitFor(t, "client close", client.isClosed)
+}
+
+func TestBackendProxyHTTPConnect(t *testing.T) {
+	proxy := httpConnectProxy(t)
+	h, backend := proxiedHarness(t, "http://user:pass@"+proxy.addr)
+
+	batch := sqlBatchPacket("SELECT 1")
+	waitWritten(t, h.connect(batch), len(batch))
+	if auth := <-proxy.auth; auth != "Basic dXNlcjpwYXNz" {
+		t.Errorf("Proxy-Authorization = %q", auth)
+	}
+	expectTunnel(t, proxy, backend)
+}
+
+func TestSetBackendProxyValidation(t *testing.T) {
+	ba := NewBridgeAcceptor("1433", "127.0.0.1:1433")
+	if err := ba.SetBackendProxy("ftp://proxy:21"); err == nil {
+		t.Error("unsupported scheme accepted")
+	}
+	if err := ba.SetBackendProxy("socks5h://proxy"); err != nil || ba.backendProxy.addr != "proxy:1080" {
+		t.Errorf("socks5h without port: err %v, addr %v", err, ba.backendProxy)
+	}
+	if err := ba.SetBackendProxy("http://proxy"); err != nil || ba.backendProxy.addr != "proxy:80" {
+		t.Errorf("http without port: err %v, addr %v", err, ba.backendProxy)
+	}
+	if err := ba.SetBackendProxy(""); err != nil || ba.backendProxy != nil {
+		t.Errorf("empty URL did not clear the proxy: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PROXY协议版本
+const (
+	PROXY_PROTOCOL_V1 = 1
+	PROXY_PROTOCOL_V2 = 2
+)
+
+// proxyProtocolV2Signature PROXY协议v2头部的12字节签名
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// SetSendProxyProtocol 连接SQL Server后立即发送PROXY协议头(版本1为文本格式，2为二进制格式)，
+// 使支持PROXY协议的上游看到客户端的真实地址：源地址为客户端地址，目的地址为客户端连接的桥接器地址。
+// 头部在后端连接处理函数之前发送。传入0关闭，其他版本返回错误。
+func (ba *BridgeAcceptor) SetSendProxyProtocol(version int) error {
+	switch version {
+	case 0, PROXY_PROTOCOL_V1, PROXY_PROTOCOL_V2:
+		ba.proxyProtocolVersion = version
+		return nil
+	default:
+		return fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+}
+
+// BuildProxyProtocolHeader 构造描述src到dst的TCP连接的PROXY协议头。
+// 两端都是IPv4时使用TCP4，否则使用TCP6(IPv4地址映射为IPv6)；地址不是TCP地址时，
+// v1发送UNKNOWN，v2发送LOCAL命令。
+func BuildProxyProtocolHeader(version int, src, dst net.Addr) ([]byte, error) {
+	srcTCP, srcOK := src.(*net.TCPAddr)
+	dstTCP, dstOK := dst.(*net.TCPAddr)
+	known := srcOK && dstOK
+
+	switch version {
+	case PROXY_PROTOCOL_V1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		family := "TCP4"
+		srcIP, dstIP := srcTCP.IP.To4(), dstTCP.IP.To4()
+		if srcIP == nil || dstIP == nil {
+			family = "TCP6"
+			srcIP, dstIP = srcTCP.IP.To16(), dstTCP.IP.To16()
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+			family, srcIP, dstIP, srcTCP.Port, dstTCP.Port)), nil
+
+	case PROXY_PROTOCOL_V2:
+		header := append([]byte{}, proxyProtocolV2Signature...)
+		if !known {
+			// LOCAL命令，地址族UNSPEC，无地址
+			return append(header, 0x20, 0x00, 0, 0), nil
+		}
+		srcIP, dstIP := srcTCP.IP.To4(), dstTCP.IP.To4()
+		family := byte(0x11) // AF_INET, STREAM
+		if srcIP == nil || dstIP == nil {
+			family = 0x21 // AF_INET6, STREAM
+			srcIP, dstIP = srcTCP.IP.To16(), dstTCP.IP.To16()
+		}
+		header = append(header, 0x21, family) // 版本2，PROXY命令
+		header = binary.BigEndian.AppendUint16(header, uint16(len(srcIP)*2+4))
+		header = append(header, srcIP...)
+		header = append(header, dstIP...)
+		header = binary.BigEndian.AppendUint16(header, uint16(srcTCP.Port))
+		return binary.BigEndian.AppendUint16(header, uint16(dstTCP.Port)), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+}
+
+// PROXY_HEADER_TIMEOUT 读取客户端连接上PROXY协议头的最长时间
+const PROXY_HEADER_TIMEOUT = 5 * time.Second
+
+// proxyProtocolV1MaxLength PROXY协议v1头部(含CRLF)的最大长度
+const proxyProtocolV1MaxLength = 107
+
+// SetAcceptProxyProtocol 设置客户端连接是否以PROXY协议头开始(v1和v2均可识别)。
+// 桥接器位于负载均衡器之后时启用，接受连接后先读取并去掉头部，连接的RemoteAddr和
+// LocalAddr随之改为头部中的源地址和目的地址，连接数限制、连接事件和日志都使用真实地址。
+// 启用后不以PROXY协议头开始或头部格式错误的连接被拒绝，并以ErrProtocol触发连接拒绝事件。
+func (ba *BridgeAcceptor) SetAcceptProxyProtocol(enabled bool) {
+	ba.acceptProxyProtocol = enabled
+}
+
+// proxiedConn 经PROXY协议转发的客户端连接，地址取自PROXY协议头
+type proxiedConn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxiedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *proxiedConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// RealClientAddr 获取客户端的真实地址：启用SetAcceptProxyProtocol时为PROXY协议头中的源地址
+// (头部未携带地址时为TCP连接的远端地址)，否则与SocketCouple.ClientAddr相同
+func (bc *BridgedConnection) RealClientAddr() net.Addr {
+	return bc.SocketCouple.ClientAddr()
+}
+
+// ProxyAddr 获取转发该连接的代理(负载均衡器)的地址，连接不是经PROXY协议接受时返回nil
+func (bc *BridgedConnection) ProxyAddr() net.Addr {
+	if proxied, ok := underlyingProxiedConn(bc.SocketCouple.ClientBridgeSocket); ok {
+		return proxied.Conn.RemoteAddr()
+	}
+	return nil
+}
+
+// underlyingProxiedConn 在预读包装之下查找经PROXY协议接受的连接
+func underlyingProxiedConn(conn net.Conn) (*proxiedConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *proxiedConn:
+			return c, true
+		case *bufferedConn:
+			conn = c.Conn
+		case *progressWriteConn:
+			conn = c.Conn
+		default:
+			return nil, false
+		}
+	}
+}
+
+// acceptProxyHeader 读取客户端连接开头的PROXY协议头，返回以头部中的地址代替连接地址的连接。
+// 头部为LOCAL命令或UNKNOWN时保留连接原有的地址。
+func acceptProxyHeader(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(PROXY_HEADER_TIMEOUT))
+	defer conn.SetReadDeadline(time.Time{})
+
+	br := bufio.NewReaderSize(conn, 256)
+	proxied := &proxiedConn{
+		Conn:       conn,
+		r:          br,
+		remoteAddr: conn.RemoteAddr(),
+		localAddr:  conn.LocalAddr(),
+	}
+
+	prefix, err := br.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	var src, dst net.Addr
+	switch {
+	case bytes.Equal(prefix, proxyProtocolV2Signature):
+		src, dst, err = readProxyHeaderV2(br)
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		src, dst, err = readProxyHeaderV1(br)
+	default:
+		return nil, fmt.Errorf("%w: missing PROXY protocol header", ErrProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if src != nil && dst != nil {
+		proxied.remoteAddr, proxied.localAddr = src, dst
+	}
+	return proxied, nil
+}
+
+// readProxyHeaderV1 读取文本格式的PROXY协议头，UNKNOWN时返回nil地址
+func readProxyHeaderV1(br *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLength {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: PROXY v1 header is not terminated by CRLF", ErrProtocol)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: malformed PROXY v1 header %q", ErrProtocol, line)
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || srcErr != nil || dstErr != nil {
+		return nil, nil, fmt.Errorf("%w: malformed PROXY v1 header %q", ErrProtocol, line)
+	}
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+// readProxyHeaderV2 读取二进制格式的PROXY协议头，LOCAL命令或非TCP地址族时返回nil地址
+func readProxyHeaderV2(br *bufio.Reader) (src, dst net.Addr, err error) {
+	header := make([]byte, len(proxyProtocolV2Signature)+4)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, nil, err
+	}
+	verCmd, family := header[12], header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, nil, err
+	}
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported PROXY v2 version %d", ErrProtocol, verCmd>>4)
+	}
+
+	switch verCmd & 0x0F {
+	case 0x00: // LOCAL
+		return nil, nil, nil
+	case 0x01: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported PROXY v2 command %d", ErrProtocol, verCmd&0x0F)
+	}
+
+	// 其后可能跟随TLV，地址之外的内容忽略
+	var ipLen int
+	switch family {
+	case 0x11: // AF_INET, STREAM
+		ipLen = net.IPv4len
+	case 0x21: // AF_INET6, STREAM
+		ipLen = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < ipLen*2+4 {
+		return nil, nil, fmt.Errorf("%w: PROXY v2 address block too short", ErrProtocol)
+	}
+	srcIP := net.IP(append([]byte(nil), body[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), body[ipLen:ipLen*2]...))
+	srcPort := binary.BigEndian.Uint16(body[ipLen*2:])
+	dstPort := binary.BigEndian.Uint16(body[ipLen*2+2:])
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBuildProxyProtocolHeader(t *testing.T) {
+	v4src := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 50000}
+	v4dst := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1433}
+	v6src := &net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 50000}
+	v6dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1433}
+	pipe := &net.UnixAddr{Name: "pipe", Net: "unix"}
+
+	v2 := func(rest ...byte) []byte {
+		return append(append([]byte{}, proxyProtocolV2Signature...), rest...)
+	}
+	tests := []struct {
+		name     string
+		version  int
+		src, dst net.Addr
+		want     []byte
+	}{
+		{"v1 IPv4", PROXY_PROTOCOL_V1, v4src, v4dst,
+			[]byte("PROXY TCP4 192.0.2.10 192.0.2.1 50000 1433\r\n")},
+		{"v1 IPv6", PROXY_PROTOCOL_V1, v6src, v6dst,
+			[]byte("PROXY TCP6 2001:db8::10 2001:db8::1 50000 1433\r\n")},
+		{"v1 unknown", PROXY_PROTOCOL_V1, pipe, v4dst,
+			[]byte("PROXY UNKNOWN\r\n")},
+		{"v2 IPv4", PROXY_PROTOCOL_V2, v4src, v4dst, v2(
+			0x21, 0x11, 0x00, 0x0C,
+			192, 0, 2, 10,
+			192, 0, 2, 1,
+			0xC3, 0x50, 0x05, 0x99)},
+		{"v2 IPv6", PROXY_PROTOCOL_V2, v6src, v6dst, v2(
+			0x21, 0x21, 0x00, 0x24,
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10,
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+			0xC3, 0x50, 0x05, 0x99)},
+		{"v2 local", PROXY_PROTOCOL_V2, v4src, pipe, v2(0x20, 0x00, 0x00, 0x00)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BuildProxyProtocolHeader(tt.version, tt.src, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("header = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := BuildProxyProtocolHeader(3, v4src, v4dst); err == nil {
+		t.Error("version 3 accepted")
+	}
+}
+
+func TestSetSendProxyProtocolRejectsUnknownVersion(t *testing.T) {
+	ba := NewBridgeAcceptor("1433", "localhost:1433")
+	if err := ba.SetSendProxyProtocol(3); err == nil {
+		t.Error("SetSendProxyProtocol(3) returned nil")
+	}
+	if err := ba.SetSendProxyProtocol(0); err != nil {
+		t.Errorf("SetSendProxyProtocol(0) = %v", err)
+	}
+}
+
+func TestProxyProtocolHeaderPrecedesFirstPacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		client  net.IP
+		want    []byte
+	}{
+		{"v1 IPv4", PROXY_PROTOCOL_V1, nil,
+			[]byte("PROXY TCP4 127.0.0.1 127.0.0.1 50000 1433\r\n")},
+		{"v1 IPv6", PROXY_PROTOCOL_V1, net.ParseIP("2001:db8::10"),
+			[]byte("PROXY TCP6 2001:db8::10 ::1 50000 1433\r\n")},
+		{"v2 IPv4", PROXY_PROTOCOL_V2, nil, append(append([]byte{}, proxyProtocolV2Signature...),
+			0x21, 0x11, 0x00, 0x0C, 127, 0, 0, 1, 127, 0, 0, 1, 0xC3, 0x50, 0x05, 0x99)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handlerSaw []byte
+			h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+				if err := ba.SetSendProxyProtocol(tt.version); err != nil {
+					t.Fatal(err)
+				}
+				// 后端连接处理函数在PROXY头之后运行
+				ba.SetBackendConnectedHandler(func(_ *BridgedConnection, backend net.Conn) error {
+					handlerSaw = backend.(*scriptedConn).Written()
+					return nil
+				})
+			})
+			packet := sqlBatchPacket("SELECT 1")
+			h.connectFrom(tt.client, packet)
+
+			written := waitWritten(t, h.backend(0), len(tt.want)+len(packet))
+			if !bytes.Equal(written[:len(tt.want)], tt.want) {
+				t.Fatalf("backend received %q first, want %q", written[:len(tt.want)], tt.want)
+			}
+			if !bytes.Equal(written[len(tt.want):], packet) {
+				t.Errorf("first TDS packet = %x, want %x", written[len(tt.want):], packet)
+			}
+			if !bytes.Equal(handlerSaw, tt.want) {
+				t.Errorf("backend connected handler saw %q, want only the PROXY header", handlerSaw)
+			}
+		})
+	}
+}
+
+func TestAcceptProxyProtocolReportsRealClient(t *testing.T) {
+	v2 := append(append([]byte{}, proxyProtocolV2Signature...),
+		0x21, 0x11, 0x00, 0x0C, 198, 51, 100, 7, 192, 0, 2, 1, 0xC3, 0x50, 0x05, 0x99)
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"v1", []byte("PROXY TCP4 198.51.100.7 192.0.2.1 50000 1433\r\n"), "198.51.100.7:50000"},
+		{"v1 IPv6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 50000 1433\r\n"), "[2001:db8::7]:50000"},
+		{"v2", v2, "198.51.100.7:50000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connected := make(chan *BridgedConnection, 1)
+			h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+				ba.SetAcceptProxyProtocol(true)
+				ba.SetBackendConnectedHandler(func(bc *BridgedConnection, _ net.Conn) error {
+					connected <- bc
+					return nil
+				})
+			})
+			packet := sqlBatchPacket("SELECT 1")
+			h.connect(append(append([]byte{}, tt.header...), packet...))
+
+			// PROXY头不转发给SQL Server
+			if written := waitWritten(t, h.backend(0), len(packet)); !bytes.Equal(written, packet) {
+				t.Errorf("backend received %x, want only the TDS packet %x", written, packet)
+			}
+			bc := <-connected
+			if got := bc.RealClientAddr().String(); got != tt.want {
+				t.Errorf("RealClientAddr() = %s, want %s", got, tt.want)
+			}
+			if proxy := bc.ProxyAddr(); proxy == nil || proxy.String() == tt.want {
+				t.Errorf("ProxyAddr() = %v, want the load balancer address", proxy)
+			}
+		})
+	}
+}
+
+func TestAcceptProxyProtocolRejectsMissingHeader(t *testing.T) {
+	rejected := make(chan error, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetAcceptProxyProtocol(true)
+		ba.SetConnectionRejectedHandler(func(_ net.Conn, err error) {
+			rejected <- err
+		})
+	})
+	client := h.connect(sqlBatchPacket("SELECT 1"))
+
+	select {
+	case err := <-rejected:
+		if !errors.Is(err, ErrProtocol) {
+			t.Errorf("rejected with %v, want ErrProtocol", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection without a PROXY header was not rejected")
+	}
+	waitFor(t, "client closed", client.isClosed)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.dialed) != 0 {
+		t.Errorf("dialed %v, want no backend", h.dialed)
+	}
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import "fmt"
+
+// QueryCostFilter 根据SQLBatch文本判断查询是否代价过高，block为true时阻止该批处理，reason说明原因
+type QueryCostFilter func(text string) (block bool, reason string)
+
+// SetQueryCostFilter 设置查询代价过滤函数，对每个SQLBatch在转发前调用，用于阻止明显昂贵的查询
+// (如大表上不带WHERE的SELECT、笛卡尔积)。normalize为true时传入NormalizeSQL规范化后的文本。
+// 被阻止的批处理不转发给后端，客户端收到包含原因的错误(超过MAX_ERROR_MESSAGE_LENGTH时截断)，并触发消息阻止事件。
+// 设置后SQLBatch消息被缓存到END_OF_MESSAGE，在转发改写函数和消息拦截函数之前执行。
+// 关闭解析时不生效。传入nil取消。需在Start之前调用。
+func (ba *BridgeAcceptor) SetQueryCostFilter(filter QueryCostFilter, normalize bool) {
+	ba.queryCostFilter = filter
+	ba.normalizeCostFilterText = normalize
+}
+
+// checkQueryCost 对SQLBatch执行查询代价过滤函数，返回阻止时发给客户端的错误消息
+func (bc *BridgedConnection) checkQueryCost(msg TDSMessage) (string, bool) {
+	filter := bc.BridgeAcceptor.queryCostFilter
+	batch, ok := msg.(*SQLBatchMessage)
+	if filter == nil || !ok {
+		return "", false
+	}
+	text := batch.GetBatchText()
+	if bc.BridgeAcceptor.normalizeCostFilterText {
+		text = NormalizeSQL(text)
+	}
+	block, reason := filter(text)
+	if !block {
+		return "", false
+	}
+	if reason == "" {
+		return "The query was rejected by this bridge.", true
+	}
+	return fmt.Sprintf("The query was rejected by this bridge: %s", reason), true
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockUnfilteredSelect 阻止不带WHERE的SELECT
+func blockUnfilteredSelect(text string) (bool, string) {
+	upper := strings.ToUpper(text)
+	if strings.HasPrefix(upper, "SELECT") && !strings.Contains(upper, "WHERE") {
+		return true, "SELECT without WHERE"
+	}
+	return false, ""
+}
+
+func TestQueryCostFilterBlocksBatch(t *testing.T) {
+	var texts []string
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetQueryCostFilter(func(text string) (bool, string) {
+			texts = append(texts, text)
+			return blockUnfilteredSelect(text)
+		}, true)
+	})
+	blocked, allowed := sqlBatchPacket("SELECT * FROM orders"), sqlBatchPacket("SELECT * FROM orders WHERE id = 7")
+	client := h.connect(blocked, allowed)
+	backend := h.backend(0)
+
+	errs := responseErrors(t, waitWritten(t, client, 1))
+	if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_BLOCKED || !strings.Contains(errs[0].Message, "SELECT without WHERE") {
+		t.Fatalf("client errors = %v, want the filter's reason", errs)
+	}
+	written := waitWritten(t, backend, len(allowed))
+	time.Sleep(20 * time.Millisecond)
+	if got := backend.Written(); len(got) != len(allowed) || string(written[:len(allowed)]) != string(allowed) {
+		t.Errorf("backend received %d bytes, want only the filtered batch", len(got))
+	}
+	if len(texts) != 2 || texts[1] != NormalizeSQL("SELECT * FROM orders WHERE id = 7") {
+		t.Errorf("filter saw %q, want the normalized batch texts", texts)
+	}
+}
+
+func TestQueryCostFilterLongReason(t *testing.T) {
+	reason := strings.Repeat("x", 40000)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetQueryCostFilter(func(text string) (bool, string) { return true, reason }, false)
+	})
+	client := h.connect(sqlBatchPacket("SELECT 1"))
+
+	response := waitWritten(t, client, HEADER_SIZE)
+	header := NewTDSHeader(response)
+	response = waitWritten(t, client, header.LengthIncludingHeader())
+	if header.LengthIncludingHeader() > 4096 || len(response) != header.LengthIncludingHeader() {
+		t.Fatalf("error response is %d bytes declaring %d, want one packet within 4096 bytes", len(response), header.LengthIncludingHeader())
+	}
+	errs := responseErrors(t, response)
+	if len(errs) != 1 || len([]rune(errs[0].Message)) != MAX_ERROR_MESSAGE_LENGTH {
+		t.Fatalf("client errors = %d, want one error truncated to %d characters", len(errs), MAX_ERROR_MESSAGE_LENGTH)
+	}
+}
+
+func TestBuildErrorResponseKeepsSurrogatePairs(t *testing.T) {
+	// 截断位置落在代理对中间时整个字符被去掉
+	message := strings.Repeat("x", MAX_ERROR_MESSAGE_LENGTH-1) + "😀"
+	errs := responseErrors(t, BuildErrorResponse(50000, 16, message))
+	if len(errs) != 1 || errs[0].Message != strings.Repeat("x", MAX_ERROR_MESSAGE_LENGTH-1) {
+		t.Errorf("message not cut before the surrogate pair")
+	}
+}
@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// QueryStat 一条规范化语句的统计
+type QueryStat struct {
+	Statement  string
+	Count      uint64
+	TotalBytes uint64
+}
+
+// queryStats 有界的语句频次统计。
+// 条目数达到上限时淘汰计数最小的条目，新条目继承其计数(Space-Saving算法)，
+// 使高频语句在内存有界的情况下仍能被准确保留。
+type queryStats struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[uint64]*QueryStat
+}
+
+// newQueryStats 创建最多保留capacity条语句的统计
+func newQueryStats(capacity int) *queryStats {
+	return &queryStats{
+		capacity: capacity,
+		entries:  make(map[uint64]*QueryStat, capacity),
+	}
+}
+
+// record 记录一次语句执行
+func (qs *queryStats) record(statement string, size int) {
+	h := fnv.New64a()
+	h.Write([]byte(statement))
+	key := h.Sum64()
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	if stat, ok := qs.entries[key]; ok {
+		stat.Count++
+		stat.TotalBytes += uint64(size)
+		return
+	}
+
+	var inherited uint64
+	if len(qs.entries) >= qs.capacity {
+		var minKey uint64
+		var minStat *QueryStat
+		for k, stat := range qs.entries {
+			if minStat == nil || stat.Count < minStat.Count {
+				minKey, minStat = k, stat
+			}
+		}
+		inherited = minStat.Count
+		delete(qs.entries, minKey)
+	}
+	qs.entries[key] = &QueryStat{
+		Statement:  statement,
+		Count:      inherited + 1,
+		TotalBytes: uint64(size),
+	}
+}
+
+// reset 清空所有统计
+func (qs *queryStats) reset() {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.entries = make(map[uint64]*QueryStat, qs.capacity)
+}
+
+// top 返回计数最高的n条语句
+func (qs *queryStats) top(n int) []QueryStat {
+	qs.mu.Lock()
+	stats := make([]QueryStat, 0, len(qs.entries))
+	for _, stat := range qs.entries {
+		stats = append(stats, *stat)
+	}
+	qs.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Statement < stats[j].Statement
+	})
+	if n >= 0 && n < len(stats) {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// NormalizeSQL 规范化SQL文本：字符串和数字字面量替换为?，连续空白压缩为一个空格
+func NormalizeSQL(text string) string {
+	var sb strings.Builder
+	space := false
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			space = true
+			i++
+			continue
+		case c == '\'' || ((c == 'N' || c == 'n') && i+1 < len(text) && text[i+1] == '\'' && (i == 0 || !isIdentifierChar(text[i-1]))):
+			// 字符串字面量
+			j := i + 1
+			if c != '\'' {
+				j++
+			}
+			for j < len(text) {
+				if text[j] == '\'' {
+					if j+1 < len(text) && text[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			writeNormalized(&sb, &space, "?")
+			i = j + 1
+		case c >= '0' && c <= '9' && (i == 0 || !isIdentifierChar(text[i-1])):
+			// 数字字面量
+			j := i
+			for j < len(text) && (text[j] == '.' || (text[j] >= '0' && text[j] <= '9') ||
+				text[j] == 'x' || text[j] == 'X' || (text[j] >= 'a' && text[j] <= 'f') || (text[j] >= 'A' && text[j] <= 'F')) {
+				j++
+			}
+			writeNormalized(&sb, &space, "?")
+			i = j
+		default:
+			writeNormalized(&sb, &space, string(c))
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// writeNormalized 写入规范化片段，必要时先补一个空格
+func writeNormalized(sb *strings.Builder, space *bool, s string) {
+	if *space && sb.Len() > 0 {
+		sb.WriteByte(' ')
+	}
+	*space = false
+	sb.WriteString(s)
+}
+
+// statementOf 提取消息中用于统计的语句文本，非语句类消息返回false
+func statementOf(msg TDSMessage) (string, bool) {
+	switch m := msg.(type) {
+	case *SQLBatchMessage:
+		return NormalizeSQL(m.GetBatchText()), true
+	case *RPCRequestMessage:
+		procName, err := m.GetProcName()
+		if err != nil {
+			return "", false
+		}
+		if isExecuteSQL(procName) {
+			if params, err := m.GetParameters(); err == nil && len(params) > 0 {
+				if stmt, err := params[0].Text(); err == nil {
+					return NormalizeSQL(stmt), true
+				}
+			}
+		}
+		return "EXEC " + procName, true
+	}
+	return "", false
+}
@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTopQueriesOrdering(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.EnableQueryStats(16)
+	})
+
+	var reads [][]byte
+	for i := 0; i < 5; i++ {
+		reads = append(reads, sqlBatchPacket(fmt.Sprintf("SELECT * FROM orders WHERE id = %d", i)))
+	}
+	for i := 0; i < 3; i++ {
+		reads = append(reads, rpcPacket("", ProcIDExecuteSQL,
+			nvarcharParam("", "SELECT name FROM users WHERE id = @id"),
+			nvarcharParam("", "@id int"),
+			intParam("@id", int32(i))))
+	}
+	reads = append(reads, rpcPacket("dbo.usp_audit", 0, intParam("@id", 1)))
+	reads = append(reads, rpcPacket("dbo.usp_audit", 0, intParam("@id", 2)))
+	reads = append(reads, sqlBatchPacket("  select   @@version "))
+	total := 0
+	for _, packet := range reads {
+		total += len(packet)
+	}
+	h.connect(reads...)
+	waitWritten(t, h.backend(0), total)
+
+	var top []QueryStat
+	waitFor(t, "query stats", func() bool {
+		top = h.ba.TopQueries(-1)
+		var count uint64
+		for _, stat := range top {
+			count += stat.Count
+		}
+		return count == 11
+	})
+
+	want := []struct {
+		statement string
+		count     uint64
+	}{
+		{"SELECT * FROM orders WHERE id = ?", 5},
+		{"SELECT name FROM users WHERE id = @id", 3},
+		{"EXEC dbo.usp_audit", 2},
+		{"select @@version", 1},
+	}
+	if len(top) != len(want) {
+		t.Fatalf("TopQueries = %+v, want %d entries", top, len(want))
+	}
+	for i, w := range want {
+		if top[i].Statement != w.statement || top[i].Count != w.count {
+			t.Errorf("TopQueries[%d] = %q x%d, want %q x%d", i, top[i].Statement, top[i].Count, w.statement, w.count)
+		}
+	}
+	if top[0].TotalBytes == 0 {
+		t.Error("TopQueries did not record total bytes")
+	}
+
+	if top2 := h.ba.TopQueries(2); len(top2) != 2 || top2[0].Statement != want[0].statement {
+		t.Fatalf("TopQueries(2) = %+v", top2)
+	}
+}
+
+func TestQueryStatsBounded(t *testing.T) {
+	// 频次超过总数/容量的语句一定被保留
+	qs := newQueryStats(10)
+	for i := 0; i < 20; i++ {
+		qs.record("hot", 10)
+	}
+	for i := 0; i < 100; i++ {
+		qs.record(fmt.Sprintf("cold %d", i), 1)
+	}
+	top := qs.top(-1)
+	if len(top) != 10 {
+		t.Fatalf("%d entries retained, want 10", len(top))
+	}
+	if top[0].Statement != "hot" || top[0].Count != 20 || top[0].TotalBytes != 200 {
+		t.Fatalf("top entry = %+v, want hot x20", top[0])
+	}
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	for in, want := range map[string]string{
+		"SELECT *\n\tFROM t WHERE a = 1":        "SELECT * FROM t WHERE a = ?",
+		"SELECT N'it''s' , 'x'":                 "SELECT ? , ?",
+		"SELECT col1, 0x1F, 3.14 FROM t2":       "SELECT col1, ?, ? FROM t2",
+		"UPDATE t SET name = 'a' WHERE id = 42": "UPDATE t SET name = ? WHERE id = ?",
+	} {
+		if got := NormalizeSQL(in); got != want {
+			t.Errorf("NormalizeSQL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
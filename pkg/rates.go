@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MAX_RATE_WINDOW 消息速率统计可查询的最长窗口，按秒分桶
+const MAX_RATE_WINDOW = 60 * time.Second
+
+const rateBucketCount = int(MAX_RATE_WINDOW / time.Second)
+
+// rateBucket 一秒内各消息类型的计数
+type rateBucket struct {
+	// 进入新的一秒时清零，仅在换桶时加锁
+	mu     sync.Mutex
+	second atomic.Int64
+	counts [256]atomic.Uint64
+}
+
+// messageRates 按消息类型统计的滑动窗口计数器。
+// 记录只需原子加法，仅在每个桶每秒第一次使用时加锁清零。
+type messageRates struct {
+	buckets [rateBucketCount]rateBucket
+	now     func() time.Time
+}
+
+// newMessageRates 创建消息速率统计
+func newMessageRates() *messageRates {
+	return &messageRates{now: time.Now}
+}
+
+// record 记录一个消息
+func (mr *messageRates) record(headerType HeaderType) {
+	sec := mr.now().Unix()
+	b := &mr.buckets[sec%int64(rateBucketCount)]
+	if b.second.Load() != sec {
+		b.mu.Lock()
+		if b.second.Load() != sec {
+			for i := range b.counts {
+				b.counts[i].Store(0)
+			}
+			b.second.Store(sec)
+		}
+		b.mu.Unlock()
+	}
+	b.counts[byte(headerType)].Add(1)
+}
+
+// reset 清零所有桶
+func (mr *messageRates) reset() {
+	for i := range mr.buckets {
+		b := &mr.buckets[i]
+		b.mu.Lock()
+		for t := range b.counts {
+			b.counts[t].Store(0)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// rates 返回最近window内各消息类型的每秒速率，window按整秒计，最长MAX_RATE_WINDOW
+func (mr *messageRates) rates(window time.Duration) map[HeaderType]float64 {
+	seconds := int64(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	if seconds > int64(rateBucketCount) {
+		seconds = int64(rateBucketCount)
+	}
+
+	now := mr.now().Unix()
+	totals := make(map[HeaderType]uint64)
+	for i := range mr.buckets {
+		b := &mr.buckets[i]
+		if sec := b.second.Load(); sec <= now-seconds || sec > now {
+			continue
+		}
+		for t := range b.counts {
+			if n := b.counts[t].Load(); n > 0 {
+				totals[HeaderType(t)] += n
+			}
+		}
+	}
+
+	rates := make(map[HeaderType]float64, len(totals))
+	for t, n := range totals {
+		rates[t] = float64(n) / float64(seconds)
+	}
+	return rates
+}
@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock 手动推进的时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestMessageRatesWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	mr := newMessageRates()
+	mr.now = clock.Now
+
+	// 第0秒：10个批处理；第1至4秒：每秒5个RPC
+	for i := 0; i < 10; i++ {
+		mr.record(SQLBatch)
+	}
+	for s := 0; s < 4; s++ {
+		clock.advance(time.Second)
+		for i := 0; i < 5; i++ {
+			mr.record(RPC)
+		}
+	}
+
+	rates := mr.rates(5 * time.Second)
+	if rates[SQLBatch] != 2 || rates[RPC] != 4 {
+		t.Errorf("5s rates = %v, want SQLBatch 2/s and RPC 4/s", rates)
+	}
+	rates = mr.rates(2 * time.Second)
+	if _, ok := rates[SQLBatch]; ok || rates[RPC] != 5 {
+		t.Errorf("2s rates = %v, want only RPC at 5/s", rates)
+	}
+	if rates = mr.rates(500 * time.Millisecond); rates[RPC] != 5 {
+		t.Errorf("sub-second window rates = %v, want the current second", rates)
+	}
+}
+
+func TestMessageRatesBucketReuse(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	mr := newMessageRates()
+	mr.now = clock.Now
+
+	mr.record(SQLBatch)
+	// 一整圈之后同一个桶被重新使用，旧计数不计入
+	clock.advance(MAX_RATE_WINDOW)
+	mr.record(RPC)
+	rates := mr.rates(MAX_RATE_WINDOW)
+	if _, ok := rates[SQLBatch]; ok {
+		t.Errorf("rates = %v, counts older than MAX_RATE_WINDOW leaked", rates)
+	}
+	if want := 1 / MAX_RATE_WINDOW.Seconds(); rates[RPC] != want {
+		t.Errorf("RPC rate = %v, want %v", rates[RPC], want)
+	}
+
+	mr.reset()
+	if rates := mr.rates(MAX_RATE_WINDOW); len(rates) != 0 {
+		t.Errorf("rates after reset = %v", rates)
+	}
+}
+
+func TestBridgeMessageRates(t *testing.T) {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.EnableMessageRates()
+	})
+	batch := sqlBatchPacket("SELECT 1")
+	rpc := rpcPacket("sp_who", 0)
+	h.connect(batch, batch, rpc)
+	waitWritten(t, h.backend(0), 2*len(batch)+len(rpc))
+
+	rates := h.ba.MessageRates(MAX_RATE_WINDOW)
+	seconds := MAX_RATE_WINDOW.Seconds()
+	if rates[SQLBatch]*seconds != 2 || rates[RPC]*seconds != 1 {
+		t.Errorf("MessageRates = %v, want 2 batches and 1 RPC over the window", rates)
+	}
+	if rates := NewBridgeAcceptor("1433", "db:1433").MessageRates(time.Minute); rates != nil {
+		t.Errorf("MessageRates without EnableMessageRates = %v, want nil", rates)
+	}
+}
@@ -0,0 +1,189 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// TLS记录的内容类型(ChangeCipherSpec、Alert、Handshake、ApplicationData)，
+// 与TDS头部类型不重叠，用于识别加密后直接在TCP上传输的TLS记录
+const (
+	tlsRecordTypeFirst = 20
+	tlsRecordTypeLast  = 23
+	tlsRecordHeaderLen = 5
+)
+
+// TDS_READER_BUFFER_SIZE TDSReader的缓冲区大小，足以容纳协商包大小上限(32767)以内的任一数据包，
+// 使PeekPacket可直接返回缓冲区中的切片
+const TDS_READER_BUFFER_SIZE = 0x8000 + HEADER_SIZE
+
+// TDSReader 从字节流中按TDS数据包读取，头部或有效载荷跨多次读取时自动拼接。
+// 底层以大块读取填充缓冲区，流水线发送的多个数据包只需一次系统调用。
+type TDSReader struct {
+	r *bufio.Reader
+	// 上一次PeekPacket返回、尚未从缓冲区丢弃的字节数
+	pending int
+}
+
+// NewTDSReader 创建新的TDSReader
+func NewTDSReader(r io.Reader) *TDSReader {
+	return &TDSReader{
+		r: bufio.NewReaderSize(r, TDS_READER_BUFFER_SIZE),
+	}
+}
+
+// discardPending 丢弃上一次PeekPacket返回的数据
+func (tr *TDSReader) discardPending() {
+	if tr.pending > 0 {
+		tr.r.Discard(tr.pending)
+		tr.pending = 0
+	}
+}
+
+// NextIsTLSRecord 检查下一帧是否为原始TLS记录(而非TDS数据包)
+func (tr *TDSReader) NextIsTLSRecord() (bool, error) {
+	tr.discardPending()
+	b, err := tr.r.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	return b[0] >= tlsRecordTypeFirst && b[0] <= tlsRecordTypeLast, nil
+}
+
+// ReadMessage 从r中读取一个完整的TDS消息(直到END_OF_MESSAGE)，按第一个数据包的类型构造消息，
+// 用于读回WriteTo写出的消息。不做预读，r中该消息之后的数据保持未读；
+// r在消息开始前结束时返回io.EOF，在消息中间结束时返回io.ErrUnexpectedEOF。
+func ReadMessage(r io.Reader) (TDSMessage, error) {
+	var msg TDSMessage
+	for {
+		header := make([]byte, HEADER_SIZE)
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF && msg != nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		tdsHeader := NewTDSHeader(header)
+		if tdsHeader.LengthIncludingHeader() < HEADER_SIZE {
+			return nil, fmt.Errorf("%w: packet length %d smaller than header", ErrProtocol, tdsHeader.LengthIncludingHeader())
+		}
+		payload := make([]byte, tdsHeader.PayloadSize())
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		packet := &TDSPacket{Header: tdsHeader, Payload: payload}
+		if msg == nil {
+			msg = CreateTDSMessageFromFirstPacket(packet)
+		} else {
+			msg.AddPacket(packet)
+		}
+		if tdsHeader.Status().IsEndOfMessage() {
+			return msg, nil
+		}
+	}
+}
+
+// ReadTLSRecord 读取一条完整的原始TLS记录(含5字节记录头)
+func (tr *TDSReader) ReadTLSRecord() ([]byte, error) {
+	tr.discardPending()
+	header := make([]byte, tlsRecordHeaderLen)
+	if _, err := io.ReadFull(tr.r, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[3:]))
+	record := make([]byte, tlsRecordHeaderLen+length)
+	copy(record, header)
+	if _, err := io.ReadFull(tr.r, record[tlsRecordHeaderLen:]); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// ReadPacket 读取一个完整的TDS数据包
+func (tr *TDSReader) ReadPacket() (*TDSPacket, error) {
+	tr.discardPending()
+	header := make([]byte, HEADER_SIZE)
+	if _, err := io.ReadFull(tr.r, header); err != nil {
+		return nil, err
+	}
+	tdsHeader := NewTDSHeader(header)
+	if tdsHeader.LengthIncludingHeader() < HEADER_SIZE {
+		return nil, fmt.Errorf("%w: packet length %d smaller than header", ErrProtocol, tdsHeader.LengthIncludingHeader())
+	}
+	payload := make([]byte, tdsHeader.PayloadSize())
+	if _, err := io.ReadFull(tr.r, payload); err != nil {
+		return nil, err
+	}
+	return &TDSPacket{
+		Header:  tdsHeader,
+		Payload: payload,
+	}, nil
+}
+
+// PeekPacket 读取一个完整的TDS数据包(头部+有效载荷)而不复制：
+// 返回的切片指向内部缓冲区，仅在下一次调用TDSReader的方法之前有效。
+// 数据包大于缓冲区时退化为分配新切片。
+func (tr *TDSReader) PeekPacket() ([]byte, error) {
+	tr.discardPending()
+	header, err := tr.r.Peek(HEADER_SIZE)
+	if err != nil {
+		if err == io.EOF && tr.r.Buffered() > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	length := int(header[2])<<8 | int(header[3])
+	if length < HEADER_SIZE {
+		return nil, fmt.Errorf("%w: packet length %d smaller than header", ErrProtocol, length)
+	}
+	if length > tr.r.Size() {
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(tr.r, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+	frame, err := tr.r.Peek(length)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	tr.pending = length
+	return frame, nil
+}
+
+// ReadMessage 读取数据包直到END_OF_MESSAGE，返回组装好的消息
+func (tr *TDSReader) ReadMessage() (TDSMessage, error) {
+	return tr.ReadMessageLimit(0)
+}
+
+// ReadMessageLimit 与ReadMessage相同，但消息总长度(含头部)超过maxSize时返回ErrBufferLimit。
+// maxSize为0表示不限制。
+func (tr *TDSReader) ReadMessageLimit(maxSize int) (TDSMessage, error) {
+	var msg TDSMessage
+	size := 0
+	for msg == nil || !msg.IsComplete() {
+		packet, err := tr.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		size += packet.Header.LengthIncludingHeader()
+		if maxSize > 0 && size > maxSize {
+			return nil, fmt.Errorf("%w: message exceeds %d bytes", ErrBufferLimit, maxSize)
+		}
+		if msg == nil {
+			msg = CreateTDSMessageFromFirstPacket(packet)
+		} else {
+			msg.AddPacket(packet)
+		}
+	}
+	return msg, nil
+}
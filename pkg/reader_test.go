@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// pipelinedPackets 构造连续发送的count个大小不同的数据包
+func pipelinedPackets(count int) [][]byte {
+	packets := make([][]byte, count)
+	for i := range packets {
+		payload := bytes.Repeat([]byte{byte(i)}, 1+i*37%500)
+		packets[i] = buildPacket(SQLBatch, END_OF_MESSAGE, byte(i), payload)
+	}
+	return packets
+}
+
+func TestPeekPacketStraddlingReads(t *testing.T) {
+	packets := pipelinedPackets(20)
+	stream := bytes.Join(packets, nil)
+
+	for _, tc := range []struct {
+		name string
+		r    io.Reader
+	}{
+		{"one byte per read", iotest.OneByteReader(bytes.NewReader(stream))},
+		{"half reads", iotest.HalfReader(bytes.NewReader(stream))},
+		{"single read", bytes.NewReader(stream)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := NewTDSReader(tc.r)
+			for i, want := range packets {
+				got, err := reader.PeekPacket()
+				if err != nil {
+					t.Fatalf("packet %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("packet %d = %x, want %x", i, got, want)
+				}
+			}
+			if _, err := reader.PeekPacket(); err != io.EOF {
+				t.Errorf("after the last packet got %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestPeekPacketLargerThanBuffer(t *testing.T) {
+	large := buildPacket(SQLBatch, NORMAL, 1, bytes.Repeat([]byte{0xAB}, 0xFFFF-HEADER_SIZE))
+	small := sqlBatchPacket("SELECT 1")
+	reader := NewTDSReader(iotest.HalfReader(bytes.NewReader(append(append([]byte{}, large...), small...))))
+
+	got, err := reader.PeekPacket()
+	if err != nil || !bytes.Equal(got, large) {
+		t.Fatalf("large packet: %d bytes, err %v", len(got), err)
+	}
+	if got, err = reader.PeekPacket(); err != nil || !bytes.Equal(got, small) {
+		t.Fatalf("packet after the large one = %x, err %v", got, err)
+	}
+}
+
+func TestPeekPacketTruncated(t *testing.T) {
+	packet := sqlBatchPacket("SELECT 1")
+	for _, n := range []int{3, HEADER_SIZE, len(packet) - 1} {
+		_, err := NewTDSReader(bytes.NewReader(packet[:n])).PeekPacket()
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("stream cut at %d bytes: got %v, want io.ErrUnexpectedEOF", n, err)
+		}
+	}
+
+	short := sqlBatchPacket("SELECT 1")
+	short[3] = 4
+	if _, err := NewTDSReader(bytes.NewReader(short)).PeekPacket(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("length below header: got %v, want ErrProtocol", err)
+	}
+}
+
+func TestTDSReaderMixedFrames(t *testing.T) {
+	batch := sqlBatchPacket("SELECT 1")
+	record := []byte{23, 3, 3, 0, 3, 1, 2, 3}
+	reader := NewTDSReader(iotest.OneByteReader(bytes.NewReader(bytes.Join([][]byte{batch, record, batch}, nil))))
+
+	if _, err := reader.PeekPacket(); err != nil {
+		t.Fatal(err)
+	}
+	if isTLS, err := reader.NextIsTLSRecord(); err != nil || !isTLS {
+		t.Fatalf("NextIsTLSRecord() = %v, %v, want true", isTLS, err)
+	}
+	if got, err := reader.ReadTLSRecord(); err != nil || !bytes.Equal(got, record) {
+		t.Fatalf("ReadTLSRecord() = %x, %v", got, err)
+	}
+	packet, err := reader.ReadPacket()
+	if err != nil || !bytes.Equal(packet.Bytes(), batch) {
+		t.Fatalf("ReadPacket() after TLS record = %v, %v", packet, err)
+	}
+}
+
+func TestReadMessageLimit(t *testing.T) {
+	payload := sqlBatchPayload("SELECT 1")
+	stream := append(buildPacket(SQLBatch, NORMAL, 1, payload[:10]), buildPacket(SQLBatch, END_OF_MESSAGE, 2, payload[10:])...)
+
+	msg, err := NewTDSReader(bytes.NewReader(stream)).ReadMessageLimit(len(stream))
+	if err != nil || len(msg.GetPackets()) != 2 || !bytes.Equal(msg.AssemblePayload(), payload) {
+		t.Fatalf("ReadMessageLimit at the exact size = %v, %v", msg, err)
+	}
+	if _, err := NewTDSReader(bytes.NewReader(stream)).ReadMessageLimit(len(stream) - 1); !errors.Is(err, ErrBufferLimit) {
+		t.Errorf("ReadMessageLimit below the size: got %v, want ErrBufferLimit", err)
+	}
+}
+
+// repeatReader 无限重复data的读取器，每次读取最多返回data的长度，模拟逐段到达的套接字
+type repeatReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *repeatReader) Read(b []byte) (int, error) {
+	n := copy(b, r.data[r.offset:])
+	r.offset = (r.offset + n) % len(r.data)
+	return n, nil
+}
+
+func BenchmarkReadPackets(b *testing.B) {
+	stream := bytes.Join(pipelinedPackets(64), nil)
+	b.Run("naive", func(b *testing.B) {
+		r := &repeatReader{data: stream}
+		header := make([]byte, HEADER_SIZE)
+		for i := 0; i < b.N; i++ {
+			if _, err := io.ReadFull(r, header); err != nil {
+				b.Fatal(err)
+			}
+			payload := make([]byte, NewTDSHeader(header).PayloadSize())
+			if _, err := io.ReadFull(r, payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "packets/s")
+	})
+	b.Run("peek", func(b *testing.B) {
+		reader := NewTDSReader(&repeatReader{data: stream})
+		for i := 0; i < b.N; i++ {
+			if _, err := reader.PeekPacket(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "packets/s")
+	})
+}
+
+func TestMessageWriteToReadMessageRoundTrip(t *testing.T) {
+	payload := rpcPayload("sp_executesql", 0, nvarcharParam("@stmt", "SELECT @p"), intParam("@p", 42))
+	original := NewRPCRequestMessageWithPacket(NewTDSPacketFromBuffer(buildPacket(RPC, NORMAL, 1, payload[:20])))
+	original.AddPacket(NewTDSPacketFromBuffer(buildPacket(RPC, NORMAL, 2, payload[20:40])))
+	original.AddPacket(NewTDSPacketFromBuffer(buildPacket(RPC, END_OF_MESSAGE, 3, payload[40:])))
+
+	var buf bytes.Buffer
+	n, err := original.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo = %d, %v; buffer has %d bytes", n, err, buf.Len())
+	}
+	// 之后的数据保持未读
+	buf.Write(sqlBatchPacket("SELECT 1"))
+
+	msg, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	rpc, ok := msg.(*RPCRequestMessage)
+	if !ok {
+		t.Fatalf("ReadMessage returned %T, want *RPCRequestMessage", msg)
+	}
+	if len(rpc.GetPackets()) != 3 || !bytes.Equal(rpc.AssemblePayload(), payload) {
+		t.Fatalf("read %d packets, want 3 carrying the original payload", len(rpc.GetPackets()))
+	}
+	if name, err := rpc.GetProcName(); err != nil || name != "sp_executesql" {
+		t.Errorf("GetProcName() = %q, %v", name, err)
+	}
+	if next, err := ReadMessage(&buf); err != nil {
+		t.Errorf("following message: %v", err)
+	} else if _, ok := next.(*SQLBatchMessage); !ok {
+		t.Errorf("following message is %T, want *SQLBatchMessage", next)
+	}
+	if _, err := ReadMessage(&buf); err != io.EOF {
+		t.Errorf("ReadMessage at the end = %v, want io.EOF", err)
+	}
+}
+
+func TestReadMessageTruncated(t *testing.T) {
+	first := buildPacket(RPC, NORMAL, 1, []byte{1, 2, 3})
+	for name, data := range map[string][]byte{
+		"missing last packet": first,
+		"cut inside payload":  first[:len(first)-1],
+	} {
+		if _, err := ReadMessage(bytes.NewReader(data)); err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: err = %v, want io.ErrUnexpectedEOF", name, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package pkg
+
+// Reassembler 将客户端数据包组装为消息。AddPacket按到达顺序接收每个数据包，
+// 消息完成时返回该消息和true，否则返回nil和false。每个连接使用独立的实例，只在客户端转发goroutine中调用。
+type Reassembler interface {
+	AddPacket(packet *TDSPacket) (TDSMessage, bool)
+}
+
+// DefaultReassembler 默认的组装方式：消息类型由第一个数据包决定，收到END_OF_MESSAGE时完成
+type DefaultReassembler struct {
+	message TDSMessage
+}
+
+// NewDefaultReassembler 创建新的DefaultReassembler
+func NewDefaultReassembler() *DefaultReassembler {
+	return &DefaultReassembler{}
+}
+
+// AddPacket 添加数据包，收到END_OF_MESSAGE时返回完整的消息
+func (r *DefaultReassembler) AddPacket(packet *TDSPacket) (TDSMessage, bool) {
+	if r.message == nil {
+		r.message = CreateTDSMessageFromFirstPacket(packet)
+	} else {
+		r.message.AddPacket(packet)
+	}
+	if (packet.Header.StatusBitMask() & END_OF_MESSAGE) != END_OF_MESSAGE {
+		return nil, false
+	}
+	message := r.message
+	r.message = nil
+	return message, true
+}
+
+// SetReassembler 设置本连接组装客户端消息的方式，替换默认的DefaultReassembler。
+// 只能在连接启动前调用，通常在连接策略处理函数中通过AcceptInfo.Connection设置。
+// 消息事件、日志和拦截都在组装器返回消息时进行；需缓存的消息(改写、拦截)也在此时才转发，
+// 其余数据包仍逐个转发。关闭解析时不使用组装器。
+func (bc *BridgedConnection) SetReassembler(reassembler Reassembler) {
+	bc.reassembler = reassembler
+}
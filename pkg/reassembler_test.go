@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDefaultReassembler(t *testing.T) {
+	payload := sqlBatchPayload("SELECT 1")
+	r := NewDefaultReassembler()
+	if msg, done := r.AddPacket(NewTDSPacketFromBuffer(buildPacket(SQLBatch, NORMAL, 1, payload[:10]))); done || msg != nil {
+		t.Fatalf("first packet completed a message: %v", msg)
+	}
+	msg, done := r.AddPacket(NewTDSPacketFromBuffer(buildPacket(SQLBatch, END_OF_MESSAGE, 2, payload[10:])))
+	if !done {
+		t.Fatal("END_OF_MESSAGE did not complete the message")
+	}
+	batch, ok := msg.(*SQLBatchMessage)
+	if !ok {
+		t.Fatalf("message type %T, want *SQLBatchMessage", msg)
+	}
+	if len(batch.GetPackets()) != 2 || !bytes.Equal(batch.AssemblePayload(), payload) {
+		t.Errorf("assembled %d packets with payload %x", len(batch.GetPackets()), batch.AssemblePayload())
+	}
+
+	// 完成后重新开始，下一个消息的类型由其第一个数据包决定
+	msg, done = r.AddPacket(NewTDSPacketFromBuffer(buildPacket(AttentionSignal, END_OF_MESSAGE, 1, nil)))
+	if !done || msg.GetPackets()[0].Header.Type() != AttentionSignal || len(msg.GetPackets()) != 1 {
+		t.Errorf("second message = %v, %v", msg, done)
+	}
+}
+
+// countingReassembler 忽略END_OF_MESSAGE，每n个数据包组成一个消息
+type countingReassembler struct {
+	n       int
+	message TDSMessage
+	packets int
+}
+
+func (r *countingReassembler) AddPacket(packet *TDSPacket) (TDSMessage, bool) {
+	if r.message == nil {
+		r.message = CreateTDSMessageFromFirstPacket(packet)
+	} else {
+		r.message.AddPacket(packet)
+	}
+	r.packets++
+	if r.packets < r.n {
+		return nil, false
+	}
+	message := r.message
+	r.message, r.packets = nil, 0
+	return message, true
+}
+
+func TestCustomReassembler(t *testing.T) {
+	messages := make(chan TDSMessage, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetConnectionPolicyHandler(func(info *AcceptInfo) (*ConnectionConfig, error) {
+			info.Connection.SetReassembler(&countingReassembler{n: 3})
+			return nil, nil
+		})
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			messages <- msg
+		})
+	})
+
+	var stream []byte
+	for _, text := range []string{"SELECT 1", "SELECT 2", "SELECT 3", "SELECT 4"} {
+		stream = append(stream, sqlBatchPacket(text)...)
+	}
+	h.connect(stream)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(stream))
+	if !bytes.Equal(backend.Written(), stream) {
+		t.Errorf("backend received %x, want the packets forwarded unchanged", backend.Written())
+	}
+
+	select {
+	case msg := <-messages:
+		if n := len(msg.GetPackets()); n != 3 {
+			t.Errorf("custom reassembler produced a message of %d packets, want 3", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message event")
+	}
+	// 第四个数据包尚未凑满一个消息
+	select {
+	case msg := <-messages:
+		t.Errorf("unexpected message of %d packets", len(msg.GetPackets()))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
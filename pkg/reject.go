@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"net"
+	"time"
+)
+
+// REJECT_READ_TIMEOUT 拒绝连接时与客户端交换PreLogin和Login7的总时长
+const REJECT_READ_TIMEOUT = 5 * time.Second
+
+// REJECT_MESSAGE_LIMIT 拒绝连接时读取的单个客户端消息的最大字节数(含头部)，足以容纳PreLogin和Login7
+const REJECT_MESSAGE_LIMIT = 8 * 1024
+
+// MAX_GRACEFUL_REJECTIONS 同时以TDS错误回应的被拒绝连接的最大数量，超出时直接关闭连接
+const MAX_GRACEFUL_REJECTIONS = 64
+
+// rejectConnection 拒绝一个尚未桥接的客户端连接。
+// 驱动程序只在登录阶段接受错误响应，因此先代替服务器以不支持加密(ENCRYPT_NOT_SUP)回应PreLogin，
+// 读取客户端随后的Login7，再回复一个TDS错误后关闭，使驱动程序报告可读的错误而不是网络错误。
+// 客户端要求加密(ENCRYPT_ON或ENCRYPT_REQ)时无法以明文继续登录，直接关闭。
+// 拒绝发生在桥接器过载时，因此每个消息限制为REJECT_MESSAGE_LIMIT字节，整个交换限制在REJECT_READ_TIMEOUT内，
+// 同时进行的交换超过MAX_GRACEFUL_REJECTIONS时直接关闭。
+func (ba *BridgeAcceptor) rejectConnection(clientConn net.Conn, reason error, number int32, message string) {
+	defer closeConn(clientConn, ba.abortiveClose)
+	ba.onConnectionRejected(clientConn, reason)
+
+	if ba.gracefulRejections.Add(1) > MAX_GRACEFUL_REJECTIONS {
+		ba.gracefulRejections.Add(-1)
+		return
+	}
+	defer ba.gracefulRejections.Add(-1)
+
+	clientConn.SetDeadline(time.Now().Add(REJECT_READ_TIMEOUT))
+	reader := NewTDSReader(clientConn)
+	msg, err := reader.ReadMessageLimit(REJECT_MESSAGE_LIMIT)
+	if err != nil {
+		return
+	}
+
+	// 未经PreLogin直接登录的客户端(如TDS 7之前的登录)直接回复错误
+	if preLogin, ok := msg.(*PreLoginRequestMessage); ok {
+		if encryption, ok := preLogin.GetEncryption(); ok && (encryption == ENCRYPT_ON || encryption == ENCRYPT_REQ) {
+			return
+		}
+		if _, err := clientConn.Write(bridgePreLoginResponse()); err != nil {
+			return
+		}
+		if _, err := reader.ReadMessageLimit(REJECT_MESSAGE_LIMIT); err != nil {
+			return
+		}
+	}
+	clientConn.Write(BuildErrorResponse(number, 20, message))
+}
+
+// rejectLogin 拒绝已由桥接器回应过PreLogin、正在等待登录响应的客户端连接，直接回复TDS错误后关闭
+func (ba *BridgeAcceptor) rejectLogin(clientConn net.Conn, reason error, number int32, message string) {
+	defer closeConn(clientConn, ba.abortiveClose)
+	ba.onConnectionRejected(clientConn, reason)
+
+	clientConn.SetWriteDeadline(time.Now().Add(REJECT_READ_TIMEOUT))
+	clientConn.Write(BuildErrorResponse(number, 20, message))
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"testing"
+)
+
+// preLoginPacket 构造声明指定加密选项的PreLogin请求
+func preLoginPacket(encryption byte) []byte {
+	payload := BuildPreLoginPayload([]PreLoginOption{
+		{Token: PreLoginVersion, Data: []byte{0x10, 0x00, 0x07, 0xD0, 0x00, 0x00}},
+		{Token: PreLoginEncryption, Data: []byte{encryption}},
+		{Token: PreLoginMARS, Data: []byte{0}},
+	})
+	return buildPacket(PreLoginMessage, END_OF_MESSAGE, 1, payload)
+}
+
+// limitedHarness 创建最多一个连接的装置，并占满该连接
+func limitedHarness(t *testing.T) *bridgeHarness {
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxConnections(1)
+	})
+	h.connect()
+	waitConnections(t, h.ba, 1)
+	return h
+}
+
+func TestRejectAtConnectionLimitSendsLoginError(t *testing.T) {
+	h := limitedHarness(t)
+
+	client := h.connect(preLoginPacket(ENCRYPT_OFF))
+
+	// 桥接器代替服务器回应PreLogin，声明不支持加密
+	preLoginResponse := waitWritten(t, client, HEADER_SIZE)
+	header := NewTDSHeader(preLoginResponse)
+	if header.Type() != TabularResult {
+		t.Fatalf("PreLogin response type = %s, want %s", header.Type(), TabularResult)
+	}
+	preLoginResponse = waitWritten(t, client, header.LengthIncludingHeader())
+	options, err := ParsePreLoginOptions(preLoginResponse[HEADER_SIZE:header.LengthIncludingHeader()])
+	if err != nil {
+		t.Fatalf("parse PreLogin response: %v", err)
+	}
+	if encryption, ok := preLoginEncryption(options); !ok || encryption != ENCRYPT_NOT_SUP {
+		t.Fatalf("PreLogin response encryption = %d, %v, want ENCRYPT_NOT_SUP", encryption, ok)
+	}
+	if written := client.Written(); len(written) != header.LengthIncludingHeader() {
+		t.Fatalf("error sent before Login7: %d bytes written", len(written))
+	}
+
+	// 收到Login7后回复说明原因的登录错误
+	client.feed(testLogin7{version: TDSVersion74, user: "sa"}.packet())
+	waitFor(t, "client close", client.isClosed)
+	errs := responseErrors(t, client.Written()[header.LengthIncludingHeader():])
+	if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_SERVER_BUSY {
+		t.Fatalf("client errors = %v, want one %d error", errs, BRIDGE_ERROR_SERVER_BUSY)
+	}
+	if errs[0].Message == "" || errs[0].ServerName != BRIDGE_SERVER_NAME {
+		t.Fatalf("error %+v is not readable", errs[0])
+	}
+	if n := len(h.ba.Connections()); n != 1 {
+		t.Fatalf("%d active connections, want 1", n)
+	}
+}
+
+func TestRejectClosesClientsRequiringEncryption(t *testing.T) {
+	for _, encryption := range []byte{ENCRYPT_ON, ENCRYPT_REQ} {
+		h := limitedHarness(t)
+		client := h.connect(preLoginPacket(encryption))
+		waitFor(t, "client close", client.isClosed)
+		if written := client.Written(); len(written) != 0 {
+			t.Fatalf("encryption %d: %d bytes sent to a client requiring encryption", encryption, len(written))
+		}
+	}
+}
+
+func TestRejectWithoutPreLogin(t *testing.T) {
+	h := limitedHarness(t)
+	client := h.connect(testLogin7{version: TDSVersion74, user: "sa"}.packet())
+	waitFor(t, "client close", client.isClosed)
+	errs := responseErrors(t, client.Written())
+	if len(errs) != 1 || errs[0].Number != BRIDGE_ERROR_SERVER_BUSY {
+		t.Fatalf("client errors = %v, want one %d error", errs, BRIDGE_ERROR_SERVER_BUSY)
+	}
+}
+
+func TestRejectClosesOversizedMessage(t *testing.T) {
+	h := limitedHarness(t)
+	// 不结束的大消息超过上限后直接关闭，不再继续缓冲
+	chunk := buildPacket(PreLoginMessage, NORMAL, 1, make([]byte, 4000))
+	client := h.connect(chunk, chunk, chunk)
+	waitFor(t, "client close", client.isClosed)
+	if written := client.Written(); len(written) != 0 {
+		t.Fatalf("%d bytes sent in reply to an oversized message", len(written))
+	}
+}
+
+func TestRejectBeyondGracefulLimitCloses(t *testing.T) {
+	h := limitedHarness(t)
+	h.ba.gracefulRejections.Store(MAX_GRACEFUL_REJECTIONS)
+	defer h.ba.gracefulRejections.Store(0)
+
+	client := h.connect(preLoginPacket(ENCRYPT_OFF))
+	waitFor(t, "client close", client.isClosed)
+	if written := client.Written(); len(written) != 0 {
+		t.Fatalf("%d bytes sent while graceful rejections are at the limit", len(written))
+	}
+	if n := h.ba.gracefulRejections.Load(); n != MAX_GRACEFUL_REJECTIONS {
+		t.Errorf("graceful rejections = %d after a plain close, want %d", n, MAX_GRACEFUL_REJECTIONS)
+	}
+}
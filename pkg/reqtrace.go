@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// 请求未得到响应的原因
+var (
+	errRequestNotForwarded = errors.New("tdsbridge: request not forwarded")
+	errRequestInterrupted  = errors.New("tdsbridge: connection closed before response")
+)
+
+// TracedRequest 开始跟踪时的请求信息
+type TracedRequest struct {
+	Start time.Time
+	Type  HeaderType
+	// Statement 批处理文本或sp_executesql的语句，经过SetRequestTracer的redact函数处理
+	Statement string
+	// ProcName RPC请求的过程名
+	ProcName string
+}
+
+// TracedResponse 结束跟踪时的响应信息
+type TracedResponse struct {
+	End time.Time
+	// RowCount 响应中带行数的DONE令牌的行数之和
+	RowCount uint64
+	// Errors 响应中的ERROR令牌
+	Errors []*ServerError
+	// Err 请求未得到响应的原因(连接断开或请求未转发)，得到响应时为nil
+	Err error
+}
+
+// RequestTracer 在完整的SQLBatch或RPC请求被解析时调用，返回在对应的最终响应完成时调用的结束函数(可为nil)。
+// 用于接入分布式跟踪而不让本包依赖具体的跟踪库，例如OpenTelemetry：
+//
+//	ba.SetRequestTracer(func(bc *pkg.BridgedConnection, req *pkg.TracedRequest) func(*pkg.TracedResponse) {
+//		_, span := tracer.Start(context.Background(), req.Type.String(), trace.WithTimestamp(req.Start),
+//			trace.WithAttributes(attribute.String("db.statement", req.Statement),
+//				attribute.String("db.operation", req.ProcName)))
+//		return func(resp *pkg.TracedResponse) {
+//			span.SetAttributes(attribute.Int64("db.row_count", int64(resp.RowCount)))
+//			for _, e := range resp.Errors {
+//				span.RecordError(e)
+//			}
+//			if resp.Err != nil || len(resp.Errors) > 0 {
+//				span.SetStatus(codes.Error, "")
+//			}
+//			span.End(trace.WithTimestamp(resp.End))
+//		}
+//	})
+type RequestTracer func(bc *BridgedConnection, req *TracedRequest) func(*TracedResponse)
+
+// SetRequestTracer 设置请求跟踪函数，按请求与响应的顺序配对(每个请求对应下一个最终响应)。
+// redact非nil时先用它处理语句再交给跟踪函数，例如传入NormalizeSQL去掉字面量。
+// 连接断开时仍未得到响应的请求以非nil的Err结束。需要解析消息，关闭解析时不生效。需在Start之前调用。
+func (ba *BridgeAcceptor) SetRequestTracer(tracer RequestTracer, redact func(string) string) {
+	ba.requestTracer = tracer
+	ba.requestTraceRedactor = redact
+}
+
+// requestSpans 一个连接上等待响应的请求的结束函数，按请求顺序排列
+type requestSpans struct {
+	mu      sync.Mutex
+	pending []requestSpan
+}
+
+// requestSpan 一个等待响应的请求及其结束函数
+type requestSpan struct {
+	msg TDSMessage
+	end func(*TracedResponse)
+}
+
+// push 加入一个等待响应的请求
+func (rs *requestSpans) push(msg TDSMessage, end func(*TracedResponse)) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.pending = append(rs.pending, requestSpan{msg: msg, end: end})
+}
+
+// popOldest 取出最早的请求，用于配对响应
+func (rs *requestSpans) popOldest() func(*TracedResponse) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.pending) == 0 {
+		return nil
+	}
+	span := rs.pending[0]
+	rs.pending = rs.pending[1:]
+	return span.end
+}
+
+// take 取出为msg开始的请求，用于被丢弃而不会有响应的请求；msg未开始跟踪时返回nil
+func (rs *requestSpans) take(msg TDSMessage) func(*TracedResponse) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for i := len(rs.pending) - 1; i >= 0; i-- {
+		if rs.pending[i].msg == msg {
+			span := rs.pending[i]
+			rs.pending = append(rs.pending[:i], rs.pending[i+1:]...)
+			return span.end
+		}
+	}
+	return nil
+}
+
+// drain 取出所有等待响应的请求
+func (rs *requestSpans) drain() []func(*TracedResponse) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	ends := make([]func(*TracedResponse), len(rs.pending))
+	for i, span := range rs.pending {
+		ends[i] = span.end
+	}
+	rs.pending = nil
+	return ends
+}
+
+// startRequestSpan 对完整的SQLBatch或RPC请求开始跟踪
+func (bc *BridgedConnection) startRequestSpan(msg TDSMessage) {
+	tracer := bc.BridgeAcceptor.requestTracer
+	if tracer == nil {
+		return
+	}
+	req := &TracedRequest{Start: time.Now()}
+	switch m := msg.(type) {
+	case *SQLBatchMessage:
+		req.Type = SQLBatch
+		req.Statement = m.GetBatchText()
+	case *RPCRequestMessage:
+		req.Type = RPC
+		procName, err := m.GetProcName()
+		if err != nil {
+			return
+		}
+		req.ProcName = procName
+		if isExecuteSQL(procName) {
+			if params, err := m.GetParameters(); err == nil && len(params) > 0 {
+				req.Statement, _ = params[0].Text()
+			}
+		}
+	default:
+		return
+	}
+	if redact := bc.BridgeAcceptor.requestTraceRedactor; redact != nil && req.Statement != "" {
+		req.Statement = redact(req.Statement)
+	}
+	end := tracer(bc, req)
+	if end == nil {
+		end = func(*TracedResponse) {}
+	}
+	bc.requestSpans.push(msg, end)
+}
+
+// endRequestSpan 以最终响应结束最早的请求的跟踪
+func (bc *BridgedConnection) endRequestSpan(result *TabularResultMessage) {
+	if bc.BridgeAcceptor.requestTracer == nil {
+		return
+	}
+	end := bc.requestSpans.popOldest()
+	if end == nil {
+		return
+	}
+	resp := &TracedResponse{End: time.Now()}
+	for _, done := range result.DoneTokens() {
+		if done.HasRowCount() {
+			resp.RowCount += done.RowCount
+		}
+	}
+	resp.Errors, _ = result.GetServerErrors()
+	end(resp)
+}
+
+// abandonRequestSpan 结束被桥接器丢弃而不会有响应的请求的跟踪，请求未开始跟踪时(如无法解析过程名)不做处理
+func (bc *BridgedConnection) abandonRequestSpan(msg TDSMessage) {
+	if bc.BridgeAcceptor.requestTracer == nil {
+		return
+	}
+	if end := bc.requestSpans.take(msg); end != nil {
+		end(&TracedResponse{End: time.Now(), Err: errRequestNotForwarded})
+	}
+}
+
+// endPendingRequestSpans 连接断开时结束所有未得到响应的请求
+func (bc *BridgedConnection) endPendingRequestSpans() {
+	err := bc.DisconnectReason()
+	if err == nil {
+		err = errRequestInterrupted
+	}
+	for _, end := range bc.requestSpans.drain() {
+		end(&TracedResponse{End: time.Now(), Err: err})
+	}
+}
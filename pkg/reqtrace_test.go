@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// tracedSpan 一次请求跟踪的开始和结束信息
+type tracedSpan struct {
+	req  *TracedRequest
+	resp *TracedResponse
+}
+
+// tracingHarness 创建设置了请求跟踪函数的装置，返回按开始顺序排列的跟踪记录
+func tracingHarness(t *testing.T, configure func(ba *BridgeAcceptor)) (*bridgeHarness, func() []tracedSpan) {
+	var mu sync.Mutex
+	var spans []*tracedSpan
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetRequestTracer(func(bc *BridgedConnection, req *TracedRequest) func(*TracedResponse) {
+			mu.Lock()
+			defer mu.Unlock()
+			span := &tracedSpan{req: req}
+			spans = append(spans, span)
+			return func(resp *TracedResponse) {
+				mu.Lock()
+				defer mu.Unlock()
+				span.resp = resp
+			}
+		}, NormalizeSQL)
+		if configure != nil {
+			configure(ba)
+		}
+	})
+	return h, func() []tracedSpan {
+		mu.Lock()
+		defer mu.Unlock()
+		result := make([]tracedSpan, len(spans))
+		for i, span := range spans {
+			result[i] = *span
+		}
+		return result
+	}
+}
+
+func TestRequestTracerPairsRequestsWithResponses(t *testing.T) {
+	h, spans := tracingHarness(t, nil)
+	first, second := sqlBatchPacket("SELECT * FROM t WHERE id = 42"), rpcPacket("sp_who", 0)
+	client := h.connect(first, second)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(first)+len(second))
+
+	firstResponse := doneResponse(DONE_FINAL|DONE_COUNT, 7)
+	secondResponse := BuildErrorResponse(50000, 16, "failed")
+	backend.feed(firstResponse)
+	backend.feed(secondResponse)
+	waitWritten(t, client, len(firstResponse)+len(secondResponse))
+
+	waitFor(t, "both spans ended", func() bool {
+		got := spans()
+		return len(got) == 2 && got[0].resp != nil && got[1].resp != nil
+	})
+	got := spans()
+	if req := got[0].req; req.Type != SQLBatch || req.Statement != NormalizeSQL("SELECT * FROM t WHERE id = 42") {
+		t.Errorf("first request = %+v, want the redacted batch", req)
+	}
+	if resp := got[0].resp; resp.RowCount != 7 || len(resp.Errors) != 0 || resp.Err != nil {
+		t.Errorf("first response = %+v, want 7 rows", resp)
+	}
+	if req := got[1].req; req.Type != RPC || req.ProcName != "sp_who" {
+		t.Errorf("second request = %+v, want RPC sp_who", req)
+	}
+	if resp := got[1].resp; len(resp.Errors) != 1 || resp.Errors[0].Number != 50000 {
+		t.Errorf("second response = %+v, want error 50000", resp)
+	}
+	if got[1].resp.End.Before(got[1].req.Start) {
+		t.Error("span ended before it started")
+	}
+}
+
+func TestRequestTracerAbandonsOnlyTracedRequest(t *testing.T) {
+	h, spans := tracingHarness(t, func(ba *BridgeAcceptor) {
+		// 丢弃所有RPC请求
+		ba.SetMessageInterceptor(func(bc *BridgedConnection, msg TDSMessage) ([]byte, bool) {
+			_, isRPC := msg.(*RPCRequestMessage)
+			return nil, isRPC
+		})
+	})
+	batch := sqlBatchPacket("SELECT 1")
+	// 过程名长度超出消息，无法解析过程名，不开始跟踪
+	malformed := binary.LittleEndian.AppendUint16(sqlBatchPayload(""), 50)
+	malformedRPC := buildPacket(RPC, END_OF_MESSAGE, 1, append(malformed, 'x', 0))
+	dropped := rpcPacket("sp_who", 0)
+	client := h.connect(batch, malformedRPC, dropped)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(batch))
+	waitFor(t, "error responses for the dropped RPCs", func() bool {
+		return len(client.Written()) > 0 && len(responseErrors(t, client.Written())) > 0
+	})
+
+	// 批处理的跟踪仍在等待响应
+	waitFor(t, "dropped RPC span ended", func() bool {
+		got := spans()
+		return len(got) == 2 && got[1].resp != nil
+	})
+	got := spans()
+	if got[0].resp != nil {
+		t.Fatalf("batch span ended with %+v when an untraced RPC was dropped", got[0].resp)
+	}
+	if got[1].req.ProcName != "sp_who" || !errors.Is(got[1].resp.Err, errRequestNotForwarded) {
+		t.Errorf("dropped RPC span = %+v, %+v, want sp_who ended as not forwarded", got[1].req, got[1].resp)
+	}
+
+	backend.feed(doneResponse(DONE_FINAL|DONE_COUNT, 1))
+	waitFor(t, "batch span ended", func() bool { return spans()[0].resp != nil })
+	if resp := spans()[0].resp; resp.Err != nil || resp.RowCount != 1 {
+		t.Errorf("batch response = %+v, want 1 row", resp)
+	}
+}
+
+func TestRequestTracerEndsPendingOnDisconnect(t *testing.T) {
+	h, spans := tracingHarness(t, nil)
+	batch := sqlBatchPacket("SELECT 1")
+	client := h.connect(batch)
+	waitWritten(t, h.backend(0), len(batch))
+	client.Close()
+
+	waitFor(t, "span ended", func() bool {
+		got := spans()
+		return len(got) == 1 && got[0].resp != nil
+	})
+	if resp := spans()[0].resp; resp.Err == nil || resp.End.IsZero() {
+		t.Errorf("response = %+v, want an error for the unanswered request", resp)
+	}
+}
@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// TabularResultMessage 服务器返回的表格结果消息
+type TabularResultMessage struct {
+	*BaseTDSMessage
+
+	// 解析令牌时使用的TDS版本
+	tdsVersion TDSVersion
+}
+
+// NewTabularResultMessage 创建新的TabularResultMessage
+func NewTabularResultMessage() *TabularResultMessage {
+	return &TabularResultMessage{
+		BaseTDSMessage: NewBaseTDSMessage(),
+	}
+}
+
+// NewTabularResultMessageWithPacket 从第一个数据包创建新的TabularResultMessage
+func NewTabularResultMessageWithPacket(firstPacket *TDSPacket) *TabularResultMessage {
+	return &TabularResultMessage{
+		BaseTDSMessage: NewBaseTDSMessageWithPacket(firstPacket),
+	}
+}
+
+// SetTDSVersion 设置解析令牌时使用的TDS版本
+func (m *TabularResultMessage) SetTDSVersion(version TDSVersion) {
+	m.tdsVersion = version
+}
+
+// GetTokens 解析消息中的令牌流
+func (m *TabularResultMessage) GetTokens() ([]*Token, error) {
+	return ParseTokens(m.AssemblePayload(), m.tdsVersion)
+}
+
+// DoneTokens 按出现顺序返回响应中的DONE、DONEPROC和DONEINPROC令牌，
+// 多语句批处理的每条语句对应一个，可用于报告执行进度。令牌流无法完整解析时返回已解析部分中的令牌。
+func (m *TabularResultMessage) DoneTokens() []DoneToken {
+	tokens, _ := m.GetTokens()
+	var dones []DoneToken
+	for _, token := range tokens {
+		switch token.Type {
+		case TokenDone, TokenDoneProc, TokenDoneInProc:
+			if done, err := decodeDoneToken(token); err == nil {
+				dones = append(dones, done)
+			}
+		}
+	}
+	return dones
+}
+
+// IsFinalResponse 检查响应是否已由不带DONE_MORE的最终DONE/DONEPROC结束，
+// 中间的DONEINPROC不视为结束。令牌流无法完整解析时，退而检查消息末尾的DONE令牌。
+func (m *TabularResultMessage) IsFinalResponse() bool {
+	tokens, err := m.GetTokens()
+	if err == nil {
+		var last *DoneToken
+		for _, token := range tokens {
+			if token.Type != TokenDone && token.Type != TokenDoneProc {
+				continue
+			}
+			done, err := decodeDoneToken(token)
+			if err != nil {
+				return false
+			}
+			last = &done
+		}
+		return last != nil && last.IsFinal()
+	}
+
+	// 回退：完整的响应总是以最终DONE结束
+	payload := m.AssemblePayload()
+	size := doneTokenSize(m.tdsVersion) + 1
+	if len(payload) < size {
+		return false
+	}
+	tail := payload[len(payload)-size:]
+	if TokenType(tail[0]) != TokenDone && TokenType(tail[0]) != TokenDoneProc {
+		return false
+	}
+	return (binary.LittleEndian.Uint16(tail[1:]) & DONE_MORE) == 0
+}
+
+func (m *TabularResultMessage) String() string {
+	if m.IsComplete() {
+		sb := strings.Builder{}
+		sb.WriteString("TabularResultMessage")
+		sb.WriteString(fmt.Sprintf("[#Packets=%d;IsComplete=%v;HasIgnoreBitSet=%v;TotalPayloadSize=%d",
+			len(m.Packets), m.IsComplete(), m.HasIgnoreBitSet(), len(m.AssemblePayload())))
+
+		for i, packet := range m.Packets {
+			sb.WriteString(fmt.Sprintf("\n\t[P%d[%s]]", i, packet))
+		}
+
+		sb.WriteString("]")
+		return sb.String()
+	}
+	return "TabularResultMessage{Incomplete message}"
+}
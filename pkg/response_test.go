@@ -0,0 +1,249 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// doneToken 构造DONE、DONEPROC或DONEINPROC令牌(TDS 7.2起的8字节行数)
+func doneToken(tokenType TokenType, status uint16, curCmd uint16, rowCount uint64) []byte {
+	token := []byte{byte(tokenType)}
+	token = binary.LittleEndian.AppendUint16(token, status)
+	token = binary.LittleEndian.AppendUint16(token, curCmd)
+	return binary.LittleEndian.AppendUint64(token, rowCount)
+}
+
+// tokensMessage 将令牌拼接为单包表格结果
+func tokensMessage(tokens ...[]byte) *TabularResultMessage {
+	var payload []byte
+	for _, token := range tokens {
+		payload = append(payload, token...)
+	}
+	return NewTabularResultMessageWithPacket(NewTDSPacketFromBuffer(buildPacket(TabularResult, END_OF_MESSAGE, 1, payload)))
+}
+
+func TestIsFinalResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tokens [][]byte
+		want   bool
+	}{
+		{"final DONE", [][]byte{doneToken(TokenDone, DONE_FINAL|DONE_COUNT, 0xC1, 3)}, true},
+		{"DONE with MORE", [][]byte{doneToken(TokenDone, DONE_MORE|DONE_COUNT, 0xC1, 3)}, false},
+		{"only DONEINPROC", [][]byte{doneToken(TokenDoneInProc, DONE_FINAL, 0xC1, 1)}, false},
+		{"DONEINPROC then final DONEPROC", [][]byte{
+			doneToken(TokenDoneInProc, DONE_MORE|DONE_COUNT, 0xC1, 1),
+			doneToken(TokenDoneProc, DONE_FINAL, 0xE0, 0),
+		}, true},
+		{"final DONE then DONEINPROC", [][]byte{
+			doneToken(TokenDone, DONE_MORE, 0xC1, 1),
+			doneToken(TokenDoneInProc, DONE_FINAL, 0xC1, 1),
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tokensMessage(tc.tokens...).IsFinalResponse(); got != tc.want {
+				t.Fatalf("IsFinalResponse() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResponseCompleteFiresAtTrueEnd(t *testing.T) {
+	completed := make(chan *TabularResultMessage, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetResponseCompleteHandler(func(bc *BridgedConnection, msg *TabularResultMessage) {
+			completed <- msg
+		})
+	})
+	client := h.connect(sqlBatchPacket("SELECT 1; SELECT 2; EXEC p"))
+	backend := h.backend(0)
+
+	// 第一个结果集：中间的DONE带DONE_MORE，存储过程内部的DONEINPROC不表示结束
+	first := append(doneToken(TokenDone, DONE_MORE|DONE_COUNT, 0xC1, 1),
+		doneToken(TokenDoneInProc, DONE_COUNT, 0xC1, 2)...)
+	backend.feed(buildPacket(TabularResult, END_OF_MESSAGE, 1, first))
+	waitWritten(t, client, HEADER_SIZE+len(first))
+	select {
+	case <-completed:
+		t.Fatal("response complete fired before the final DONE")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 跨两个数据包的最后一个结果集，以最终DONEPROC结束
+	last := append(doneToken(TokenDoneInProc, DONE_MORE|DONE_COUNT, 0xC1, 5),
+		doneToken(TokenDoneProc, DONE_FINAL, 0xE0, 0)...)
+	backend.feed(buildPacket(TabularResult, 0, 1, last[:10]))
+	backend.feed(buildPacket(TabularResult, END_OF_MESSAGE, 2, last[10:]))
+	select {
+	case msg := <-completed:
+		if len(msg.Packets) != 2 {
+			t.Fatalf("completed response has %d packets, want 2", len(msg.Packets))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("response complete not fired at the final DONE")
+	}
+	select {
+	case <-completed:
+		t.Fatal("response complete fired more than once")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServerResponseSplitAcrossReads(t *testing.T) {
+	completed := make(chan *TabularResultMessage, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetResponseCompleteHandler(func(bc *BridgedConnection, msg *TabularResultMessage) {
+			completed <- msg
+		})
+	})
+	client := h.connect(sqlBatchPacket("SELECT 1"))
+	backend := h.backend(0)
+
+	// 两个数据包的响应逐字节到达，头部和有效载荷都跨越多次读取
+	tokens := append(doneToken(TokenDone, DONE_MORE|DONE_COUNT, 0xC1, 3), doneToken(TokenDone, DONE_FINAL, 0xC1, 0)...)
+	response := append(buildPacket(TabularResult, NORMAL, 1, tokens[:5]), buildPacket(TabularResult, END_OF_MESSAGE, 2, tokens[5:])...)
+	for _, b := range response {
+		backend.feed([]byte{b})
+	}
+
+	waitWritten(t, client, len(response))
+	if !bytes.Equal(client.Written(), response) {
+		t.Errorf("client received %x, want %x", client.Written(), response)
+	}
+	select {
+	case msg := <-completed:
+		if len(msg.Packets) != 2 || !bytes.Equal(msg.AssemblePayload(), tokens) {
+			t.Errorf("reassembled %d packets with payload %x, want 2 packets with %x", len(msg.Packets), msg.AssemblePayload(), tokens)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("split response was not reassembled")
+	}
+}
+
+func TestDoneTokens(t *testing.T) {
+	// INSERT; UPDATE (在存储过程内); SELECT
+	msg := tokensMessage(
+		doneToken(TokenDone, DONE_MORE|DONE_COUNT, 0xC3, 1),
+		doneToken(TokenDoneInProc, DONE_MORE|DONE_COUNT, 0xC5, 5),
+		doneToken(TokenDone, DONE_FINAL|DONE_COUNT, 0xC1, 10),
+	)
+	want := []DoneToken{
+		{Type: TokenDone, Status: DONE_MORE | DONE_COUNT, CurCmd: 0xC3, RowCount: 1},
+		{Type: TokenDoneInProc, Status: DONE_MORE | DONE_COUNT, CurCmd: 0xC5, RowCount: 5},
+		{Type: TokenDone, Status: DONE_FINAL | DONE_COUNT, CurCmd: 0xC1, RowCount: 10},
+	}
+	got := msg.DoneTokens()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DoneTokens() = %+v, want %+v", got, want)
+	}
+	for i, done := range got {
+		if !done.HasRowCount() {
+			t.Errorf("token %d: HasRowCount() = false", i)
+		}
+		if final := i == len(got)-1; done.IsFinal() != final {
+			t.Errorf("token %d: IsFinal() = %v, want %v", i, done.IsFinal(), final)
+		}
+	}
+
+	if done := tokensMessage(doneToken(TokenDone, DONE_FINAL, 0xC1, 0)).DoneTokens(); len(done) != 1 || done[0].HasRowCount() {
+		t.Errorf("DONE without DONE_COUNT: %+v", done)
+	}
+}
+
+func TestDoneTokensTruncatedStream(t *testing.T) {
+	first := doneToken(TokenDone, DONE_MORE|DONE_COUNT, 0xC3, 2)
+	second := doneToken(TokenDone, DONE_FINAL|DONE_COUNT, 0xC1, 7)
+	got := tokensMessage(first, second[:6]).DoneTokens()
+	if len(got) != 1 || got[0].RowCount != 2 {
+		t.Errorf("DoneTokens() on truncated stream = %+v, want only the complete first token", got)
+	}
+}
+
+// testColumn COLMETADATA中的一列：列名和TYPE_INFO的原始字节
+type testColumn struct {
+	name     string
+	typeInfo []byte
+}
+
+// colMetadataToken 构造COLMETADATA令牌
+func colMetadataToken(columns ...testColumn) []byte {
+	token := []byte{byte(TokenColMetadata)}
+	token = binary.LittleEndian.AppendUint16(token, uint16(len(columns)))
+	for _, col := range columns {
+		token = binary.LittleEndian.AppendUint32(token, 0)      // UserType
+		token = binary.LittleEndian.AppendUint16(token, 0x0009) // Flags: Nullable | Updateable
+		token = append(token, col.typeInfo...)
+		token = append(token, byte(len(col.name)))
+		token = append(token, encodeUTF16LE(col.name)...)
+	}
+	return token
+}
+
+func TestParseTokensNBCRow(t *testing.T) {
+	collation := []byte{0x09, 0x04, 0xD0, 0x00, 0x34}
+	metadata := colMetadataToken(
+		testColumn{"id", []byte{byte(typeIntN), 4}},
+		testColumn{"name", append([]byte{byte(typeNVarChar), 40, 0}, collation...)},
+		testColumn{"note", append([]byte{byte(typeBigVarChar), 10, 0}, collation...)},
+	)
+	int4 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32([]byte{4}, v) }
+	varLen := func(b []byte) []byte { return append(binary.LittleEndian.AppendUint16(nil, uint16(len(b))), b...) }
+
+	row := bytes.Join([][]byte{{byte(TokenRow)}, int4(1), varLen(encodeUTF16LE("a")), varLen([]byte("x"))}, nil)
+	// 位图第1、2位：name和note为NULL，不占用值字节
+	nbcRow1 := bytes.Join([][]byte{{byte(TokenNBCRow), 0x06}, int4(2)}, nil)
+	// 位图第0位：id为NULL
+	nbcRow2 := bytes.Join([][]byte{{byte(TokenNBCRow), 0x01}, varLen(encodeUTF16LE("b")), varLen([]byte("y"))}, nil)
+	msg := tokensMessage(metadata, row, nbcRow1, nbcRow2, doneToken(TokenDone, DONE_FINAL|DONE_COUNT, 0xC1, 3))
+
+	tokens, err := msg.GetTokens()
+	if err != nil {
+		t.Fatalf("GetTokens() error: %v", err)
+	}
+	var rows [][][]byte
+	for _, token := range tokens {
+		if token.Type == TokenRow || token.Type == TokenNBCRow {
+			rows = append(rows, token.Values)
+		}
+	}
+	want := [][][]byte{
+		{{1, 0, 0, 0}, encodeUTF16LE("a"), []byte("x")},
+		{{2, 0, 0, 0}, nil, nil},
+		{nil, encodeUTF16LE("b"), []byte("y")},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+	if done := msg.DoneTokens(); len(done) != 1 || done[0].RowCount != uint64(len(rows)) {
+		t.Errorf("DoneTokens() = %+v, want a row count of %d", done, len(rows))
+	}
+}
+
+func TestParseTokensNBCRowMultiByteBitmap(t *testing.T) {
+	// 9列需要2字节的位图
+	var columns []testColumn
+	for i := 0; i < 9; i++ {
+		columns = append(columns, testColumn{string(rune('a' + i)), []byte{byte(typeInt4)}})
+	}
+	row := []byte{byte(TokenNBCRow), 0x00, 0x01}
+	var want [][]byte
+	for i := 0; i < 8; i++ {
+		row = binary.LittleEndian.AppendUint32(row, uint32(i))
+		want = append(want, binary.LittleEndian.AppendUint32(nil, uint32(i)))
+	}
+	want = append(want, nil)
+
+	tokens, err := tokensMessage(colMetadataToken(columns...), row, doneToken(TokenDone, DONE_FINAL, 0xC1, 0)).GetTokens()
+	if err != nil {
+		t.Fatalf("GetTokens() error: %v", err)
+	}
+	if len(tokens) != 3 || tokens[1].Type != TokenNBCRow {
+		t.Fatalf("tokens = %v, want COLMETADATA, NBCROW, DONE", tokens)
+	}
+	if !reflect.DeepEqual(tokens[1].Values, want) {
+		t.Errorf("values = %v, want %v", tokens[1].Values, want)
+	}
+}
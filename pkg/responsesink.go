@@ -0,0 +1,37 @@
+package pkg
+
+// SetResponseSink 设置服务器响应的旁路输出函数，用于审计：收到完整服务器响应消息(结果集等)的副本，
+// 在独立的goroutine中按到达顺序执行，不影响转发给客户端的数据流：缓冲bufferSize个消息，
+// 队列满时丢弃新消息并计入DroppedResponses。需要在服务器方向重组消息，关闭解析时不生效。
+// 传入nil取消。需在Start之前调用。
+func (ba *BridgeAcceptor) SetResponseSink(sink TDSMessageReceivedHandler, bufferSize int) {
+	ba.responseSink = sink
+	ba.responseSinkBufferSize = bufferSize
+}
+
+// DroppedResponses 获取旁路输出队列已满而未投递给旁路输出函数的响应数
+func (ba *BridgeAcceptor) DroppedResponses() uint64 {
+	ba.mu.Lock()
+	responseSinks := ba.responseSinks
+	ba.mu.Unlock()
+
+	if responseSinks == nil {
+		return 0
+	}
+	return responseSinks.dropped.Load()
+}
+
+// sinkResponse 将服务器响应的副本投递给旁路输出函数
+func (bc *BridgedConnection) sinkResponse(msg TDSMessage) {
+	ba := bc.BridgeAcceptor
+	ba.mu.Lock()
+	responseSinks := ba.responseSinks
+	ba.mu.Unlock()
+
+	sink := ba.responseSink
+	if sink == nil || responseSinks == nil {
+		return
+	}
+	copied := cloneMessage(msg)
+	responseSinks.enqueue(func() { sink(bc, copied) })
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestResponseSinkReceivesResponse(t *testing.T) {
+	sunk := make(chan TDSMessage, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetResponseSink(func(bc *BridgedConnection, msg TDSMessage) {
+			sunk <- msg
+		}, 4)
+	})
+	batch := sqlBatchPacket("SELECT 1")
+	client := h.connect(batch)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(batch))
+
+	response := doneResponse(DONE_FINAL|DONE_COUNT, 5)
+	backend.feed(response)
+	if got := waitWritten(t, client, len(response)); !bytes.Equal(got, response) {
+		t.Fatalf("client received %x, want the response unchanged", got)
+	}
+	select {
+	case msg := <-sunk:
+		result, ok := msg.(*TabularResultMessage)
+		if !ok {
+			t.Fatalf("sink received %T, want a tabular result", msg)
+		}
+		if done := result.DoneTokens(); len(done) != 1 || done[0].RowCount != 5 {
+			t.Errorf("sink DONE tokens = %v, want one with 5 rows", done)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("response not delivered to the sink")
+	}
+}
+
+func TestResponseSinkNeverDelaysClient(t *testing.T) {
+	release := make(chan struct{})
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetResponseSink(func(bc *BridgedConnection, msg TDSMessage) {
+			<-release
+		}, 1)
+	})
+	defer close(release)
+	batch := sqlBatchPacket("SELECT 1")
+	client := h.connect(batch)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(batch))
+
+	// 旁路输出函数一直阻塞，响应仍转发给客户端，放不进队列的副本被丢弃
+	const responses = 5
+	response := doneResponse(DONE_FINAL, 0)
+	for i := 0; i < responses; i++ {
+		backend.feed(response)
+	}
+	waitWritten(t, client, responses*len(response))
+	waitFor(t, "dropped responses", func() bool { return h.ba.DroppedResponses() > 0 })
+}
@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	"fmt"
+)
+
+// bridgeRoute 一个监听地址及其对应的SQL Server端点
+type bridgeRoute struct {
+	listenAddr string
+	backend    string
+	// 是否为按改写的路由重新连接的临时端口，backend为服务器路由的真实目标
+	redirected bool
+}
+
+// AddRoute 增加一个监听地址到后端的映射，用于由一个桥接器为多个SQL Server实例各开一个端口。
+// 每个路由有各自的监听器和接受循环，与构造时指定的端口一起由Start和Stop启停；
+// 除后端外，所有设置和处理函数由各路由共享。构造时的端口为空时只监听增加的路由。
+// 连接所属的路由可通过BridgedConnection.Route获取。需在Start之前调用，运行中调用返回错误。
+func (ba *BridgeAcceptor) AddRoute(listenAddr, backend string) error {
+	ba.mu.Lock()
+	defer ba.mu.Unlock()
+
+	if ba.enabled {
+		return fmt.Errorf("add route %s: bridge is running", listenAddr)
+	}
+	ba.routes = append(ba.routes, &bridgeRoute{listenAddr: listenAddr, backend: backend})
+	return nil
+}
+
+// allRoutes 获取构造时指定的路由和增加的路由
+func (ba *BridgeAcceptor) allRoutes() []*bridgeRoute {
+	routes := make([]*bridgeRoute, 0, len(ba.routes)+1)
+	if ba.acceptPort != "" {
+		routes = append(routes, &bridgeRoute{listenAddr: ":" + ba.acceptPort, backend: ba.sqlServerEndpoint})
+	}
+	return append(routes, ba.routes...)
+}
+
+// Route 获取接受该连接的路由的监听地址(构造时指定的端口为":端口")
+func (bc *BridgedConnection) Route() string {
+	if bc.route == nil {
+		return ""
+	}
+	return bc.route.listenAddr
+}
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoutesBridgeIndependently(t *testing.T) {
+	// 各路由使用不同的固定地址，以便区分连接所属的路由
+	var listenAddrs []string
+	for i := 0; i < 2; i++ {
+		probe, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Skipf("loopback listen unavailable: %v", err)
+		}
+		listenAddrs = append(listenAddrs, probe.Addr().String())
+		probe.Close()
+	}
+
+	var mu sync.Mutex
+	backends := map[string]*scriptedConn{
+		"alpha:1433": newScriptedConn(),
+		"beta:1433":  newScriptedConn(),
+	}
+	routeOf := make(map[string]string)
+	ba := NewBridgeAcceptor("", "unused:1433")
+	ba.dialFunc = func(endpoint string) (net.Conn, error) {
+		return backends[endpoint], nil
+	}
+	ba.SetBackendConnectedHandler(func(bc *BridgedConnection, backend net.Conn) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for endpoint, conn := range backends {
+			if conn == backend {
+				routeOf[endpoint] = bc.Route()
+			}
+		}
+		return nil
+	})
+	if err := ba.AddRoute(listenAddrs[0], "alpha:1433"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ba.AddRoute(listenAddrs[1], "beta:1433"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ba.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ba.Stop()
+	if err := ba.AddRoute("127.0.0.1:0", "gamma:1433"); err == nil {
+		t.Error("AddRoute while running returned nil")
+	}
+
+	requests := [][]byte{sqlBatchPacket("SELECT 'alpha'"), sqlBatchPacket("SELECT 'beta'")}
+	var clients []net.Conn
+	for i, addr := range listenAddrs {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write(requests[i]); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, conn)
+	}
+
+	// 每个路由的请求只到达自己的后端，回应也只回到自己的客户端
+	for i, endpoint := range []string{"alpha:1433", "beta:1433"} {
+		backend := backends[endpoint]
+		if got := waitWritten(t, backend, len(requests[i])); !bytes.Equal(got, requests[i]) {
+			t.Errorf("%s received %x, want %x", endpoint, got, requests[i])
+		}
+		response := doneResponse(DONE_FINAL, uint64(i+1))
+		backend.feed(response)
+		got := make([]byte, len(response))
+		if _, err := io.ReadFull(clients[i], got); err != nil {
+			t.Fatalf("read %s response: %v", endpoint, err)
+		}
+		if !bytes.Equal(got, response) {
+			t.Errorf("client of %s received %x, want %x", endpoint, got, response)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, endpoint := range []string{"alpha:1433", "beta:1433"} {
+		if routeOf[endpoint] != listenAddrs[i] {
+			t.Errorf("connection to %s reported route %q, want %s", endpoint, routeOf[endpoint], listenAddrs[i])
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+)
+
+// LOGIN_PEEK_TIMEOUT 预读客户端PreLogin和Login7的最长时间
+const LOGIN_PEEK_TIMEOUT = 15 * time.Second
+
+// DEFAULT_LOGIN_PEEK_LIMIT 默认的登录预读缓冲上限(PreLogin和Login7合计)
+const DEFAULT_LOGIN_PEEK_LIMIT = 64 * 1024
+
+// BackendSelector 为客户端连接选择SQL Server端点。
+// 未启用登录预读时login为nil；返回错误时连接被关闭并报告ErrBackendDial。
+type BackendSelector func(client net.Conn, login *Login7Message) (endpoint string, err error)
+
+// SetBackendSelector 设置按连接选择后端的回调，nil表示始终使用构造时指定的端点。
+// peekLogin为true时，桥接器先代替服务器回应PreLogin并缓存客户端的Login7，
+// 据此选择后端后再连接，并以相同选项与后端完成PreLogin、转发缓存的Login7。
+// 预读要求会话不加密：桥接器在两侧都声明不支持加密(ENCRYPT_NOT_SUP)并关闭MARS，
+// 要求加密的客户端或后端会被断开。预读的数据量受SetLoginPeekLimit限制。
+func (ba *BridgeAcceptor) SetBackendSelector(selector BackendSelector, peekLogin bool) {
+	ba.backendSelector = selector
+	ba.selectorPeeksLogin = peekLogin
+}
+
+// SetLoginPeekLimit 设置预读登录时在内存中缓冲的最大字节数(PreLogin和Login7合计)，
+// 超出时断开连接并报告ErrBufferLimit。0表示使用DEFAULT_LOGIN_PEEK_LIMIT。
+func (ba *BridgeAcceptor) SetLoginPeekLimit(n int) {
+	ba.loginPeekLimit = n
+}
+
+// bufferedConn 先返回预读时已缓冲的数据，再从底层连接读取
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// peekedLogin 预读得到的客户端PreLogin和Login7
+type peekedLogin struct {
+	options []PreLoginOption
+	login   *Login7Message
+	// wire 经过拦截和改写、将转发给后端的Login7数据包，重试时原样重放
+	wire []byte
+}
+
+// bridgePreLoginOptions 将客户端的PreLogin选项改为不加密、不使用MARS，用于双方协商
+func bridgePreLoginOptions(options []PreLoginOption) []PreLoginOption {
+	patched := make([]PreLoginOption, 0, len(options))
+	for _, option := range options {
+		switch option.Token {
+		case PreLoginEncryption:
+			option.Data = []byte{ENCRYPT_NOT_SUP}
+		case PreLoginMARS:
+			option.Data = []byte{0}
+		}
+		patched = append(patched, option)
+	}
+	return patched
+}
+
+// peekLogin 代替服务器回应客户端的PreLogin并读取其Login7。
+// 返回的连接包含预读时可能多读的数据，后续应使用它代替原客户端连接。
+func (bc *BridgedConnection) peekLogin(clientConn net.Conn) (*peekedLogin, net.Conn, error) {
+	clientConn.SetDeadline(time.Now().Add(LOGIN_PEEK_TIMEOUT))
+	defer clientConn.SetDeadline(time.Time{})
+
+	br := bufio.NewReaderSize(clientConn, TDS_READER_BUFFER_SIZE)
+	reader := NewTDSReader(br)
+
+	limit := bc.BridgeAcceptor.loginPeekLimit
+	if limit <= 0 {
+		limit = DEFAULT_LOGIN_PEEK_LIMIT
+	}
+
+	msg, err := reader.ReadMessageLimit(limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit -= len(msg.AssemblePayload()) + len(msg.GetPackets())*HEADER_SIZE; limit <= 0 {
+		return nil, nil, fmt.Errorf("%w: prelogin fills the login peek buffer", ErrBufferLimit)
+	}
+	preLogin, ok := msg.(*PreLoginRequestMessage)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: expected PreLogin, got %s", ErrProtocol, msg.GetPackets()[0].Header.Type())
+	}
+	options, err := preLogin.GetOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	if encryption, ok := preLoginEncryption(options); ok && (encryption == ENCRYPT_ON || encryption == ENCRYPT_REQ) {
+		return nil, nil, fmt.Errorf("%w: client requires encryption, login cannot be inspected", ErrProtocol)
+	}
+	bc.observeMessage(preLogin)
+
+	// 代替服务器回应PreLogin
+	if err := bc.writeToClient(bridgePreLoginResponse()); err != nil {
+		return nil, nil, err
+	}
+
+	msg, err = reader.ReadMessageLimit(limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	login, ok := msg.(*Login7Message)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: expected Login7, got %s", ErrProtocol, msg.GetPackets()[0].Header.Type())
+	}
+
+	// 登录只观察和拦截一次，重试连接后端时重放同样的数据
+	bc.observeMessage(login)
+	wire, err := bc.loginWire(login)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	peeked := &peekedLogin{
+		options: options,
+		login:   login,
+		wire:    wire,
+	}
+	return peeked, &bufferedConn{Conn: clientConn, r: br}, nil
+}
+
+// loginWire 对预读的Login7执行拦截和改写，返回要转发给后端的数据包
+func (bc *BridgedConnection) loginWire(login *Login7Message) ([]byte, error) {
+	if bc.BridgeAcceptor.parsingDisabled {
+		var data []byte
+		for _, packet := range login.GetPackets() {
+			data = append(data, packet.Bytes()...)
+		}
+		return data, nil
+	}
+	data, drop, err := bc.interceptMessage(login)
+	if err != nil {
+		return nil, err
+	}
+	if drop {
+		return nil, fmt.Errorf("%w: login rejected by interceptor", ErrProtocol)
+	}
+	return data, nil
+}
+
+// replayLogin 与后端完成PreLogin协商并转发预读时已拦截的Login7数据包
+func (bc *BridgedConnection) replayLogin(sqlConn net.Conn, peeked *peekedLogin) error {
+	sqlConn.SetDeadline(time.Now().Add(LOGIN_PEEK_TIMEOUT))
+	defer sqlConn.SetDeadline(time.Time{})
+
+	payload := BuildPreLoginPayload(bridgePreLoginOptions(peeked.options))
+	packet := append(BuildTDSHeader(PreLoginMessage, END_OF_MESSAGE, len(payload)+HEADER_SIZE, 1), payload...)
+	if _, err := sqlConn.Write(packet); err != nil {
+		return err
+	}
+
+	response, err := NewTDSReader(sqlConn).ReadMessage()
+	if err != nil {
+		return err
+	}
+	options, err := ParsePreLoginOptions(response.AssemblePayload())
+	if err != nil {
+		return err
+	}
+	if encryption, ok := preLoginEncryption(options); ok && (encryption == ENCRYPT_ON || encryption == ENCRYPT_REQ) {
+		return fmt.Errorf("%w: backend requires encryption", ErrProtocol)
+	}
+
+	_, err = sqlConn.Write(peeked.wire)
+	return err
+}
+
+// observeMessage 对桥接器自行处理的客户端消息触发与正常转发相同的检查和事件
+func (bc *BridgedConnection) observeMessage(msg TDSMessage) {
+	for _, packet := range msg.GetPackets() {
+		bc.onTDSPacketReceived(packet)
+	}
+	if !bc.BridgeAcceptor.parsingDisabled {
+		bc.inspectMessage(msg)
+		bc.onTDSMessageReceived(msg)
+	}
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+// peekingHarness 创建按登录用户名选择后端的装置
+func peekingHarness(t *testing.T, backends map[string]string) *bridgeHarness {
+	return newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBackendSelector(func(client net.Conn, login *Login7Message) (string, error) {
+			if login == nil {
+				return "", errors.New("login not peeked")
+			}
+			endpoint, ok := backends[login.GetUserName()]
+			if !ok {
+				return "", errors.New("unknown user " + login.GetUserName())
+			}
+			return endpoint, nil
+		}, true)
+	})
+}
+
+func TestBackendSelectorRoutesByLogin(t *testing.T) {
+	h := peekingHarness(t, map[string]string{"alice": "db-a:1433", "bob": "db-b:1433"})
+	response := bridgePreLoginResponse()
+
+	for i, user := range []string{"alice", "bob"} {
+		login := testLogin7{version: TDSVersion74, user: user, database: "master"}.packet()
+		h.prepareBackend(newScriptedConn(response))
+		client := h.connect(preLoginPacket(ENCRYPT_NOT_SUP), login)
+
+		// 客户端收到桥接器代答的PreLogin响应，后端收到重新协商的PreLogin和缓存的Login7
+		waitWritten(t, client, len(response))
+		backend := h.backend(i)
+		waitFor(t, user+" login at the backend", func() bool {
+			return bytes.HasSuffix(backend.Written(), login)
+		})
+		msg, err := NewTDSReader(bytes.NewReader(backend.Written())).ReadMessage()
+		if err != nil {
+			t.Fatalf("read backend prelogin: %v", err)
+		}
+		if _, ok := msg.(*PreLoginRequestMessage); !ok {
+			t.Errorf("backend first received %T, want a PreLogin", msg)
+		}
+	}
+
+	h.mu.Lock()
+	dialed := append([]string(nil), h.dialed...)
+	h.mu.Unlock()
+	if len(dialed) != 2 || dialed[0] != "db-a:1433" || dialed[1] != "db-b:1433" {
+		t.Errorf("dialed %v, want [db-a:1433 db-b:1433]", dialed)
+	}
+}
+
+func TestBackendSelectorRejectsEncryptedClient(t *testing.T) {
+	h := peekingHarness(t, map[string]string{"alice": "db-a:1433"})
+	client := h.connect(preLoginPacket(ENCRYPT_REQ))
+	waitFor(t, "client close", client.isClosed)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.dialed) != 0 {
+		t.Errorf("dialed %v for a client requiring encryption", h.dialed)
+	}
+}
+
+func TestBackendSelectorErrorClosesClient(t *testing.T) {
+	h := peekingHarness(t, map[string]string{})
+	login := testLogin7{version: TDSVersion74, user: "mallory"}.packet()
+	client := h.connect(preLoginPacket(ENCRYPT_NOT_SUP), login)
+	waitFor(t, "client close", client.isClosed)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.dialed) != 0 {
+		t.Errorf("dialed %v after the selector failed", h.dialed)
+	}
+}
+
+func TestPeekedLoginReplayedIntact(t *testing.T) {
+	h := peekingHarness(t, map[string]string{"alice": "db-a:1433"})
+	response := bridgePreLoginResponse()
+
+	// 分成两个数据包的Login7，紧随其后的批处理与登录在同一次读取中到达
+	payload := testLogin7{version: TDSVersion74, user: "alice", app: "reporting", database: "sales"}.payload()
+	login := append(
+		buildPacket(TDS7Login, NORMAL, 1, payload[:40]),
+		buildPacket(TDS7Login, END_OF_MESSAGE, 2, payload[40:])...)
+	batch := sqlBatchPacket("SELECT 1")
+	h.prepareBackend(newScriptedConn(response))
+	client := h.connect(preLoginPacket(ENCRYPT_NOT_SUP), append(append([]byte{}, login...), batch...))
+
+	waitWritten(t, client, len(response))
+	backend := h.backend(0)
+	want := append(append([]byte{}, login...), batch...)
+	waitFor(t, "login and batch at the backend", func() bool {
+		return bytes.HasSuffix(backend.Written(), want)
+	})
+	preLogin := backend.Written()[:len(backend.Written())-len(want)]
+	if issues := ValidateStream(bytes.NewReader(preLogin)); len(issues) != 0 {
+		t.Errorf("backend prelogin is malformed: %v", issues)
+	}
+}
+
+func TestLoginPeekLimit(t *testing.T) {
+	h, exceptions := exceptionHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetBackendSelector(func(net.Conn, *Login7Message) (string, error) { return "db-a:1433", nil }, true)
+		ba.SetLoginPeekLimit(200)
+	})
+	login := testLogin7{version: TDSVersion74, user: "alice", app: string(make([]byte, 200))}.packet()
+	client := h.connect(preLoginPacket(ENCRYPT_NOT_SUP), login)
+
+	firstMatching(t, exceptions, ErrBufferLimit)
+	waitFor(t, "client close", client.isClosed)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.dialed) != 0 {
+		t.Errorf("dialed %v after the peek buffer overflowed", h.dialed)
+	}
+}
@@ -0,0 +1,363 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// 知名存储过程的ProcID(NameLenProcID为0xFFFF时使用)
+const (
+	ProcIDCursor          = 1
+	ProcIDCursorOpen      = 2
+	ProcIDCursorPrepare   = 3
+	ProcIDCursorExecute   = 4
+	ProcIDCursorPrepExec  = 5
+	ProcIDCursorUnprepare = 6
+	ProcIDCursorFetch     = 7
+	ProcIDCursorOption    = 8
+	ProcIDCursorClose     = 9
+	ProcIDExecuteSQL      = 10
+	ProcIDPrepare         = 11
+	ProcIDExecute         = 12
+	ProcIDPrepExec        = 13
+	ProcIDPrepExecRPC     = 14
+	ProcIDUnprepare       = 15
+)
+
+var procIDNames = map[uint16]string{
+	ProcIDCursor:          "sp_cursor",
+	ProcIDCursorOpen:      "sp_cursoropen",
+	ProcIDCursorPrepare:   "sp_cursorprepare",
+	ProcIDCursorExecute:   "sp_cursorexecute",
+	ProcIDCursorPrepExec:  "sp_cursorprepexec",
+	ProcIDCursorUnprepare: "sp_cursorunprepare",
+	ProcIDCursorFetch:     "sp_cursorfetch",
+	ProcIDCursorOption:    "sp_cursoroption",
+	ProcIDCursorClose:     "sp_cursorclose",
+	ProcIDExecuteSQL:      "sp_executesql",
+	ProcIDPrepare:         "sp_prepare",
+	ProcIDExecute:         "sp_execute",
+	ProcIDPrepExec:        "sp_prepexec",
+	ProcIDPrepExecRPC:     "sp_prepexecrpc",
+	ProcIDUnprepare:       "sp_unprepare",
+}
+
+// MAX_RPC_PARAMETERS 默认最多解析的RPC参数个数，与SQL Server单个请求的参数上限相同
+const MAX_RPC_PARAMETERS = 2100
+
+// RPC参数状态位
+const (
+	RPC_PARAM_BY_REF_VALUE  = 0x01
+	RPC_PARAM_DEFAULT_VALUE = 0x02
+	RPC_PARAM_ENCRYPTED     = 0x08
+)
+
+// RPCParameter RPC请求中的一个参数
+type RPCParameter struct {
+	Name     string
+	Status   byte
+	TypeInfo *TypeInfo
+	// Value 原始值字节，NULL时为nil
+	Value []byte
+}
+
+// IsNull 参数值是否为NULL
+func (p *RPCParameter) IsNull() bool {
+	return p.Value == nil
+}
+
+// Text 将字符类型参数的值解码为字符串
+func (p *RPCParameter) Text() (string, error) {
+	switch {
+	case isUnicodeType(p.TypeInfo.Type):
+		return decodeUTF16LE(p.Value), nil
+	case isCharType(p.TypeInfo.Type):
+		return string(p.Value), nil
+	}
+	return "", fmt.Errorf("parameter %s is not a character type: %s", p.Name, p.TypeInfo)
+}
+
+// SQLLiteral 将参数值格式化为T-SQL字面量
+func (p *RPCParameter) SQLLiteral() (string, error) {
+	return FormatSQLLiteral(p.TypeInfo, p.Value)
+}
+
+// SetTDSVersion 设置连接协商的TDS版本
+func (m *RPCRequestMessage) SetTDSVersion(version TDSVersion) {
+	m.tdsVersion = version
+}
+
+// SetMaxParameters 设置GetParameters最多解析的参数个数，超出时停止解析并返回错误。
+// 0表示使用MAX_RPC_PARAMETERS。
+func (m *RPCRequestMessage) SetMaxParameters(n int) {
+	m.maxParameters = n
+}
+
+// rpcReader 返回定位在ALL_HEADERS(TDS 7.2起)之后的RPC请求体读取器
+func (m *RPCRequestMessage) rpcReader() (*bytes.Reader, error) {
+	payload := m.AssemblePayload()
+	headerLength, err := requestBodyOffset(payload, m.tdsVersion)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(payload[headerLength:]), nil
+}
+
+// readProcName 读取NameLenProcID，返回存储过程名和ProcID(按名称调用时为0)
+func readProcName(r *bytes.Reader) (string, uint16, error) {
+	nameLen, err := readUint16(r)
+	if err != nil {
+		return "", 0, err
+	}
+	if nameLen == 0xFFFF {
+		procID, err := readUint16(r)
+		if err != nil {
+			return "", 0, err
+		}
+		if name, ok := procIDNames[procID]; ok {
+			return name, procID, nil
+		}
+		return fmt.Sprintf("ProcID=%d", procID), procID, nil
+	}
+	b, err := readBytes(r, int(nameLen)*2)
+	if err != nil {
+		return "", 0, err
+	}
+	return decodeUTF16LE(b), 0, nil
+}
+
+// GetProcName 获取被调用的存储过程名，按ProcID调用时返回对应的知名存储过程名
+func (m *RPCRequestMessage) GetProcName() (string, error) {
+	r, err := m.rpcReader()
+	if err != nil {
+		return "", err
+	}
+	name, _, err := readProcName(r)
+	return name, err
+}
+
+// GetParameters 解析第一个RPC调用的全部参数。参数个数超过上限(见SetMaxParameters)时
+// 停止解析，返回同时匹配ErrProtocol和ErrBufferLimit的错误。
+func (m *RPCRequestMessage) GetParameters() ([]*RPCParameter, error) {
+	maxParameters := m.maxParameters
+	if maxParameters <= 0 {
+		maxParameters = MAX_RPC_PARAMETERS
+	}
+	r, err := m.rpcReader()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := readProcName(r); err != nil {
+		return nil, err
+	}
+	// OptionFlags
+	if _, err := readUint16(r); err != nil {
+		return nil, err
+	}
+
+	var params []*RPCParameter
+	for r.Len() > 0 {
+		// 批内多个RPC之间的分隔符
+		if b, _ := r.ReadByte(); b == 0xFF || b == 0x80 {
+			break
+		}
+		r.UnreadByte()
+		if len(params) >= maxParameters {
+			return nil, newBridgeError(ErrProtocol, "parse RPC parameters",
+				fmt.Errorf("%w: more than %d parameters", ErrBufferLimit, maxParameters))
+		}
+
+		name, err := readBVarChar(r)
+		if err != nil {
+			return nil, err
+		}
+		status, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		ti, err := readTypeInfo(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readTypedValue(r, ti)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, &RPCParameter{
+			Name:     name,
+			Status:   status,
+			TypeInfo: ti,
+			Value:    value,
+		})
+	}
+	return params, nil
+}
+
+// isExecuteSQL 检查存储过程名是否为sp_executesql(允许架构/数据库前缀)
+func isExecuteSQL(procName string) bool {
+	name := strings.ToLower(procName)
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return strings.Trim(name, "[]") == "sp_executesql"
+}
+
+// EffectiveSQL 对sp_executesql调用，返回将参数值内联到@stmt后的"实际执行"SQL文本，
+// 便于调试参数化查询。NULL参数替换为NULL，字符串按T-SQL规则转义。
+// 其他存储过程返回错误。
+func (m *RPCRequestMessage) EffectiveSQL() (string, error) {
+	procName, err := m.GetProcName()
+	if err != nil {
+		return "", err
+	}
+	if !isExecuteSQL(procName) {
+		return "", fmt.Errorf("effective SQL: %s is not sp_executesql", procName)
+	}
+
+	params, err := m.GetParameters()
+	if err != nil {
+		return "", err
+	}
+	if len(params) == 0 || params[0].IsNull() {
+		return "", fmt.Errorf("%w: sp_executesql without @stmt", ErrProtocol)
+	}
+	stmt, err := params[0].Text()
+	if err != nil {
+		return "", err
+	}
+	if len(params) < 3 {
+		return stmt, nil
+	}
+
+	// 第二个参数为参数声明，按声明顺序为未命名的参数补全名称
+	var declared []string
+	if !params[1].IsNull() {
+		decl, err := params[1].Text()
+		if err != nil {
+			return "", err
+		}
+		declared = parseParamDeclarations(decl)
+	}
+
+	values := make(map[string]string)
+	for i, p := range params[2:] {
+		name := p.Name
+		if name == "" && i < len(declared) {
+			name = declared[i]
+		}
+		if name == "" {
+			continue
+		}
+		literal, err := p.SQLLiteral()
+		if err != nil {
+			return "", fmt.Errorf("parameter %s: %w", name, err)
+		}
+		values[strings.ToLower(name)] = literal
+	}
+	return substituteParameters(stmt, values), nil
+}
+
+// parseParamDeclarations 从"@p0 int, @p1 decimal(18,2) OUTPUT"形式的声明中依次提取参数名
+func parseParamDeclarations(decl string) []string {
+	var names []string
+	depth := 0
+	start := 0
+	for i := 0; i <= len(decl); i++ {
+		if i < len(decl) {
+			switch decl[i] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		fields := strings.Fields(decl[start:i])
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+			names = append(names, fields[0])
+		}
+		start = i + 1
+	}
+	return names
+}
+
+// isIdentifierChar 是否为T-SQL标识符字符
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '@' || c == '#' || c == '$' ||
+		(c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// substituteParameters 将语句中的@参数替换为字面量，跳过字符串、注释和@@系统变量
+func substituteParameters(stmt string, values map[string]string) string {
+	var sb strings.Builder
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == '\'':
+			// 字符串字面量
+			j := i + 1
+			for j < len(stmt) {
+				if stmt[j] == '\'' {
+					if j+1 < len(stmt) && stmt[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			end := min(j+1, len(stmt))
+			sb.WriteString(stmt[i:end])
+			i = end
+		case c == '-' && i+1 < len(stmt) && stmt[i+1] == '-':
+			// 行注释
+			j := strings.IndexByte(stmt[i:], '\n')
+			if j < 0 {
+				j = len(stmt) - i
+			}
+			sb.WriteString(stmt[i : i+j])
+			i += j
+		case c == '/' && i+1 < len(stmt) && stmt[i+1] == '*':
+			// 块注释
+			j := strings.Index(stmt[i+2:], "*/")
+			end := len(stmt)
+			if j >= 0 {
+				end = i + 2 + j + 2
+			}
+			sb.WriteString(stmt[i:end])
+			i = end
+		case c == '@':
+			j := i + 1
+			for j < len(stmt) && isIdentifierChar(stmt[j]) {
+				j++
+			}
+			token := stmt[i:j]
+			if literal, ok := values[strings.ToLower(token)]; ok && !strings.HasPrefix(token, "@@") {
+				sb.WriteString(literal)
+			} else {
+				sb.WriteString(token)
+			}
+			i = j
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// min 返回两个整数中的较小值
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
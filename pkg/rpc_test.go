@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testCollation 测试参数使用的排序规则(SQL_Latin1_General_CP1_CI_AS)
+var testCollation = []byte{0x09, 0x04, 0xD0, 0x00, 0x34}
+
+// nvarcharParam 构造NVARCHAR(4000)类型的RPC参数
+func nvarcharParam(name, value string) []byte {
+	param := rpcParamHeader(name, typeNVarChar)
+	param = binary.LittleEndian.AppendUint16(param, 8000)
+	param = append(param, testCollation...)
+	data := encodeUTF16LE(value)
+	param = binary.LittleEndian.AppendUint16(param, uint16(len(data)))
+	return append(param, data...)
+}
+
+// intParam 构造INTN(4)类型的RPC参数
+func intParam(name string, value int32) []byte {
+	param := append(rpcParamHeader(name, typeIntN), 4, 4)
+	return binary.LittleEndian.AppendUint32(param, uint32(value))
+}
+
+// nullIntParam 构造值为NULL的INTN(4)类型的RPC参数
+func nullIntParam(name string) []byte {
+	return append(rpcParamHeader(name, typeIntN), 4, 0)
+}
+
+// rpcParamHeader 构造参数名、状态和类型标记
+func rpcParamHeader(name string, dataType TDSDataType) []byte {
+	param := []byte{byte(len([]rune(name)))}
+	param = append(param, encodeUTF16LE(name)...)
+	return append(param, 0, byte(dataType))
+}
+
+// rpcPayload 构造带ALL_HEADERS的RPC请求，procName为空时按procID调用
+func rpcPayload(procName string, procID uint16, params ...[]byte) []byte {
+	payload := sqlBatchPayload("")
+	if procName == "" {
+		payload = binary.LittleEndian.AppendUint16(payload, 0xFFFF)
+		payload = binary.LittleEndian.AppendUint16(payload, procID)
+	} else {
+		payload = binary.LittleEndian.AppendUint16(payload, uint16(len([]rune(procName))))
+		payload = append(payload, encodeUTF16LE(procName)...)
+	}
+	payload = binary.LittleEndian.AppendUint16(payload, 0) // OptionFlags
+	for _, param := range params {
+		payload = append(payload, param...)
+	}
+	return payload
+}
+
+// rpcPacket 构造单包RPC请求
+func rpcPacket(procName string, procID uint16, params ...[]byte) []byte {
+	return buildPacket(RPC, END_OF_MESSAGE, 1, rpcPayload(procName, procID, params...))
+}
+
+// rpcMessage 构造已完整的RPCRequestMessage
+func rpcMessage(procName string, procID uint16, params ...[]byte) *RPCRequestMessage {
+	return NewRPCRequestMessageWithPacket(NewTDSPacketFromBuffer(rpcPacket(procName, procID, params...)))
+}
+
+func TestRPCParameters(t *testing.T) {
+	msg := rpcMessage("dbo.usp_get", 0, intParam("@id", 7), nvarcharParam("@name", "abc"), nullIntParam("@n"))
+	if name, err := msg.GetProcName(); err != nil || name != "dbo.usp_get" {
+		t.Fatalf("GetProcName() = %q, %v", name, err)
+	}
+	params, err := msg.GetParameters()
+	if err != nil {
+		t.Fatalf("GetParameters: %v", err)
+	}
+	if len(params) != 3 {
+		t.Fatalf("%d parameters, want 3", len(params))
+	}
+	if params[0].Name != "@id" || binary.LittleEndian.Uint32(params[0].Value) != 7 {
+		t.Errorf("parameter 0 = %s %v", params[0].Name, params[0].Value)
+	}
+	if text, err := params[1].Text(); err != nil || text != "abc" {
+		t.Errorf("parameter 1 text = %q, %v", text, err)
+	}
+	if !params[2].IsNull() {
+		t.Errorf("parameter 2 is not NULL")
+	}
+}
+
+func TestEffectiveSQL(t *testing.T) {
+	msg := rpcMessage("", ProcIDExecuteSQL,
+		nvarcharParam("", "SELECT * FROM t WHERE name = @name AND id = @id AND x = @x AND note = '@id' -- @id\n AND @@ROWCOUNT > 0"),
+		nvarcharParam("", "@name nvarchar(50), @id int, @x int"),
+		nvarcharParam("@name", "O'Brien"),
+		intParam("@id", 42),
+		nullIntParam("@x"),
+	)
+	got, err := msg.EffectiveSQL()
+	if err != nil {
+		t.Fatalf("EffectiveSQL: %v", err)
+	}
+	want := "SELECT * FROM t WHERE name = N'O''Brien' AND id = 42 AND x = NULL AND note = '@id' -- @id\n AND @@ROWCOUNT > 0"
+	if got != want {
+		t.Fatalf("EffectiveSQL() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestEffectiveSQLUnnamedParameters(t *testing.T) {
+	// 参数值未命名时按声明顺序对应
+	msg := rpcMessage("sp_executesql", 0,
+		nvarcharParam("", "UPDATE t SET v = @P2 WHERE id = @P1"),
+		nvarcharParam("", "@P1 int,@P2 decimal(18,2)"),
+		intParam("", 5),
+		nvarcharParam("", "x"),
+	)
+	got, err := msg.EffectiveSQL()
+	if err != nil {
+		t.Fatalf("EffectiveSQL: %v", err)
+	}
+	if want := "UPDATE t SET v = N'x' WHERE id = 5"; got != want {
+		t.Fatalf("EffectiveSQL() = %q, want %q", got, want)
+	}
+}
+
+func TestEffectiveSQLRejectsOtherProcedures(t *testing.T) {
+	msg := rpcMessage("dbo.usp_get", 0, intParam("@id", 7))
+	if _, err := msg.EffectiveSQL(); err == nil {
+		t.Fatal("EffectiveSQL on a non-sp_executesql call succeeded")
+	}
+}
+
+func TestParseParamDeclarations(t *testing.T) {
+	got := parseParamDeclarations("@a int, @b decimal(18, 2) OUTPUT,@c nvarchar(max)")
+	want := []string{"@a", "@b", "@c"}
+	if len(got) != len(want) {
+		t.Fatalf("parseParamDeclarations = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("parseParamDeclarations = %v, want %v", got, want)
+		}
+	}
+}
+
+// manyIntParams 构造n个整数参数
+func manyIntParams(n int) [][]byte {
+	params := make([][]byte, n)
+	for i := range params {
+		params[i] = intParam(fmt.Sprintf("@p%d", i), int32(i))
+	}
+	return params
+}
+
+func TestRPCParameterCap(t *testing.T) {
+	msg := rpcMessage("dbo.usp_wide", 0, manyIntParams(10)...)
+	msg.SetTDSVersion(TDSVersion74)
+
+	msg.SetMaxParameters(10)
+	if params, err := msg.GetParameters(); err != nil || len(params) != 10 {
+		t.Fatalf("GetParameters at the cap = %d params, %v", len(params), err)
+	}
+
+	msg.SetMaxParameters(9)
+	params, err := msg.GetParameters()
+	if !errors.Is(err, ErrBufferLimit) || !errors.Is(err, ErrProtocol) {
+		t.Errorf("GetParameters past the cap: err = %v, want ErrBufferLimit and ErrProtocol", err)
+	}
+	if params != nil {
+		t.Errorf("GetParameters past the cap returned %d params", len(params))
+	}
+
+	// 默认上限为MAX_RPC_PARAMETERS
+	wide := rpcMessage("dbo.usp_wide", 0, manyIntParams(MAX_RPC_PARAMETERS+1)...)
+	wide.SetTDSVersion(TDSVersion74)
+	if _, err := wide.GetParameters(); !errors.Is(err, ErrBufferLimit) {
+		t.Errorf("GetParameters with %d params: err = %v, want ErrBufferLimit", MAX_RPC_PARAMETERS+1, err)
+	}
+}
+
+func TestBridgeMaxRPCParameters(t *testing.T) {
+	type messageError struct {
+		msg TDSMessage
+		err error
+	}
+	errs := make(chan messageError, 2)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxRPCParameters(3)
+		ba.SetMessageErrorHandler(func(bc *BridgedConnection, msg TDSMessage, err error) {
+			errs <- messageError{msg, err}
+		})
+	})
+	wide := rpcPacket("dbo.usp_wide", 0, manyIntParams(4)...)
+	narrow := rpcPacket("dbo.usp_narrow", 0, manyIntParams(3)...)
+	h.connect(wide, narrow)
+
+	// 原始字节照常转发
+	want := append(append([]byte{}, wide...), narrow...)
+	if got := waitWritten(t, h.backend(0), len(want)); !bytes.Equal(got, want) {
+		t.Errorf("backend received %x, want both RPCs unchanged", got)
+	}
+	select {
+	case e := <-errs:
+		if !errors.Is(e.err, ErrBufferLimit) {
+			t.Errorf("message error = %v, want ErrBufferLimit", e.err)
+		}
+		if name, _ := e.msg.(*RPCRequestMessage).GetProcName(); name != "dbo.usp_wide" {
+			t.Errorf("message error for %q", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message error event")
+	}
+	select {
+	case e := <-errs:
+		t.Errorf("unexpected message error %v", e.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
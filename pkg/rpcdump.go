@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// rpcDumper 将RPC请求的有效载荷逐个写入目录中的文件
+type rpcDumper struct {
+	dir string
+	// 本次运行的文件名前缀，使多次运行或多个进程写入同一目录时文件名不冲突
+	prefix string
+	seq    atomic.Uint64
+}
+
+// SetRPCDumpDir 将每个完整RPC请求组装后的有效载荷写入dir中的新文件，
+// 文件名为本次设置时生成的前缀(时间和进程号)加递增序号和".raw"，用于离线分析RPC调用。
+// 不会覆盖已存在的文件。目录需已存在；写入失败时触发RPC转储错误事件，不影响转发。
+// 关闭解析时不生效。传入空字符串关闭。需在Start之前调用。
+func (ba *BridgeAcceptor) SetRPCDumpDir(dir string) {
+	if dir == "" {
+		ba.rpcDump = nil
+		return
+	}
+	ba.rpcDump = &rpcDumper{
+		dir:    dir,
+		prefix: fmt.Sprintf("%s-%d-", time.Now().Format("20060102T150405.000000000"), os.Getpid()),
+	}
+}
+
+// SetRPCDumpErrorHandler 设置RPC转储错误处理函数，参数为写入失败的文件路径和错误
+func (ba *BridgeAcceptor) SetRPCDumpErrorHandler(handler RPCDumpErrorHandler) {
+	ba.rpcDumpErrorHandler = handler
+}
+
+// dump 将RPC请求的有效载荷写入下一个序号的新文件，返回文件路径；文件已存在时返回错误而不覆盖
+func (d *rpcDumper) dump(msg *RPCRequestMessage) (string, error) {
+	fileName := filepath.Join(d.dir, d.prefix+strconv.FormatUint(d.seq.Add(1), 10)+".raw")
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fileName, err
+	}
+	if _, err = file.Write(msg.AssemblePayload()); err != nil {
+		file.Close()
+		return fileName, err
+	}
+	return fileName, file.Close()
+}
+
+// dumpRPC 在启用RPC转储时写入RPC请求，失败时触发RPC转储错误事件
+func (bc *BridgedConnection) dumpRPC(msg TDSMessage) {
+	dumper := bc.BridgeAcceptor.rpcDump
+	rpc, ok := msg.(*RPCRequestMessage)
+	if dumper == nil || !ok || !bc.capturesMessage(msg) {
+		return
+	}
+	if fileName, err := dumper.dump(rpc); err != nil {
+		bc.BridgeAcceptor.onRPCDumpError(bc, fileName, err)
+	}
+}
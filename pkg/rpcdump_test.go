@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpFiles 按序号顺序返回目录中的转储文件
+func dumpFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return len(files[i]) < len(files[j]) || len(files[i]) == len(files[j]) && files[i] < files[j]
+	})
+	return files
+}
+
+func TestRPCDumpWritesPayloads(t *testing.T) {
+	dir := t.TempDir()
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetRPCDumpDir(dir)
+	})
+	first := rpcPayload("sp_executesql", 0, nvarcharParam("@stmt", "SELECT 1"))
+	second := rpcPayload("", 10, intParam("@handle", 7))
+	stream := append(buildPacket(RPC, END_OF_MESSAGE, 1, first), sqlBatchPacket("SELECT 2")...)
+	stream = append(stream, buildPacket(RPC, END_OF_MESSAGE, 1, second)...)
+	h.connect(stream)
+	waitWritten(t, h.backend(0), len(stream))
+
+	waitFor(t, "dump files", func() bool { return len(dumpFiles(t, dir)) == 2 })
+	for i, want := range [][]byte{first, second} {
+		file := dumpFiles(t, dir)[i]
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s = %x, want %x", filepath.Base(file), got, want)
+		}
+	}
+}
+
+func TestRPCDumpNeverOverwrites(t *testing.T) {
+	dir := t.TempDir()
+	msg := rpcMessage("sp_who", 0)
+
+	// 两次设置(如重启后)写入同一目录，文件名不冲突
+	first := &rpcDumper{dir: dir, prefix: "run1-"}
+	second := &rpcDumper{dir: dir, prefix: "run2-"}
+	for _, d := range []*rpcDumper{first, second} {
+		if _, err := d.dump(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := dumpFiles(t, dir); len(files) != 2 {
+		t.Fatalf("dump files = %v, want one per run", files)
+	}
+
+	// 文件已存在时返回错误，原内容保留
+	existing := filepath.Join(dir, "run1-2.raw")
+	if err := os.WriteFile(existing, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileName, err := first.dump(msg)
+	if fileName != existing || !errors.Is(err, fs.ErrExist) {
+		t.Errorf("dump over an existing file = %q, %v; want %q and fs.ErrExist", fileName, err, existing)
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "keep" {
+		t.Errorf("existing file overwritten with %x", data)
+	}
+}
+
+func TestRPCDumpDirPrefixesDiffer(t *testing.T) {
+	ba := NewBridgeAcceptor("1433", "localhost:1433")
+	ba.SetRPCDumpDir("dumps")
+	prefix := ba.rpcDump.prefix
+	if !strings.HasSuffix(prefix, "-") || prefix == "-" {
+		t.Errorf("prefix = %q", prefix)
+	}
+	time.Sleep(time.Microsecond)
+	ba.SetRPCDumpDir("dumps")
+	if ba.rpcDump.prefix == prefix {
+		t.Errorf("two SetRPCDumpDir calls share prefix %q", prefix)
+	}
+	ba.SetRPCDumpDir("")
+	if ba.rpcDump != nil {
+		t.Error("empty directory did not disable dumping")
+	}
+}
+
+func TestRPCDumpErrorEvent(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	type dumpError struct {
+		fileName string
+		err      error
+	}
+	errs := make(chan dumpError, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetRPCDumpDir(dir)
+		ba.SetRPCDumpErrorHandler(func(bc *BridgedConnection, fileName string, err error) {
+			errs <- dumpError{fileName, err}
+		})
+	})
+	packet := rpcPacket("sp_who", 0)
+	h.connect(packet)
+
+	// 转储失败不影响转发
+	if got := waitWritten(t, h.backend(0), len(packet)); !bytes.Equal(got, packet) {
+		t.Errorf("backend received %x", got)
+	}
+	select {
+	case e := <-errs:
+		if filepath.Dir(e.fileName) != dir || !errors.Is(e.err, fs.ErrNotExist) {
+			t.Errorf("dump error = %q, %v", e.fileName, e.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no RPC dump error event")
+	}
+}
@@ -0,0 +1,80 @@
+package pkg
+
+// SetCaptureSecrets 设置捕获中是否保留登录密码，默认false。
+//
+// 警告：开启后SetCaptureWriter/SetGzipCaptureFile写入的Login7数据包包含客户端的密码和修改密码字段
+// (仅做了可逆的混淆，等同明文)，事件中Login7Message.GetPassword也会返回解除混淆后的密码。
+// 只应在受控的调试环境中临时开启，并妥善保管捕获文件。
+//
+// 默认情况下捕获的Login7数据包中这两个字段的字节被替换为0(转发给后端的数据不受影响)，
+// 交给事件处理函数的登录消息的GetPassword返回空字符串。
+func (ba *BridgeAcceptor) SetCaptureSecrets(enabled bool) {
+	ba.captureSecrets = enabled
+}
+
+// login7SecretFields Login7中需要在捕获时隐藏的字段
+var login7SecretFields = []int{login7Password, login7ChangePassword}
+
+// loginRedactor 在客户端数据包的捕获副本中隐藏Login7的密码字段。
+// 密码的位置由登录记录开头的偏移表决定，登录可能跨多个数据包，因此按消息内的偏移逐包处理。
+type loginRedactor struct {
+	// 当前消息是否为Login7，以及当前数据包的有效载荷在消息中的偏移
+	active bool
+	offset int
+	// 需隐藏的[开始, 结束)区间，为消息内的偏移
+	ranges [][2]int
+}
+
+// redact 返回隐藏了密码字节的数据包副本，数据包不含密码时原样返回frame
+func (r *loginRedactor) redact(frame []byte, header *TDSHeader, isFirstPacket bool) []byte {
+	payload := frame[HEADER_SIZE:]
+	if isFirstPacket {
+		r.active = header.Type() == TDS7Login
+		r.offset = 0
+		r.ranges = r.ranges[:0]
+		if r.active && len(payload) >= LOGIN7_FIXED_SIZE {
+			for _, idx := range login7SecretFields {
+				ib := int(payload[idx]) | int(payload[idx+1])<<8
+				cch := int(payload[idx+2]) | int(payload[idx+3])<<8
+				if cch > 0 {
+					r.ranges = append(r.ranges, [2]int{ib, ib + cch*2})
+				}
+			}
+		}
+	}
+	if !r.active {
+		return frame
+	}
+
+	start, end := r.offset, r.offset+len(payload)
+	r.offset = end
+	if (header.StatusBitMask() & END_OF_MESSAGE) == END_OF_MESSAGE {
+		r.active = false
+	}
+
+	var redacted []byte
+	for _, rng := range r.ranges {
+		from, to := max(rng[0], start), min(rng[1], end)
+		if from >= to {
+			continue
+		}
+		if redacted == nil {
+			redacted = append([]byte(nil), frame...)
+		}
+		for i := HEADER_SIZE + from - start; i < HEADER_SIZE+to-start; i++ {
+			redacted[i] = 0
+		}
+	}
+	if redacted == nil {
+		return frame
+	}
+	return redacted
+}
+
+// max 返回两个整数中的较大值
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// passwordRange 返回登录记录中密码字段在有效载荷中的[开始, 结束)区间
+func passwordRange(payload []byte) (int, int) {
+	ib := int(binary.LittleEndian.Uint16(payload[login7Password:]))
+	cch := int(binary.LittleEndian.Uint16(payload[login7Password+2:]))
+	return ib, ib + cch*2
+}
+
+// captureLogin 经过开启捕获的桥接器发送登录数据包，返回捕获的客户端数据包
+func captureLogin(t *testing.T, captureSecrets bool, packets ...[]byte) [][]byte {
+	t.Helper()
+	var capture syncBuffer
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetCaptureWriter(&capture)
+		ba.SetCaptureSecrets(captureSecrets)
+	})
+	var stream []byte
+	for _, packet := range packets {
+		stream = append(stream, packet...)
+	}
+	h.connect(stream)
+
+	// 转发给后端的数据不受影响
+	if got := waitWritten(t, h.backend(0), len(stream)); !bytes.Equal(got, stream) {
+		t.Fatalf("backend received %x, want the original login", got)
+	}
+	var frames [][]byte
+	waitFor(t, "captured login", func() bool {
+		frames = frames[:0]
+		for _, frame := range readCaptureFrames(t, capture.Bytes()) {
+			if frame.Source == ClientBridge {
+				frames = append(frames, frame.Data)
+			}
+		}
+		return len(frames) == len(packets)
+	})
+	return frames
+}
+
+func TestCapturedLoginPasswordZeroed(t *testing.T) {
+	payload := testLogin7{version: TDSVersion74, user: "sa", password: "Secret1!", database: "master"}.payload()
+	login := buildPacket(TDS7Login, END_OF_MESSAGE, 1, payload)
+	from, to := passwordRange(payload)
+
+	frames := captureLogin(t, false, login)
+	captured := frames[0][HEADER_SIZE:]
+	if !bytes.Equal(captured[from:to], make([]byte, to-from)) {
+		t.Errorf("captured password bytes = %x, want zeros", captured[from:to])
+	}
+	if !bytes.Equal(captured[:from], payload[:from]) || !bytes.Equal(captured[to:], payload[to:]) {
+		t.Error("redaction changed bytes outside the password field")
+	}
+}
+
+func TestCapturedLoginPasswordZeroedAcrossPackets(t *testing.T) {
+	payload := testLogin7{version: TDSVersion74, user: "sa", password: "a much longer secret", database: "master"}.payload()
+	from, to := passwordRange(payload)
+	// 在密码字段中间分包
+	split := (from + to) / 2
+	first := buildPacket(TDS7Login, NORMAL, 1, payload[:split])
+	second := buildPacket(TDS7Login, END_OF_MESSAGE, 2, payload[split:])
+
+	frames := captureLogin(t, false, first, second)
+	captured := append(append([]byte{}, frames[0][HEADER_SIZE:]...), frames[1][HEADER_SIZE:]...)
+	if !bytes.Equal(captured[from:to], make([]byte, to-from)) {
+		t.Errorf("captured password bytes = %x, want zeros", captured[from:to])
+	}
+	if !bytes.Equal(captured[to:], payload[to:]) {
+		t.Error("redaction changed bytes after the password field")
+	}
+}
+
+func TestCaptureSecretsKeepsPassword(t *testing.T) {
+	login := testLogin7{version: TDSVersion74, user: "sa", password: "Secret1!"}.packet()
+	frames := captureLogin(t, true, login)
+	if !bytes.Equal(frames[0], login) {
+		t.Error("SetCaptureSecrets(true) still redacted the captured login")
+	}
+}
+
+func TestGetPasswordRequiresOverride(t *testing.T) {
+	msg := testLogin7{version: TDSVersion74, user: "sa", password: "Secret1!"}.message()
+	if got := msg.GetPassword(); got != "" {
+		t.Errorf("GetPassword() without override = %q", got)
+	}
+	msg.SetRevealPassword(true)
+	if got := msg.GetPassword(); got != "Secret1!" {
+		t.Errorf("GetPassword() with override = %q", got)
+	}
+
+	for _, reveal := range []bool{false, true} {
+		passwords := make(chan string, 1)
+		h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+			ba.SetCaptureSecrets(reveal)
+			ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+				passwords <- msg.(*Login7Message).GetPassword()
+			})
+		})
+		h.connect(testLogin7{version: TDSVersion74, user: "sa", password: "Secret1!"}.packet())
+		want := ""
+		if reveal {
+			want = "Secret1!"
+		}
+		select {
+		case got := <-passwords:
+			if got != want {
+				t.Errorf("SetCaptureSecrets(%v): event GetPassword() = %q, want %q", reveal, got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no login event")
+		}
+	}
+}
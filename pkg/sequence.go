@@ -0,0 +1,52 @@
+package pkg
+
+// PacketSequenceAnomaly 消息内数据包ID不连续的情况
+type PacketSequenceAnomaly struct {
+	// Source 数据包的来源一侧
+	Source ConnectionType
+	// Type 数据包所属消息的类型
+	Type HeaderType
+	// Expected 按上一个数据包推算的ID，Actual 实际收到的ID
+	Expected byte
+	Actual   byte
+}
+
+// SetPacketSequenceAnomalyHandler 设置数据包序号异常处理函数，设置后检查双向每个消息内的数据包ID：
+// 除消息的第一个数据包外，每个数据包的ID应为上一个数据包的ID加1(255之后回绕为0)，
+// 不符时触发事件，参数说明期望值与实际值，之后按实际ID继续检查。只做观察，不影响转发。
+func (ba *BridgeAcceptor) SetPacketSequenceAnomalyHandler(handler PacketSequenceAnomalyHandler) {
+	ba.packetSequenceAnomalyHandler = handler
+}
+
+// packetSequence 跟踪一个方向上当前消息的数据包ID
+type packetSequence struct {
+	// 当前消息中下一个数据包应有的ID，以及是否处于消息中间
+	next      byte
+	inMessage bool
+}
+
+// check 记录一个数据包，ID与期望值不符时返回期望值和false
+func (s *packetSequence) check(header *TDSHeader) (expected byte, ok bool) {
+	expected, ok = s.next, true
+	if s.inMessage && header.PacketID() != expected {
+		ok = false
+	}
+	s.next = header.PacketID() + 1
+	s.inMessage = (header.StatusBitMask() & END_OF_MESSAGE) != END_OF_MESSAGE
+	return expected, ok
+}
+
+// checkPacketSequence 在设置了处理函数时检查数据包ID，不连续时触发数据包序号异常事件
+func (bc *BridgedConnection) checkPacketSequence(sequence *packetSequence, source ConnectionType, header *TDSHeader) {
+	if bc.BridgeAcceptor.packetSequenceAnomalyHandler == nil {
+		return
+	}
+	if expected, ok := sequence.check(header); !ok {
+		bc.BridgeAcceptor.onPacketSequenceAnomaly(bc, &PacketSequenceAnomaly{
+			Source:   source,
+			Type:     header.Type(),
+			Expected: expected,
+			Actual:   header.PacketID(),
+		})
+	}
+}
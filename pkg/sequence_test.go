@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketSequenceCheck(t *testing.T) {
+	header := func(status, id byte) *TDSHeader {
+		return NewTDSHeader(buildPacket(SQLBatch, status, id, nil)[:HEADER_SIZE])
+	}
+	for _, tc := range []struct {
+		name     string
+		packets  [][2]byte // status, id
+		badIndex int       // 不连续的数据包下标，-1表示没有
+		expected byte
+	}{
+		{"consecutive", [][2]byte{{NORMAL, 1}, {NORMAL, 2}, {END_OF_MESSAGE, 3}}, -1, 0},
+		{"wraps after 255", [][2]byte{{NORMAL, 254}, {NORMAL, 255}, {NORMAL, 0}, {END_OF_MESSAGE, 1}}, -1, 0},
+		{"skipped id", [][2]byte{{NORMAL, 1}, {NORMAL, 2}, {END_OF_MESSAGE, 4}}, 2, 3},
+		{"repeated id", [][2]byte{{NORMAL, 7}, {END_OF_MESSAGE, 7}}, 1, 8},
+		{"new message may restart", [][2]byte{{END_OF_MESSAGE, 1}, {END_OF_MESSAGE, 1}, {NORMAL, 9}, {END_OF_MESSAGE, 10}}, -1, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var s packetSequence
+			for i, p := range tc.packets {
+				expected, ok := s.check(header(p[0], p[1]))
+				if want := i != tc.badIndex; ok != want {
+					t.Errorf("packet %d: ok = %v, want %v", i, ok, want)
+				}
+				if !ok && expected != tc.expected {
+					t.Errorf("packet %d: expected = %d, want %d", i, expected, tc.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestPacketSequenceAnomalyEvent(t *testing.T) {
+	anomalies := make(chan *PacketSequenceAnomaly, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetPacketSequenceAnomalyHandler(func(bc *BridgedConnection, anomaly *PacketSequenceAnomaly) {
+			anomalies <- anomaly
+		})
+	})
+
+	// 客户端消息跳过了ID 2，之后的消息正常
+	payload := sqlBatchPayload("SELECT 1")
+	stream := append(buildPacket(SQLBatch, NORMAL, 1, payload[:10]),
+		buildPacket(SQLBatch, END_OF_MESSAGE, 3, payload[10:])...)
+	stream = append(stream, sqlBatchPacket("SELECT 2")...)
+	h.connect(stream)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(stream))
+
+	// 服务器响应跳过了ID 2
+	done := doneResponse(DONE_FINAL, 0)
+	backend.feed(append(buildPacket(TabularResult, NORMAL, 1, nil), buildPacket(TabularResult, END_OF_MESSAGE, 3, done[HEADER_SIZE:])...))
+
+	want := []PacketSequenceAnomaly{
+		{Source: ClientBridge, Type: SQLBatch, Expected: 2, Actual: 3},
+		{Source: BridgeSQL, Type: TabularResult, Expected: 2, Actual: 3},
+	}
+	for _, w := range want {
+		select {
+		case got := <-anomalies:
+			if *got != w {
+				t.Errorf("anomaly = %+v, want %+v", *got, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no anomaly, want %+v", w)
+		}
+	}
+	select {
+	case got := <-anomalies:
+		t.Errorf("unexpected anomaly %+v", *got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ServerError 服务器在ERROR令牌中返回的错误
+type ServerError struct {
+	Number     int32
+	State      byte
+	Class      byte
+	Message    string
+	ServerName string
+	ProcName   string
+	LineNumber int32
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("Msg %d, Level %d, State %d, Line %d: %s", e.Number, e.Class, e.State, e.LineNumber, e.Message)
+}
+
+// DecodeServerError 解码ERROR或INFO令牌，两者格式相同。
+// TDS 7.2之前LineNumber为2字节，按令牌体剩余长度判断。
+func DecodeServerError(t *Token) (*ServerError, error) {
+	if t.Type != TokenError && t.Type != TokenInfo {
+		return nil, fmt.Errorf("%w: %s is not an ERROR or INFO token", ErrProtocol, t.Type)
+	}
+	r := bytes.NewReader(t.Data)
+	// 令牌体长度
+	if _, err := readUint16(r); err != nil {
+		return nil, err
+	}
+
+	e := &ServerError{}
+	number, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	e.Number = int32(number)
+	if e.State, err = readByte(r); err != nil {
+		return nil, err
+	}
+	if e.Class, err = readByte(r); err != nil {
+		return nil, err
+	}
+	if e.Message, err = readUSVarChar(r); err != nil {
+		return nil, err
+	}
+	if e.ServerName, err = readBVarChar(r); err != nil {
+		return nil, err
+	}
+	if e.ProcName, err = readBVarChar(r); err != nil {
+		return nil, err
+	}
+	if r.Len() >= 4 {
+		line, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		e.LineNumber = int32(line)
+	} else {
+		line, err := readUint16(r)
+		if err != nil {
+			return nil, err
+		}
+		e.LineNumber = int32(line)
+	}
+	return e, nil
+}
+
+// GetServerErrors 解码响应中的所有ERROR令牌。令牌流无法完整解析时，
+// 返回已解析部分中的错误以及解析错误。
+func (m *TabularResultMessage) GetServerErrors() ([]*ServerError, error) {
+	tokens, parseErr := m.GetTokens()
+	var errs []*ServerError
+	for _, token := range tokens {
+		if token.Type != TokenError {
+			continue
+		}
+		e, err := DecodeServerError(token)
+		if err != nil {
+			return errs, err
+		}
+		errs = append(errs, e)
+	}
+	return errs, parseErr
+}
+
+// HasServerError 检查响应中是否有DONE类令牌设置了DONE_ERROR或DONE_SRVERROR
+func (m *TabularResultMessage) HasServerError() bool {
+	tokens, _ := m.GetTokens()
+	for _, token := range tokens {
+		if token.Type != TokenDone && token.Type != TokenDoneProc && token.Type != TokenDoneInProc {
+			continue
+		}
+		if done, err := decodeDoneToken(token); err == nil && done.Status&(DONE_ERROR|DONE_SRVERROR) != 0 {
+			return true
+		}
+	}
+	return false
+}
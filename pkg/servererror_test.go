@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// errorToken 构造ERROR或INFO令牌，lineBytes为LineNumber的宽度(TDS 7.2起为4)
+func errorToken(tokenType TokenType, e ServerError, lineBytes int) []byte {
+	body := binary.LittleEndian.AppendUint32(nil, uint32(e.Number))
+	body = append(body, e.State, e.Class)
+	message := encodeUTF16LE(e.Message)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(message)/2))
+	body = append(body, message...)
+	for _, s := range []string{e.ServerName, e.ProcName} {
+		text := encodeUTF16LE(s)
+		body = append(body, byte(len(text)/2))
+		body = append(body, text...)
+	}
+	if lineBytes == 4 {
+		body = binary.LittleEndian.AppendUint32(body, uint32(e.LineNumber))
+	} else {
+		body = binary.LittleEndian.AppendUint16(body, uint16(e.LineNumber))
+	}
+	token := []byte{byte(tokenType)}
+	token = binary.LittleEndian.AppendUint16(token, uint16(len(body)))
+	return append(token, body...)
+}
+
+var divideByZero = ServerError{
+	Number: 8134, State: 1, Class: 16, Message: "Divide by zero error encountered.",
+	ServerName: "SQL01", ProcName: "usp_calc", LineNumber: 12,
+}
+
+func TestGetServerErrors(t *testing.T) {
+	conversion := ServerError{Number: 245, State: 1, Class: 16, Message: "Conversion failed.", ServerName: "SQL01", LineNumber: 3}
+	info := ServerError{Number: 5701, State: 2, Class: 0, Message: "Changed database context to 'master'.", ServerName: "SQL01", LineNumber: 1}
+	msg := tokensMessage(
+		errorToken(TokenInfo, info, 4),
+		errorToken(TokenError, divideByZero, 4),
+		errorToken(TokenError, conversion, 4),
+		doneToken(TokenDone, DONE_ERROR|DONE_FINAL, 0xC1, 0),
+	)
+
+	errs, err := msg.GetServerErrors()
+	if err != nil {
+		t.Fatalf("GetServerErrors: %v", err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("got %d errors, want 2 (INFO is not an error)", len(errs))
+	}
+	if *errs[0] != divideByZero {
+		t.Errorf("first error = %+v, want %+v", *errs[0], divideByZero)
+	}
+	if *errs[1] != conversion {
+		t.Errorf("second error = %+v, want %+v", *errs[1], conversion)
+	}
+	if got, want := errs[0].Error(), "Msg 8134, Level 16, State 1, Line 12: Divide by zero error encountered."; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !msg.HasServerError() {
+		t.Error("HasServerError() = false for a DONE with DONE_ERROR")
+	}
+	if tokensMessage(doneToken(TokenDone, DONE_FINAL, 0xC1, 0)).HasServerError() {
+		t.Error("HasServerError() = true for a clean DONE")
+	}
+}
+
+func TestDecodeServerErrorShortLineNumber(t *testing.T) {
+	tokens, err := tokensMessage(errorToken(TokenInfo, divideByZero, 2)).GetTokens()
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("GetTokens() = %v, %v", tokens, err)
+	}
+	e, err := DecodeServerError(tokens[0])
+	if err != nil {
+		t.Fatalf("DecodeServerError: %v", err)
+	}
+	if *e != divideByZero {
+		t.Errorf("decoded %+v, want %+v", *e, divideByZero)
+	}
+}
+
+func TestServerErrorHandler(t *testing.T) {
+	errs := make(chan *ServerError, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetServerErrorHandler(func(bc *BridgedConnection, e *ServerError) {
+			errs <- e
+		})
+	})
+	client := h.connect(sqlBatchPacket("SELECT 1/0"))
+	backend := h.backend(0)
+	payload := append(errorToken(TokenError, divideByZero, 4), doneToken(TokenDone, DONE_ERROR|DONE_FINAL, 0xC1, 0)...)
+	response := buildPacket(TabularResult, END_OF_MESSAGE, 1, payload)
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+
+	select {
+	case e := <-errs:
+		if *e != divideByZero {
+			t.Errorf("server error event %+v, want %+v", *e, divideByZero)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no server error event")
+	}
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DEFAULT_DRAIN_TIMEOUT RunUntilSignal停止时等待活动连接自行结束的最长时间
+const DEFAULT_DRAIN_TIMEOUT = 30 * time.Second
+
+// drainPollInterval 等待活动连接结束时检查连接数的间隔
+const drainPollInterval = 100 * time.Millisecond
+
+// StopGracefully 停止接受新连接，然后等待活动连接自行结束，最多等待timeout；
+// 超时后关闭剩余的连接并返回ErrDrainTimeout。timeout为0时不等待，直接关闭所有连接。
+// 事件队列和捕获在等待结束后才停止，排空期间的连接仍正常触发事件和写入捕获。
+func (ba *BridgeAcceptor) StopGracefully(timeout time.Duration) error {
+	ba.mu.Lock()
+	stopped := ba.closeListeners()
+	ba.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for len(ba.Connections()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	remaining := ba.Connections()
+	for _, bc := range remaining {
+		bc.closeWithReason(ErrDrainTimeout)
+	}
+
+	// 等待期间重新启动时队列和捕获属于新的运行，不再停止
+	if stopped {
+		ba.mu.Lock()
+		if !ba.enabled {
+			ba.releaseRunState()
+		}
+		ba.mu.Unlock()
+	}
+
+	if len(remaining) == 0 {
+		return nil
+	}
+	return newBridgeError(ErrDrainTimeout, "stop", fmt.Errorf("closed %d active connections", len(remaining)))
+}
+
+// RunUntilSignal 启动BridgeAcceptor，阻塞直到收到任一指定的信号，然后以DEFAULT_DRAIN_TIMEOUT
+// 优雅停止，返回启动或停止时的错误。未指定信号时等待os.Interrupt和SIGTERM。
+//
+//	if err := bridgeAcceptor.RunUntilSignal(); err != nil {
+//		log.Fatal(err)
+//	}
+func (ba *BridgeAcceptor) RunUntilSignal(sig ...os.Signal) error {
+	if len(sig) == 0 {
+		sig = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig...)
+	defer signal.Stop(signals)
+
+	return ba.runUntil(signals, DEFAULT_DRAIN_TIMEOUT)
+}
+
+// runUntil 启动BridgeAcceptor，等到stop中有值后优雅停止
+func (ba *BridgeAcceptor) runUntil(stop <-chan os.Signal, drainTimeout time.Duration) error {
+	if err := ba.Start(); err != nil {
+		return err
+	}
+	<-stop
+	return ba.StopGracefully(drainTimeout)
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunUntilStopsOnSignal(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback listen unavailable: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(probe.Addr().String())
+	probe.Close()
+
+	ba := NewBridgeAcceptor(port, "127.0.0.1:1")
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- ba.runUntil(signals, time.Second) }()
+
+	addr := net.JoinHostPort("127.0.0.1", port)
+	waitFor(t, "acceptor listening", func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+
+	signals <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("runUntil() = %v, want a clean stop", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runUntil did not return after the signal")
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("acceptor still listening after the signal")
+	}
+}
+
+func TestStopGracefullyCapturesWhileDraining(t *testing.T) {
+	var capture syncBuffer
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetCaptureWriter(&capture)
+	})
+	client := h.connect()
+	backend := h.backend(0)
+
+	done := make(chan error, 1)
+	go func() { done <- h.ba.StopGracefully(5 * time.Second) }()
+	waitFor(t, "acceptor stopped", func() bool {
+		h.ba.mu.Lock()
+		defer h.ba.mu.Unlock()
+		return !h.ba.enabled
+	})
+
+	// 排空期间的流量仍写入捕获
+	batch := sqlBatchPacket("SELECT 1")
+	client.feed(batch)
+	waitWritten(t, backend, len(batch))
+	client.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("StopGracefully() = %v, want the connection to drain", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("StopGracefully did not return after the connection closed")
+	}
+	frames := readCaptureFrames(t, capture.Bytes())
+	if len(frames) != 1 || !bytes.Equal(frames[0].Data, batch) {
+		t.Errorf("captured %d frames, want the batch sent while draining", len(frames))
+	}
+}
@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// TimeoutKind 超时的种类
+type TimeoutKind int
+
+const (
+	// TimeoutIdle 两个方向都超过SetIdleTimeout的时长没有数据
+	TimeoutIdle TimeoutKind = iota
+	// TimeoutLifetime 连接超过SetMaxConnectionLifetime的最长存活时间
+	TimeoutLifetime
+	// TimeoutHandshake 开始转发之前的握手超时：预读或重放登录、代理握手、连接后端
+	TimeoutHandshake
+	// TimeoutRead 转发时读取超时
+	TimeoutRead
+	// TimeoutWrite 转发时写入超时
+	TimeoutWrite
+)
+
+func (k TimeoutKind) String() string {
+	switch k {
+	case TimeoutIdle:
+		return "Idle"
+	case TimeoutLifetime:
+		return "Lifetime"
+	case TimeoutHandshake:
+		return "Handshake"
+	case TimeoutRead:
+		return "Read"
+	case TimeoutWrite:
+		return "Write"
+	default:
+		return "Unknown"
+	}
+}
+
+// SetTimeoutHandler 设置超时处理函数，连接因任一种超时而关闭时触发一次，参数说明超时的种类。
+// 超时导致的读写错误仍会照常触发桥接异常事件(匹配ErrTimeout)，本事件用于区分超时的来源。
+func (ba *BridgeAcceptor) SetTimeoutHandler(handler TimeoutHandler) {
+	ba.timeoutHandler = handler
+}
+
+// isTimeout 检查错误是否为网络超时
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// ioTimeoutKind 按网络错误的操作区分读超时和写超时，不是超时时第二个返回值为false
+func ioTimeoutKind(err error) (TimeoutKind, bool) {
+	if !isTimeout(err) {
+		return 0, false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "write" {
+		return TimeoutWrite, true
+	}
+	return TimeoutRead, true
+}
+
+// onTimeout 触发超时事件
+func (bc *BridgedConnection) onTimeout(kind TimeoutKind) {
+	bc.BridgeAcceptor.onTimeout(bc, kind)
+}
+
+// WRITE_TIMEOUT_CHUNK 启用写入超时时单次写入的最大字节数，每块写入前重新设置写入期限
+const WRITE_TIMEOUT_CHUNK = 32 * 1024
+
+// SetWriteTimeout 设置转发时的写入超时，0表示不限制。超时按写入进展计算而非整体期限：
+// 大的写入被分为WRITE_TIMEOUT_CHUNK大小的块，每块写入前重新设置期限，写出了部分数据的块也重新计时，
+// 因此缓慢但持续读取的对端不会超时，只有该时长内完全没有读取的对端才会触发超时，
+// 连接随后关闭并触发超时事件(TimeoutWrite)。两个方向都生效。仅影响之后建立的连接。
+func (ba *BridgeAcceptor) SetWriteTimeout(d time.Duration) {
+	ba.writeTimeout = d
+}
+
+// progressWriteConn 写入期限随写入进展重新计时的连接
+type progressWriteConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *progressWriteConn) Write(b []byte) (int, error) {
+	defer c.Conn.SetWriteDeadline(time.Time{})
+
+	written := 0
+	for written < len(b) {
+		chunk := b[written:]
+		if len(chunk) > WRITE_TIMEOUT_CHUNK {
+			chunk = chunk[:WRITE_TIMEOUT_CHUNK]
+		}
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			// 期限内写出了部分数据说明对端仍在读取，重新计时后继续
+			if n > 0 && isTimeout(err) {
+				continue
+			}
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// applyWriteTimeout 为连接的两端启用写入超时
+func (sc *SocketCouple) applyWriteTimeout(timeout time.Duration) {
+	sc.ClientBridgeSocket = &progressWriteConn{Conn: sc.ClientBridgeSocket, timeout: timeout}
+	sc.BridgeSQLSocket = &progressWriteConn{Conn: sc.BridgeSQLSocket, timeout: timeout}
+}
@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// timeoutReadConn 读取时返回读超时错误的连接
+type timeoutReadConn struct {
+	*scriptedConn
+}
+
+func (c *timeoutReadConn) Read(b []byte) (int, error) {
+	return 0, &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+}
+
+// timeoutHarness 记录超时事件的桥接环境
+func timeoutHarness(t *testing.T, configure func(ba *BridgeAcceptor)) (*bridgeHarness, chan TimeoutKind) {
+	timeouts := make(chan TimeoutKind, 4)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetTimeoutHandler(func(bc *BridgedConnection, kind TimeoutKind) {
+			timeouts <- kind
+		})
+		if configure != nil {
+			configure(ba)
+		}
+	})
+	return h, timeouts
+}
+
+// expectTimeout 等待第一个超时事件并检查其种类
+func expectTimeout(t *testing.T, timeouts chan TimeoutKind, want TimeoutKind) {
+	t.Helper()
+	select {
+	case kind := <-timeouts:
+		if kind != want {
+			t.Errorf("timeout kind = %s, want %s", kind, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no timeout event, want %s", want)
+	}
+}
+
+func TestTimeoutKindIdle(t *testing.T) {
+	h, timeouts := timeoutHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetIdleTimeout(30 * time.Millisecond)
+	})
+	h.connect()
+	expectTimeout(t, timeouts, TimeoutIdle)
+}
+
+func TestTimeoutKindLifetime(t *testing.T) {
+	h, timeouts := timeoutHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxConnectionLifetime(30 * time.Millisecond)
+	})
+	h.connect()
+	expectTimeout(t, timeouts, TimeoutLifetime)
+}
+
+func TestTimeoutKindHandshake(t *testing.T) {
+	h, timeouts := timeoutHarness(t, func(ba *BridgeAcceptor) {
+		// 预读登录时客户端读取超时
+		ba.SetBackendSelector(func(net.Conn, *Login7Message) (string, error) { return "db-a:1433", nil }, true)
+	})
+	h.listener.conns <- &timeoutReadConn{newScriptedConn()}
+	expectTimeout(t, timeouts, TimeoutHandshake)
+}
+
+func TestTimeoutKindRead(t *testing.T) {
+	h, timeouts := timeoutHarness(t, nil)
+	h.listener.conns <- &timeoutReadConn{newScriptedConn()}
+	expectTimeout(t, timeouts, TimeoutRead)
+}
+
+func TestTimeoutKindWrite(t *testing.T) {
+	h, timeouts := timeoutHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetWriteTimeout(time.Second)
+	})
+	client := h.connect()
+	backend := h.backend(0)
+	response := doneResponse(DONE_FINAL, 0)
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+
+	// 后端不再读取，写入超时
+	backend.failWrites(&net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded})
+	client.feed(sqlBatchPacket("SELECT 1"))
+	expectTimeout(t, timeouts, TimeoutWrite)
+}
+
+func TestTimeoutKindString(t *testing.T) {
+	for kind, want := range map[TimeoutKind]string{
+		TimeoutIdle: "Idle", TimeoutLifetime: "Lifetime", TimeoutHandshake: "Handshake",
+		TimeoutRead: "Read", TimeoutWrite: "Write", TimeoutKind(99): "Unknown",
+	} {
+		if got := kind.String(); got != want {
+			t.Errorf("TimeoutKind(%d).String() = %q, want %q", int(kind), got, want)
+		}
+	}
+}
+
+func TestIOTimeoutKind(t *testing.T) {
+	read := &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}
+	write := &net.OpError{Op: "write", Err: os.ErrDeadlineExceeded}
+	if kind, ok := ioTimeoutKind(newBridgeError(ErrTimeout, "client", read)); !ok || kind != TimeoutRead {
+		t.Errorf("wrapped read timeout = %s, %v", kind, ok)
+	}
+	if kind, ok := ioTimeoutKind(write); !ok || kind != TimeoutWrite {
+		t.Errorf("write timeout = %s, %v", kind, ok)
+	}
+	if _, ok := ioTimeoutKind(errors.New("connection reset")); ok {
+		t.Error("non-timeout error classified as a timeout")
+	}
+}
+
+func TestWriteTimeoutRearmsOnProgress(t *testing.T) {
+	local, peer := net.Pipe()
+	defer local.Close()
+	defer peer.Close()
+	conn := &progressWriteConn{Conn: local, timeout: 30 * time.Millisecond}
+
+	// 对端每5ms读取16KB，整个写入远超过超时时长，但每段时间内都有进展
+	data := make([]byte, 16*WRITE_TIMEOUT_CHUNK)
+	go func() {
+		buf := make([]byte, 16*1024)
+		for {
+			time.Sleep(5 * time.Millisecond)
+			if _, err := peer.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	start := time.Now()
+	if n, err := conn.Write(data); err != nil || n != len(data) {
+		t.Fatalf("Write() = %d, %v, want all %d bytes", n, err, len(data))
+	}
+	if elapsed := time.Since(start); elapsed < conn.timeout {
+		t.Fatalf("write finished in %v, the reader was not slow enough to exercise the timeout", elapsed)
+	}
+}
+
+func TestWriteTimeoutFiresOnStalledPeer(t *testing.T) {
+	local, peer := net.Pipe()
+	defer local.Close()
+	defer peer.Close()
+	conn := &progressWriteConn{Conn: local, timeout: 30 * time.Millisecond}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 1024))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if kind, ok := ioTimeoutKind(err); !ok || kind != TimeoutWrite {
+			t.Errorf("Write() error = %v, want a write timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("write to a stalled peer did not time out")
+	}
+}
@@ -0,0 +1,295 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ColumnMetadata COLMETADATA令牌中的一列
+type ColumnMetadata struct {
+	UserType  uint32
+	Flags     uint16
+	TypeInfo  *TypeInfo
+	TableName []string
+	Name      string
+}
+
+// Token 服务器响应令牌流中的一个令牌
+type Token struct {
+	Type TokenType
+	// Data 令牌体(不含令牌类型字节)的原始字节
+	Data []byte
+	// Columns COLMETADATA令牌解析出的列
+	Columns []*ColumnMetadata
+	// Values ROW和NBCROW令牌解析出的列值，NULL为nil
+	Values [][]byte
+}
+
+// 其他可能出现在响应中的令牌
+const (
+	tokenOffset       = 0x78
+	tokenTabName      = 0xA4
+	tokenColInfo      = 0xA5
+	tokenSessionState = 0xE4
+	tokenFedAuthInfo  = 0xEE
+)
+
+// doneTokenSize 返回DONE类令牌体的长度，TDS 7.2起行数为8字节
+func doneTokenSize(version TDSVersion) int {
+	if version == TDSVersionUnknown || version.AtLeast(TDSVersion72) {
+		return 12
+	}
+	return 8
+}
+
+// ParseTokens 解析表格结果(TabularResult)的令牌流。
+// version用于确定与版本相关的字段长度，未知时按TDS 7.2及以上处理。
+// 遇到不支持的令牌时返回已解析的令牌和错误。
+func ParseTokens(payload []byte, version TDSVersion) ([]*Token, error) {
+	r := bytes.NewReader(payload)
+	var tokens []*Token
+	var columns []*ColumnMetadata
+
+	for r.Len() > 0 {
+		start := len(payload) - r.Len()
+		t, _ := r.ReadByte()
+		token := &Token{Type: TokenType(t)}
+
+		var err error
+		switch token.Type {
+		case TokenError, TokenInfo, TokenLoginAck, TokenEnvChange, TokenOrder, TokenSSPI, tokenTabName, tokenColInfo:
+			var n uint16
+			if n, err = readUint16(r); err == nil {
+				_, err = readBytes(r, int(n))
+			}
+		case TokenDone, TokenDoneProc, TokenDoneInProc:
+			_, err = readBytes(r, doneTokenSize(version))
+		case TokenReturnStatus, tokenOffset:
+			_, err = readBytes(r, 4)
+		case tokenSessionState, tokenFedAuthInfo:
+			var n uint32
+			if n, err = readUint32(r); err == nil {
+				_, err = readBytes(r, int(n))
+			}
+		case TokenFeatureExtAck:
+			err = skipFeatureExtAck(r)
+		case TokenColMetadata:
+			columns, err = readColMetadata(r, version)
+			token.Columns = columns
+		case TokenRow:
+			token.Values, err = readRow(r, columns)
+		case TokenNBCRow:
+			token.Values, err = readNBCRow(r, columns)
+		case TokenReturnValue:
+			err = skipReturnValue(r, version)
+		default:
+			err = fmt.Errorf("%w: unsupported token 0x%02X at offset %d", ErrProtocol, t, start)
+		}
+		if err != nil {
+			return tokens, err
+		}
+
+		token.Data = payload[start+1 : len(payload)-r.Len()]
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// skipFeatureExtAck 跳过FEATUREEXTACK令牌体，以0xFF结束
+func skipFeatureExtAck(r *bytes.Reader) error {
+	for {
+		id, err := readByte(r)
+		if err != nil {
+			return err
+		}
+		if id == 0xFF {
+			return nil
+		}
+		n, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		if _, err := readBytes(r, int(n)); err != nil {
+			return err
+		}
+	}
+}
+
+// readUserType 读取UserType，TDS 7.2起为4字节
+func readUserType(r *bytes.Reader, version TDSVersion) (uint32, error) {
+	if version == TDSVersionUnknown || version.AtLeast(TDSVersion72) {
+		return readUint32(r)
+	}
+	v, err := readUint16(r)
+	return uint32(v), err
+}
+
+// readColMetadata 读取COLMETADATA令牌体
+func readColMetadata(r *bytes.Reader, version TDSVersion) ([]*ColumnMetadata, error) {
+	count, err := readUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	// 0xFFFF表示没有元数据
+	if count == 0xFFFF {
+		return nil, nil
+	}
+
+	columns := make([]*ColumnMetadata, 0, count)
+	for i := 0; i < int(count); i++ {
+		col := &ColumnMetadata{}
+		if col.UserType, err = readUserType(r, version); err != nil {
+			return nil, err
+		}
+		if col.Flags, err = readUint16(r); err != nil {
+			return nil, err
+		}
+		if col.TypeInfo, err = readTypeInfo(r); err != nil {
+			return nil, err
+		}
+		switch col.TypeInfo.Type {
+		case typeText, typeNText, typeImage:
+			parts, err := readByte(r)
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j < int(parts); j++ {
+				part, err := readUSVarChar(r)
+				if err != nil {
+					return nil, err
+				}
+				col.TableName = append(col.TableName, part)
+			}
+		}
+		if col.Name, err = readBVarChar(r); err != nil {
+			return nil, err
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// readColumnValue 按列元数据读取行中的一个值，NULL返回nil
+func readColumnValue(r *bytes.Reader, ti *TypeInfo) ([]byte, error) {
+	switch ti.Type {
+	case typeText, typeNText, typeImage:
+		// TextPointer为空表示NULL，否则跳过指针和8字节时间戳
+		n, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		if _, err := readBytes(r, int(n)+8); err != nil {
+			return nil, err
+		}
+		length, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		return readBytes(r, int(length))
+	}
+	return readTypedValue(r, ti)
+}
+
+// readRow 读取ROW令牌体
+func readRow(r *bytes.Reader, columns []*ColumnMetadata) ([][]byte, error) {
+	values := make([][]byte, len(columns))
+	for i, col := range columns {
+		value, err := readColumnValue(r, col.TypeInfo)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// readNBCRow 读取NBCROW令牌体：先是每列一位的空值位图(按列数向上取整到字节)，
+// 位为1的列为NULL且不占用值字节，其余列按ROW的格式依次排列
+func readNBCRow(r *bytes.Reader, columns []*ColumnMetadata) ([][]byte, error) {
+	bitmap, err := readBytes(r, (len(columns)+7)/8)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(columns))
+	for i, col := range columns {
+		if bitmap[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
+		value, err := readColumnValue(r, col.TypeInfo)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// skipReturnValue 跳过RETURNVALUE令牌体
+func skipReturnValue(r *bytes.Reader, version TDSVersion) error {
+	// ParamOrdinal
+	if _, err := readUint16(r); err != nil {
+		return err
+	}
+	if _, err := readBVarChar(r); err != nil {
+		return err
+	}
+	// Status
+	if _, err := readByte(r); err != nil {
+		return err
+	}
+	if _, err := readUserType(r, version); err != nil {
+		return err
+	}
+	// Flags
+	if _, err := readUint16(r); err != nil {
+		return err
+	}
+	ti, err := readTypeInfo(r)
+	if err != nil {
+		return err
+	}
+	_, err = readTypedValue(r, ti)
+	return err
+}
+
+// DoneToken DONE、DONEPROC或DONEINPROC令牌
+type DoneToken struct {
+	Type     TokenType
+	Status   uint16
+	CurCmd   uint16
+	RowCount uint64
+}
+
+// IsFinal 是否为不带DONE_MORE的最终DONE/DONEPROC
+func (d DoneToken) IsFinal() bool {
+	return d.Type != TokenDoneInProc && (d.Status&DONE_MORE) == 0
+}
+
+// HasRowCount 行数是否有效(设置了DONE_COUNT)
+func (d DoneToken) HasRowCount() bool {
+	return (d.Status & DONE_COUNT) != 0
+}
+
+// decodeDoneToken 解码DONE类令牌
+func decodeDoneToken(t *Token) (DoneToken, error) {
+	r := bytes.NewReader(t.Data)
+	done := DoneToken{Type: t.Type}
+	var err error
+	if done.Status, err = readUint16(r); err != nil {
+		return done, err
+	}
+	if done.CurCmd, err = readUint16(r); err != nil {
+		return done, err
+	}
+	if r.Len() >= 8 {
+		done.RowCount, err = readUint64(r)
+	} else {
+		var count uint32
+		count, err = readUint32(r)
+		done.RowCount = uint64(count)
+	}
+	return done, err
+}
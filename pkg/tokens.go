@@ -0,0 +1,70 @@
+package pkg
+
+// TokenType 表格结果(TabularResult)中的令牌类型
+type TokenType byte
+
+const (
+	TokenReturnStatus  TokenType = 0x79
+	TokenColMetadata   TokenType = 0x81
+	TokenOrder         TokenType = 0xA9
+	TokenError         TokenType = 0xAA
+	TokenInfo          TokenType = 0xAB
+	TokenReturnValue   TokenType = 0xAC
+	TokenLoginAck      TokenType = 0xAD
+	TokenFeatureExtAck TokenType = 0xAE
+	TokenRow           TokenType = 0xD1
+	TokenNBCRow        TokenType = 0xD2
+	TokenEnvChange     TokenType = 0xE3
+	TokenSSPI          TokenType = 0xED
+	TokenDone          TokenType = 0xFD
+	TokenDoneProc      TokenType = 0xFE
+	TokenDoneInProc    TokenType = 0xFF
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case TokenReturnStatus:
+		return "RETURNSTATUS"
+	case TokenColMetadata:
+		return "COLMETADATA"
+	case TokenOrder:
+		return "ORDER"
+	case TokenError:
+		return "ERROR"
+	case TokenInfo:
+		return "INFO"
+	case TokenReturnValue:
+		return "RETURNVALUE"
+	case TokenLoginAck:
+		return "LOGINACK"
+	case TokenFeatureExtAck:
+		return "FEATUREEXTACK"
+	case TokenRow:
+		return "ROW"
+	case TokenNBCRow:
+		return "NBCROW"
+	case TokenEnvChange:
+		return "ENVCHANGE"
+	case TokenSSPI:
+		return "SSPI"
+	case TokenDone:
+		return "DONE"
+	case TokenDoneProc:
+		return "DONEPROC"
+	case TokenDoneInProc:
+		return "DONEINPROC"
+	default:
+		return "Unknown"
+	}
+}
+
+// DONE/DONEPROC/DONEINPROC令牌的状态位
+const (
+	DONE_FINAL    = 0x00
+	DONE_MORE     = 0x01
+	DONE_ERROR    = 0x02
+	DONE_INXACT   = 0x04
+	DONE_COUNT    = 0x10
+	DONE_ATTN     = 0x20
+	DONE_SRVERROR = 0x100
+)
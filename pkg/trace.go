@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TRACE_PREVIEW_BYTES 协议跟踪中每个数据包显示的有效载荷字节数
+const TRACE_PREVIEW_BYTES = 16
+
+// statusBitNames 状态位名称，按位从低到高
+var statusBitNames = []struct {
+	bit  byte
+	name string
+}{
+	{END_OF_MESSAGE, "EOM"},
+	{IGNORE_EVENT, "IGNORE"},
+	{MULTI_PART_MESSAGE, "MULTI_PART"},
+	{RESET_CONNECTION, "RESET_CONNECTION"},
+	{RESET_CONNECTION_SKIP_TRAN, "RESET_CONNECTION_SKIP_TRAN"},
+}
+
+// StatusBitString 将状态位掩码解码为以|分隔的名称，如"EOM|RESET_CONNECTION"；无状态位时返回"NORMAL"
+func StatusBitString(status byte) string {
+	var names []string
+	for _, sb := range statusBitNames {
+		if status&sb.bit != 0 {
+			names = append(names, sb.name)
+			status &^= sb.bit
+		}
+	}
+	if status != 0 {
+		names = append(names, fmt.Sprintf("0x%02X", status))
+	}
+	if len(names) == 0 {
+		return "NORMAL"
+	}
+	return strings.Join(names, "|")
+}
+
+// HexPreview 返回data前n个字节的十六进制和可打印字符预览，超出部分以...表示
+func HexPreview(data []byte, n int) string {
+	truncated := len(data) > n
+	if truncated {
+		data = data[:n]
+	}
+	sb := strings.Builder{}
+	for i, b := range data {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%02X", b)
+	}
+	if truncated {
+		sb.WriteString(" ...")
+	}
+	sb.WriteString(" |")
+	for _, b := range data {
+		if b >= 0x20 && b < 0x7F {
+			sb.WriteByte(b)
+		} else {
+			sb.WriteByte('.')
+		}
+	}
+	sb.WriteByte('|')
+	return sb.String()
+}
+
+// packetTracer 将每帧的注释行写入io.Writer，多个连接并发写入时按行串行化
+type packetTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// trace 写入一帧的注释行
+func (pt *packetTracer) trace(connectionID uint64, source ConnectionType, frame []byte, isTLSRecord bool) {
+	direction := "C->S"
+	if source == BridgeSQL {
+		direction = "S->C"
+	}
+
+	var line string
+	if isTLSRecord {
+		line = fmt.Sprintf("%s #%d %s TLS record type=%d len=%d %s\n",
+			time.Now().UTC().Format(time.RFC3339Nano), connectionID, direction, frame[0], len(frame),
+			HexPreview(frame[tlsRecordHeaderLen:], TRACE_PREVIEW_BYTES))
+	} else {
+		header := NewTDSHeader(frame)
+		line = fmt.Sprintf("%s #%d %s %s status=%s spid=%d packet=%d len=%d %s\n",
+			time.Now().UTC().Format(time.RFC3339Nano), connectionID, direction,
+			header.Type(), StatusBitString(header.StatusBitMask()), header.SPID(), header.GetByte(6),
+			header.LengthIncludingHeader(), HexPreview(frame[HEADER_SIZE:], TRACE_PREVIEW_BYTES))
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	io.WriteString(pt.w, line)
+}
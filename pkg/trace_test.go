@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStatusBitString(t *testing.T) {
+	tests := []struct {
+		status byte
+		want   string
+	}{
+		{NORMAL, "NORMAL"},
+		{END_OF_MESSAGE, "EOM"},
+		{END_OF_MESSAGE | RESET_CONNECTION, "EOM|RESET_CONNECTION"},
+		{IGNORE_EVENT | 0x80, "IGNORE|0x80"},
+	}
+	for _, tt := range tests {
+		if got := StatusBitString(tt.status); got != tt.want {
+			t.Errorf("StatusBitString(%#x) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestHexPreview(t *testing.T) {
+	if got, want := HexPreview([]byte("AB\x00"), 4), "41 42 00 |AB.|"; got != want {
+		t.Errorf("HexPreview = %q, want %q", got, want)
+	}
+	if got, want := HexPreview([]byte("ABCDEF"), 2), "41 42 ... |AB|"; got != want {
+		t.Errorf("truncated HexPreview = %q, want %q", got, want)
+	}
+}
+
+func TestTraceWriter(t *testing.T) {
+	var trace syncBuffer
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetTraceWriter(&trace)
+	})
+	batch := buildPacket(SQLBatch, END_OF_MESSAGE|RESET_CONNECTION, 1, sqlBatchPayload("SELECT 1"))
+	response := doneResponse(DONE_FINAL, 0)
+	client := h.connect(batch)
+	backend := h.backend(0)
+	waitWritten(t, backend, len(batch))
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+
+	lines := strings.Split(strings.TrimSuffix(string(trace.Bytes()), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("trace has %d lines, want 2:\n%s", len(lines), trace.Bytes())
+	}
+	wantRequest := " #1 C->S SQLBatch status=EOM|RESET_CONNECTION spid=0 packet=1 len=" + strconv.Itoa(len(batch)) + " 16 00 00 00 "
+	if !strings.Contains(lines[0], wantRequest) {
+		t.Errorf("request trace %q, want it to contain %q", lines[0], wantRequest)
+	}
+	if !strings.Contains(lines[1], " #1 S->C TabularResult status=EOM ") {
+		t.Errorf("response trace %q lacks the decoded response header", lines[1])
+	}
+}
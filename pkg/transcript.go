@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TranscriptEntry 会话记录中的一条：一个完整的客户端请求或服务器响应
+type TranscriptEntry struct {
+	Time time.Time
+	// Direction ClientBridge为客户端请求，BridgeSQL为服务器响应
+	Direction ConnectionType
+	Type      HeaderType
+	// Summary 可读的内容摘要：请求的SQL或过程名，响应的行数和错误
+	Summary string
+}
+
+func (e TranscriptEntry) String() string {
+	arrow := "->"
+	if e.Direction == BridgeSQL {
+		arrow = "<-"
+	}
+	if e.Summary == "" {
+		return fmt.Sprintf("%s %s %s", e.Time.Format("15:04:05.000"), arrow, e.Type)
+	}
+	return fmt.Sprintf("%s %s %s %s", e.Time.Format("15:04:05.000"), arrow, e.Type, e.Summary)
+}
+
+// SessionTranscript 一个连接按发生顺序记录的请求和响应，只保留最近的若干条
+type SessionTranscript struct {
+	mu      sync.Mutex
+	entries []TranscriptEntry
+	max     int
+	dropped uint64
+}
+
+// newSessionTranscript 创建最多保留max条记录的会话记录
+func newSessionTranscript(max int) *SessionTranscript {
+	return &SessionTranscript{max: max}
+}
+
+// SetMaxTranscriptEntries 为之后建立的连接启用会话记录，每个连接保留最近的n条请求和响应，
+// 超出时丢弃最早的记录。0表示不记录。记录需要解析消息，关闭解析时不生效。
+func (ba *BridgeAcceptor) SetMaxTranscriptEntries(n int) {
+	ba.maxTranscriptEntries = n
+}
+
+// Transcript 获取连接的会话记录，未启用时返回nil
+func (bc *BridgedConnection) Transcript() *SessionTranscript {
+	return bc.transcript
+}
+
+// add 追加一条记录，超出上限时丢弃最早的一条
+func (t *SessionTranscript) add(entry TranscriptEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.entries) >= t.max {
+		copy(t.entries, t.entries[1:])
+		t.entries = t.entries[:len(t.entries)-1]
+		t.dropped++
+	}
+	t.entries = append(t.entries, entry)
+}
+
+// Entries 获取当前保留的记录的副本，按发生顺序排列
+func (t *SessionTranscript) Entries() []TranscriptEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TranscriptEntry(nil), t.entries...)
+}
+
+// Dropped 获取因超出上限而丢弃的记录数
+func (t *SessionTranscript) Dropped() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dropped
+}
+
+// String 将记录格式化为每行一条的文本
+func (t *SessionTranscript) String() string {
+	entries := t.Entries()
+	sb := strings.Builder{}
+	if dropped := t.Dropped(); dropped > 0 {
+		sb.WriteString(fmt.Sprintf("(%d earlier entries dropped)\n", dropped))
+	}
+	for _, entry := range entries {
+		sb.WriteString(entry.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// recordTranscript 在启用会话记录时记录一个完整的消息
+func (bc *BridgedConnection) recordTranscript(direction ConnectionType, msg TDSMessage, preLoginResponse bool) {
+	if bc.transcript == nil {
+		return
+	}
+	entry := TranscriptEntry{
+		Time:      time.Now(),
+		Direction: direction,
+		Type:      UnknownHeader,
+	}
+	if packets := msg.GetPackets(); len(packets) > 0 {
+		entry.Type = packets[0].Header.Type()
+	}
+	if !preLoginResponse {
+		entry.Summary = transcriptSummary(msg)
+	}
+	bc.transcript.add(entry)
+}
+
+// transcriptSummary 生成消息的可读摘要
+func transcriptSummary(msg TDSMessage) string {
+	switch m := msg.(type) {
+	case *SQLBatchMessage:
+		return m.GetBatchText()
+	case *RPCRequestMessage:
+		procName, err := m.GetProcName()
+		if err != nil {
+			return ""
+		}
+		if isExecuteSQL(procName) {
+			if sql, err := m.EffectiveSQL(); err == nil {
+				return sql
+			}
+		}
+		return "EXEC " + procName
+	case *Login7Message:
+		return fmt.Sprintf("user=%s database=%s app=%s", m.GetUserName(), m.GetDatabase(), m.GetAppName())
+	case *TabularResultMessage:
+		var parts []string
+		for _, done := range m.DoneTokens() {
+			if done.HasRowCount() {
+				parts = append(parts, fmt.Sprintf("%d rows", done.RowCount))
+			}
+		}
+		serverErrors, _ := m.GetServerErrors()
+		for _, serverError := range serverErrors {
+			parts = append(parts, serverError.Error())
+		}
+		return strings.Join(parts, "; ")
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// transcriptHarness 创建启用会话记录的装置，返回连接建立后的BridgedConnection
+func transcriptHarness(t *testing.T, max int) (*bridgeHarness, chan *BridgedConnection) {
+	connected := make(chan *BridgedConnection, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetMaxTranscriptEntries(max)
+		ba.SetBackendConnectedHandler(func(bc *BridgedConnection, _ net.Conn) error {
+			connected <- bc
+			return nil
+		})
+	})
+	return h, connected
+}
+
+func TestTranscriptRecordsRequestAndResponse(t *testing.T) {
+	h, connected := transcriptHarness(t, 10)
+	request := sqlBatchPacket("SELECT name FROM t")
+	client := h.connect(request)
+	backend := h.backend(0)
+	bc := <-connected
+	waitWritten(t, backend, len(request))
+	response := doneResponse(DONE_FINAL|DONE_COUNT, 3)
+	backend.feed(response)
+	waitWritten(t, client, len(response))
+
+	transcript := bc.Transcript()
+	waitFor(t, "response recorded", func() bool { return len(transcript.Entries()) == 2 })
+	entries := transcript.Entries()
+	want := []struct {
+		direction ConnectionType
+		typ       HeaderType
+		summary   string
+	}{
+		{ClientBridge, SQLBatch, "SELECT name FROM t"},
+		{BridgeSQL, TabularResult, "3 rows"},
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Direction != w.direction || e.Type != w.typ || e.Summary != w.summary {
+			t.Errorf("entry %d = %s %s %q, want %s %s %q", i, e.Direction, e.Type, e.Summary, w.direction, w.typ, w.summary)
+		}
+	}
+	if entries[1].Time.Before(entries[0].Time) {
+		t.Errorf("response recorded at %v, before the request at %v", entries[1].Time, entries[0].Time)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(transcript.String(), "\n"), "\n")
+	if len(lines) != 2 ||
+		!strings.HasSuffix(lines[0], "-> SQLBatch SELECT name FROM t") ||
+		!strings.HasSuffix(lines[1], "<- TabularResult 3 rows") {
+		t.Errorf("String() =\n%s", transcript)
+	}
+}
+
+func TestTranscriptKeepsLatestEntries(t *testing.T) {
+	h, connected := transcriptHarness(t, 2)
+	batches := []string{"SELECT 1", "SELECT 2", "SELECT 3"}
+	var reads [][]byte
+	total := 0
+	for _, text := range batches {
+		reads = append(reads, sqlBatchPacket(text))
+		total += len(reads[len(reads)-1])
+	}
+	h.connect(reads...)
+	waitWritten(t, h.backend(0), total)
+	transcript := (<-connected).Transcript()
+
+	waitFor(t, "all batches recorded", func() bool { return transcript.Dropped() == 1 })
+	entries := transcript.Entries()
+	if len(entries) != 2 || entries[0].Summary != "SELECT 2" || entries[1].Summary != "SELECT 3" {
+		t.Errorf("entries = %v, want the last two batches", entries)
+	}
+	if s := transcript.String(); !strings.HasPrefix(s, "(1 earlier entries dropped)\n") {
+		t.Errorf("String() =\n%s", s)
+	}
+}
+
+func TestTranscriptDisabledByDefault(t *testing.T) {
+	h, connected := transcriptHarness(t, 0)
+	h.connect()
+	h.backend(0)
+	if transcript := (<-connected).Transcript(); transcript != nil {
+		t.Errorf("Transcript() = %v, want nil without SetMaxTranscriptEntries", transcript)
+	}
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ValidationIssue 捕获流中发现的一处异常
+type ValidationIssue struct {
+	// Offset 异常所在数据包(或流末尾)在流中的字节偏移
+	Offset int64
+	// Packet 异常所在数据包的序号(从0开始)，与具体数据包无关时为-1
+	Packet  int
+	Message string
+}
+
+func (vi ValidationIssue) String() string {
+	if vi.Packet < 0 {
+		return fmt.Sprintf("offset %d: %s", vi.Offset, vi.Message)
+	}
+	return fmt.Sprintf("packet %d at offset %d: %s", vi.Packet, vi.Offset, vi.Message)
+}
+
+// ValidateStream 按TDS数据包读取捕获的单向字节流，报告其中的自相矛盾之处：
+// 长度与实际数据不符、未知的头部类型、消息中途改变类型、数据包ID不连续、
+// 未设置END_OF_MESSAGE却设置了忽略位，以及流结束时消息仍未结束。
+// 原始TLS记录按记录头跳过。长度异常后无法重新同步，此时停止检查。
+func ValidateStream(r io.Reader) []ValidationIssue {
+	var issues []ValidationIssue
+	report := func(offset int64, packet int, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{Offset: offset, Packet: packet, Message: fmt.Sprintf(format, args...)})
+	}
+
+	reader := NewTDSReader(r)
+	var offset int64
+	index := 0
+	// 当前未结束的消息：类型、起始数据包序号和上一个数据包ID
+	inMessage := false
+	var messageType HeaderType
+	var messageStart int
+	var lastPacketID byte
+
+	for {
+		isTLSRecord, err := reader.NextIsTLSRecord()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && isTLSRecord {
+			var record []byte
+			if record, err = reader.ReadTLSRecord(); err == nil {
+				offset += int64(len(record))
+				continue
+			}
+		}
+
+		var packet *TDSPacket
+		if err == nil {
+			packet, err = reader.ReadPacket()
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrProtocol):
+				report(offset, index, "%v", err)
+			case err == io.EOF || err == io.ErrUnexpectedEOF:
+				report(offset, index, "stream truncated inside a packet")
+			default:
+				report(offset, index, "read error: %v", err)
+			}
+			return issues
+		}
+
+		header := packet.Header
+		status := header.StatusBitMask()
+		endOfMessage := (status & END_OF_MESSAGE) == END_OF_MESSAGE
+		packetID := header.GetByte(6)
+
+		if header.Type().String() == "Unknown" {
+			report(offset, index, "unknown header type %d", header.Type())
+		}
+		if (status&IGNORE_EVENT) == IGNORE_EVENT && !endOfMessage {
+			report(offset, index, "ignore bit set without END_OF_MESSAGE")
+		}
+		if inMessage {
+			if header.Type() != messageType {
+				report(offset, index, "header type changed from %s to %s inside the message started at packet %d",
+					messageType, header.Type(), messageStart)
+			}
+			if packetID != lastPacketID+1 {
+				report(offset, index, "packet ID %d does not follow %d", packetID, lastPacketID)
+			}
+		} else {
+			inMessage = true
+			messageType = header.Type()
+			messageStart = index
+		}
+		lastPacketID = packetID
+		if endOfMessage {
+			inMessage = false
+		}
+
+		offset += int64(header.LengthIncludingHeader())
+		index++
+	}
+
+	if inMessage {
+		report(offset, -1, "stream ended before END_OF_MESSAGE of the %s message started at packet %d", messageType, messageStart)
+	}
+	return issues
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestValidateStream(t *testing.T) {
+	batch := sqlBatchPayload("SELECT 1")
+	concat := func(packets ...[]byte) []byte { return bytes.Join(packets, nil) }
+	shortLength := buildPacket(SQLBatch, END_OF_MESSAGE, 1, batch)
+	binary.BigEndian.PutUint16(shortLength[2:], 4)
+
+	tests := []struct {
+		name   string
+		stream []byte
+		want   []string
+	}{
+		{"clean", concat(sqlBatchPacket("SELECT 1"), sqlBatchPacket("SELECT 2")), nil},
+		{"multi-packet message", concat(
+			buildPacket(SQLBatch, NORMAL, 1, batch[:10]),
+			buildPacket(SQLBatch, END_OF_MESSAGE, 2, batch[10:]),
+		), nil},
+		{"length smaller than header", shortLength, []string{"smaller than header"}},
+		{"truncated payload", sqlBatchPacket("SELECT 1")[:20], []string{"truncated inside a packet"}},
+		{"missing end of message", buildPacket(SQLBatch, NORMAL, 1, batch), []string{"ended before END_OF_MESSAGE"}},
+		{"unknown header type", buildPacket(HeaderType(0x55), END_OF_MESSAGE, 1, batch), []string{"unknown header type 85"}},
+		{"ignore without end of message", concat(
+			buildPacket(SQLBatch, IGNORE_EVENT, 1, batch),
+			buildPacket(SQLBatch, END_OF_MESSAGE|IGNORE_EVENT, 2, batch),
+		), []string{"ignore bit set without END_OF_MESSAGE"}},
+		{"type change and packet ID gap", concat(
+			buildPacket(SQLBatch, NORMAL, 1, batch),
+			buildPacket(RPC, END_OF_MESSAGE, 3, batch),
+		), []string{"header type changed", "packet ID 3 does not follow 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := ValidateStream(bytes.NewReader(tt.stream))
+			if len(issues) != len(tt.want) {
+				t.Fatalf("got %d issues %v, want %d", len(issues), issues, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(issues[i].Message, want) {
+					t.Errorf("issue %d = %q, want it to contain %q", i, issues[i].Message, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateStreamIssueLocation(t *testing.T) {
+	first := sqlBatchPacket("SELECT 1")
+	stream := append(append([]byte{}, first...), buildPacket(HeaderType(0x55), END_OF_MESSAGE, 1, nil)...)
+	issues := ValidateStream(bytes.NewReader(stream))
+	if len(issues) != 1 {
+		t.Fatalf("got %v, want one issue", issues)
+	}
+	if issues[0].Packet != 1 || issues[0].Offset != int64(len(first)) {
+		t.Errorf("issue at packet %d offset %d, want packet 1 offset %d", issues[0].Packet, issues[0].Offset, len(first))
+	}
+}
@@ -0,0 +1,47 @@
+package pkg
+
+import "fmt"
+
+// TDSVersion TDS协议版本号，与Login7/LOGINACK中的TDSVersion字段取值一致
+type TDSVersion uint32
+
+const (
+	TDSVersionUnknown TDSVersion = 0
+	TDSVersion70      TDSVersion = 0x70000000
+	TDSVersion71      TDSVersion = 0x71000001
+	TDSVersion72      TDSVersion = 0x72090002
+	TDSVersion73A     TDSVersion = 0x730A0003
+	TDSVersion73B     TDSVersion = 0x730B0003
+	TDSVersion74      TDSVersion = 0x74000004
+)
+
+// Major 主版本号(如7.4中的7)
+func (v TDSVersion) Major() int {
+	return int(v>>28) & 0x0F
+}
+
+// Minor 次版本号(如7.4中的4)
+func (v TDSVersion) Minor() int {
+	return int(v>>24) & 0x0F
+}
+
+// AtLeast 检查版本是否不低于指定版本，未知版本总是返回false
+func (v TDSVersion) AtLeast(other TDSVersion) bool {
+	if v == TDSVersionUnknown {
+		return false
+	}
+	return v>>24 >= other>>24
+}
+
+func (v TDSVersion) String() string {
+	switch v {
+	case TDSVersionUnknown:
+		return "Unknown"
+	case TDSVersion73A:
+		return "7.3A"
+	case TDSVersion73B:
+		return "7.3B"
+	default:
+		return fmt.Sprintf("%d.%d", v.Major(), v.Minor())
+	}
+}
@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTDSVersionReportedFromLogin7(t *testing.T) {
+	logins := make(chan *BridgedConnection, 1)
+	h := newBridgeHarness(t, func(ba *BridgeAcceptor) {
+		ba.SetTDSMessageReceivedHandler(func(bc *BridgedConnection, msg TDSMessage) {
+			if _, ok := msg.(*Login7Message); ok {
+				logins <- bc
+			}
+		})
+	})
+
+	h.connect(testLogin7{version: TDSVersion74, user: "sa", database: "master"}.packet())
+	select {
+	case bc := <-logins:
+		if v := bc.TDSVersion(); v != TDSVersion74 {
+			t.Fatalf("TDSVersion() = %s, want 7.4", v)
+		}
+		if s := bc.TDSVersion().String(); s != "7.4" {
+			t.Fatalf("TDSVersion().String() = %q, want %q", s, "7.4")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("login message not received")
+	}
+}
+
+func TestTDSVersionAtLeast(t *testing.T) {
+	if !TDSVersion74.AtLeast(TDSVersion72) {
+		t.Error("7.4 should be at least 7.2")
+	}
+	if TDSVersion71.AtLeast(TDSVersion72) {
+		t.Error("7.1 should not be at least 7.2")
+	}
+	if TDSVersionUnknown.AtLeast(TDSVersion70) {
+		t.Error("unknown version should not be at least 7.0")
+	}
+}
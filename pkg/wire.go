@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// 以下为从bytes.Reader中按TDS线格式(小端)读取基本类型的辅助函数，
+// 数据不足时返回包装了ErrProtocol的错误。
+
+// truncated 返回数据截断错误
+func truncated(what string) error {
+	return fmt.Errorf("%w: truncated %s: %v", ErrProtocol, what, io.ErrUnexpectedEOF)
+}
+
+func readByte(r *bytes.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, truncated("byte")
+	}
+	return b, nil
+}
+
+func readUint16(r *bytes.Reader) (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, truncated("uint16")
+	}
+	return binary.LittleEndian.Uint16(b[:]), nil
+}
+
+func readUint32(r *bytes.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, truncated("uint32")
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func readUint64(r *bytes.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, truncated("uint64")
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+func readBytes(r *bytes.Reader, n int) ([]byte, error) {
+	if n < 0 || n > r.Len() {
+		return nil, truncated(fmt.Sprintf("%d bytes", n))
+	}
+	b := make([]byte, n)
+	io.ReadFull(r, b)
+	return b, nil
+}
+
+// readBVarChar 读取以1字节字符数为前缀的UTF-16字符串(B_VARCHAR)
+func readBVarChar(r *bytes.Reader) (string, error) {
+	n, err := readByte(r)
+	if err != nil {
+		return "", err
+	}
+	b, err := readBytes(r, int(n)*2)
+	if err != nil {
+		return "", err
+	}
+	return decodeUTF16LE(b), nil
+}
+
+// readUSVarChar 读取以2字节字符数为前缀的UTF-16字符串(US_VARCHAR)
+func readUSVarChar(r *bytes.Reader) (string, error) {
+	n, err := readUint16(r)
+	if err != nil {
+		return "", err
+	}
+	b, err := readBytes(r, int(n)*2)
+	if err != nil {
+		return "", err
+	}
+	return decodeUTF16LE(b), nil
+}